@@ -0,0 +1,282 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// AuditLogTable is the managed table storing the audit trail
+const AuditLogTable = "gpo_audit_log"
+
+// auditTables remembers which connection pools already have the managed table
+var auditTables sync.Map
+
+// AuditChange is the old and new value of a column, nil for the side which does not exist
+type AuditChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// AuditEntry is a mutation of one row recorded in the audit log
+type AuditEntry struct {
+	ID         int64
+	Table      string
+	Operation  string
+	PrimaryKey string
+	// Changes holds the changed columns of updates and all columns of inserts and deletes
+	Changes   map[string]AuditChange
+	Actor     string
+	CreatedAt time.Time
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor returns a context carrying the actor recorded in the audit log, e.g. a user ID
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFromContext returns the actor set with WithAuditActor, or "" when none was set
+func AuditActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+func (s PostgreSQLConnector) ensureAuditTable(ctx context.Context) error {
+	db := s.GetConnection()
+	if _, ok := auditTables.Load(db); ok {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGSERIAL PRIMARY KEY,
+		table_name TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		primary_key TEXT NOT NULL,
+		changes JSONB NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW());
+		CREATE INDEX IF NOT EXISTS %[1]s_row_idx ON %[1]s (table_name, primary_key)`, AuditLogTable))
+	if err != nil {
+		return fmt.Errorf("error creating audit log table: %v", err)
+	}
+	auditTables.Store(db, true)
+	return nil
+}
+
+// audited wraps a mutation so it records the affected rows in the audit log in
+// the same transaction, starting one when the mutation runs outside of a transaction
+func (s PostgreSQLConnector) audited(operation string, model interface{}, conditions []Condition, run func(ctx context.Context, tx *sql.Tx) (int64, error)) func(ctx context.Context, tx *sql.Tx) (int64, error) {
+	return func(ctx context.Context, tx *sql.Tx) (affected int64, err error) {
+		if err = s.ensureAuditTable(ctx); err != nil {
+			return 0, err
+		}
+		if tx == nil {
+			tx, err = s.beginTx(ctx)
+			if err != nil {
+				return 0, err
+			}
+			defer func() {
+				if err != nil {
+					s.RollbackTx(tx)
+					return
+				}
+				err = s.CommitTx(tx)
+			}()
+		}
+
+		var before []reflect.Value
+		if operation != "insert" {
+			if before, err = s.lockRows(ctx, tx, model, conditions); err != nil {
+				return 0, fmt.Errorf("error reading rows for the audit log: %v", err)
+			}
+		}
+		if affected, err = run(ctx, tx); err != nil {
+			return 0, err
+		}
+
+		after := reflect.ValueOf(model)
+		for after.Kind() == reflect.Ptr {
+			after = after.Elem()
+		}
+		// InsertModels audits the elements of a slice
+		inserted := []reflect.Value{after}
+		tableModel := model
+		if after.Kind() == reflect.Slice {
+			inserted = inserted[:0]
+			for i := 0; i < after.Len(); i++ {
+				inserted = append(inserted, after.Index(i))
+			}
+			tableModel = reflect.New(after.Type().Elem()).Interface()
+			after = reflect.New(after.Type().Elem()).Elem()
+		}
+		meta := metadataOf(after.Type(), s.naming())
+		table := s.tableName(tableModel)
+		switch operation {
+		case "insert":
+			for _, row := range inserted {
+				if err = s.writeAuditEntry(ctx, tx, table, operation, meta, reflect.Value{}, row); err != nil {
+					break
+				}
+			}
+		case "update":
+			var updated []reflect.Value
+			if updated, err = s.updatedRows(ctx, tx, model, meta, before, after); err != nil {
+				return 0, err
+			}
+			for i, row := range before {
+				if err = s.writeAuditEntry(ctx, tx, table, operation, meta, row, updated[i]); err != nil {
+					break
+				}
+			}
+		case "delete":
+			for _, row := range before {
+				if err = s.writeAuditEntry(ctx, tx, table, operation, meta, row, reflect.Value{}); err != nil {
+					break
+				}
+			}
+		}
+		return affected, err
+	}
+}
+
+// lockRows reads and locks the rows matching the conditions before they are changed
+func (s PostgreSQLConnector) lockRows(ctx context.Context, tx *sql.Tx, model interface{}, conditions []Condition) ([]reflect.Value, error) {
+	var queryProps DatabaseQuery
+	queryProps.Table = s.tableName(model)
+	queryProps.Conditions = conditions
+	fieldMap := parseTags(model, s.naming(), &queryProps.fields)
+	query, args := buildQuery(&queryProps)
+	rows, err := tx.QueryContext(ctx, query+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	modelType := indirectType(model)
+	scanner := s.scannerFor(columns, fieldMap, modelType)
+	var result []reflect.Value
+	for rows.Next() {
+		row := reflect.New(modelType).Elem()
+		if err := scanner.scan(rows, row); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// updatedRows reads the rows of an update back by the primary keys they had before,
+// so each row is compared with its own new values rather than with the model.
+// Rows whose primary key changed and models without one fall back to the model.
+func (s PostgreSQLConnector) updatedRows(ctx context.Context, tx *sql.Tx, model interface{}, meta *modelMetadata, before []reflect.Value, fallback reflect.Value) ([]reflect.Value, error) {
+	updated := make([]reflect.Value, len(before))
+	for i := range updated {
+		updated[i] = fallback
+	}
+	if meta.primaryKey == nil || len(before) == 0 {
+		return updated, nil
+	}
+	keys := make([]interface{}, len(before))
+	for i, row := range before {
+		keys[i] = row.Field(meta.primaryKey.index).Interface()
+	}
+	rows, err := s.lockRows(ctx, tx, model, []Condition{{Field: meta.primaryKey.tag.ColumnName, Operator: "IN", Value: keys}})
+	if err != nil {
+		return nil, fmt.Errorf("error reading updated rows for the audit log: %v", err)
+	}
+	byKey := make(map[string]reflect.Value, len(rows))
+	for _, row := range rows {
+		byKey[fmt.Sprint(row.Field(meta.primaryKey.index).Interface())] = row
+	}
+	for i, key := range keys {
+		if row, ok := byKey[fmt.Sprint(key)]; ok {
+			updated[i] = row
+		}
+	}
+	return updated, nil
+}
+
+// writeAuditEntry records one row, before is invalid for inserts and after for deletes.
+// Updates only record changed columns and nothing when no column changed.
+func (s PostgreSQLConnector) writeAuditEntry(ctx context.Context, tx *sql.Tx, table string, operation string, meta *modelMetadata, before reflect.Value, after reflect.Value) error {
+	changes := make(map[string]AuditChange)
+	for _, field := range meta.fields {
+		var change AuditChange
+		if before.IsValid() {
+			change.Old = before.Field(field.index).Interface()
+		}
+		if after.IsValid() {
+			change.New = after.Field(field.index).Interface()
+		}
+		if before.IsValid() && after.IsValid() && reflect.DeepEqual(change.Old, change.New) {
+			continue
+		}
+		changes[field.tag.ColumnName] = change
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("error encoding audit log changes: %v", err)
+	}
+
+	var primaryKey string
+	if meta.primaryKey != nil {
+		row := after
+		if before.IsValid() {
+			row = before
+		}
+		primaryKey = fmt.Sprint(row.Field(meta.primaryKey.index).Interface())
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (table_name, operation, primary_key, changes, actor, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		AuditLogTable), table, operation, primaryKey, string(data), AuditActorFromContext(ctx), s.now())
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %v", err)
+	}
+	return nil
+}
+
+// AuditTrail returns the audit log entries of the row of the model with the
+// primary key value id, oldest first
+func (s PostgreSQLConnector) AuditTrail(model interface{}, id interface{}, opts ...Option) ([]AuditEntry, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if err := s.ensureAuditTable(config.ctx); err != nil {
+		return nil, err
+	}
+	query, args, err := NewQueryBuilder().
+		Select("id", "table_name", "operation", "primary_key", "changes", "actor", "created_at").
+		From(AuditLogTable).
+		Where("table_name", "=", s.tableName(model)).
+		Where("primary_key", "=", fmt.Sprint(id)).
+		OrderByAsc("id").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readRows(config, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit log: %v", err)
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+		if err := rows.Scan(&entry.ID, &entry.Table, &entry.Operation, &entry.PrimaryKey, &changes, &entry.Actor, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning audit log: %v", err)
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("error decoding audit log changes: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
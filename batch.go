@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// maxStatementParams is the maximum number of bind parameters of a Postgres statement
+const maxStatementParams = 65535
+
+// InsertModels validates and inserts the models of a slice, given as pointer to
+// a slice of structs, with multi-row INSERT statements. The returned rows are
+// scanned back into the elements, filling generated keys, defaults and readonly
+// columns. They are matched by primary key when it is known before the insert,
+// otherwise by position. Like InsertModel it accepts context, transaction and audit
+// logging; WithIdempotencyKey and WithAssociations are not supported.
+func (s PostgreSQLConnector) InsertModels(models interface{}, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	val := reflect.ValueOf(models)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice || val.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error handling %T: models must be a pointer to a slice of structs", models)
+	}
+	if config.idempotencyKey != "" || config.associations {
+		return fmt.Errorf("InsertModels does not support idempotency keys and associations")
+	}
+	slice := val.Elem()
+	if slice.Len() == 0 {
+		return nil
+	}
+	for i := 0; i < slice.Len(); i++ {
+		model := slice.Index(i).Addr().Interface()
+		if err := s.validateModel(config.ctx, model); err != nil {
+			return fmt.Errorf("model %d: %w", i, err)
+		}
+		if err := s.generatePrimaryKey(model); err != nil {
+			return err
+		}
+	}
+
+	insert := func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		return s.insertBatchWithTx(ctx, tx, slice)
+	}
+	if s.AuditLog && config.dryRun == nil {
+		insert = s.audited("insert", models, nil, insert)
+	}
+	_, err := insert(config.ctx, config.tx)
+	return err
+}
+
+// insertBatchWithTx inserts the elements of slice in statements of at most
+// maxStatementParams parameters
+func (s PostgreSQLConnector) insertBatchWithTx(ctx context.Context, tx *sql.Tx, slice reflect.Value) (int64, error) {
+	elementType := slice.Type().Elem()
+	sample := reflect.New(elementType).Interface()
+	table := s.tableName(sample)
+	meta := metadataOf(elementType, s.naming())
+	columns := writableColumns(sample, s.naming())
+	withKey := hasDefaultID(sample) && s.keyGeneration(sample) != ServerUUID
+	if withKey {
+		columns = append(Fields{DefaultIDField}, columns...)
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("%s has no columns to insert", elementType.Name())
+	}
+	batchSize := maxStatementParams / len(columns)
+	if tx == nil && dryRunOf(ctx) == nil && slice.Len() > batchSize {
+		// Several statements are needed, they are inserted in one transaction so a failure inserts none
+		tx, err := s.beginTx(ctx)
+		if err != nil {
+			return 0, err
+		}
+		inserted, err := s.insertBatchWithTx(ctx, tx, slice)
+		if err != nil {
+			s.RollbackTx(tx)
+			return 0, err
+		}
+		return inserted, s.CommitTx(tx)
+	}
+
+	// Returned rows are matched by key when it is known, Postgres does not guarantee their order
+	returning := strings.Join(meta.selectable, ", ")
+	keyColumn := ""
+	if withKey {
+		keyColumn = DefaultIDField
+		returning = strings.Join(append([]string{DefaultIDField}, meta.selectable...), ", ")
+	} else if meta.primaryKey != nil && contains(columns, meta.primaryKey.tag.ColumnName) {
+		keyColumn = meta.primaryKey.tag.ColumnName
+	}
+	if returning == "" {
+		returning = DefaultIDField
+	}
+	var inserted int64
+	for start := 0; start < slice.Len(); start += batchSize {
+		end := start + batchSize
+		if end > slice.Len() {
+			end = slice.Len()
+		}
+		var rowsSQL []string
+		var args []interface{}
+		keys := make([]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			row := slice.Index(i)
+			placeholders := make([]string, 0, len(columns))
+			for _, column := range columns {
+				if column == DefaultIDField && withKey {
+					key := s.generateKey(sample)
+					keys = append(keys, key)
+					args = append(args, key)
+				} else {
+					field := meta.byColumn[column]
+					args = append(args, columnArg(field, row.Field(field.index)))
+				}
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+			}
+			rowsSQL = append(rowsSQL, "("+strings.Join(placeholders, ",")+")")
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s RETURNING %s",
+			table, strings.Join(columns, ","), strings.Join(rowsSQL, ","), returning)
+
+		targets := slice.Slice(start, end)
+		matchKeys := keys
+		if !withKey {
+			matchKeys = nil
+			if keyColumn != "" {
+				matchKeys = knownKeys(targets, meta.primaryKey.index)
+			}
+		}
+		n, err := s.scanReturning(ctx, tx, query, args, targets, keyColumn, matchKeys)
+		if err != nil {
+			return inserted, err
+		}
+		inserted += n
+		for i := start; i < end; i++ {
+			model := slice.Index(i).Addr().Interface()
+			primaryKey := modelPrimaryKey(model)
+			if withKey {
+				primaryKey = keys[i-start]
+			}
+			s.emit(ctx, tx, ChangeEvent{Table: table, Operation: "insert", Model: model, PrimaryKey: primaryKey})
+		}
+	}
+	s.invalidateCache(ctx, tx, table)
+	return inserted, nil
+}
+
+// knownKeys returns the primary keys of the targets, nil when one of them is not set yet
+func knownKeys(targets reflect.Value, index int) []interface{} {
+	keys := make([]interface{}, targets.Len())
+	for i := range keys {
+		field := targets.Index(i).Field(index)
+		if field.IsZero() {
+			return nil
+		}
+		keys[i] = field.Interface()
+	}
+	return keys
+}
+
+// scanReturning runs a statement with a RETURNING clause and scans the returned
+// rows into the elements of targets. With keys, the keys of the targets in
+// order, a row is scanned into the target whose key matches its keyColumn,
+// otherwise the rows are scanned in order. A dry run returns no rows.
+func (s PostgreSQLConnector) scanReturning(ctx context.Context, tx *sql.Tx, query string, args []interface{}, targets reflect.Value, keyColumn string, keys []interface{}) (int64, error) {
+	rows, err := s.queryStatement(ctx, tx, query, args...)
+	if errors.Is(err, errPlanned) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	elementType := targets.Type().Elem()
+	scanner := s.scannerFor(columns, metadataOf(elementType, s.naming()).fieldMap, elementType)
+	keyIndex := -1
+	targetOf := make(map[string]int, len(keys))
+	if keys != nil {
+		for i, column := range columns {
+			if column == keyColumn {
+				keyIndex = i
+			}
+		}
+		for i, key := range keys {
+			targetOf[keyString(key)] = i
+		}
+	}
+	var n int64
+	for rows.Next() {
+		if n >= int64(targets.Len()) {
+			return n, fmt.Errorf("statement returned more than %d rows", targets.Len())
+		}
+		if keyIndex < 0 {
+			if err := scanner.scan(rows, targets.Index(int(n))); err != nil {
+				return n, fmt.Errorf("error scanning returned row: %v", err)
+			}
+			n++
+			continue
+		}
+		row := reflect.New(elementType).Elem()
+		if err := scanner.scan(rows, row); err != nil {
+			return n, fmt.Errorf("error scanning returned row: %v", err)
+		}
+		key := scanner.discards[keyIndex]
+		if path := scanner.paths[keyIndex]; path != nil {
+			key = row.FieldByIndex(path).Interface()
+		}
+		i, ok := targetOf[keyString(key)]
+		if !ok {
+			return n, fmt.Errorf("statement returned a row with unknown %s %v", keyColumn, key)
+		}
+		target := targets.Index(i)
+		for _, path := range scanner.paths {
+			if path != nil {
+				target.FieldByIndex(path).Set(row.FieldByIndex(path))
+			}
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if n != int64(targets.Len()) {
+		return n, fmt.Errorf("statement returned %d of %d rows", n, targets.Len())
+	}
+	return n, nil
+}
+
+// keyString returns the text of a key, as given or as scanned from a column
+func keyString(key interface{}) string {
+	if b, ok := key.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(key)
+}
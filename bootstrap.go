@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// BootstrapPlan describes the database, roles and privileges of a fresh
+// environment, see Bootstrap
+type BootstrapPlan struct {
+	// Database is created when missing, empty means the database of the connector
+	Database string
+	// Owner becomes the owner of Database, it may be one of Roles
+	Owner string
+	// Schema holds the tables the privileges are granted on, defaults to public
+	Schema string
+	// Roles are created when missing and granted their privileges
+	Roles []BootstrapRole
+}
+
+// BootstrapRole is a role created by Bootstrap
+type BootstrapRole struct {
+	Name string
+	// Password allows the role to log in, without it the role is a group role
+	Password string
+	// Privileges are granted on all tables of the schema, e.g. "SELECT" or
+	// "INSERT", and by default on the tables created later by Owner, or by the
+	// connector user without Owner
+	Privileges []string
+}
+
+// tablePrivileges are the privileges that can be granted on tables
+var tablePrivileges = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"TRUNCATE": true, "REFERENCES": true, "TRIGGER": true, "ALL": true,
+}
+
+// privilegeList validates table privileges and joins them for GRANT and REVOKE
+func privilegeList(privileges []string) (string, error) {
+	if len(privileges) == 0 {
+		return "", fmt.Errorf("no privileges given")
+	}
+	list := make([]string, len(privileges))
+	for i, privilege := range privileges {
+		list[i] = strings.ToUpper(strings.TrimSpace(privilege))
+		if !tablePrivileges[list[i]] {
+			return "", fmt.Errorf("unknown table privilege %q", privilege)
+		}
+	}
+	return strings.Join(list, ", "), nil
+}
+
+// Bootstrap provisions a fresh environment: it creates the missing roles and the
+// database, makes Owner its owner and grants the privileges of the roles on the
+// tables of the schema, including tables created later. Roles and database are
+// created with the connection of the connector, which needs the CREATEROLE and
+// CREATEDB attributes; the privileges are granted in a connection to Database.
+// Running it again changes nothing but missing privileges.
+func (s *PostgreSQLConnector) Bootstrap(plan BootstrapPlan, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	schema := plan.Schema
+	if schema == "" {
+		schema = "public"
+	}
+	var grants []string
+	for _, role := range plan.Roles {
+		if len(role.Privileges) == 0 {
+			continue
+		}
+		stmts, err := grantStmts(schema, plan.Owner, role)
+		if err != nil {
+			return fmt.Errorf("error granting privileges to %s: %v", role.Name, err)
+		}
+		grants = append(grants, stmts...)
+	}
+
+	for _, role := range plan.Roles {
+		if err := s.createRole(config.ctx, role); err != nil {
+			return err
+		}
+	}
+	if plan.Database != "" {
+		if err := s.CreateDatabase(plan.Database, WithContext(config.ctx)); err != nil {
+			return fmt.Errorf("error creating database %s: %v", plan.Database, err)
+		}
+	}
+	if plan.Owner != "" {
+		database := plan.Database
+		if database == "" {
+			database = s.Database
+		}
+		if _, err := s.execStatement(config.ctx, nil, fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", quoteIdentifier(database), quoteIdentifier(plan.Owner))); err != nil {
+			return fmt.Errorf("error changing the owner of %s: %v", database, err)
+		}
+	}
+	if len(grants) == 0 {
+		return nil
+	}
+
+	var target execer = s.observed(s.GetConnection())
+	if plan.Database != "" && plan.Database != s.Database {
+		connector := PostgreSQLConnector{Host: s.Host, Port: s.Port, User: s.User, Password: s.Password, SSLMode: s.SSLMode,
+			Database: plan.Database, CredentialProvider: s.CredentialProvider, ConnectTimeout: s.ConnectTimeout}
+		db, err := connector.open()
+		if err != nil {
+			return fmt.Errorf("error connecting to %s: %v", plan.Database, err)
+		}
+		defer db.Close()
+		target = s.observed(db)
+	}
+	for _, stmt := range grants {
+		if _, err := target.ExecContext(config.ctx, stmt); err != nil {
+			return fmt.Errorf("error granting privileges: %s: %v", stmt, err)
+		}
+	}
+	return nil
+}
+
+// createRole creates a role unless it exists
+func (s *PostgreSQLConnector) createRole(ctx context.Context, role BootstrapRole) error {
+	if !validIdentifier(role.Name) {
+		return fmt.Errorf("invalid role name %q", role.Name)
+	}
+	rows, err := s.queryStatement(ctx, nil, "SELECT 1 FROM pg_roles WHERE rolname = $1", role.Name)
+	if err != nil {
+		return fmt.Errorf("error looking up role %s: %v", role.Name, err)
+	}
+	exists := rows.Next()
+	rows.Close()
+	if exists {
+		return nil
+	}
+	stmt := "CREATE ROLE " + quoteIdentifier(role.Name)
+	observedStmt := stmt
+	if role.Password != "" {
+		stmt += " LOGIN PASSWORD " + pq.QuoteLiteral(role.Password)
+		observedStmt += " LOGIN PASSWORD '[REDACTED]'"
+	}
+	// The statement holds the password, it bypasses the middleware and only its redacted text is observed
+	start := s.now()
+	_, err = s.GetConnection().ExecContext(ctx, stmt)
+	s.observeQuery(start, observedStmt, nil)
+	if err != nil {
+		return fmt.Errorf("error creating role %s: %v", role.Name, err)
+	}
+	return nil
+}
+
+// grantStmts returns the statements granting the privileges of a role on the
+// existing tables of the schema and on the tables created later by owner, or by
+// the current user when owner is empty. Roles allowed to insert may also use the
+// sequences of serial columns.
+func grantStmts(schema string, owner string, role BootstrapRole) ([]string, error) {
+	privileges, err := privilegeList(role.Privileges)
+	if err != nil {
+		return nil, err
+	}
+	schema, name := quoteIdentifier(schema), quoteIdentifier(role.Name)
+	defaults := "ALTER DEFAULT PRIVILEGES"
+	if owner != "" {
+		defaults += " FOR ROLE " + quoteIdentifier(owner)
+	}
+	stmts := []string{
+		fmt.Sprintf("GRANT USAGE ON SCHEMA %s TO %s", schema, name),
+		fmt.Sprintf("GRANT %s ON ALL TABLES IN SCHEMA %s TO %s", privileges, schema, name),
+		fmt.Sprintf("%s IN SCHEMA %s GRANT %s ON TABLES TO %s", defaults, schema, privileges, name),
+	}
+	if strings.Contains(privileges, "INSERT") || strings.Contains(privileges, "ALL") {
+		stmts = append(stmts,
+			fmt.Sprintf("GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA %s TO %s", schema, name),
+			fmt.Sprintf("%s IN SCHEMA %s GRANT USAGE, SELECT ON SEQUENCES TO %s", defaults, schema, name))
+	}
+	return stmts, nil
+}
+
+// GrantOnModel grants table privileges, e.g. "SELECT" or "INSERT", on the table
+// of a model or table name to a role, e.g. next to the migration creating it
+// with WithTransaction
+func (s *PostgreSQLConnector) GrantOnModel(modelOrTableName interface{}, role string, privileges []string, opts ...Option) error {
+	return s.changePrivileges("GRANT %s ON TABLE %s TO %s", modelOrTableName, role, privileges, opts)
+}
+
+// RevokeOnModel revokes table privileges on the table of a model or table name from a role
+func (s *PostgreSQLConnector) RevokeOnModel(modelOrTableName interface{}, role string, privileges []string, opts ...Option) error {
+	return s.changePrivileges("REVOKE %s ON TABLE %s FROM %s", modelOrTableName, role, privileges, opts)
+}
+
+// changePrivileges runs a GRANT or REVOKE statement format with the privileges,
+// the table name, unquoted like in CREATE TABLE, and the quoted role
+func (s *PostgreSQLConnector) changePrivileges(format string, modelOrTableName interface{}, role string, privileges []string, opts []Option) error {
+	list, err := privilegeList(privileges)
+	if err != nil {
+		return err
+	}
+	table := s.tableNameFromModelOrName(modelOrTableName)
+	if !validIdentifier(table) {
+		return fmt.Errorf("invalid identifier %q", table)
+	}
+	config := processOptions(opts)
+	defer config.release()
+	_, err = s.execStatement(config.ctx, config.tx, fmt.Sprintf(format, list, table, quoteIdentifier(role)))
+	return err
+}
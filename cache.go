@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores query results by key. Entries are grouped by table so writes can
+// invalidate all results read from a table.
+type Cache interface {
+	Get(ctx context.Context, key string) (interface{}, bool)
+	Set(ctx context.Context, table string, key string, value interface{}, ttl time.Duration)
+	Invalidate(ctx context.Context, table string)
+}
+
+// StaleCache is implemented by caches keeping expired entries for a while, served
+// while the CircuitBreaker is open, see ServeStale
+type StaleCache interface {
+	GetStale(ctx context.Context, key string) (interface{}, bool)
+}
+
+// CachedModel is implemented by models declaring the cache TTL of their table,
+// zero or less never caches it. CacheTTLs on the connector take precedence.
+type CachedModel interface {
+	CacheTTL() time.Duration
+}
+
+// WithoutCache bypasses the Cache for a read
+func WithoutCache() Option {
+	return func(c *Config) { c.noCache = true }
+}
+
+// cacheTTL resolves the TTL of a model's table
+func (s *PostgreSQLConnector) cacheTTL(model interface{}) time.Duration {
+	t := indirectType(model)
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	table := strings.TrimPrefix(s.tableName(reflect.New(t).Interface()), s.tablePrefix())
+	if ttl, ok := s.CacheTTLs[table]; ok {
+		return ttl
+	}
+	if cached, ok := reflect.New(t).Interface().(CachedModel); ok {
+		return cached.CacheTTL()
+	}
+	return s.DefaultCacheTTL
+}
+
+// cacheKey returns the cache key and TTL of a read, or an empty key when the read
+// is not cached. Reads in transactions and from the primary are never cached so
+// they see their own writes.
+func (s *PostgreSQLConnector) cacheKey(config *Config, model interface{}, query string, args []interface{}) (string, time.Duration) {
+	if s.Cache == nil || config.noCache || config.tx != nil || config.primary {
+		return "", 0
+	}
+	ttl := s.cacheTTL(model)
+	if ttl <= 0 {
+		return "", 0
+	}
+	return fmt.Sprintf("%s|%s|%v", indirectType(model), query, args), ttl
+}
+
+// cachedRead copies a cached result into dest, see copyCached
+func (s *PostgreSQLConnector) cachedRead(config *Config, key string, dest reflect.Value) bool {
+	if key == "" {
+		return false
+	}
+	value, ok := s.Cache.Get(config.ctx, key)
+	return ok && copyCached(value, dest)
+}
+
+// staleRead copies an expired cached result into dest like cachedRead, when the
+// read failed because the CircuitBreaker is open and it serves stale results
+func (s *PostgreSQLConnector) staleRead(config *Config, key string, dest reflect.Value, err error) bool {
+	stale, ok := s.Cache.(StaleCache)
+	if key == "" || !ok || s.CircuitBreaker == nil || !s.CircuitBreaker.ServeStale || !errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	value, ok := stale.GetStale(config.ctx, key)
+	return ok && copyCached(value, dest)
+}
+
+// copyCached copies a cached result into dest, a struct or a slice the result is appended to
+func copyCached(value interface{}, dest reflect.Value) bool {
+	cached := reflect.ValueOf(value)
+	if cached.Type() != dest.Type() {
+		return false
+	}
+	if dest.Kind() == reflect.Slice {
+		dest.Set(reflect.AppendSlice(dest, cached))
+	} else {
+		dest.Set(cached)
+	}
+	return true
+}
+
+// cacheRead stores a copy of a read result
+func (s *PostgreSQLConnector) cacheRead(config *Config, key string, ttl time.Duration, table string, value reflect.Value) {
+	if key == "" {
+		return
+	}
+	if value.Kind() == reflect.Slice {
+		value = reflect.AppendSlice(reflect.MakeSlice(value.Type(), 0, value.Len()), value)
+	}
+	s.Cache.Set(config.ctx, table, key, value.Interface(), ttl)
+}
+
+// invalidateCache drops the cached results of the tables. Changes made in tx
+// drop them again once it committed, other sessions may have cached the results
+// they still read before the commit in the meantime.
+func (s PostgreSQLConnector) invalidateCache(ctx context.Context, tx *sql.Tx, tables ...string) {
+	if s.Cache == nil || dryRunOf(ctx) != nil {
+		return
+	}
+	invalidate := func() {
+		for _, table := range tables {
+			s.Cache.Invalidate(ctx, table)
+		}
+	}
+	invalidate()
+	if bus := s.eventBus(); tx != nil && bus != nil {
+		bus.queue(tx, pendingEvent{ctx: ctx, afterCommit: invalidate})
+	}
+}
+
+// InvalidateCache drops the cached results of the models' tables, e.g. after CustomMutate
+func (s *PostgreSQLConnector) InvalidateCache(ctx context.Context, modelsOrTableNames ...interface{}) {
+	for _, modelOrTableName := range modelsOrTableNames {
+		s.invalidateCache(ctx, nil, s.tableNameFromModelOrName(modelOrTableName))
+	}
+}
+
+type cacheEntry struct {
+	table   string
+	value   interface{}
+	expires time.Time
+}
+
+// MemoryCache is an in-process Cache with per-entry TTLs. Cached values are
+// shallow copies, so pointer fields are shared between hits.
+type MemoryCache struct {
+	// Clock provides the time entries expire by, defaults to the system clock
+	Clock Clock
+	// StaleTTL keeps entries this long after they expired for GetStale
+	StaleTTL time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	tables    map[string]map[string]struct{}
+	nextSweep time.Time
+}
+
+// NewMemoryCache creates an empty MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries: make(map[string]cacheEntry),
+		tables:  make(map[string]map[string]struct{}),
+	}
+}
+
+func (c *MemoryCache) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock.Now()
+	}
+	return systemClock{}.Now()
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now := c.now(); !now.Before(entry.expires) {
+		if !now.Before(entry.expires.Add(c.StaleTTL)) {
+			c.remove(key, entry.table)
+		}
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// GetStale returns an entry also after it expired, until StaleTTL passed
+func (c *MemoryCache) GetStale(ctx context.Context, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires.Add(c.StaleTTL)) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *MemoryCache) Set(ctx context.Context, table string, key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.After(c.nextSweep) {
+		// Drop expired entries that were never read again
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires.Add(c.StaleTTL)) {
+				c.remove(k, entry.table)
+			}
+		}
+		c.nextSweep = now.Add(time.Minute)
+	}
+	c.entries[key] = cacheEntry{table: table, value: value, expires: now.Add(ttl)}
+	if c.tables[table] == nil {
+		c.tables[table] = make(map[string]struct{})
+	}
+	c.tables[table][key] = struct{}{}
+}
+
+func (c *MemoryCache) Invalidate(ctx context.Context, table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.tables[table] {
+		delete(c.entries, key)
+	}
+	delete(c.tables, table)
+}
+
+// Clear drops all entries
+func (c *MemoryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.tables = make(map[string]map[string]struct{})
+}
+
+// Len returns the number of cached entries, including expired ones not yet dropped
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *MemoryCache) remove(key, table string) {
+	delete(c.entries, key)
+	delete(c.tables[table], key)
+}
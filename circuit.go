@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrCircuitOpen is returned without querying the database while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker fails reads fast with ErrCircuitOpen when too many of them fail
+// or are slow, giving the database room to recover. Only failures of the
+// database count, i.e. connection errors, timeouts and the SQLSTATE classes 08
+// (connection exception), 53 (insufficient resources) and 57 (operator
+// intervention), not errors of the statement itself such as syntax errors. After OpenDuration a single
+// trial read is let through, closing the circuit again when it succeeds.
+type CircuitBreaker struct {
+	// FailureRatio is the share of failed or slow reads within Window that opens the circuit
+	FailureRatio float64
+	// MinRequests avoids opening the circuit on a handful of reads
+	MinRequests int
+	// Window is the period over which reads are counted
+	Window time.Duration
+	// SlowCallDuration counts reads taking longer as failures, zero disables it
+	SlowCallDuration time.Duration
+	// OpenDuration is how long the circuit stays open before a trial read
+	OpenDuration time.Duration
+	// ServeStale answers FindFirst and FindAll reads rejected while the circuit is
+	// open with expired results of a Cache implementing StaleCache, when it has them
+	ServeStale bool
+
+	mu          sync.Mutex
+	open        bool
+	probing     bool
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+}
+
+// NewCircuitBreaker creates a circuit breaker opening at 50% failures of at least
+// 20 reads within 10 seconds, and retrying after 30 seconds
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureRatio: 0.5,
+		MinRequests:  20,
+		Window:       10 * time.Second,
+		OpenDuration: 30 * time.Second,
+	}
+}
+
+// IsOpen reports whether reads are currently rejected
+func (b *CircuitBreaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// allow returns ErrCircuitOpen while the circuit is open and no trial read is due
+func (b *CircuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	if b.probing || now.Sub(b.openedAt) < b.OpenDuration {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a read and opens or closes the circuit accordingly
+func (b *CircuitBreaker) record(now time.Time, elapsed time.Duration, err error) {
+	failed := isDatabaseFailure(err) || (b.SlowCallDuration > 0 && elapsed > b.SlowCallDuration)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		if !b.probing {
+			return
+		}
+		// Outcome of the trial read
+		b.probing = false
+		if failed {
+			b.openedAt = now
+			return
+		}
+		b.open = false
+		b.windowStart, b.requests, b.failures = now, 0, 0
+		return
+	}
+
+	if now.Sub(b.windowStart) > b.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.MinRequests && float64(b.failures)/float64(b.requests) >= b.FailureRatio {
+		b.open = true
+		b.openedAt = now
+	}
+}
+
+// isDatabaseFailure reports whether err indicates an unavailable or overloaded
+// database rather than a faulty statement
+func isDatabaseFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+			return true
+		}
+	}
+	return false
+}
+
+// guardRead runs a read through the connector CircuitBreaker, if any
+func (s *PostgreSQLConnector) guardRead(read func() error) error {
+	breaker := s.CircuitBreaker
+	if breaker == nil {
+		return read()
+	}
+	if err := breaker.allow(s.now()); err != nil {
+		return err
+	}
+	start := s.now()
+	err := read()
+	now := s.now()
+	breaker.record(now, now.Sub(start), err)
+	return err
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -18,9 +21,49 @@ func processOptions(opts []Option) *Config {
 	for _, opt := range opts {
 		opt(config)
 	}
+	if config.timeout > 0 {
+		config.ctx, config.cancel = context.WithTimeout(config.ctx, config.timeout)
+	}
+	if config.dryRun != nil {
+		config.ctx = context.WithValue(config.ctx, dryRunKey{}, config.dryRun)
+	}
+	if config.txOptions != nil {
+		config.ctx = context.WithValue(config.ctx, txOptionsKey{}, config.txOptions)
+	}
 	return config
 }
 
+// splitModelsAndOptions separates Option values from a variadic list of models
+func splitModelsAndOptions(items []interface{}) ([]interface{}, []Option) {
+	var models []interface{}
+	var opts []Option
+	for _, item := range items {
+		switch v := item.(type) {
+		case Option:
+			opts = append(opts, v)
+		case func(*Config):
+			opts = append(opts, v)
+		default:
+			models = append(models, item)
+		}
+	}
+	return models, opts
+}
+
+// optionsToItems converts options for a variadic list of models
+func optionsToItems(opts []Option) []interface{} {
+	items := make([]interface{}, len(opts))
+	for i, opt := range opts {
+		items[i] = opt
+	}
+	return items
+}
+
+// processJoinOptions processes options for join methods, which take the context as an argument
+func processJoinOptions(ctx context.Context, opts []Option) *Config {
+	return processOptions(append([]Option{WithContext(ctx)}, opts...))
+}
+
 type PostgreSQLConnector struct {
 	Host        string  `json:"host"`
 	Port        string  `json:"port"`
@@ -30,73 +73,336 @@ type PostgreSQLConnector struct {
 	SSLMode     string  `json:"sslmode"` // options: verify-full, verify-ca, disable
 	db          *sql.DB // db connection
 	TablePrefix string
+	// NamingStrategy derives table names and omitted column names, defaults to DefaultNaming
+	NamingStrategy NamingStrategy `json:"-"`
+	// TimestampTZ creates time.Time columns as TIMESTAMPTZ instead of TIMESTAMP
+	TimestampTZ bool `json:"-"`
+	// Location converts the times scanned into models, e.g. time.UTC, nil keeps
+	// the location returned by the driver
+	Location *time.Location `json:"-"`
+	// AutoColumns maps exported fields without gpo tag to columns named by the
+	// NamingStrategy, fields tagged gpo:"-" are skipped
+	AutoColumns bool `json:"-"`
+	// KeyGeneration generates the default id column of models without a primary
+	// key field, models can choose their own with KeyGenerator
+	KeyGeneration KeyGeneration `json:"-"`
+	// SnowflakeNode is the node number (0 to MaxSnowflakeNode) of the Snowflake
+	// primary keys generated on insert, processes sharing a table need distinct ones
+	SnowflakeNode int `json:"-"`
+	// PolymorphicTypes are the models Preload resolves interface typed
+	// polymorphic relations to, matched by their table name without prefix
+	PolymorphicTypes []interface{} `json:"-"`
+	// ConstraintNaming names the constraints added by MigrateTable, defaults to DefaultConstraintName
+	ConstraintNaming ConstraintNaming `json:"-"`
+	// DestructiveMigrations decides whether MigrateTable drops columns and narrows
+	// column types, by default it refuses to
+	DestructiveMigrations DestructiveMode `json:"-"`
+	// ConnectTimeout is the maximum wait for a connection in seconds, zero waits indefinitely
+	ConnectTimeout int `json:"connect_timeout,omitempty"`
+	// ApplicationName is reported to the server, e.g. in pg_stat_activity
+	ApplicationName string `json:"application_name,omitempty"`
+	// Logger receives advisory messages, defaults to the standard logger
+	Logger Logger `json:"-"`
+	// VacuumThresholds overrides DefaultVacuumThresholds for VacuumAdvisory
+	VacuumThresholds *VacuumThresholds `json:"-"`
+	// Clock provides timestamps written by the ORM, defaults to the system clock
+	Clock Clock `json:"-"`
+	// Replicas receive reads (FindFirst, FindAll, joins) outside of transactions,
+	// writes always go to this connector
+	Replicas       []PostgreSQLConnector `json:"replicas,omitempty"`
+	replicaCounter *uint64
+	// RetryPolicy retries transient failures of reads outside of transactions
+	// and of WithinTransaction, nil disables retries
+	RetryPolicy *RetryPolicy `json:"-"`
+	// PageTokenSecret signs the page tokens issued by FindPage
+	PageTokenSecret []byte `json:"-"`
+	// DefaultStatementTimeout makes the server cancel any statement running longer, zero disables it
+	DefaultStatementTimeout time.Duration `json:"-"`
+	// Translator localizes validation and constraint error messages by the
+	// context locale (see WithLocale), defaults to DefaultMessages
+	Translator Translator `json:"-"`
+	// CircuitBreaker fails reads outside of transactions fast while the database is struggling
+	CircuitBreaker *CircuitBreaker `json:"-"`
+	// CredentialProvider supplies User and Password for every new connection,
+	// e.g. short-lived IAM tokens or Vault dynamic credentials
+	CredentialProvider CredentialProvider `json:"-"`
+	// NoPreparedStatements executes statements without preparing them first and
+	// connects with binary_parameters, which is required behind pgbouncer in
+	// transaction pooling mode
+	NoPreparedStatements bool `json:"no_prepared_statements,omitempty"`
+	// OnReconnect is called after Reconnect replaced the connection pool, e.g. to invalidate caches
+	OnReconnect func() `json:"-"`
+	// SlowQueryThreshold is the duration above which statements are reported to OnSlowQuery
+	SlowQueryThreshold time.Duration `json:"-"`
+	// OnSlowQuery receives statements running longer than SlowQueryThreshold. With
+	// ExplainSlowQueries it is called from a separate goroutine once the plan is known.
+	OnSlowQuery func(SlowQuery) `json:"-"`
+	// ExplainSlowQueries adds the EXPLAIN output of slow statements to OnSlowQuery
+	ExplainSlowQueries bool `json:"-"`
+	// Cache stores FindFirst/FindAll results of tables with a cache TTL, see CacheTTLs
+	Cache Cache `json:"-"`
+	// DefaultCacheTTL applies to tables without a TTL in CacheTTLs or CachedModel, zero disables caching
+	DefaultCacheTTL time.Duration `json:"-"`
+	// CacheTTLs declares the cache TTL per table name without prefix, zero never caches the table
+	CacheTTLs map[string]time.Duration `json:"-"`
+	// AuditLog records every InsertModel, UpdateModel and DeleteModel in AuditLogTable,
+	// in the same transaction as the mutation, see AuditTrail
+	AuditLog bool `json:"-"`
+	// Recorder, when set, captures the executed statements, see SQLRecorder
+	Recorder *SQLRecorder `json:"-"`
+	// TrackQueries remembers the distinct statements executed by the connector,
+	// so TopQueries can tell them from statements of other clients
+	TrackQueries bool `json:"-"`
+	// live holds the current connection pool, shared by copies of the connector
+	live *liveConnection
+	// events holds the OnCommit listeners and the work waiting for commits, shared
+	// by copies of the connector
+	events *eventBus
+	// extensions are the extensions found by DetectExtensions, guarded by extensionsMu
+	extensions *extensionSet
+	// middleware wraps every statement, see Use
+	middleware []Middleware
+	// scopes are the default scopes per model type, global ones under nil, see AddScope
+	scopes map[reflect.Type][]Scope
+	// tableNames are the tables of the TableNamer models passed to RegisterModels
+	tableNames map[string]bool
+	// queries holds the statements remembered with TrackQueries
+	queries *queryRegistry
 }
 
 func (s *PostgreSQLConnector) getConnectionString() string {
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		s.Host,
-		s.Port,
-		s.User,
-		s.Password,
-		s.Database,
-		s.SSLMode,
+	return s.connectionString(s.User, s.Password)
+}
+
+// connectionString builds the connection string with the given login
+func (s *PostgreSQLConnector) connectionString(user string, password string) string {
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quoteConnValue(s.Host),
+		quoteConnValue(s.Port),
+		quoteConnValue(user),
+		quoteConnValue(password),
+		quoteConnValue(s.Database),
+		quoteConnValue(s.SSLMode),
 	)
+	if s.ConnectTimeout > 0 {
+		connStr += fmt.Sprintf(" connect_timeout=%d", s.ConnectTimeout)
+	}
+	if s.ApplicationName != "" {
+		connStr += " application_name=" + quoteConnValue(s.ApplicationName)
+	}
+	if s.DefaultStatementTimeout > 0 {
+		// Sent as a run-time parameter, the server cancels statements running longer
+		connStr += fmt.Sprintf(" statement_timeout=%d", s.DefaultStatementTimeout.Milliseconds())
+	}
+	if s.NoPreparedStatements {
+		// lib/pq then sends parameterized statements in a single round trip
+		// with the unnamed statement instead of preparing them separately
+		connStr += " binary_parameters=yes"
+	}
+	return connStr
+}
+
+// quoteConnValue quotes a connection string value so that empty values and
+// values with spaces, quotes or backslashes keep their meaning
+func quoteConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
 }
 
 func (s *PostgreSQLConnector) CloseConnection() {
-	if s.db != nil {
-		s.db.Close()
+	if db := s.GetConnection(); db != nil {
+		db.Close()
+	}
+	for i := range s.Replicas {
+		s.Replicas[i].CloseConnection()
+	}
+}
+
+// open creates a connection pool with the connector settings
+func (s *PostgreSQLConnector) open() (*sql.DB, error) {
+	if s.CredentialProvider != nil {
+		return sql.OpenDB(&credentialConnector{s: s, provider: s.CredentialProvider}), nil
 	}
+	return sql.Open("postgres", s.getConnectionString())
 }
 
 func (s *PostgreSQLConnector) Connect() (err error) {
-	s.db, err = sql.Open("postgres", s.getConnectionString())
-	return err
+	s.db, err = s.open()
+	if err != nil {
+		return err
+	}
+	s.live = &liveConnection{db: s.db}
+	s.queries = &queryRegistry{}
+	s.initEventBus()
+	for i := range s.Replicas {
+		if err = s.Replicas[i].Connect(); err != nil {
+			return fmt.Errorf("error connecting replica %s: %v", s.Replicas[i].Host, err)
+		}
+	}
+	s.replicaCounter = new(uint64)
+	return nil
+}
+
+// ConnectWithDB uses an already opened connection pool instead of connecting, e.g.
+// one opened with another driver or the FakeConnector of the dbtest package.
+// Replicas are not connected.
+func (s *PostgreSQLConnector) ConnectWithDB(db *sql.DB) {
+	s.db = db
+	s.live = &liveConnection{db: db}
+	s.queries = &queryRegistry{}
+	s.initEventBus()
+	s.replicaCounter = new(uint64)
 }
 
 func (s *PostgreSQLConnector) Close() error {
-	return s.db.Close()
+	for i := range s.Replicas {
+		if err := s.Replicas[i].Close(); err != nil {
+			return err
+		}
+	}
+	return s.GetConnection().Close()
 }
 
 func (s *PostgreSQLConnector) GetConnection() *sql.DB {
+	if s.live != nil {
+		return s.live.get()
+	}
 	return s.db
 }
 
-func (s *PostgreSQLConnector) Ping() error {
+func (s *PostgreSQLConnector) Ping(opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
 	db := s.GetConnection()
-	return db.Ping()
+	return db.PingContext(config.ctx)
 }
 
-func (s *PostgreSQLConnector) CreateDatabase(dbName string) error {
+func (s *PostgreSQLConnector) CreateDatabase(dbName string, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
 	db := s.GetConnection()
 	// Check if the database exists
 	var exists bool
-	db.QueryRow("SELECT 1 FROM pg_database WHERE datname=$1", dbName).Scan(&exists)
+	db.QueryRowContext(config.ctx, "SELECT 1 FROM pg_database WHERE datname=$1", dbName).Scan(&exists)
 	if exists {
 		return nil
 	}
 
 	// If not, create it
-	_, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+	_, err := db.ExecContext(config.ctx, fmt.Sprintf("CREATE DATABASE %s", dbName))
 	return err
 }
 
 // CreateTable creates a single table in the database for the given model
-func (s *PostgreSQLConnector) CreateTable(model interface{}) error {
-	tableName := getTableNameFromModel(s.TablePrefix, model)
-	columns, foreignKeys := getColumnsAndForeignKeysFromStructWithPrefix(model, s.TablePrefix)
-	table := Table{Name: tableName, Columns: columns, ForeignKeys: foreignKeys}
-	db := s.GetConnection()
-	return _createTable(db, table)
+func (s *PostgreSQLConnector) CreateTable(model interface{}, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	table := s.tableDefinition(model, config.related)
+	s.warnUnsignedColumns(table.Name, model)
+	var db execer = s.GetConnection()
+	if config.tx != nil {
+		db = config.tx
+	}
+	db = s.observed(db)
+	if config.dryRun != nil {
+		db = config.dryRun
+	}
+	return _createTable(config.ctx, db, table)
+}
+
+// RegisterModels makes the TableName of TableNamer models known to fk(...)
+// declarations of other models, which then reference these tables without
+// TablePrefix. Models passed to the same CreateTables or MigrateTables call need
+// no registration. Register models while setting up the connector, before it
+// runs statements.
+func (s *PostgreSQLConnector) RegisterModels(models ...interface{}) {
+	if s.tableNames == nil {
+		s.tableNames = make(map[string]bool)
+	}
+	for _, model := range models {
+		if name := modelMetadataOf(model, nil).tableName; name != "" {
+			s.tableNames[name] = true
+		}
+	}
+}
+
+// referencedTable returns the table an fk(...) declaration of model references:
+// the TableName of the model itself, of one of the related models or of a
+// registered model as is, other tables with TablePrefix
+func (s *PostgreSQLConnector) referencedTable(table string, model interface{}, related []interface{}) string {
+	if s.tableNames[table] {
+		return table
+	}
+	for _, candidate := range append([]interface{}{model}, related...) {
+		if modelMetadataOf(candidate, nil).tableName == table {
+			return table
+		}
+	}
+	return s.TablePrefix + table
+}
+
+// tableDefinition returns the table declared by the model, fk(...) declarations
+// may reference the TableName of the related models
+func (s *PostgreSQLConnector) tableDefinition(model interface{}, related []interface{}) Table {
+	tableName := s.tableName(model)
+	columns, foreignKeys := columnsAndForeignKeys(model, s.naming(), func(table string) string {
+		return s.referencedTable(table, model, related)
+	})
+	if s.TimestampTZ {
+		for i := range columns {
+			if columns[i].Type == "TIMESTAMP" {
+				columns[i].Type = "TIMESTAMPTZ"
+			}
+		}
+	}
+	if s.unavailableExtension("citext") {
+		// Fallback for servers without citext, the columns compare case-sensitively
+		for i := range columns {
+			if strings.EqualFold(columns[i].Type, "CITEXT") {
+				columns[i].Type = "TEXT"
+			}
+		}
+	}
+	if hasDefaultID(model) && s.keyGeneration(model) == ServerUUID {
+		columns[0].Default = "gen_random_uuid()"
+	}
+	table := Table{Name: tableName, Columns: columns, ForeignKeys: foreignKeys, Indexes: getIndexesFromStruct(model, s.naming())}
+	for i := range table.Indexes {
+		// Index names are unique per schema, the tag name only groups the columns
+		table.Indexes[i].Name = s.constraintName(unqualifiedName(tableName), table.Indexes[i].Columns, "idx")
+	}
+	if commenter, ok := model.(TableCommenter); ok {
+		table.Comment = commenter.TableComment()
+	}
+	if partitioned, ok := model.(PartitionedModel); ok {
+		partitioning := partitioned.Partitioning()
+		table.Partitioning = &partitioning
+	}
+	table.History = keepsHistory(model)
+	return table
 }
 
-func (s *PostgreSQLConnector) DropTable(modelOrTableName interface{}, cascade bool) error {
+// warnUnsignedColumns logs the uint and uint64 columns of a model, which are stored
+// as BIGINT so values above math.MaxInt64 cannot be written
+func (s *PostgreSQLConnector) warnUnsignedColumns(tableName string, model interface{}) {
+	t := indirectType(model)
+	for _, field := range metadataOf(t, s.naming()).fields {
+		kind := t.Field(field.index).Type.Kind()
+		if (kind == reflect.Uint || kind == reflect.Uint64) && field.tag.Type == "" {
+			s.logger().Printf("gpo: column %s.%s stores %s as BIGINT, values above %d cannot be written",
+				tableName, field.tag.ColumnName, kind, int64(math.MaxInt64))
+		}
+	}
+}
+
+func (s *PostgreSQLConnector) DropTable(modelOrTableName interface{}, cascade bool, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
 	var tableName string
 	switch v := modelOrTableName.(type) {
 	case string:
 		tableName = v
 	default:
-		tableName = getTableNameFromModel(s.TablePrefix, v)
+		tableName = s.tableName(v)
 	}
 
 	sql := fmt.Sprintf("DROP TABLE %s", tableName)
@@ -105,25 +411,86 @@ func (s *PostgreSQLConnector) DropTable(modelOrTableName interface{}, cascade bo
 		sql += " CASCADE"
 	}
 
-	db := s.GetConnection()
-	_, err := db.Exec(sql)
+	db := s.observed(s.GetConnection())
+	if config.dryRun != nil {
+		db = config.dryRun
+	}
+	_, err := db.ExecContext(config.ctx, sql)
 	return err
 }
 
-// CreateTables creates tables in the database for the given models (table names are populated from the struct names)
+// dropOrder returns the models in reverse dependency order followed by the table
+// names. With circular references the models keep their order, CASCADE drops
+// the constraints between them.
+func (s *PostgreSQLConnector) dropOrder(modelsOrTableNames []interface{}) []interface{} {
+	var models, names []interface{}
+	for _, modelOrTableName := range modelsOrTableNames {
+		if _, ok := modelOrTableName.(string); ok {
+			names = append(names, modelOrTableName)
+		} else {
+			models = append(models, modelOrTableName)
+		}
+	}
+	if sorted, err := sortModelsByDependencies(models, s.naming()); err == nil {
+		for i := range sorted {
+			models[len(sorted)-1-i] = sorted[i]
+		}
+	}
+	return append(models, names...)
+}
+
+// CreateTables creates tables in the database for the given models (table names are populated from the struct names).
+// Models are created in foreign key dependency order, so they can be passed in any order.
+// All tables are created in one transaction, so a failure leaves none of them behind.
+// Option values in the list, e.g. WithContext or WithTransaction, apply to every statement.
+// WithSeedData also upserts the SeedData of the models implementing Seeder.
 func (s *PostgreSQLConnector) CreateTables(models ...interface{}) error {
-	for _, model := range models {
-		err := s.CreateTable(model)
-		if err != nil {
-			return err
+	models, opts := splitModelsAndOptions(models)
+	models, err := sortModelsByDependencies(models, s.naming())
+	if err != nil {
+		return err
+	}
+	config := processOptions(opts)
+	defer config.release()
+	return s.ddlTransaction(config.ctx, config.tx, s.GetConnection().BeginTx, func(tx *sql.Tx) error {
+		txOpts := append(opts[:len(opts):len(opts)], WithTransaction(tx), withRelatedModels(models))
+		for _, model := range models {
+			if err := s.CreateTable(model, txOpts...); err != nil {
+				return err
+			}
 		}
+		if config.seed {
+			return s.seedTables(config.ctx, tx, models)
+		}
+		return nil
+	})
+}
+
+// ddlTransaction runs fn in tx, or when it is nil in a new transaction started
+// with begin that is committed with CommitTx when fn succeeds and rolled back
+// with RollbackTx otherwise. A dry run begins no transaction.
+func (s *PostgreSQLConnector) ddlTransaction(ctx context.Context, tx *sql.Tx, begin func(context.Context, *sql.TxOptions) (*sql.Tx, error), fn func(tx *sql.Tx) error) error {
+	if tx != nil || dryRunOf(ctx) != nil {
+		return fn(tx)
 	}
-	return nil
+	tx, err := begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		s.RollbackTx(tx)
+		return err
+	}
+	return s.CommitTx(tx)
 }
 
+// DropTables drops the tables of the given models or table names with CASCADE.
+// Models are dropped in reverse foreign key dependency order, before the tables
+// given by name. Option values in the list, e.g. WithContext, apply to every statement.
 func (s *PostgreSQLConnector) DropTables(modelsOrTableNames ...interface{}) error {
-	for _, modelOrTableName := range modelsOrTableNames {
-		err := s.DropTable(modelOrTableName, true) // true for CASCADE
+	modelsOrTableNames, opts := splitModelsAndOptions(modelsOrTableNames)
+	for _, modelOrTableName := range s.dropOrder(modelsOrTableNames) {
+		err := s.DropTable(modelOrTableName, true, opts...) // true for CASCADE
 		if err != nil {
 			return err
 		}
@@ -131,53 +498,166 @@ func (s *PostgreSQLConnector) DropTables(modelsOrTableNames ...interface{}) erro
 	return nil
 }
 
-func (s PostgreSQLConnector) insertWithTx(ctx context.Context, tx *sql.Tx, model interface{}) (err error) {
-	insertStmt := DatabaseInsert{
-		Table: getTableNameFromModel(s.TablePrefix, model),
+// TruncateTable empties the table of a model or a table name, see WithRestartIdentity and WithCascade
+func (s *PostgreSQLConnector) TruncateTable(modelOrTableName interface{}, opts ...Option) error {
+	return s.TruncateTables(append([]interface{}{modelOrTableName}, optionsToItems(opts)...)...)
+}
+
+// TruncateTables empties the tables of the given models or table names in a single statement.
+// Option values in the list, e.g. WithRestartIdentity or WithCascade, apply to the statement.
+func (s *PostgreSQLConnector) TruncateTables(modelsOrTableNames ...interface{}) error {
+	modelsOrTableNames, opts := splitModelsAndOptions(modelsOrTableNames)
+	if len(modelsOrTableNames) == 0 {
+		return fmt.Errorf("no tables to truncate")
+	}
+	config := processOptions(opts)
+	defer config.release()
+
+	tables := make([]string, len(modelsOrTableNames))
+	for i, modelOrTableName := range modelsOrTableNames {
+		tables[i] = s.tableNameFromModelOrName(modelOrTableName)
+	}
+	query := "TRUNCATE TABLE " + strings.Join(tables, ", ")
+	if config.restartIdentity {
+		query += " RESTART IDENTITY"
+	}
+	if config.cascade {
+		query += " CASCADE"
+	}
+	if _, err := s.execStatement(config.ctx, config.tx, query); err != nil {
+		return err
 	}
-	parseTags(model, &insertStmt.Fields)
-	q, args, err := buildInsertStmt(&insertStmt, model)
+	s.invalidateCache(config.ctx, config.tx, tables...)
+	for _, table := range tables {
+		s.emit(config.ctx, config.tx, ChangeEvent{Table: table, Operation: "truncate"})
+	}
+	return nil
+}
+
+// ResetDatabase empties the tables of the models with a single TRUNCATE ... RESTART IDENTITY,
+// e.g. between tests. With WithRecreate the tables are dropped and created again in
+// foreign key dependency order instead, e.g. after the models changed; like
+// CreateTables this does not run in a transaction given with WithTransaction.
+func (s *PostgreSQLConnector) ResetDatabase(modelsAndOptions ...interface{}) error {
+	models, opts := splitModelsAndOptions(modelsAndOptions)
+	models, err := sortModelsByDependencies(models, s.naming())
 	if err != nil {
-		return
+		return err
+	}
+	if len(models) == 0 {
+		return fmt.Errorf("no tables to reset")
+	}
+	config := processOptions(opts)
+	defer config.release()
+	if !config.recreate {
+		return s.TruncateTables(append(models, WithContext(config.ctx), WithTransaction(config.tx), WithRestartIdentity())...)
+	}
+
+	tables := make([]string, len(models))
+	for i, model := range models {
+		tables[len(models)-1-i] = s.tableName(model)
+	}
+	if _, err := s.execStatement(config.ctx, nil, "DROP TABLE IF EXISTS "+strings.Join(tables, ", ")+" CASCADE"); err != nil {
+		return err
 	}
+	s.invalidateCache(config.ctx, nil, tables...)
+	return s.CreateTables(append(models, WithContext(config.ctx))...)
+}
 
-	// Prepare the query
-	stmt, err := prepareStatement(ctx, tx, s.GetConnection(), q)
+func (s PostgreSQLConnector) insertWithTx(ctx context.Context, tx *sql.Tx, model interface{}) (err error) {
+	if err = s.generatePrimaryKey(model); err != nil {
+		return
+	}
+	insertStmt := DatabaseInsert{
+		Table: s.tableName(model),
+		key:   s.generateKey(model),
+	}
+	insertStmt.Fields = writableColumns(model, s.naming())
+	q, args, err := buildInsertStmt(&insertStmt, model, s.naming())
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
+
 	// Execute the query
-	_, err = stmt.ExecContext(ctx, args...)
+	if _, err = s.execStatement(ctx, tx, q, args...); err == nil {
+		s.invalidateCache(ctx, tx, insertStmt.Table)
+		primaryKey := modelPrimaryKey(model)
+		if insertStmt.key != nil {
+			primaryKey = insertStmt.key
+		}
+		s.emit(ctx, tx, ChangeEvent{Table: insertStmt.Table, Operation: "insert", Model: model, PrimaryKey: primaryKey})
+	}
 	return
 }
 
 func (s PostgreSQLConnector) CustomMutate(ctx context.Context, transactionOrNil *sql.Tx, query string, args ...interface{}) (result *sql.Result, err error) {
-	stmt, err := prepareStatement(ctx, transactionOrNil, s.GetConnection(), query)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
 	// Execute the query
-	res, err := stmt.ExecContext(ctx, args...)
+	res, err := s.execStatement(ctx, transactionOrNil, query, args...)
 	return &res, err
 }
 
 func (s PostgreSQLConnector) CustomQuery(ctx context.Context, transactionOrNil *sql.Tx, query string, args ...interface{}) (rows *sql.Rows, err error) {
-	stmt, err := prepareStatement(ctx, transactionOrNil, s.GetConnection(), query)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
 	// Perform a query
-	rows, err = stmt.QueryContext(ctx, args...)
+	rows, err = s.queryStatement(ctx, transactionOrNil, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
-func (s PostgreSQLConnector) first(ctx context.Context, tx *sql.Tx, model interface{}, conditionOrId interface{}) error {
+// ScanRows scans the rows, e.g. of CustomQuery, into models, a pointer to a slice
+// of models, and closes them. Columns are mapped to fields by the gpo tags like in
+// FindAll, columns without field are discarded.
+func (s PostgreSQLConnector) ScanRows(rows *sql.Rows, models interface{}) error {
+	defer rows.Close()
+	val := reflect.ValueOf(models)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice || val.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error handling %T: models must be a pointer to a slice of structs", models)
+	}
+	elementType := val.Elem().Type().Elem()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	scanner := s.scannerFor(columns, metadataOf(elementType, s.naming()).fieldMap, elementType)
+	slice := val.Elem()
+	zero := reflect.Zero(elementType)
+	for rows.Next() {
+		slice.Set(reflect.Append(slice, zero))
+		if err := scanner.scan(rows, slice.Index(slice.Len()-1)); err != nil {
+			slice.SetLen(slice.Len() - 1)
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+	}
+	return rows.Err()
+}
+
+// ScanRow scans the first of the rows into model, a pointer to a model, like
+// ScanRows and closes them. It returns sql.ErrNoRows when there is no row.
+func (s PostgreSQLConnector) ScanRow(rows *sql.Rows, model interface{}) error {
+	defer rows.Close()
+	val := reflect.ValueOf(model)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error handling %T: model must be a pointer to a struct", model)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	t := val.Elem().Type()
+	if err := s.scannerFor(columns, metadataOf(t, s.naming()).fieldMap, t).scan(rows, val.Elem()); err != nil {
+		return fmt.Errorf("error scanning row: %v", err)
+	}
+	return rows.Err()
+}
+
+func (s PostgreSQLConnector) first(config *Config, model interface{}, conditionOrId interface{}) error {
 	if conditionOrId == nil {
 		return fmt.Errorf("conditionOrId cannot be nil")
 	}
@@ -186,31 +666,51 @@ func (s PostgreSQLConnector) first(ctx context.Context, tx *sql.Tx, model interf
 	case []Condition:
 		condition = v
 	default:
-		condition = createPrimaryKeyCondition(model, v)
+		if !isExample(v) {
+			condition = createPrimaryKeyCondition(model, s.naming(), v)
+			break
+		}
+		if condition = exampleConditions(v, s.naming()); len(condition) == 0 {
+			return fmt.Errorf("example %T has no non-zero fields", v)
+		}
 	}
 	var queryProps DatabaseQuery
-	queryProps.Table = getTableNameFromModel(s.TablePrefix, model)
+	queryProps.Table = s.tableName(model)
 	queryProps.Conditions = condition
+	queryProps = *s.scopedQuery(config, indirectType(model), &queryProps)
 	queryProps.Limit = 1
-	fieldMap := parseTags(model, &queryProps.fields)
-	rows, err := s.executeQuery(ctx, tx, &queryProps)
+	fieldMap := parseTags(model, s.naming(), &queryProps.fields)
+	q, args := s.buildReadQuery(config, &queryProps)
+	val := reflect.ValueOf(model).Elem()
+	key, ttl := s.cacheKey(config, model, q, args)
+	if s.cachedRead(config, key, val) {
+		return nil
+	}
+	rows, err := s.readRows(config, q, args...)
 	if err != nil {
+		if s.staleRead(config, key, val, err) {
+			return nil
+		}
 		return fmt.Errorf("error querying database: %v", err)
 	}
 	defer rows.Close()
 	if rows.Next() {
 		columns, _ := rows.Columns()
-		val := reflect.ValueOf(model).Elem()
-		scanArgs := scanRowToModel(columns, fieldMap, val)
-		err = rows.Scan(scanArgs...)
+		err = s.scannerFor(columns, fieldMap, val.Type()).scan(rows, val)
 		if err != nil {
 			return fmt.Errorf("error scanning row: %v", err)
 		}
+		s.cacheRead(config, key, ttl, queryProps.Table, val)
+	} else if config.requireRow {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error querying database: %v", err)
+		}
+		return sql.ErrNoRows
 	}
 	return nil
 }
 
-func (s PostgreSQLConnector) all(ctx context.Context, tx *sql.Tx, models interface{}, queryProps *DatabaseQuery) error {
+func (s PostgreSQLConnector) all(config *Config, models interface{}, queryProps *DatabaseQuery) error {
 	// Ensure models is a pointer to a slice
 	val := reflect.ValueOf(models)
 	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
@@ -223,40 +723,76 @@ func (s PostgreSQLConnector) all(ctx context.Context, tx *sql.Tx, models interfa
 	// Create a new instance of the element type
 	modelInstance := reflect.New(elementType).Interface()
 
+	// Complete a copy of the query, the caller may reuse it
+	query := *queryProps
+	queryProps = &query
 	if queryProps.Table == "" {
-		queryProps.Table = getTableNameFromModel(s.TablePrefix, modelInstance)
+		queryProps.Table = s.tableName(modelInstance)
+	}
+	queryProps = s.scopedQuery(config, elementType, queryProps)
+	var fields Fields
+	fieldMap := parseTags(modelInstance, s.naming(), &fields)
+	queryProps.fields = fields
+	if len(queryProps.columns) > 0 {
+		// Restrict the selection to the projection columns
+		for _, column := range queryProps.columns {
+			if _, ok := fieldMap[column]; !ok {
+				return fmt.Errorf("unknown column %s in projection", column)
+			}
+		}
+		queryProps.fields = append(Fields{}, queryProps.columns...)
+	}
+	if len(queryProps.Aggregates) > 0 {
+		fields, err := aggregateFields(queryProps.fields, queryProps.Aggregates)
+		if err != nil {
+			return err
+		}
+		queryProps.fields = fields
+	}
+	q, args := s.buildReadQuery(config, queryProps)
+	key, ttl := s.cacheKey(config, models, q, args)
+	if s.cachedRead(config, key, val.Elem()) {
+		return nil
 	}
-	fieldMap := parseTags(modelInstance, &queryProps.fields)
-	rows, err := s.executeQuery(ctx, tx, queryProps)
+	rows, err := s.readRows(config, q, args...)
 	if err != nil {
+		if s.staleRead(config, key, val.Elem(), err) {
+			return nil
+		}
 		return fmt.Errorf("error querying database: %v", err)
 	}
 	defer rows.Close()
 	columns, _ := rows.Columns()
+	slice := val.Elem()
+	start := slice.Len()
+	scanner := s.scannerFor(columns, fieldMap, elementType)
+	zero := reflect.Zero(elementType)
 
-	// scan rows into "models" slice
+	// scan rows directly into new elements of the "models" slice
 	for rows.Next() {
-		modelType := reflect.TypeOf(modelInstance)
-		if modelType.Kind() == reflect.Ptr {
-			modelType = modelType.Elem()
-		}
-		modelVal := reflect.New(modelType)
-		scanArgs := scanRowToModel(columns, fieldMap, modelVal.Elem())
-		err = rows.Scan(scanArgs...)
+		slice.Set(reflect.Append(slice, zero))
+		err = scanner.scan(rows, slice.Index(slice.Len()-1))
 		if err != nil {
+			slice.SetLen(slice.Len() - 1)
 			return fmt.Errorf("error scanning row: %v", err)
 		}
-		val.Elem().Set(reflect.Append(val.Elem(), modelVal.Elem()))
+	}
+	if rows.Err() == nil {
+		s.cacheRead(config, key, ttl, queryProps.Table, val.Elem().Slice(start, val.Elem().Len()))
 	}
 	return nil
 }
 
 func (s PostgreSQLConnector) Query(ctx context.Context, model interface{}, queryProps *DatabaseQuery) ([]interface{}, error) {
+	query := *queryProps
+	queryProps = &query
 	if queryProps.Table == "" {
-		queryProps.Table = getTableNameFromModel(s.TablePrefix, model)
+		queryProps.Table = s.tableName(model)
 	}
-	fieldMap := parseTags(model, &queryProps.fields)
-	rows, err := s.executeQuery(ctx, nil, queryProps)
+	var fields Fields
+	fieldMap := parseTags(model, s.naming(), &fields)
+	queryProps.fields = fields
+	rows, err := s.executeQuery(&Config{ctx: ctx}, queryProps)
 	if err != nil {
 		return nil, fmt.Errorf("error querying database: %v", err)
 	}
@@ -264,10 +800,11 @@ func (s PostgreSQLConnector) Query(ctx context.Context, model interface{}, query
 
 	var results []interface{}
 	columns, _ := rows.Columns()
+	modelType := reflect.TypeOf(model).Elem()
+	scanner := s.scannerFor(columns, fieldMap, modelType)
 	for rows.Next() {
-		val := reflect.New(reflect.TypeOf(model).Elem())
-		scanArgs := scanRowToModel(columns, fieldMap, val.Elem())
-		err = rows.Scan(scanArgs...)
+		val := reflect.New(modelType)
+		err = scanner.scan(rows, val.Elem())
 		if err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
@@ -278,7 +815,7 @@ func (s PostgreSQLConnector) Query(ctx context.Context, model interface{}, query
 
 func (s PostgreSQLConnector) deleteWithTx(ctx context.Context, tx *sql.Tx, model interface{}, condition ...Condition) (int64, error) {
 	deleteStmt := DatabaseDelete{
-		Table:      getTableNameFromModel(s.TablePrefix, model),
+		Table:      s.tableName(model),
 		Conditions: condition,
 	}
 
@@ -286,28 +823,21 @@ func (s PostgreSQLConnector) deleteWithTx(ctx context.Context, tx *sql.Tx, model
 	qb := NewQueryBuilder()
 	qb.DeleteFrom(deleteStmt.Table)
 
-	// Add conditions using centralized logic
-	for _, cond := range deleteStmt.Conditions {
-		qb.Where(cond.Field, cond.Operator, cond.Value)
-	}
+	// Add conditions like buildQuery, they are not checked against the known operators of Where
+	qb.conditions = append(qb.conditions, deleteStmt.Conditions...)
 
 	query, args, err := qb.Build()
 	if err != nil {
 		return 0, fmt.Errorf("error building DELETE query: %v", err)
 	}
 
-	// Prepare the statement
-	stmt, err := prepareStatement(ctx, tx, s.GetConnection(), query)
-	if err != nil {
-		return 0, err
-	}
-	defer stmt.Close()
-
 	// Execute the delete statement
-	result, err := stmt.Exec(args...)
+	result, err := s.execStatement(ctx, tx, query, args...)
 	if err != nil {
 		return 0, err
 	}
+	s.invalidateCache(ctx, tx, deleteStmt.Table)
+	s.emit(ctx, tx, ChangeEvent{Table: deleteStmt.Table, Operation: "delete", Model: model, PrimaryKey: changedPrimaryKey(model, s.naming(), condition)})
 	affectedRows, err := result.RowsAffected()
 	if err != nil {
 		return 0, err
@@ -317,7 +847,7 @@ func (s PostgreSQLConnector) deleteWithTx(ctx context.Context, tx *sql.Tx, model
 
 func (s PostgreSQLConnector) updateWithTx(ctx context.Context, tx *sql.Tx, model interface{}, conditionsOrNil interface{}) (int64, error) {
 	updateStmt := DatabaseUpdate{
-		Table: getTableNameFromModel(s.TablePrefix, model),
+		Table: s.tableName(model),
 	}
 	if conditionsOrNil != nil {
 		switch v := conditionsOrNil.(type) {
@@ -327,86 +857,226 @@ func (s PostgreSQLConnector) updateWithTx(ctx context.Context, tx *sql.Tx, model
 			return 0, fmt.Errorf("conditionsOrNil must be a slice of Condition")
 		}
 	}
-	parseTags(model, &updateStmt.Fields)
+	parseTags(model, s.naming(), &updateStmt.Fields)
 	val := reflect.ValueOf(model)
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
 	}
-	t := val.Type()
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if isPrimaryKeyField(field) && len(updateStmt.Conditions) == 0 {
-			gpoField := parseGPOTag(field)
-			if gpoField != nil {
-				updateStmt.Conditions = append(updateStmt.Conditions, []Condition{
-					{
-						Field:    gpoField.ColumnName,
-						Operator: "=",
-						Value:    val.Field(i).Interface(),
-					},
-				}...)
-			}
-			break
-		}
+	if pk := metadataOf(val.Type(), s.naming()).primaryKey; pk != nil && len(updateStmt.Conditions) == 0 {
+		updateStmt.Conditions = append(updateStmt.Conditions, Condition{
+			Field:    pk.tag.ColumnName,
+			Operator: "=",
+			Value:    val.Field(pk.index).Interface(),
+		})
 	}
-	q, args, err := buildUpdateStmt(&updateStmt, model)
+	q, args, err := buildUpdateStmt(&updateStmt, model, s.naming())
 	if err != nil {
 		return 0, err
 	}
 
-	// Prepare the query
-	stmt, err := prepareStatement(ctx, tx, s.GetConnection(), q)
-	if err != nil {
-		return 0, err
-	}
-	defer stmt.Close()
-
 	// Execute the query
-	result, err := stmt.Exec(args...)
+	result, err := s.execStatement(ctx, tx, q, args...)
 	if err != nil {
 		return 0, err
 	}
+	s.invalidateCache(ctx, tx, updateStmt.Table)
+	s.emit(ctx, tx, ChangeEvent{Table: updateStmt.Table, Operation: "update", Model: model, PrimaryKey: changedPrimaryKey(model, s.naming(), updateStmt.Conditions)})
 	return result.RowsAffected()
 }
 
 // executeQuery executes a query with optional transaction support
-func (s *PostgreSQLConnector) executeQuery(ctx context.Context, tx *sql.Tx, queryProps *DatabaseQuery) (rows *sql.Rows, err error) {
-	var q string
-	var args []interface{}
-	if queryProps.AllowPagination || queryProps.AllowSearch {
-		q, args = buildAdvancedQuery(queryProps)
-	} else {
-		q, args = buildQuery(queryProps)
+func (s *PostgreSQLConnector) executeQuery(config *Config, queryProps *DatabaseQuery) (rows *sql.Rows, err error) {
+	q, args := s.buildReadQuery(config, queryProps)
+	return s.readRows(config, q, args...)
+}
+
+// buildReadQuery builds the SELECT statement of a query
+func (s *PostgreSQLConnector) buildReadQuery(config *Config, queryProps *DatabaseQuery) (string, []interface{}) {
+	props := *queryProps
+	props.Conditions = s.prepareConditions(config, queryProps.Conditions)
+	if props.AllowPagination || props.AllowSearch {
+		return buildAdvancedQuery(&props)
+	}
+	return buildQuery(&props)
+}
+
+// prepareConditions applies extension fallbacks and the large IN strategy to conditions of a read
+func (s *PostgreSQLConnector) prepareConditions(config *Config, conditions []Condition) []Condition {
+	return applyLargeInStrategy(s.applyExtensionFallbacks(conditions), config)
+}
+
+// readRows runs a read-only query. Outside of transactions it is load-balanced
+// across the connected replicas unless WithPrimary was given, retried according
+// to the RetryPolicy and guarded by the CircuitBreaker.
+func (s *PostgreSQLConnector) readRows(config *Config, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = s.runOperation(config.ctx, Operation{Kind: "query", SQL: query, Args: args, Tx: config.tx}, func(ctx context.Context, op Operation) (err error) {
+		defer s.observeQuery(s.now(), op.SQL, op.Args)
+		if config.tx != nil {
+			rows, err = config.tx.QueryContext(ctx, op.SQL, op.Args...)
+			return err
+		}
+		return s.guardRead(func() error {
+			return s.withRetry(ctx, func() error {
+				db := s.GetConnection()
+				if !config.primary {
+					if replica := s.nextReplica(); replica != nil {
+						db = replica
+					}
+				}
+				rows, err = db.QueryContext(ctx, op.SQL, op.Args...)
+				return err
+			})
+		})
+	})
+	return closeOnError(rows, err)
+}
+
+// nextReplica returns the next replica connection in round-robin order, or nil without replicas
+func (s *PostgreSQLConnector) nextReplica() *sql.DB {
+	if len(s.Replicas) == 0 || s.replicaCounter == nil {
+		return nil
+	}
+	n := atomic.AddUint64(s.replicaCounter, 1)
+	return s.Replicas[n%uint64(len(s.Replicas))].GetConnection()
+}
+
+// execStatement executes a statement in the transaction when given, otherwise on the
+// connection pool. It is prepared first unless NoPreparedStatements is set.
+func (s PostgreSQLConnector) execStatement(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if dryRun := dryRunOf(ctx); dryRun != nil {
+		return dryRun.ExecContext(ctx, query, args...)
+	}
+	var result sql.Result
+	err := s.runOperation(ctx, Operation{Kind: "exec", SQL: query, Args: args, Tx: tx}, func(ctx context.Context, op Operation) (err error) {
+		defer s.observeQuery(s.now(), op.SQL, op.Args)
+		if s.NoPreparedStatements {
+			if tx != nil {
+				result, err = tx.ExecContext(ctx, op.SQL, op.Args...)
+			} else {
+				result, err = s.GetConnection().ExecContext(ctx, op.SQL, op.Args...)
+			}
+			return err
+		}
+		stmt, err := prepareStatement(ctx, tx, s.GetConnection(), op.SQL)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		result, err = stmt.ExecContext(ctx, op.Args...)
+		return err
+	})
+	return result, err
+}
+
+// queryStatement is execStatement for queries returning rows
+func (s PostgreSQLConnector) queryStatement(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	if dryRun := dryRunOf(ctx); dryRun != nil {
+		dryRun.ExecContext(ctx, query, args...)
+		return nil, errPlanned
 	}
+	var rows *sql.Rows
+	err := s.runOperation(ctx, Operation{Kind: "query", SQL: query, Args: args, Tx: tx}, func(ctx context.Context, op Operation) (err error) {
+		defer s.observeQuery(s.now(), op.SQL, op.Args)
+		if s.NoPreparedStatements {
+			rows, err = s.queryRows(ctx, tx, op.SQL, op.Args...)
+			return err
+		}
+		stmt, err := prepareStatement(ctx, tx, s.GetConnection(), op.SQL)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		rows, err = stmt.QueryContext(ctx, op.Args...)
+		return err
+	})
+	return closeOnError(rows, err)
+}
 
+// closeOnError closes the rows of a query that middleware failed after it ran
+func closeOnError(rows *sql.Rows, err error) (*sql.Rows, error) {
+	if err != nil && rows != nil {
+		rows.Close()
+		return nil, err
+	}
+	return rows, err
+}
+
+// queryRows runs a query in the transaction when given, otherwise on the connection pool
+func (s *PostgreSQLConnector) queryRows(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
 	if tx != nil {
-		return tx.QueryContext(ctx, q, args...)
+		return tx.QueryContext(ctx, query, args...)
 	}
 
 	db := s.GetConnection()
-	return db.QueryContext(ctx, q, args...)
+	return db.QueryContext(ctx, query, args...)
 }
 
 func (s *PostgreSQLConnector) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return s.db.BeginTx(ctx, opts)
+	return s.GetConnection().BeginTx(ctx, opts)
 }
 
+type txOptionsKey struct{}
+
+// beginTx begins the transaction of an operation with the isolation level and
+// access mode of WithIsolation and WithReadOnly
+func (s *PostgreSQLConnector) beginTx(ctx context.Context) (*sql.Tx, error) {
+	opts, _ := ctx.Value(txOptionsKey{}).(*sql.TxOptions)
+	return s.BeginTx(ctx, opts)
+}
+
+// CommitTx commits the transaction and delivers its OnCommit events
 func (s *PostgreSQLConnector) CommitTx(tx *sql.Tx) error {
-	return tx.Commit()
+	bus := s.eventBus()
+	if bus == nil {
+		return tx.Commit()
+	}
+	// Take the events first, the ended transaction would otherwise drop them
+	events := bus.take(tx)
+	err := tx.Commit()
+	if err == nil {
+		bus.deliver(events)
+	}
+	return err
 }
 
+// RollbackTx rolls back the transaction and drops its OnCommit events
 func (s *PostgreSQLConnector) RollbackTx(tx *sql.Tx) error {
+	if bus := s.eventBus(); bus != nil {
+		bus.take(tx)
+	}
 	return tx.Rollback()
 }
 
-func (s *PostgreSQLConnector) join(ctx context.Context, props *JoinProps) ([]map[string]interface{}, error) {
+// SetConstraintsDeferred defers the checks of the deferrable constraints, see
+// the deferrable fk option, to the commit of the transaction. Rows referencing
+// each other can then be inserted in any order within the transaction.
+func (s *PostgreSQLConnector) SetConstraintsDeferred(tx *sql.Tx, opts ...Option) error {
+	if tx == nil {
+		return fmt.Errorf("SetConstraintsDeferred requires a transaction")
+	}
+	config := processOptions(opts)
+	defer config.release()
+	_, err := s.execStatement(config.ctx, tx, "SET CONSTRAINTS ALL DEFERRED")
+	return err
+}
+
+func (s *PostgreSQLConnector) join(config *Config, props *JoinProps) ([]map[string]interface{}, error) {
 	// Validate join type
 	if props.JoinType == "" {
 		return nil, fmt.Errorf("join type is required")
 	}
 
-	mainTableName := getTableNameFromModel(s.TablePrefix, props.MainTableModel)
-	joinTableName := getTableNameFromModel(s.TablePrefix, props.JoinTableModel)
+	// Derive the ON clause from fk tags when it was not given
+	if props.JoinCondition == "" {
+		condition, err := s.AutoJoin(props.MainTableModel, props.JoinTableModel)
+		if err != nil {
+			return nil, err
+		}
+		props.JoinCondition = condition
+	}
+
+	mainTableName := s.tableName(props.MainTableModel)
+	joinTableName := s.tableName(props.JoinTableModel)
 
 	// Build column selections with aliases to preserve table context
 	var selectParts []string
@@ -421,6 +1091,15 @@ func (s *PostgreSQLConnector) join(ctx context.Context, props *JoinProps) ([]map
 		selectParts = append(selectParts, fmt.Sprintf("%s.%s AS \"%s.%s\"", joinTableName, col, joinTableName, col))
 	}
 
+	// Add aggregate selections
+	for _, aggregate := range props.Aggregates {
+		aggregateSelect, err := buildAggregateSelect(aggregate)
+		if err != nil {
+			return nil, err
+		}
+		selectParts = append(selectParts, aggregateSelect)
+	}
+
 	// Build the SQL query with the specified join type
 	query := fmt.Sprintf("SELECT %s FROM %s %s %s ON %s",
 		strings.Join(selectParts, ", "),
@@ -430,20 +1109,22 @@ func (s *PostgreSQLConnector) join(ctx context.Context, props *JoinProps) ([]map
 		props.JoinCondition,
 	)
 
-	var args []interface{}
-	if len(props.WhereConditions) > 0 {
-		query += " WHERE "
-		for i, condition := range props.WhereConditions {
-			if i > 0 {
-				query += " AND "
-			}
-			query += fmt.Sprintf("%s %s $%d", condition.Field, condition.Operator, i+1)
-			args = append(args, condition.Value)
-		}
+	// Add WHERE conditions using centralized function
+	whereClause, args := buildConditions(s.prepareConditions(config, props.WhereConditions), nil)
+	if whereClause != "" {
+		query += " WHERE " + whereClause
 	}
 
-	db := s.GetConnection()
-	rows, err := db.QueryContext(ctx, query, args...)
+	// Add GROUP BY and HAVING
+	if len(props.GroupBy) > 0 {
+		query += " GROUP BY " + strings.Join(props.GroupBy, ", ")
+	}
+	havingClause, args := buildConditions(props.Having, args)
+	if havingClause != "" {
+		query += " HAVING " + havingClause
+	}
+
+	rows, err := s.readRows(config, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error executing join query: %v", err)
 	}
@@ -493,12 +1174,21 @@ func (s *PostgreSQLConnector) join(ctx context.Context, props *JoinProps) ([]map
 }
 
 // joinIntoStruct performs a join operation and scans results into a struct slice
-func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinResult) error {
+func (s *PostgreSQLConnector) joinIntoStruct(config *Config, props *JoinResult) error {
 	// Validate join type
 	if props.JoinType == "" {
 		return fmt.Errorf("join type is required")
 	}
 
+	// Derive the ON clause from fk tags when it was not given
+	if props.JoinCondition == "" {
+		condition, err := s.AutoJoin(props.MainTableModel, props.JoinTableModel)
+		if err != nil {
+			return err
+		}
+		props.JoinCondition = condition
+	}
+
 	// Ensure ResultModel is a pointer to a slice
 	val := reflect.ValueOf(props.ResultModel)
 	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
@@ -512,12 +1202,12 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 	// Create a new instance of the element type to extract field information
 	modelInstance := reflect.New(elementType).Interface()
 
-	mainTableName := getTableNameFromModel(s.TablePrefix, props.MainTableModel)
-	joinTableName := getTableNameFromModel(s.TablePrefix, props.JoinTableModel)
+	mainTableName := s.tableName(props.MainTableModel)
+	joinTableName := s.tableName(props.JoinTableModel)
 
 	// Parse tags from the result model to get field mapping
 	var fields Fields
-	fieldMap := parseTags(modelInstance, &fields)
+	fieldMap := parseTags(modelInstance, s.naming(), &fields)
 
 	// Build column selections based on struct tags and custom mappings
 	var selectParts []string
@@ -530,8 +1220,8 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 	} else {
 		// Auto-build from struct fields and table models
 		var mainFields, joinFields Fields
-		parseTags(props.MainTableModel, &mainFields)
-		parseTags(props.JoinTableModel, &joinFields)
+		parseTags(props.MainTableModel, s.naming(), &mainFields)
+		parseTags(props.JoinTableModel, s.naming(), &joinFields)
 
 		// For each field in the result struct, try to map it to a table column
 		for _, field := range fields {
@@ -544,6 +1234,13 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 		}
 	}
 
+	// Route table-qualified columns into nested model fields, e.g. struct { User User; Post Post }
+	nestedParts, nestedFields := nestedJoinFields(elementType, map[reflect.Type]string{
+		indirectType(props.MainTableModel): mainTableName,
+		indirectType(props.JoinTableModel): joinTableName,
+	}, s.naming())
+	selectParts = append(selectParts, nestedParts...)
+
 	// Build the SQL query with the specified join type
 	query := fmt.Sprintf("SELECT %s FROM %s %s %s ON %s",
 		strings.Join(selectParts, ", "),
@@ -553,20 +1250,13 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 		props.JoinCondition,
 	)
 
-	var args []interface{}
-	if len(props.WhereConditions) > 0 {
-		query += " WHERE "
-		for i, condition := range props.WhereConditions {
-			if i > 0 {
-				query += " AND "
-			}
-			query += fmt.Sprintf("%s %s $%d", condition.Field, condition.Operator, i+1)
-			args = append(args, condition.Value)
-		}
+	// Add WHERE conditions using centralized function
+	whereClause, args := buildConditions(s.prepareConditions(config, props.WhereConditions), nil)
+	if whereClause != "" {
+		query += " WHERE " + whereClause
 	}
 
-	db := s.GetConnection()
-	rows, err := db.QueryContext(ctx, query, args...)
+	rows, err := s.readRows(config, query, args...)
 	if err != nil {
 		return fmt.Errorf("error executing join query: %v", err)
 	}
@@ -577,17 +1267,22 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 		return fmt.Errorf("error getting columns: %v", err)
 	}
 
+	// Map the columns once, nested model columns are scanned into their field index paths
+	scanner := s.scannerFor(columns, fieldMap, elementType)
+	for i, column := range columns {
+		if index, ok := nestedFields[column]; ok {
+			scanner.paths[i] = index
+		}
+	}
+
 	// Scan rows into struct slice
 	for rows.Next() {
 		// Create a new instance of the element type
 		newElement := reflect.New(elementType)
 		elementVal := newElement.Elem()
 
-		// Prepare scan arguments
-		scanArgs := scanRowToModel(columns, fieldMap, elementVal)
-
 		// Scan the row into the struct
-		if err := rows.Scan(scanArgs...); err != nil {
+		if err := scanner.scan(rows, elementVal); err != nil {
 			return fmt.Errorf("error scanning row: %v", err)
 		}
 
@@ -598,6 +1293,42 @@ func (s *PostgreSQLConnector) joinIntoStruct(ctx context.Context, props *JoinRes
 	return nil
 }
 
+// indirectType returns the struct type of a model or model pointer
+func indirectType(model interface{}) reflect.Type {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// nestedJoinFields finds untagged struct fields of the result type whose type is one of
+// the joined models. It returns select parts aliased as "table.column" and the field
+// index path each alias is scanned into.
+func nestedJoinFields(resultType reflect.Type, tables map[reflect.Type]string, naming NamingStrategy) ([]string, map[string][]int) {
+	var selectParts []string
+	nestedFields := make(map[string][]int)
+	for i := 0; i < resultType.NumField(); i++ {
+		field := resultType.Field(i)
+		if _, tagged := field.Tag.Lookup(GPOTag); tagged {
+			continue
+		}
+		tableName, ok := tables[field.Type]
+		if !ok {
+			continue
+		}
+		for _, nested := range metadataOf(field.Type, naming).fields {
+			alias := fmt.Sprintf("%s.%s", tableName, nested.tag.ColumnName)
+			if _, exists := nestedFields[alias]; exists {
+				continue
+			}
+			selectParts = append(selectParts, fmt.Sprintf("%s AS \"%s\"", alias, alias))
+			nestedFields[alias] = []int{i, nested.index}
+		}
+	}
+	return selectParts, nestedFields
+}
+
 // Helper function to check if a slice contains a string
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -608,80 +1339,198 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-// InsertModel inserts a model into the database, accepting optional context and transaction
+// InsertModel validates a model (see Validator) and inserts it into the database, accepting optional context and transaction
 func (s PostgreSQLConnector) InsertModel(model interface{}, opts ...Option) error {
 	config := processOptions(opts)
-	return s.insertWithTx(config.ctx, config.tx, model)
+	defer config.release()
+	if err := s.validateModel(config.ctx, model); err != nil {
+		return err
+	}
+	insert := func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		if config.associations {
+			return 1, s.insertWithAssociations(ctx, tx, model)
+		}
+		return 1, s.insertWithTx(ctx, tx, model)
+	}
+	if s.AuditLog && config.dryRun == nil {
+		insert = s.audited("insert", model, nil, insert)
+	}
+	if config.idempotencyKey != "" && config.dryRun == nil {
+		_, err := s.runIdempotent(config, "insert", model, nil, insert)
+		return err
+	}
+	_, err := insert(config.ctx, config.tx)
+	return err
 }
 
 // DeleteModel deletes a model from the database, accepting optional context and transaction
 func (s PostgreSQLConnector) DeleteModel(model interface{}, conditions []Condition, opts ...Option) (int64, error) {
 	config := processOptions(opts)
+	defer config.release()
+	conditions = s.applyExtensionFallbacks(s.scopedConditions(config, model, conditions, false))
+	if s.AuditLog && config.dryRun == nil {
+		return s.audited("delete", model, conditions, func(ctx context.Context, tx *sql.Tx) (int64, error) {
+			return s.deleteWithTx(ctx, tx, model, conditions...)
+		})(config.ctx, config.tx)
+	}
 	return s.deleteWithTx(config.ctx, config.tx, model, conditions...)
 }
 
-// UpdateModel updates a model in the database, accepting optional context and transaction
+// UpdateModel validates a model (see Validator) and updates it in the database, accepting optional context and transaction
 func (s PostgreSQLConnector) UpdateModel(model interface{}, conditions interface{}, opts ...Option) (int64, error) {
 	config := processOptions(opts)
-	return s.updateWithTx(config.ctx, config.tx, model, conditions)
+	defer config.release()
+	if err := s.validateModel(config.ctx, model); err != nil {
+		return 0, err
+	}
+	if c, ok := conditions.([]Condition); ok || conditions == nil {
+		if scoped := s.applyExtensionFallbacks(s.scopedConditions(config, model, c, true)); len(scoped) > 0 {
+			conditions = scoped
+		}
+	}
+	update := func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		return s.updateWithTx(ctx, tx, model, conditions)
+	}
+	if s.AuditLog && config.dryRun == nil {
+		auditConditions, _ := conditions.([]Condition)
+		if len(auditConditions) == 0 {
+			// updateWithTx updates the row with the primary key of the model
+			auditConditions = createPrimaryKeyCondition(model, s.naming(), primaryKeyValue(model))
+		}
+		update = s.audited("update", model, auditConditions, update)
+	}
+	if config.idempotencyKey != "" && config.dryRun == nil {
+		return s.runIdempotent(config, "update", model, conditions, update)
+	}
+	return update(config.ctx, config.tx)
 }
 
-// FindFirst finds the first record matching the condition or primary key, accepting optional context and transaction
+// FindFirst finds the first record matching the condition or primary key, accepting optional context and transaction.
+// A model as conditionOrId is a query by example, its non-zero tagged fields must all be equal.
 func (s PostgreSQLConnector) FindFirst(model interface{}, conditionOrId interface{}, opts ...Option) error {
 	config := processOptions(opts)
-	return s.first(config.ctx, config.tx, model, conditionOrId)
+	defer config.release()
+	if err := s.first(config, model, conditionOrId); err != nil {
+		return err
+	}
+	return s.preloadModels(config, model, config.preloads)
 }
 
 // FindAll finds all records matching the query properties, accepting optional context and transaction
 func (s PostgreSQLConnector) FindAll(models interface{}, queryProps *DatabaseQuery, opts ...Option) error {
 	config := processOptions(opts)
-	return s.all(config.ctx, config.tx, models, queryProps)
+	defer config.release()
+	preloads := config.preloads
+	view := config.view
+	if view == "" {
+		view = queryProps.View
+	}
+	if view != "" {
+		projection, err := resolveProjection(models, view)
+		if err != nil {
+			return err
+		}
+		query := *queryProps
+		queryProps = &query
+		queryProps.columns = projection.Columns
+		preloads = append(append([]string{}, projection.Preloads...), preloads...)
+	}
+	if err := s.all(config, models, queryProps); err != nil {
+		return err
+	}
+	if !config.unmasked {
+		maskModels(models)
+	}
+	return s.preloadModels(config, models, preloads)
+}
+
+// FindAllByExample finds all records whose columns equal the non-zero tagged
+// fields of the example model, accepting the options of FindAll. An example
+// without non-zero fields finds all records.
+func (s PostgreSQLConnector) FindAllByExample(models interface{}, example interface{}, opts ...Option) error {
+	if !isExample(example) {
+		return fmt.Errorf("example must be a model, got %T", example)
+	}
+	return s.FindAll(models, &DatabaseQuery{Conditions: exampleConditions(example, s.naming())}, opts...)
+}
+
+// resolveProjection looks up a projection preset on the element type of a model slice pointer
+func resolveProjection(models interface{}, view string) (*Projection, error) {
+	modelType := reflect.TypeOf(models)
+	for modelType.Kind() == reflect.Ptr || modelType.Kind() == reflect.Slice {
+		modelType = modelType.Elem()
+	}
+	provider, ok := reflect.New(modelType).Interface().(ProjectionProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s does not declare projections", modelType.Name())
+	}
+	projection, ok := provider.Projections()[view]
+	if !ok {
+		return nil, fmt.Errorf("%s has no projection named %q", modelType.Name(), view)
+	}
+	return &projection, nil
 }
 
 // LeftJoinWithContext performs a LEFT JOIN between two tables
-func (s *PostgreSQLConnector) LeftJoinWithContext(ctx context.Context, props *JoinProps) ([]map[string]interface{}, error) {
+func (s *PostgreSQLConnector) LeftJoinWithContext(ctx context.Context, props *JoinProps, opts ...Option) ([]map[string]interface{}, error) {
 	props.JoinType = LeftJoin
-	return s.join(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.join(config, props)
 }
 
 // RightJoinWithContext performs a RIGHT JOIN between two tables
-func (s *PostgreSQLConnector) RightJoinWithContext(ctx context.Context, props *JoinProps) ([]map[string]interface{}, error) {
+func (s *PostgreSQLConnector) RightJoinWithContext(ctx context.Context, props *JoinProps, opts ...Option) ([]map[string]interface{}, error) {
 	props.JoinType = RightJoin
-	return s.join(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.join(config, props)
 }
 
 // FullJoinWithContext performs a FULL OUTER JOIN between two tables
-func (s *PostgreSQLConnector) FullJoinWithContext(ctx context.Context, props *JoinProps) ([]map[string]interface{}, error) {
+func (s *PostgreSQLConnector) FullJoinWithContext(ctx context.Context, props *JoinProps, opts ...Option) ([]map[string]interface{}, error) {
 	props.JoinType = FullJoin
-	return s.join(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.join(config, props)
 }
 
 // InnerJoinWithContext performs an INNER JOIN between two tables
-func (s *PostgreSQLConnector) InnerJoinWithContext(ctx context.Context, props *JoinProps) ([]map[string]interface{}, error) {
+func (s *PostgreSQLConnector) InnerJoinWithContext(ctx context.Context, props *JoinProps, opts ...Option) ([]map[string]interface{}, error) {
 	props.JoinType = InnerJoin
-	return s.join(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.join(config, props)
 }
 
 // LeftJoinIntoStruct performs a LEFT JOIN and scans results into a struct slice
-func (s *PostgreSQLConnector) LeftJoinIntoStruct(ctx context.Context, props *JoinResult) error {
+func (s *PostgreSQLConnector) LeftJoinIntoStruct(ctx context.Context, props *JoinResult, opts ...Option) error {
 	props.JoinType = LeftJoin
-	return s.joinIntoStruct(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.joinIntoStruct(config, props)
 }
 
 // RightJoinIntoStruct performs a RIGHT JOIN and scans results into a struct slice
-func (s *PostgreSQLConnector) RightJoinIntoStruct(ctx context.Context, props *JoinResult) error {
+func (s *PostgreSQLConnector) RightJoinIntoStruct(ctx context.Context, props *JoinResult, opts ...Option) error {
 	props.JoinType = RightJoin
-	return s.joinIntoStruct(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.joinIntoStruct(config, props)
 }
 
 // FullJoinIntoStruct performs a FULL OUTER JOIN and scans results into a struct slice
-func (s *PostgreSQLConnector) FullJoinIntoStruct(ctx context.Context, props *JoinResult) error {
+func (s *PostgreSQLConnector) FullJoinIntoStruct(ctx context.Context, props *JoinResult, opts ...Option) error {
 	props.JoinType = FullJoin
-	return s.joinIntoStruct(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.joinIntoStruct(config, props)
 }
 
 // InnerJoinIntoStruct performs an INNER JOIN and scans results into a struct slice
-func (s *PostgreSQLConnector) InnerJoinIntoStruct(ctx context.Context, props *JoinResult) error {
+func (s *PostgreSQLConnector) InnerJoinIntoStruct(ctx context.Context, props *JoinResult, opts ...Option) error {
 	props.JoinType = InnerJoin
-	return s.joinIntoStruct(ctx, props)
+	config := processJoinOptions(ctx, opts)
+	defer config.release()
+	return s.joinIntoStruct(config, props)
 }
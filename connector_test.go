@@ -1,11 +1,22 @@
 package db
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -64,6 +75,15 @@ type TestUser struct {
 	Email    string    `gpo:"email,unique"`
 	Name     string    `gpo:"name,length(30)"`
 	UserType int       `gpo:"user_type"`
+	// Permissions is a has-many relation loaded with Preload
+	Permissions []TestUserCompanyPermission
+}
+
+func (TestUser) Projections() map[string]Projection {
+	return map[string]Projection{
+		"summary": {Columns: []string{"id", "email"}},
+		"detail":  {Columns: []string{"id", "email", "name", "user_type"}, Preloads: []string{"Permissions"}},
+	}
 }
 
 type TestUserCompanyPermission struct {
@@ -71,6 +91,8 @@ type TestUserCompanyPermission struct {
 	UserID    uuid.UUID `gpo:"user_id,fk(testuser:id,cascade)"`
 	CompanyID uuid.UUID `gpo:"company_id,fk(testcompany:id,cascade)"`
 	Role      string    `gpo:"role"`
+	// Company is a belongs-to relation loaded with Preload
+	Company *TestCompany
 }
 
 type TestCompany struct {
@@ -108,6 +130,31 @@ func TestPingDatabase(t *testing.T) {
 	}
 }
 
+func TestReconnect(t *testing.T) {
+	reconnected := false
+	connector.OnReconnect = func() { reconnected = true }
+	defer func() { connector.OnReconnect = nil }()
+	if err := connector.Reconnect(context.Background()); err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+	if !reconnected {
+		t.Error("expected OnReconnect to be called")
+	}
+	if err := connector.Ping(); err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	status, err := connector.HealthCheck(context.Background())
+	if err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+	if status.ServerVersion == "" || status.Pool.OpenConnections == 0 {
+		t.Errorf("expected server version and open connections, got %+v", status)
+	}
+}
+
 func TestCreateTables(t *testing.T) {
 	err := connector.CreateTables(TABLES...)
 	if err != nil {
@@ -159,6 +206,507 @@ func TestSelectCompany(t *testing.T) {
 	}
 }
 
+func TestSeedAndLoadFixtures(t *testing.T) {
+	companies := []TestCompany{{ID: uuid.New(), CompanyName: "Seeded A"}, {ID: uuid.New(), CompanyName: "Seeded B"}}
+	if err := connector.Seed(&companies); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "companies.yaml")
+	fixtures := "testcompany:\n  - id: {{ uuid \"fixture-company\" }}\n    company_name: Fixture Company\n"
+	if err := os.WriteFile(path, []byte(fixtures), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.LoadFixtures(context.Background(), path); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	found := []TestCompany{}
+	err := connector.FindAll(&found, &DatabaseQuery{
+		Conditions: []Condition{{Field: "company_name", Operator: "IN", Value: []string{"Seeded A", "Seeded B", "Fixture Company"}}},
+	})
+	if err != nil || len(found) != 3 {
+		t.Errorf("expected 3 seeded companies, got %d, error: %v", len(found), err)
+	}
+	for _, company := range found {
+		connector.DeleteModel(&company, []Condition{{Field: "id", Operator: "=", Value: company.ID}})
+	}
+}
+
+func TestExportAndImportCompanies(t *testing.T) {
+	companies := []TestCompany{{ID: uuid.New(), CompanyName: "Export, Inc."}, {ID: uuid.New(), CompanyName: "Export\tTabs"}}
+	if err := connector.Seed(&companies); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	for _, format := range []TransferFormat{FormatCSV, FormatCopyText} {
+		var buf bytes.Buffer
+		if err := connector.ExportTable(&TestCompany{}, &buf, format); err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		for _, company := range companies {
+			connector.DeleteModel(&company, []Condition{{Field: "id", Operator: "=", Value: company.ID}})
+		}
+		// Only the seeded companies are imported again, other tests may have rows in the table
+		filtered := filterExport(t, buf.String(), format, companies)
+		imported, err := connector.ImportTable(&TestCompany{}, strings.NewReader(filtered), format)
+		if err != nil || imported != 2 {
+			t.Fatalf("expected 2 imported companies, got %d, error: %v", imported, err)
+		}
+		found := TestCompany{}
+		err = connector.FindFirst(&found, companies[1].ID)
+		if err != nil || found.CompanyName != "Export\tTabs" {
+			t.Errorf("unexpected company %+v after %s import, error: %v", found, format, err)
+		}
+	}
+	for _, company := range companies {
+		connector.DeleteModel(&company, []Condition{{Field: "id", Operator: "=", Value: company.ID}})
+	}
+}
+
+// filterExport keeps the header and the lines of an export that belong to the companies
+func filterExport(t *testing.T, export string, format TransferFormat, companies []TestCompany) string {
+	t.Helper()
+	var kept []string
+	for i, line := range strings.Split(strings.TrimSuffix(export, "\n"), "\n") {
+		if i == 0 && format == FormatCSV {
+			kept = append(kept, line)
+			continue
+		}
+		for _, company := range companies {
+			if strings.Contains(line, company.ID.String()) {
+				kept = append(kept, line)
+			}
+		}
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+func TestCompanyRESTHandler(t *testing.T) {
+	handler := http.StripPrefix("/companies", NewRESTHandler(&connector, &TestCompany{}, RESTOptions{SearchFields: []string{"company_name"}}))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return recorder
+	}
+	id := uuid.New()
+	if recorder := serve("POST", "/companies", fmt.Sprintf(`{"ID": %q, "CompanyName": "REST Company"}`, id)); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := serve("PUT", "/companies/"+id.String(), `{"CompanyName": "REST Company Renamed"}`); recorder.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	recorder := serve("GET", "/companies?q=company_name+eq+%27REST+Company+Renamed%27", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), id.String()) {
+		t.Errorf("expected the company in the list, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := serve("DELETE", "/companies/"+id.String(), ""); recorder.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := serve("GET", "/companies/"+id.String(), ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after the delete, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestCompanyAuditLog(t *testing.T) {
+	audited := connector
+	audited.AuditLog = true
+	ctx := WithAuditActor(context.Background(), "auditor")
+	company := &TestCompany{ID: uuid.New(), CompanyName: "Audited Company"}
+	if err := audited.InsertModel(company, WithContext(ctx)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	company.CompanyName = "Audited Company Renamed"
+	if _, err := audited.UpdateModel(company, nil, WithContext(ctx)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if _, err := audited.DeleteModel(company, []Condition{{Field: "id", Operator: "=", Value: company.ID}}, WithContext(ctx)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	trail, err := audited.AuditTrail(company, company.ID)
+	if err != nil || len(trail) != 3 {
+		t.Fatalf("expected 3 audit entries, got %d, error: %v", len(trail), err)
+	}
+	update := trail[1]
+	if update.Operation != "update" || update.Actor != "auditor" || len(update.Changes) != 1 ||
+		update.Changes["company_name"].Old != "Audited Company" || update.Changes["company_name"].New != "Audited Company Renamed" {
+		t.Errorf("unexpected update entry: %+v", update)
+	}
+	if trail[0].Operation != "insert" || trail[2].Operation != "delete" || trail[2].Changes["company_name"].New != nil {
+		t.Errorf("unexpected audit trail: %+v", trail)
+	}
+}
+
+func TestCompanyOnCommitEvents(t *testing.T) {
+	listening := connector
+	listening.events = nil
+	var events []ChangeEvent
+	listening.OnCommit(func(ctx context.Context, event ChangeEvent) {
+		events = append(events, event)
+	})
+	company := &TestCompany{ID: uuid.New(), CompanyName: "Event Company"}
+	err := listening.WithinTransaction(func(tx *sql.Tx) error {
+		if err := listening.InsertModel(company, WithTransaction(tx)); err != nil {
+			return err
+		}
+		if len(events) != 0 {
+			t.Error("expected no events before the commit")
+		}
+		return nil
+	})
+	if err != nil || len(events) != 1 || events[0].Operation != "insert" || events[0].PrimaryKey != company.ID {
+		t.Fatalf("expected an insert event after the commit, got %+v, error: %v", events, err)
+	}
+	listening.WithinTransaction(func(tx *sql.Tx) error {
+		listening.DeleteModel(company, []Condition{{Field: "id", Operator: "=", Value: company.ID}}, WithTransaction(tx))
+		return errors.New("rolled back")
+	})
+	if len(events) != 1 {
+		t.Errorf("expected no events of the rolled back transaction, got %+v", events)
+	}
+	listening.DeleteModel(company, []Condition{{Field: "id", Operator: "=", Value: company.ID}})
+	if len(events) != 2 || events[1].Operation != "delete" {
+		t.Errorf("expected a delete event, got %+v", events)
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	queued := connector
+	queued.Clock = clock
+	q := NewQueue(&queued, "test-"+uuid.NewString())
+	q.RetryDelay = time.Minute
+	q.MaxAttempts = 2
+
+	if _, err := q.Enqueue(map[string]string{"to": "a@example.com"}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	job, err := q.Dequeue()
+	if err != nil || job == nil || job.Attempts != 1 {
+		t.Fatalf("expected the job, got %+v, error: %v", job, err)
+	}
+	var payload map[string]string
+	if err := job.Decode(&payload); err != nil || payload["to"] != "a@example.com" {
+		t.Errorf("unexpected payload %v, error: %v", payload, err)
+	}
+	if other, err := q.Dequeue(); err != nil || other != nil {
+		t.Errorf("expected the job to be locked, got %+v, error: %v", other, err)
+	}
+
+	if err := q.Fail(job, errors.New("smtp unavailable")); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if retry, _ := q.Dequeue(); retry != nil {
+		t.Errorf("expected no retry before the retry delay, got %+v", retry)
+	}
+	clock.Advance(time.Minute)
+	retry, err := q.Dequeue()
+	if err != nil || retry == nil || retry.Attempts != 2 {
+		t.Fatalf("expected the retry, got %+v, error: %v", retry, err)
+	}
+	if err := q.Complete(retry); err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+	clock.Advance(time.Hour)
+	if next, _ := q.Dequeue(); next != nil {
+		t.Errorf("expected an empty queue, got %+v", next)
+	}
+}
+
+func TestJobQueueRedelivery(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	queued := connector
+	queued.Clock = clock
+	q := NewQueue(&queued, "test-"+uuid.NewString())
+	if _, err := q.Enqueue("payload"); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	late, err := q.Dequeue()
+	if err != nil || late == nil {
+		t.Fatalf("expected the job, got %+v, error: %v", late, err)
+	}
+	clock.Advance(q.VisibilityTimeout)
+	current, err := q.Dequeue()
+	if err != nil || current == nil || current.ID != late.ID {
+		t.Fatalf("expected the job to be delivered again, got %+v, error: %v", current, err)
+	}
+	if err := q.Complete(late); !errors.Is(err, ErrJobLost) {
+		t.Errorf("expected ErrJobLost for the late worker, got %v", err)
+	}
+	if err := q.Fail(late, errors.New("timeout")); !errors.Is(err, ErrJobLost) {
+		t.Errorf("expected ErrJobLost for the late worker, got %v", err)
+	}
+	if err := q.Complete(current); err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	// Leases expire by the database clock, so the test waits for real
+	name := "test-" + uuid.NewString()
+	a := NewLeaderElector(&connector, name, time.Second)
+	b := NewLeaderElector(&connector, name, time.Second)
+	ctx := context.Background()
+
+	if acquired, err := a.TryAcquire(ctx); err != nil || !acquired {
+		t.Fatalf("expected a to acquire the lease, got %v, error: %v", acquired, err)
+	}
+	if acquired, err := b.TryAcquire(ctx); err != nil || acquired {
+		t.Fatalf("expected b not to acquire the held lease, got %v, error: %v", acquired, err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if acquired, _ := a.TryAcquire(ctx); !acquired {
+		t.Error("expected a to renew the lease")
+	}
+	time.Sleep(700 * time.Millisecond)
+	if acquired, _ := b.TryAcquire(ctx); acquired {
+		t.Error("expected b not to acquire the renewed lease")
+	}
+	time.Sleep(time.Second)
+	if acquired, _ := b.TryAcquire(ctx); !acquired {
+		t.Error("expected b to take over the expired lease")
+	}
+	if acquired, _ := a.TryAcquire(ctx); acquired || a.IsLeader() {
+		t.Error("expected a to have lost the lease")
+	}
+	if err := b.Release(ctx); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if acquired, _ := a.TryAcquire(ctx); !acquired {
+		t.Error("expected a to acquire the released lease")
+	}
+	a.Release(ctx)
+}
+
+type TestAppointment struct {
+	ID       uuid.UUID  `gpo:"id,pk"`
+	StartsAt time.Time  `gpo:"starts_at,timestamptz"`
+	EndsAt   *time.Time `gpo:"ends_at,timestamptz,nullable"`
+}
+
+func TestAppointmentTimesInLocation(t *testing.T) {
+	utc := connector
+	utc.Location = time.UTC
+	if err := utc.CreateTable(&TestAppointment{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	defer utc.DropTable(&TestAppointment{}, false)
+
+	helsinki := time.FixedZone("EET", 2*60*60)
+	startsAt := time.Date(2024, 3, 1, 9, 0, 0, 0, helsinki)
+	endsAt := startsAt.Add(time.Hour)
+	appointment := TestAppointment{ID: uuid.New(), StartsAt: startsAt, EndsAt: &endsAt}
+	if err := utc.InsertModel(&appointment); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	var found TestAppointment
+	if err := utc.FindFirst(&found, appointment.ID); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if !found.StartsAt.Equal(startsAt) || found.StartsAt.Location() != time.UTC {
+		t.Errorf("expected %s in UTC, got %s", startsAt, found.StartsAt)
+	}
+	if found.EndsAt == nil || !found.EndsAt.Equal(endsAt) || found.EndsAt.Location() != time.UTC {
+		t.Errorf("expected %s in UTC, got %v", endsAt, found.EndsAt)
+	}
+}
+
+type TestOpeningHours struct {
+	ID      uuid.UUID      `gpo:"id,pk"`
+	Day     time.Time      `gpo:"day,date"`
+	OpensAt time.Time      `gpo:"opens_at,time"`
+	Slot    time.Duration  `gpo:"slot,interval"`
+	Break   *time.Duration `gpo:"break,interval,nullable"`
+}
+
+func TestOpeningHoursDateTimeInterval(t *testing.T) {
+	if err := connector.CreateTable(&TestOpeningHours{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	defer connector.DropTable(&TestOpeningHours{}, false)
+
+	hours := TestOpeningHours{
+		ID:      uuid.New(),
+		Day:     time.Date(2024, 3, 1, 18, 30, 0, 0, time.UTC),
+		OpensAt: time.Date(2024, 3, 1, 9, 15, 0, 0, time.UTC),
+		Slot:    26*time.Hour + 30*time.Minute,
+	}
+	if err := connector.InsertModel(&hours); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	var found TestOpeningHours
+	if err := connector.FindFirst(&found, hours.ID); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if y, m, d := found.Day.Date(); y != 2024 || m != time.March || d != 1 || found.Day.Hour() != 0 {
+		t.Errorf("expected the date only, got %s", found.Day)
+	}
+	if found.OpensAt.Hour() != 9 || found.OpensAt.Minute() != 15 {
+		t.Errorf("expected the time of day, got %s", found.OpensAt)
+	}
+	if found.Slot != hours.Slot || found.Break != nil {
+		t.Errorf("expected interval %s and no break, got %s, %v", hours.Slot, found.Slot, found.Break)
+	}
+}
+
+type TestAccessLog struct {
+	ID       uuid.UUID        `gpo:"id,pk"`
+	ClientIP net.IP           `gpo:"client_ip,inet"`
+	Network  net.IPNet        `gpo:"network,cidr"`
+	Device   net.HardwareAddr `gpo:"device,macaddr"`
+}
+
+func TestAccessLogNetworkTypes(t *testing.T) {
+	if err := connector.CreateTable(&TestAccessLog{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	defer connector.DropTable(&TestAccessLog{}, false)
+
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+	device, _ := net.ParseMAC("08:00:2b:01:02:03")
+	entry := TestAccessLog{ID: uuid.New(), ClientIP: net.ParseIP("10.1.2.3"), Network: *network, Device: device}
+	if err := connector.InsertModel(&entry); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	var entries []TestAccessLog
+	err := connector.FindAll(&entries, &DatabaseQuery{
+		Conditions: []Condition{{Field: "client_ip", Operator: NetContainedIn, Value: private}},
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(entries) != 1 || !entries[0].ClientIP.Equal(entry.ClientIP) || entries[0].Network.String() != "10.1.0.0/16" || entries[0].Device.String() != device.String() {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+type TestTicket struct {
+	ID    ULID   `gpo:"id,pk"`
+	Title string `gpo:"title"`
+}
+
+func TestTicketULIDKeysetOrder(t *testing.T) {
+	if err := connector.CreateTable(&TestTicket{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	defer connector.DropTable(&TestTicket{}, false)
+
+	var inserted []ULID
+	for i := 0; i < 5; i++ {
+		ticket := TestTicket{Title: fmt.Sprintf("ticket %d", i)}
+		if err := connector.InsertModel(&ticket); err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		inserted = append(inserted, ticket.ID)
+	}
+
+	pager := connector
+	pager.PageTokenSecret = []byte("test-secret")
+	var found []ULID
+	token := ""
+	for {
+		tickets := []TestTicket{}
+		next, err := pager.FindPage(&tickets, &DatabaseQuery{Limit: 2}, token)
+		if err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		for _, ticket := range tickets {
+			found = append(found, ticket.ID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(found) != fmt.Sprint(inserted) {
+		t.Errorf("expected the tickets in insertion order %v, got %v", inserted, found)
+	}
+}
+
+type TestUserTypeReport struct {
+	UserType int   `gpo:"user_type"`
+	Users    int64 `gpo:"users"`
+}
+
+func TestSelectUserTypeReport(t *testing.T) {
+	all := []TestUser{}
+	if err := connector.FindAll(&all, &DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	reports := []TestUserTypeReport{}
+	err := connector.FindAll(&reports, &DatabaseQuery{
+		Table:      "orm_testuser",
+		GroupBy:    []string{"user_type"},
+		Aggregates: []Aggregate{{Function: "COUNT", Column: "*", Alias: "users"}},
+		Having:     []Condition{{Field: "COUNT(*)", Operator: ">", Value: 0}},
+		OrderBy:    "user_type",
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var total int64
+	for _, report := range reports {
+		total += report.Users
+	}
+	if len(reports) == 0 || total != int64(len(all)) {
+		t.Errorf("expected the report to count %d users, got %+v", len(all), reports)
+	}
+}
+
+func TestCompanyRepository(t *testing.T) {
+	repo := NewRepository[TestCompany](&connector)
+	renamed := 0
+	repo.Hooks.BeforeUpdate = func(ctx context.Context, model *TestCompany) error {
+		renamed++
+		return nil
+	}
+
+	company := &TestCompany{ID: uuid.New(), CompanyName: "Repository Company"}
+	if err := repo.Insert(company); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	company.CompanyName = "Renamed Company"
+	if affected, err := repo.Update(company); err != nil || affected != 1 || renamed != 1 {
+		t.Errorf("expected one updated row and hook call, got %d, %d, %v", affected, renamed, err)
+	}
+	found, err := repo.Find(company.ID)
+	if err != nil || found.CompanyName != "Renamed Company" {
+		t.Errorf("unexpected company %+v, error: %v", found, err)
+	}
+
+	// Scopes restrict every operation
+	scoped := NewRepository[TestCompany](&connector)
+	scoped.Scopes = []Condition{{Field: "company_name", Operator: "=", Value: "Other Company"}}
+	if affected, err := scoped.DeleteByID(company.ID); err != nil || affected != 0 {
+		t.Errorf("expected the scope to prevent the delete, got %d, %v", affected, err)
+	}
+	if affected, err := repo.DeleteByID(company.ID); err != nil || affected != 1 {
+		t.Errorf("expected one deleted row, got %d, %v", affected, err)
+	}
+}
+
+func TestCompanyWithoutPreparedStatements(t *testing.T) {
+	unprepared := connector
+	unprepared.NoPreparedStatements = true
+
+	company := TestCompany{ID: uuid.New(), CompanyName: "Unprepared Company"}
+	if err := unprepared.InsertModel(&company); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	company.CompanyName = "Still Unprepared"
+	if affected, err := unprepared.UpdateModel(&company, nil); err != nil || affected != 1 {
+		t.Errorf("expected one updated row, got %d, %v", affected, err)
+	}
+	affected, err := unprepared.DeleteModel(&company, []Condition{{Field: "id", Operator: "=", Value: company.ID}})
+	if err != nil || affected != 1 {
+		t.Errorf("expected one deleted row, got %d, %v", affected, err)
+	}
+}
+
 func TestInsertUserCompanyPermission(t *testing.T) {
 	r := fakeHttpRequest()
 	err := connector.InsertModel(&TestUserCompanyPermission{
@@ -182,6 +730,128 @@ func TestSelectUserCompanyPermission(t *testing.T) {
 	}
 }
 
+func TestPreloadUserPermissionsWithCompany(t *testing.T) {
+	r := fakeHttpRequest()
+	m := &TestUser{}
+	err := connector.FindFirst(m, testUserId, WithContext(r.Context()), Preload("Permissions.Company"))
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(m.Permissions) != 1 {
+		t.Fatalf("expected 1 preloaded permission, got %d", len(m.Permissions))
+	}
+	if m.Permissions[0].Company == nil || m.Permissions[0].Company.ID != testCompanyId {
+		t.Errorf("expected permission company %s to be preloaded, got %v", testCompanyId, m.Permissions[0].Company)
+	}
+}
+
+func TestInsertUserWithAssociations(t *testing.T) {
+	r := fakeHttpRequest()
+	userId := uuid.New()
+	user := &TestUser{
+		ID:       userId,
+		Email:    "associations@example.com",
+		Name:     "Associations User",
+		UserType: 1,
+		Permissions: []TestUserCompanyPermission{
+			{ID: uuid.New(), CompanyID: testCompanyId, Role: "viewer"},
+		},
+	}
+	err := connector.InsertModel(user, WithContext(r.Context()), WithAssociations())
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	m := &TestUserCompanyPermission{}
+	err = connector.FindFirst(m, user.Permissions[0].ID, WithContext(r.Context()))
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if m.UserID != userId {
+		t.Errorf("expected permission user_id %s, got %s", userId, m.UserID)
+	}
+
+	_, err = connector.DeleteModel(&TestUser{}, []Condition{{Field: "id", Operator: "=", Value: userId}}, WithContext(r.Context()))
+	if err != nil {
+		t.Logf("Warning: Failed to clean up user: %v", err)
+	}
+}
+
+func TestInsertUserWithIdempotencyKey(t *testing.T) {
+	r := fakeHttpRequest()
+	key := uuid.New().String()
+	user := &TestUser{
+		ID:       uuid.New(),
+		Email:    "idempotent@example.com",
+		Name:     "Idempotent User",
+		UserType: 1,
+	}
+	for i := 0; i < 2; i++ {
+		err := connector.InsertModel(user, WithContext(r.Context()), WithIdempotencyKey(key))
+		if err != nil {
+			t.Fatalf("attempt %d: error should be nil, but was: %s", i+1, err)
+		}
+	}
+
+	user.Name = "Different Payload"
+	err := connector.InsertModel(user, WithContext(r.Context()), WithIdempotencyKey(key))
+	if err != ErrIdempotencyKeyReused {
+		t.Errorf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	_, err = connector.DeleteModel(&TestUser{}, []Condition{{Field: "id", Operator: "=", Value: user.ID}}, WithContext(r.Context()))
+	if err != nil {
+		t.Logf("Warning: Failed to clean up user: %v", err)
+	}
+}
+
+func TestJoinWithINAndLIKEConditions(t *testing.T) {
+	r := fakeHttpRequest()
+	results, err := connector.InnerJoinWithContext(r.Context(), &JoinProps{
+		MainTableModel: &TestUser{},
+		JoinTableModel: &TestUserCompanyPermission{},
+		MainTableCols:  []string{"id", "email"},
+		JoinTableCols:  []string{"role"},
+		WhereConditions: []Condition{
+			{Field: "orm_testuser.id", Operator: "IN", Value: []uuid.UUID{testUserId, uuid.New()}},
+			{Field: "orm_testusercompanypermission.role", Operator: "LIKE", Value: "adm"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+	}
+}
+
+func TestJoinWithGroupByAndHaving(t *testing.T) {
+	r := fakeHttpRequest()
+	results, err := connector.LeftJoinWithContext(r.Context(), &JoinProps{
+		MainTableModel: &TestUser{},
+		JoinTableModel: &TestUserCompanyPermission{},
+		MainTableCols:  []string{"id"},
+		GroupBy:        []string{"orm_testuser.id"},
+		Aggregates: []Aggregate{
+			{Function: "COUNT", Column: "orm_testusercompanypermission.id", Alias: "permission_count"},
+		},
+		Having: []Condition{
+			{Field: "COUNT(orm_testusercompanypermission.id)", Operator: ">=", Value: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("expected at least one user with permissions")
+	}
+	for _, result := range results {
+		if count, ok := result["permission_count"].(int64); !ok || count < 1 {
+			t.Errorf("expected permission_count >= 1, got %v", result["permission_count"])
+		}
+	}
+}
+
 func TestJoinUserWithPermissions(t *testing.T) {
 	// Generate new test IDs to avoid conflicts with other tests
 	joinTestUserId := uuid.New()
@@ -732,6 +1402,70 @@ func TestInsertMoreUsers(t *testing.T) {
 	}
 }
 
+func TestSelectAllUsersWithCache(t *testing.T) {
+	cache := NewMemoryCache()
+	cached := connector
+	cached.Cache = cache
+	cached.CacheTTLs = map[string]time.Duration{"testuser": time.Minute}
+
+	first := []TestUser{}
+	if err := cached.FindAll(&first, &DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected the result to be cached, got %d entries", cache.Len())
+	}
+	second := []TestUser{}
+	if err := cached.FindAll(&second, &DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(second) != len(first) {
+		t.Errorf("expected %d cached users, got %d", len(first), len(second))
+	}
+
+	user := TestUser{ID: uuid.New(), Email: "cached@example.com", Name: "Cached", UserType: 1}
+	if err := cached.InsertModel(&user); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("expected the insert to invalidate the cache, got %d entries", cache.Len())
+	}
+	if _, err := cached.DeleteModel(&user, []Condition{{Field: "id", Operator: "=", Value: user.ID}}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+}
+
+func TestLoadUsersInOneQuery(t *testing.T) {
+	all := []TestUser{}
+	if err := connector.FindAll(&all, &DatabaseQuery{Limit: 5}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var queries int32
+	counted := connector
+	counted.SlowQueryThreshold = time.Nanosecond
+	counted.OnSlowQuery = func(SlowQuery) { atomic.AddInt32(&queries, 1) }
+
+	loader := NewLoader[TestUser](&counted)
+	var wg sync.WaitGroup
+	for _, user := range all {
+		wg.Add(1)
+		go func(id uuid.UUID) {
+			defer wg.Done()
+			loaded, err := loader.Load(id)
+			if err != nil || loaded == nil || loaded.ID != id {
+				t.Errorf("expected user %s, got %+v, error: %v", id, loaded, err)
+			}
+		}(user.ID)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("expected one query, got %d", n)
+	}
+	if missing, err := loader.Load(uuid.New()); missing != nil || err != nil {
+		t.Errorf("expected nil for a missing user, got %+v, error: %v", missing, err)
+	}
+}
+
 func TestSelectAllUsers(t *testing.T) {
 	r := fakeHttpRequest()
 	models := []TestUser{}
@@ -745,6 +1479,25 @@ func TestSelectAllUsers(t *testing.T) {
 	}
 }
 
+func TestSelectAllUsersWithView(t *testing.T) {
+	r := fakeHttpRequest()
+	models := []TestUser{}
+	err := connector.FindAll(&models, &DatabaseQuery{}, WithContext(r.Context()), WithView("summary"))
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	for _, model := range models {
+		if model.Email == "" || model.Name != "" {
+			t.Errorf("expected only id and email to be selected, got %+v", model)
+		}
+	}
+
+	err = connector.FindAll(&models, &DatabaseQuery{View: "unknown"}, WithContext(r.Context()))
+	if err == nil {
+		t.Error("expected an error for an unknown view")
+	}
+}
+
 func TestSelectAllUsersInDescendingOrder(t *testing.T) {
 	r := fakeHttpRequest()
 	models := []TestUser{}
@@ -808,6 +1561,103 @@ func TestSelectUsersWithINCondition(t *testing.T) {
 	}
 }
 
+func TestSelectUsersWithLargeINStrategies(t *testing.T) {
+	all := []TestUser{}
+	if err := connector.FindAll(&all, &DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	ids := []uuid.UUID{}
+	for _, user := range all {
+		ids = append(ids, user.ID)
+	}
+	for i := 0; i < 100; i++ {
+		ids = append(ids, uuid.New())
+	}
+
+	for _, strategy := range []LargeInStrategy{InList, InValues, InArray} {
+		models := []TestUser{}
+		err := connector.FindAll(&models, &DatabaseQuery{
+			Conditions: []Condition{{Field: "id", Operator: "IN", Value: ids}},
+		}, WithLargeInStrategy(strategy, 10))
+		if err != nil {
+			t.Errorf("strategy %d: error should be nil, but was: %s", strategy, err)
+		}
+		if len(models) != len(all) {
+			t.Errorf("strategy %d: expected %d users, got %d", strategy, len(all), len(models))
+		}
+
+		models = []TestUser{}
+		err = connector.FindAll(&models, &DatabaseQuery{
+			Conditions: []Condition{{Field: "id", Operator: "NOT IN", Value: ids}},
+		}, WithLargeInStrategy(strategy, 10))
+		if err != nil {
+			t.Errorf("strategy %d: error should be nil, but was: %s", strategy, err)
+		}
+		if len(models) != 0 {
+			t.Errorf("strategy %d: expected no users, got %d", strategy, len(models))
+		}
+	}
+}
+
+func TestSelectUsersWithPageTokens(t *testing.T) {
+	all := []TestUser{}
+	if err := connector.FindAll(&all, &DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	pager := connector
+	pager.PageTokenSecret = []byte("test-secret")
+	query := &DatabaseQuery{OrderBy: "user_type", Limit: 4}
+	seen := make(map[uuid.UUID]bool)
+	token := ""
+	for {
+		models := []TestUser{}
+		next, err := pager.FindPage(&models, query, token)
+		if err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		for _, model := range models {
+			if seen[model.ID] {
+				t.Errorf("user %s was returned twice", model.ID)
+			}
+			seen[model.ID] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if len(seen) != len(all) {
+		t.Errorf("expected %d users over all pages, got %d", len(all), len(seen))
+	}
+
+	models := []TestUser{}
+	if _, err := pager.FindPage(&models, &DatabaseQuery{OrderBy: "email", Limit: 4}, token); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("expected ErrInvalidPageToken for changed ordering, got %v", err)
+	}
+}
+
+func TestExplainSelectUsers(t *testing.T) {
+	query := &DatabaseQuery{
+		Table:      "orm_testuser",
+		Conditions: []Condition{{Field: "email", Operator: "=", Value: "nobody@example.com"}},
+	}
+	plan, err := connector.Explain(query)
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if plan.Plan.NodeType == "" || plan.ExecutionTime != 0 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+	plan, err = connector.Explain(query, WithAnalyze())
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if plan.ExecutionTime <= 0 {
+		t.Errorf("expected an execution time from EXPLAIN ANALYZE, got %+v", plan)
+	}
+}
+
 func TestSelectLimitedUsers(t *testing.T) {
 	r := fakeHttpRequest()
 	models := []TestUser{}
@@ -900,7 +1750,7 @@ func TestSelectUsersWithSearch(t *testing.T) {
 func TestDeleteOne(t *testing.T) {
 	r := fakeHttpRequest()
 	testUser := &TestUser{}
-	pkField := getPrimaryKeyField(testUser)
+	pkField := getPrimaryKeyField(testUser, nil)
 	condition := Condition{
 		Field:    pkField,
 		Operator: "=",
@@ -926,9 +1776,116 @@ func TestDeleteAllWithContext(t *testing.T) {
 	}
 }
 
+type TestEvent struct {
+	ID        uuid.UUID `gpo:"id,pk"`
+	Kind      string    `gpo:"kind"`
+	CreatedAt time.Time `gpo:"created_at"`
+}
+
+func (TestEvent) Partitioning() Partitioning {
+	return Partitioning{Strategy: PartitionByRange, Columns: []string{"created_at"}}
+}
+
+func TestPartitionedTable(t *testing.T) {
+	if err := connector.CreateTable(&TestEvent{}); err != nil {
+		t.Fatalf("error should be nil but was: %s", err)
+	}
+	defer connector.DropTable(&TestEvent{}, true)
+
+	from := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	if err := connector.CreateMonthlyPartitions(&TestEvent{}, from, 2); err != nil {
+		t.Fatalf("error should be nil but was: %s", err)
+	}
+	if err := connector.InsertModel(&TestEvent{ID: uuid.New(), Kind: "login", CreatedAt: from}); err != nil {
+		t.Errorf("error should be nil but was: %s", err)
+	}
+	if err := connector.InsertModel(&TestEvent{ID: uuid.New(), Kind: "login", CreatedAt: from.AddDate(1, 0, 0)}); err == nil {
+		t.Error("expected an error for a row outside of all partitions")
+	}
+
+	if err := connector.DetachPartition(&TestEvent{}, "orm_testevent_y2024m01"); err != nil {
+		t.Errorf("error should be nil but was: %s", err)
+	}
+	events := []TestEvent{}
+	if err := connector.FindAll(&events, &DatabaseQuery{}); err != nil || len(events) != 0 {
+		t.Errorf("expected no events after detaching, got %d, %v", len(events), err)
+	}
+	connector.DropTable("orm_testevent_y2024m01", false)
+}
+
+func TestTruncateTables(t *testing.T) {
+	company := TestCompany{ID: uuid.New(), CompanyName: "Truncated Company"}
+	if err := connector.InsertModel(&company); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.TruncateTables(append(TABLES, WithRestartIdentity(), WithCascade())...); err != nil {
+		t.Errorf("error should be nil, but was: %s", err)
+	}
+	companies := []TestCompany{}
+	if err := connector.FindAll(&companies, &DatabaseQuery{}); err != nil || len(companies) != 0 {
+		t.Errorf("expected no companies after truncating, got %d, %v", len(companies), err)
+	}
+}
+
+func TestResetDatabase(t *testing.T) {
+	for _, opts := range [][]interface{}{nil, {WithRecreate()}} {
+		company := TestCompany{ID: uuid.New(), CompanyName: "Reset Company"}
+		if err := connector.InsertModel(&company); err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		if err := connector.ResetDatabase(append(append([]interface{}{}, TABLES...), opts...)...); err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		companies := []TestCompany{}
+		if err := connector.FindAll(&companies, &DatabaseQuery{}); err != nil || len(companies) != 0 {
+			t.Errorf("expected no companies after resetting, got %d, %v", len(companies), err)
+		}
+	}
+}
+
 func TestDropTables(t *testing.T) {
 	err := connector.DropTables(TABLES...)
 	if err != nil {
 		t.Errorf("error should be nil but was: %s", err)
 	}
 }
+
+// BenchmarkFindAllLargeIn compares the large IN strategies for a few thousand keys
+func BenchmarkFindAllLargeIn(b *testing.B) {
+	if connector.GetConnection() == nil {
+		if err := connector.Connect(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := connector.CreateTables(TABLES...); err != nil {
+		b.Fatal(err)
+	}
+	defer connector.DropTables(TABLES...)
+
+	ids := make([]uuid.UUID, 5000)
+	for i := range ids {
+		ids[i] = uuid.New()
+		if i%2 == 0 {
+			continue
+		}
+		err := connector.InsertModel(&TestUser{ID: ids[i], Email: fmt.Sprintf("bench%d@example.com", i), Name: "Bench"})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	strategies := map[string]LargeInStrategy{"List": InList, "Values": InValues, "Array": InArray}
+	for name, strategy := range strategies {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				models := []TestUser{}
+				err := connector.FindAll(&models, &DatabaseQuery{
+					Conditions: []Condition{{Field: "id", Operator: "IN", Value: ids}},
+				}, WithLargeInStrategy(strategy, 1))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Credentials are the login of a database connection. Token, when set, is used
+// as the password, e.g. an AWS RDS or GCP Cloud SQL IAM authentication token.
+type Credentials struct {
+	User     string
+	Password string
+	Token    string
+	// ExpiresAt is when the credentials have to be fetched again, zero never expires
+	ExpiresAt time.Time
+}
+
+// CredentialProvider supplies credentials for new connections, e.g. from IAM or Vault
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// CredentialProviderFunc adapts a function to a CredentialProvider
+type CredentialProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Credentials implements CredentialProvider
+func (f CredentialProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// credentialRefreshMargin renews credentials shortly before they expire so a
+// connection attempt does not race the expiry
+const credentialRefreshMargin = 30 * time.Second
+
+// credentialConnector is a driver.Connector opening every new pool connection
+// with the current credentials of the provider
+type credentialConnector struct {
+	s        *PostgreSQLConnector
+	provider CredentialProvider
+
+	mu          sync.Mutex
+	credentials *Credentials
+}
+
+func (c *credentialConnector) current(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials != nil && (c.credentials.ExpiresAt.IsZero() ||
+		c.s.now().Add(credentialRefreshMargin).Before(c.credentials.ExpiresAt)) {
+		return *c.credentials, nil
+	}
+	credentials, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("error fetching database credentials: %v", err)
+	}
+	c.credentials = &credentials
+	return credentials, nil
+}
+
+// Connect implements driver.Connector
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	credentials, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	password := credentials.Password
+	if credentials.Token != "" {
+		password = credentials.Token
+	}
+	user := credentials.User
+	if user == "" {
+		user = c.s.User
+	}
+	connector, err := pq.NewConnector(c.s.connectionString(user, password))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// Driver implements driver.Connector
+func (c *credentialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
@@ -0,0 +1,98 @@
+package dbtest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/phasi/go-postgresql-orm"
+)
+
+// PostgresImage is the image started by NewPostgresContainer
+var PostgresImage = "postgres:16-alpine"
+
+// ContainerStartTimeout is how long NewPostgresContainer waits for Postgres to accept connections
+var ContainerStartTimeout = time.Minute
+
+// NewPostgresContainer starts a disposable Postgres container with Docker, creates
+// the tables of the models and returns a connected connector. The container is
+// removed when the test finishes. The test is skipped when Docker is not available.
+func NewPostgresContainer(t testing.TB, models ...interface{}) *db.PostgreSQLConnector {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+	id, err := docker("run", "-d", "--rm",
+		"-e", "POSTGRES_USER=postgres",
+		"-e", "POSTGRES_PASSWORD=postgres",
+		"-e", "POSTGRES_DB=test",
+		"-p", "127.0.0.1::5432",
+		PostgresImage)
+	if err != nil {
+		t.Fatalf("error starting postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		docker("rm", "-f", id)
+	})
+
+	address, err := docker("port", id, "5432/tcp")
+	if err != nil {
+		t.Fatalf("error reading postgres container port: %v", err)
+	}
+	// docker port prints one line per address, e.g. 127.0.0.1:49153
+	host, port, err := net.SplitHostPort(strings.Fields(address)[0])
+	if err != nil {
+		t.Fatalf("unexpected postgres container address %q: %v", address, err)
+	}
+
+	connector := &db.PostgreSQLConnector{
+		Host:     host,
+		Port:     port,
+		User:     "postgres",
+		Password: "postgres",
+		Database: "test",
+		SSLMode:  "disable",
+	}
+	if err := connector.Connect(); err != nil {
+		t.Fatalf("error connecting to postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		connector.Close()
+	})
+
+	// The image only listens on TCP once initialization is done
+	deadline := time.Now().Add(ContainerStartTimeout)
+	for {
+		err := connector.Ping(db.WithTimeout(time.Second))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("postgres container did not become ready: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if len(models) > 0 {
+		if err := connector.CreateTables(models...); err != nil {
+			t.Fatalf("error creating tables: %v", err)
+		}
+	}
+	return connector
+}
+
+// docker runs a docker command and returns its trimmed output
+func docker(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,19 @@
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNewPostgresContainer(t *testing.T) {
+	connector := NewPostgresContainer(t, &Account{})
+	account := Account{ID: uuid.New(), Email: "a@example.com", Age: 30}
+	if err := connector.InsertModel(&account); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var found Account
+	if err := connector.FindFirst(&found, account.ID); err != nil || found != account {
+		t.Errorf("expected %+v, got %+v, error: %v", account, found, err)
+	}
+}
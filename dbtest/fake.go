@@ -0,0 +1,281 @@
+// Package dbtest provides helpers for testing code built on go-postgresql-orm
+// without a live Postgres.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	db "github.com/phasi/go-postgresql-orm"
+)
+
+// Statement is a statement executed against a FakeConnector. Args are recorded
+// as passed, before conversion to driver values.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// Rows is a canned query result
+type Rows struct {
+	Columns []string
+	Values  [][]interface{}
+}
+
+// NewRows creates an empty result with the given columns
+func NewRows(columns ...string) *Rows {
+	return &Rows{Columns: columns}
+}
+
+// AddRow appends a row with one value per column
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	r.Values = append(r.Values, values)
+	return r
+}
+
+type response struct {
+	contains     string
+	query        bool
+	rows         *Rows
+	rowsAffected int64
+	err          error
+}
+
+// FakeConnector is a database/sql connector recording every statement and
+// answering with canned results. Statements without a matching response return
+// no rows and affect no rows.
+type FakeConnector struct {
+	mu         sync.Mutex
+	statements []Statement
+	responses  []response
+}
+
+// NewFakeConnector returns a PostgreSQLConnector backed by a new FakeConnector
+func NewFakeConnector() (*db.PostgreSQLConnector, *FakeConnector) {
+	fake := &FakeConnector{}
+	connector := &db.PostgreSQLConnector{}
+	connector.ConnectWithDB(sql.OpenDB(fake))
+	return connector, fake
+}
+
+// OnQuery returns rows for queries containing the given SQL fragment
+func (f *FakeConnector) OnQuery(contains string, rows *Rows) {
+	f.respond(response{contains: contains, query: true, rows: rows})
+}
+
+// OnExec reports rowsAffected for statements containing the given SQL fragment
+func (f *FakeConnector) OnExec(contains string, rowsAffected int64) {
+	f.respond(response{contains: contains, rowsAffected: rowsAffected})
+}
+
+// OnError fails queries and statements containing the given SQL fragment
+func (f *FakeConnector) OnError(contains string, err error) {
+	f.respond(response{contains: contains, err: err})
+}
+
+func (f *FakeConnector) respond(r response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, r)
+}
+
+// Statements returns the statements executed so far, transactions are recorded
+// as BEGIN, with isolation level and access mode when given, COMMIT and ROLLBACK
+func (f *FakeConnector) Statements() []Statement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Statement{}, f.statements...)
+}
+
+// LastStatement returns the most recent statement, or an empty one when there is none
+func (f *FakeConnector) LastStatement() Statement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.statements) == 0 {
+		return Statement{}
+	}
+	return f.statements[len(f.statements)-1]
+}
+
+// Reset forgets the recorded statements and the responses
+func (f *FakeConnector) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = nil
+	f.responses = nil
+}
+
+// record records a statement and returns the first matching response
+func (f *FakeConnector) record(query string, args []driver.NamedValue, isQuery bool) response {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, Statement{SQL: query, Args: values})
+	for _, r := range f.responses {
+		if strings.Contains(query, r.contains) && (r.err != nil || r.query == isQuery) {
+			return r
+		}
+	}
+	return response{query: isQuery}
+}
+
+// Connect implements driver.Connector
+func (f *FakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{fake: f}, nil
+}
+
+// Driver implements driver.Connector
+func (f *FakeConnector) Driver() driver.Driver {
+	return fakeDriver{fake: f}
+}
+
+type fakeDriver struct {
+	fake *FakeConnector
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{fake: d.fake}, nil
+}
+
+type fakeConn struct {
+	fake *FakeConnector
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	begin := "BEGIN"
+	if level := sql.IsolationLevel(opts.Isolation); level != sql.LevelDefault {
+		begin += " ISOLATION LEVEL " + strings.ToUpper(level.String())
+	}
+	if opts.ReadOnly {
+		begin += " READ ONLY"
+	}
+	if r := c.fake.record(begin, nil, false); r.err != nil {
+		return nil, r.err
+	}
+	return &fakeTx{fake: c.fake}, nil
+}
+
+// CheckNamedValue accepts any argument so statements are recorded with the original values
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r := c.fake.record(query, args, false)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return driver.RowsAffected(r.rowsAffected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r := c.fake.record(query, args, true)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.rows == nil {
+		return &fakeRows{rows: &Rows{}}, nil
+	}
+	return &fakeRows{rows: r.rows}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type fakeTx struct {
+	fake *FakeConnector
+}
+
+func (t *fakeTx) Commit() error {
+	return t.fake.record("COMMIT", nil, false).err
+}
+
+func (t *fakeTx) Rollback() error {
+	return t.fake.record("ROLLBACK", nil, false).err
+}
+
+type fakeRows struct {
+	rows *Rows
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.rows.Columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.Values) {
+		return io.EOF
+	}
+	row := r.rows.Values[r.next]
+	r.next++
+	if len(row) != len(dest) {
+		return errors.New("dbtest: canned row does not match the columns")
+	}
+	for i, value := range row {
+		converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			return err
+		}
+		dest[i] = converted
+	}
+	return nil
+}
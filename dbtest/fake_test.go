@@ -0,0 +1,1361 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	db "github.com/phasi/go-postgresql-orm"
+)
+
+type Account struct {
+	ID    uuid.UUID `gpo:"id,pk"`
+	Email string    `gpo:"email,unique"`
+	Age   int       `gpo:"age"`
+}
+
+func TestFakeConnectorReturnsCannedRows(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	id := uuid.New()
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").
+		AddRow(id, "a@example.com", 30).
+		AddRow(uuid.New(), "b@example.com", 40))
+
+	var accounts []Account
+	if err := connector.FindAll(&accounts, &db.DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(accounts) != 2 || accounts[0] != (Account{ID: id, Email: "a@example.com", Age: 30}) {
+		t.Errorf("unexpected accounts: %+v", accounts)
+	}
+
+	var account Account
+	if err := connector.FindFirst(&account, id); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	last := fake.LastStatement()
+	if !strings.Contains(last.SQL, "WHERE id = $1") || !reflect.DeepEqual(last.Args, []interface{}{id}) {
+		t.Errorf("unexpected statement: %+v", last)
+	}
+}
+
+func TestFindAllDoesNotChangeTheQuery(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	query := &db.DatabaseQuery{Conditions: []db.Condition{{Field: "age", Operator: ">", Value: 18}}}
+	for i := 0; i < 2; i++ {
+		var accounts []Account
+		if err := connector.FindAll(&accounts, query); err != nil {
+			t.Fatalf("error should be nil, but was: %s", err)
+		}
+		if sql := fake.LastStatement().SQL; !strings.HasPrefix(sql, "SELECT id, email, age FROM") {
+			t.Fatalf("unexpected select list in query %d: %s", i+1, sql)
+		}
+	}
+	if query.Table != "" {
+		t.Errorf("expected the query to be left unchanged, got table %q", query.Table)
+	}
+}
+
+func TestFakeConnectorRecordsStatements(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnExec("UPDATE gpo_account", 3)
+	account := Account{ID: uuid.New(), Email: "a@example.com", Age: 30}
+
+	err := connector.WithinTransaction(func(tx *sql.Tx) error {
+		if err := connector.InsertModel(&account, db.WithTransaction(tx)); err != nil {
+			return err
+		}
+		affected, err := connector.UpdateModel(&account, nil, db.WithTransaction(tx))
+		if affected != 3 {
+			t.Errorf("expected 3 affected rows, got %d", affected)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var statements []string
+	for _, statement := range fake.Statements() {
+		statements = append(statements, strings.Fields(statement.SQL)[0])
+	}
+	if !reflect.DeepEqual(statements, []string{"BEGIN", "INSERT", "UPDATE", "COMMIT"}) {
+		t.Errorf("unexpected statements: %v", statements)
+	}
+
+	fake.Reset()
+	failure := errors.New("connection reset")
+	fake.OnError("DELETE FROM gpo_account", failure)
+	if _, err := connector.DeleteModel(&account, []db.Condition{{Field: "id", Operator: "=", Value: account.ID}}); !errors.Is(err, failure) {
+		t.Errorf("expected the canned error, got %v", err)
+	}
+}
+
+func TestScanRowsFromCustomQuery(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	id := uuid.New()
+	fake.OnQuery("WHERE age >", NewRows("id", "email", "age", "score").
+		AddRow(id, "a@example.com", 30, 0.5).
+		AddRow(uuid.New(), "b@example.com", 40, 0.7))
+
+	rows, err := connector.CustomQuery(context.Background(), nil, "SELECT id, email, age, 0.5 AS score FROM gpo_account WHERE age > $1", 18)
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var accounts []Account
+	if err := connector.ScanRows(rows, &accounts); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(accounts) != 2 || accounts[0] != (Account{ID: id, Email: "a@example.com", Age: 30}) {
+		t.Errorf("unexpected accounts: %+v", accounts)
+	}
+
+	rows, _ = connector.CustomQuery(context.Background(), nil, "SELECT id, email, age FROM gpo_account WHERE age > $1", 18)
+	var account Account
+	if err := connector.ScanRow(rows, &account); err != nil || account.ID != id {
+		t.Errorf("expected the first account, got %+v, error: %v", account, err)
+	}
+	rows, _ = connector.CustomQuery(context.Background(), nil, "SELECT id FROM gpo_account WHERE email = $1", "none")
+	if err := connector.ScanRow(rows, &account); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestQueryToMaps(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("GROUP BY age", NewRows("age", "accounts").AddRow(int64(30), int64(2)).AddRow(int64(40), int64(1)))
+
+	results, err := connector.QueryToMaps(context.Background(), "SELECT age, COUNT(*) AS accounts FROM gpo_account GROUP BY age")
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	want := []map[string]interface{}{{"age": int64(30), "accounts": int64(2)}, {"age": int64(40), "accounts": int64(1)}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("expected %v, got %v", want, results)
+	}
+}
+
+func TestInsertModelsScansReturnedRows(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	first, second := uuid.New(), uuid.New()
+	fake.OnQuery("INSERT INTO gpo_account", NewRows("id", "email", "age").
+		AddRow(first, "a@example.com", 30).
+		AddRow(second, "b@example.com", 18))
+
+	accounts := []Account{{ID: first, Email: "a@example.com", Age: 30}, {ID: second, Email: "b@example.com"}}
+	if err := connector.InsertModels(&accounts); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if accounts[1].Age != 18 {
+		t.Errorf("expected the returned default to be scanned into the second account, got %+v", accounts[1])
+	}
+	last := fake.LastStatement()
+	want := "INSERT INTO gpo_account (id,email,age) VALUES ($1,$2,$3),($4,$5,$6) RETURNING id, email, age"
+	if last.SQL != want || len(last.Args) != 6 {
+		t.Errorf("unexpected statement: %+v", last)
+	}
+
+	fake.Reset()
+	fake.OnQuery("INSERT INTO gpo_account", NewRows("id", "email", "age").AddRow(first, "a@example.com", 30))
+	if err := connector.InsertModels(&accounts); err == nil || !strings.Contains(err.Error(), "returned 1 of 2 rows") {
+		t.Errorf("expected an error for missing returned rows, got %v", err)
+	}
+
+	fake.Reset()
+	fake.OnQuery("INSERT INTO gpo_account", NewRows("id", "email", "age").
+		AddRow(second, "b@example.com", 21).
+		AddRow(first, "a@example.com", 30))
+	if err := connector.InsertModels(&accounts); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if accounts[0].Age != 30 || accounts[1].Age != 21 {
+		t.Errorf("expected the returned rows to be matched by primary key, got %+v", accounts)
+	}
+}
+
+func TestInsertModelsChunksInOneTransaction(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	failure := errors.New("injected failure")
+	fake.OnError("INSERT INTO gpo_account", failure)
+	// More rows than fit into the bind parameters of one statement
+	accounts := make([]Account, 65535/3+1)
+	for i := range accounts {
+		accounts[i] = Account{ID: uuid.New(), Email: fmt.Sprintf("%d@example.com", i)}
+	}
+	if err := connector.InsertModels(&accounts); !errors.Is(err, failure) {
+		t.Fatalf("expected the injected failure, got %v", err)
+	}
+	var verbs []string
+	for _, statement := range fake.Statements() {
+		verbs = append(verbs, strings.Fields(statement.SQL)[0])
+	}
+	if strings.Join(verbs, " ") != "BEGIN INSERT ROLLBACK" {
+		t.Errorf("expected the chunks to be inserted in a transaction, got %v", verbs)
+	}
+}
+
+type Article struct {
+	ID       uuid.UUID `gpo:"id,pk"`
+	Title    string    `gpo:"title"`
+	Comments []Comment `gpo:"-,polymorphic(owner_type,owner_id)"`
+}
+
+type Photo struct {
+	ID  uuid.UUID `gpo:"id,pk"`
+	URL string    `gpo:"url"`
+}
+
+type Comment struct {
+	ID        uuid.UUID   `gpo:"id,pk"`
+	OwnerType string      `gpo:"owner_type"`
+	OwnerID   uuid.UUID   `gpo:"owner_id"`
+	Owner     interface{} `gpo:"-,polymorphic(owner_type,owner_id)"`
+}
+
+func TestPreloadPolymorphicRelations(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	connector.PolymorphicTypes = []interface{}{Article{}, Photo{}}
+	articleID, photoID := uuid.New(), uuid.New()
+	fake.OnQuery("FROM gpo_comment", NewRows("id", "owner_type", "owner_id").
+		AddRow(uuid.New(), "article", articleID).
+		AddRow(uuid.New(), "photo", photoID))
+	fake.OnQuery("FROM gpo_article", NewRows("id", "title").AddRow(articleID, "Hello"))
+	fake.OnQuery("FROM gpo_photo", NewRows("id", "url").AddRow(photoID, "/a.png"))
+
+	var comments []Comment
+	if err := connector.FindAll(&comments, &db.DatabaseQuery{}, db.Preload("Owner")); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if article, ok := comments[0].Owner.(*Article); !ok || article.Title != "Hello" {
+		t.Errorf("expected the article owner, got %#v", comments[0].Owner)
+	}
+	if photo, ok := comments[1].Owner.(*Photo); !ok || photo.URL != "/a.png" {
+		t.Errorf("expected the photo owner, got %#v", comments[1].Owner)
+	}
+
+	var article Article
+	if err := connector.FindFirst(&article, articleID, db.Preload("Comments")); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	last := fake.LastStatement()
+	if !strings.Contains(last.SQL, "owner_type = $") || last.Args[len(last.Args)-1] != "article" {
+		t.Errorf("expected the comments to be filtered by owner type, got %+v", last)
+	}
+
+	connector.PolymorphicTypes = nil
+	if err := connector.FindAll(&comments, &db.DatabaseQuery{}, db.Preload("Owner")); err == nil || !strings.Contains(err.Error(), "PolymorphicTypes") {
+		t.Errorf("expected an error for an unknown owner type, got %v", err)
+	}
+}
+
+type Employee struct {
+	ID        uuid.UUID `gpo:"id,pk"`
+	ManagerID uuid.UUID `gpo:"manager_id,nullable,fk(employee:id,deferred)"`
+}
+
+func TestDeferredForeignKeys(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTable(Employee{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if create := fake.Statements()[0].SQL; !strings.Contains(create, "(manager_id) REFERENCES employee(id) DEFERRABLE INITIALLY DEFERRED") {
+		t.Errorf("expected a deferred foreign key, got %s", create)
+	}
+
+	fake.Reset()
+	err := connector.WithinTransaction(func(tx *sql.Tx) error {
+		return connector.SetConstraintsDeferred(tx)
+	})
+	statements := fake.Statements()
+	if err != nil || len(statements) < 2 || statements[len(statements)-2].SQL != "SET CONSTRAINTS ALL DEFERRED" {
+		t.Errorf("unexpected statements: %+v, error: %v", statements, err)
+	}
+}
+
+type Review struct {
+	ID        uuid.UUID `gpo:"id,pk"`
+	AccountID uuid.UUID `gpo:"account_id,fk(gpo_account:id,cascade)"`
+}
+
+func TestMigrateTableAddsForeignKeys(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).AddRow("account_id", "uuid", "uuid", nil, nil, nil))
+	fake.OnQuery("table_constraints", NewRows("constraint_name", "constraint_type", "column_name").AddRow("gpo_review_pkey", "PRIMARY KEY", "id"))
+	fake.OnQuery("string_agg", NewRows("conname", "relname", "columns"))
+	fake.OnQuery("pg_constraint", NewRows("conname", "attname", "relname", "attname", "confdeltype", "condeferrable", "condeferred"))
+	recorder := &db.SQLRecorder{}
+	connector.Recorder = recorder
+
+	if err := connector.MigrateTable(Review{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var altered []string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "ALTER TABLE") {
+			altered = append(altered, statement.SQL)
+		}
+	}
+	want := "ALTER TABLE gpo_review ADD CONSTRAINT gpo_review_account_id_fkey FOREIGN KEY (account_id) REFERENCES gpo_account(id) ON DELETE CASCADE"
+	if len(altered) != 1 || altered[0] != want {
+		t.Errorf("unexpected statements: %v", altered)
+	}
+	if golden := recorder.String(); !strings.Contains(golden, "information_schema.columns") || !strings.Contains(golden, want+"\n") {
+		t.Errorf("expected the schema reads and migration statements to be recorded, got %q", golden)
+	}
+	connector.Recorder = nil
+
+	fake.Reset()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).AddRow("account_id", "uuid", "uuid", nil, nil, nil))
+	fake.OnQuery("table_constraints", NewRows("constraint_name", "constraint_type", "column_name").AddRow("gpo_review_pkey", "PRIMARY KEY", "id"))
+	fake.OnQuery("string_agg", NewRows("conname", "relname", "columns"))
+	fake.OnQuery("pg_constraint", NewRows("conname", "attname", "relname", "attname", "confdeltype", "condeferrable", "condeferred").
+		AddRow("gpo_review_account_id_fkey", "account_id", "gpo_account", "id", "c", false, false))
+	if err := connector.MigrateTable(Review{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "ALTER TABLE") {
+			t.Errorf("expected no changes for a matching constraint, got %s", statement.SQL)
+		}
+	}
+}
+
+func TestMigrateTablesHoldsMigrationLock(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.MigrateTables(Review{}, Account{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	statements := fake.Statements()
+	first, last := statements[0], statements[len(statements)-1]
+	if first.SQL != "SELECT pg_advisory_lock(hashtext($1))" || first.Args[0] != db.MigrationLockName {
+		t.Errorf("expected the migration lock to be acquired first, got %+v", first)
+	}
+	if last.SQL != "SELECT pg_advisory_unlock(hashtext($1))" {
+		t.Errorf("expected the migration lock to be released last, got %+v", last)
+	}
+	creates := 0
+	for _, statement := range statements {
+		if strings.HasPrefix(statement.SQL, "CREATE TABLE") {
+			creates++
+		}
+	}
+	if creates != 2 {
+		t.Errorf("expected both missing tables to be created, got %d CREATE TABLE statements", creates)
+	}
+}
+
+func TestCreateTablesRollsBackOnFailure(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnError("CREATE TABLE IF NOT EXISTS gpo_account", errors.New("permission denied"))
+	if err := connector.CreateTables(Review{}, Account{}); err == nil {
+		t.Fatal("expected the failing CREATE TABLE to be reported")
+	}
+	var executed []string
+	for _, statement := range fake.Statements() {
+		executed = append(executed, strings.Fields(statement.SQL)[0])
+	}
+	if strings.Join(executed, " ") != "BEGIN CREATE CREATE ROLLBACK" {
+		t.Errorf("expected both tables to be created in one rolled back transaction, got %v", executed)
+	}
+}
+
+type Reply struct {
+	ID       uuid.UUID `gpo:"id,pk"`
+	ReviewID uuid.UUID `gpo:"review_id,fk(review:id,cascade)"`
+}
+
+func TestTablesInDependencyOrder(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTables(Reply{}, Review{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.DropTables(Review{}, "gpo_legacy", Reply{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var tables []string
+	for _, statement := range fake.Statements() {
+		if table, ok := strings.CutPrefix(statement.SQL, "CREATE TABLE IF NOT EXISTS "); ok {
+			tables = append(tables, "CREATE "+strings.Fields(table)[0])
+		} else if table, ok := strings.CutPrefix(statement.SQL, "DROP TABLE "); ok {
+			tables = append(tables, "DROP "+strings.Fields(table)[0])
+		}
+	}
+	want := "CREATE gpo_review,CREATE gpo_reply,DROP gpo_reply,DROP gpo_review,DROP gpo_legacy"
+	if strings.Join(tables, ",") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(tables, ","))
+	}
+}
+
+func TestSchemaHelpers(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.DropColumn(Account{}, "legacy"); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.RenameColumn("gpo_account", "age", "years"); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.RenameTable("gpo_account", "gpo_member"); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var executed []string
+	for _, statement := range fake.Statements() {
+		executed = append(executed, statement.SQL)
+	}
+	want := []string{
+		"ALTER TABLE gpo_account DROP COLUMN legacy",
+		"ALTER TABLE gpo_account RENAME COLUMN age TO years",
+		"ALTER TABLE gpo_account RENAME TO gpo_member",
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("unexpected statements: %v", executed)
+	}
+
+	if err := connector.DropColumn(Account{}, "age; DROP TABLE gpo_account"); err == nil {
+		t.Error("expected an error for an invalid column name")
+	}
+}
+
+type Subscriber struct {
+	ID    uuid.UUID `gpo:"id,pk"`
+	Email string    `gpo:"email,citext,unique"`
+}
+
+func TestCitextColumns(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTable(Subscriber{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	statements := fake.Statements()
+	if statements[0].SQL != "CREATE EXTENSION IF NOT EXISTS citext" || !strings.Contains(statements[1].SQL, "email CITEXT NOT NULL UNIQUE") {
+		t.Errorf("unexpected statements: %+v", statements)
+	}
+
+	fake.Reset()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).AddRow("email", "character varying", "varchar", int64(255), nil, nil))
+	fake.OnQuery("table_constraints", NewRows("constraint_name", "constraint_type", "column_name").
+		AddRow("gpo_subscriber_pkey", "PRIMARY KEY", "id").AddRow("gpo_subscriber_email_key", "UNIQUE", "email"))
+	if err := connector.MigrateTable(Subscriber{}); err != nil {
+		t.Fatalf("expected widening VARCHAR to CITEXT to be allowed, got %s", err)
+	}
+	var altered []string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "ALTER TABLE") || strings.HasPrefix(statement.SQL, "CREATE EXTENSION") {
+			altered = append(altered, statement.SQL)
+		}
+	}
+	want := []string{"CREATE EXTENSION IF NOT EXISTS citext", "ALTER TABLE gpo_subscriber ALTER COLUMN email TYPE CITEXT USING email::CITEXT"}
+	if !reflect.DeepEqual(altered, want) {
+		t.Errorf("unexpected statements: %v", altered)
+	}
+}
+
+type Role struct {
+	ID   int    `gpo:"id,pk"`
+	Name string `gpo:"name"`
+}
+
+func (Role) SeedData() []interface{} {
+	return []interface{}{Role{ID: 1, Name: "admin"}, &Role{ID: 2, Name: "member"}}
+}
+
+func TestCreateTablesWithSeedData(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTables(Role{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "INSERT") {
+			t.Fatalf("expected no seed data without WithSeedData, got %s", statement.SQL)
+		}
+	}
+
+	fake.Reset()
+	if err := connector.CreateTables(Role{}, db.WithSeedData()); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var inserts []Statement
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "INSERT") {
+			inserts = append(inserts, statement)
+		}
+	}
+	want := "INSERT INTO gpo_role (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name"
+	if len(inserts) != 2 || inserts[0].SQL != want {
+		t.Fatalf("unexpected seed statements: %+v", inserts)
+	}
+	if !reflect.DeepEqual(inserts[1].Args, []interface{}{2, "member"}) {
+		t.Errorf("unexpected seed arguments: %v", inserts[1].Args)
+	}
+	if last := fake.LastStatement(); last.SQL != "COMMIT" {
+		t.Errorf("expected the seed data to be inserted in the create transaction, got %s", last.SQL)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	var plan []db.PlannedStatement
+	account := &Account{ID: uuid.New(), Email: "alice@example.com", Age: 30}
+	if err := connector.CreateTables(Account{}, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.InsertModel(account, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if _, err := connector.UpdateModel(account, nil, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if _, err := connector.DeleteModel(account, []db.Condition{{Field: "id", Operator: "=", Value: account.ID}}, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.DropTable(Account{}, true, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if statements := fake.Statements(); len(statements) != 0 {
+		t.Fatalf("expected nothing to be executed, got %+v", statements)
+	}
+	var verbs []string
+	for _, statement := range plan {
+		verbs = append(verbs, strings.Fields(statement.SQL)[0])
+	}
+	if strings.Join(verbs, " ") != "CREATE INSERT UPDATE DELETE DROP" {
+		t.Fatalf("unexpected plan: %v", verbs)
+	}
+	if !reflect.DeepEqual(plan[1].Args[1:], []interface{}{"alice@example.com", 30}) {
+		t.Errorf("unexpected insert arguments: %v", plan[1].Args)
+	}
+
+	plan = nil
+	connector.AuditLog = true
+	accounts := []Account{{ID: uuid.New(), Email: "bob@example.com"}, {ID: uuid.New(), Email: "carol@example.com"}}
+	if err := connector.InsertModels(&accounts, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if statements := fake.Statements(); len(statements) != 0 {
+		t.Fatalf("expected nothing to be executed, got %+v", statements)
+	}
+	if len(plan) != 1 || !strings.HasPrefix(plan[0].SQL, "INSERT INTO gpo_account") {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestDryRunMigration(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).
+		AddRow("email", "character varying", "varchar", int64(255), nil, nil))
+	var plan []db.PlannedStatement
+	if err := connector.MigrateTables(Account{}, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "ALTER") || strings.HasPrefix(statement.SQL, "BEGIN") {
+			t.Errorf("expected the migration not to be executed, got %s", statement.SQL)
+		}
+	}
+	if len(plan) == 0 || !strings.HasPrefix(plan[0].SQL, "ALTER TABLE gpo_account ADD COLUMN age") {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestSQLRecorder(t *testing.T) {
+	connector, _ := NewFakeConnector()
+	recorder := &db.SQLRecorder{}
+	connector.Recorder = recorder
+	account := &Account{ID: uuid.MustParse("7a1f3c2e-1111-4c43-9a3e-3c1f5d0b7e21"), Email: "alice@example.com", Age: 30}
+	if err := connector.CreateTable(Account{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.InsertModel(account); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	statements := recorder.Statements()
+	if len(statements) != 2 || !strings.HasPrefix(statements[0].SQL, "CREATE TABLE IF NOT EXISTS gpo_account") {
+		t.Fatalf("unexpected statements: %+v", statements)
+	}
+	want := "INSERT INTO gpo_account (id,email,age) VALUES ($1,$2,$3) -- [7a1f3c2e-1111-4c43-9a3e-3c1f5d0b7e21 alice@example.com 30]\n"
+	if golden := recorder.String(); !strings.HasSuffix(golden, want) {
+		t.Errorf("expected the log to end with %q, got %q", want, golden)
+	}
+	recorder.Reset()
+	if len(recorder.Statements()) != 0 {
+		t.Error("expected Reset to clear the log")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	var calls []string
+	connector.Use(func(next db.OperationFunc) db.OperationFunc {
+		return func(ctx context.Context, op db.Operation) error {
+			calls = append(calls, "outer "+op.Kind)
+			return next(ctx, op)
+		}
+	}, func(next db.OperationFunc) db.OperationFunc {
+		return func(ctx context.Context, op db.Operation) error {
+			calls = append(calls, "inner "+op.Kind)
+			if strings.HasPrefix(op.SQL, "DELETE") {
+				return errors.New("injected failure")
+			}
+			op.SQL += " /* tenant=acme */"
+			return next(ctx, op)
+		}
+	})
+	account := &Account{ID: uuid.New(), Email: "alice@example.com"}
+	if err := connector.InsertModel(account); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); !strings.HasSuffix(last.SQL, "/* tenant=acme */") {
+		t.Errorf("expected middleware to rewrite the statement, got %s", last.SQL)
+	}
+	var accounts []Account
+	if err := connector.FindAll(&accounts, &db.DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if _, err := connector.DeleteModel(account, nil); err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Errorf("expected the injected failure, got %v", err)
+	}
+	want := []string{"outer exec", "inner exec", "outer query", "inner query", "outer exec", "inner exec"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected %v, got %v", want, calls)
+	}
+}
+
+func TestDefaultScopes(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	connector.AddScope(Account{}, func(query *db.DatabaseQuery) {
+		query.Conditions = append(query.Conditions, db.Condition{Field: "age", Operator: ">=", Value: 18})
+	})
+	query := &db.DatabaseQuery{Conditions: []db.Condition{{Field: "email", Operator: "=", Value: "alice@example.com"}}}
+	var accounts []Account
+	if err := connector.FindAll(&accounts, query); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); !strings.Contains(last.SQL, "email = $1 AND age >= $2") {
+		t.Errorf("expected the scope condition, got %s", last.SQL)
+	}
+	if len(query.Conditions) != 1 {
+		t.Errorf("expected the query of the caller to be unchanged, got %v", query.Conditions)
+	}
+
+	account := &Account{ID: uuid.New(), Email: "alice@example.com", Age: 30}
+	if _, err := connector.UpdateModel(account, nil); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); !strings.Contains(last.SQL, "WHERE id = $3 AND age >= $4") {
+		t.Errorf("expected the primary key and the scope condition, got %s", last.SQL)
+	}
+	if _, err := connector.DeleteModel(account, nil); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); !strings.HasSuffix(last.SQL, "DELETE FROM gpo_account WHERE age >= $1") {
+		t.Errorf("expected a delete without conditions to match the scope only, got %s", last.SQL)
+	}
+	if _, err := connector.DeleteModel(account, nil, db.Unscoped()); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); strings.Contains(last.SQL, "age") {
+		t.Errorf("expected Unscoped to skip the scope, got %s", last.SQL)
+	}
+}
+
+func TestFindAllByExample(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").AddRow(uuid.New().String(), "alice@example.com", int64(30)))
+	var accounts []Account
+	if err := connector.FindAllByExample(&accounts, Account{Email: "alice@example.com", Age: 30}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	last := fake.LastStatement()
+	if !strings.Contains(last.SQL, "WHERE email = $1 AND age = $2") || !reflect.DeepEqual(last.Args, []interface{}{"alice@example.com", 30}) {
+		t.Errorf("unexpected statement: %s %v", last.SQL, last.Args)
+	}
+	if len(accounts) != 1 || accounts[0].Email != "alice@example.com" {
+		t.Errorf("unexpected accounts: %+v", accounts)
+	}
+	if err := connector.FindAllByExample(&accounts, "alice@example.com"); err == nil {
+		t.Error("expected an error for an example that is no model")
+	}
+}
+
+func TestFindInBatches(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	fake.OnQuery("id > $", NewRows("id", "email", "age").AddRow(ids[2].String(), "carol@example.com", int64(40)))
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").
+		AddRow(ids[0].String(), "alice@example.com", int64(30)).AddRow(ids[1].String(), "bob@example.com", int64(35)))
+	var accounts []Account
+	var batches [][]string
+	err := connector.FindInBatches(&accounts, &db.DatabaseQuery{}, 2, func(tx *sql.Tx) error {
+		if tx == nil {
+			t.Error("expected each batch to run in a transaction")
+		}
+		var emails []string
+		for _, account := range accounts {
+			emails = append(emails, account.Email)
+		}
+		batches = append(batches, emails)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	want := [][]string{{"alice@example.com", "bob@example.com"}, {"carol@example.com"}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("expected %v, got %v", want, batches)
+	}
+	var selects []Statement
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "SELECT") {
+			selects = append(selects, statement)
+		}
+	}
+	if len(selects) != 2 || !strings.Contains(selects[0].SQL, "ORDER BY id") || !reflect.DeepEqual(selects[1].Args, []interface{}{ids[1]}) {
+		t.Errorf("expected keyset batches ordered by primary key, got %+v", selects)
+	}
+	for _, statement := range selects {
+		if !strings.HasPrefix(statement.SQL, "SELECT id, email, age FROM") {
+			t.Errorf("expected every batch to select the model columns once, got %s", statement.SQL)
+		}
+	}
+}
+
+func TestTransactionIsolationAndRetries(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	err := connector.WithinTransaction(func(tx *sql.Tx) error {
+		var accounts []Account
+		return connector.FindAll(&accounts, &db.DatabaseQuery{}, db.WithTransaction(tx))
+	}, db.WithIsolation(sql.LevelRepeatableRead), db.WithReadOnly())
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if begin := fake.Statements()[0].SQL; begin != "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY" {
+		t.Errorf("unexpected transaction start: %s", begin)
+	}
+
+	fake.Reset()
+	fake.OnError("UPDATE gpo_account", &pq.Error{Code: "40001", Message: "could not serialize access"})
+	attempts := 0
+	err = connector.WithinTransaction(func(tx *sql.Tx) error {
+		attempts++
+		_, err := connector.UpdateModel(&Account{ID: uuid.New()}, nil, db.WithTransaction(tx))
+		return err
+	}, db.WithIsolation(sql.LevelSerializable))
+	if err == nil || attempts != db.SerializationRetryPolicy.MaxAttempts {
+		t.Errorf("expected %d attempts, got %d, error: %v", db.SerializationRetryPolicy.MaxAttempts, attempts, err)
+	}
+	if begin := fake.Statements()[0].SQL; begin != "BEGIN ISOLATION LEVEL SERIALIZABLE" {
+		t.Errorf("unexpected transaction start: %s", begin)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	connector.Database = "shop"
+	recorder := &db.SQLRecorder{}
+	connector.Recorder = recorder
+	err := connector.Bootstrap(db.BootstrapPlan{
+		Owner: "app_owner",
+		Roles: []db.BootstrapRole{
+			{Name: "app_owner"},
+			{Name: "app", Password: "it's secret", Privileges: []string{"select", "INSERT"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var executed []string
+	for _, statement := range fake.Statements() {
+		if !strings.HasPrefix(statement.SQL, "SELECT") {
+			executed = append(executed, statement.SQL)
+		}
+	}
+	want := []string{
+		`CREATE ROLE "app_owner"`,
+		`CREATE ROLE "app" LOGIN PASSWORD 'it''s secret'`,
+		`ALTER DATABASE "shop" OWNER TO "app_owner"`,
+		`GRANT USAGE ON SCHEMA "public" TO "app"`,
+		`GRANT SELECT, INSERT ON ALL TABLES IN SCHEMA "public" TO "app"`,
+		`ALTER DEFAULT PRIVILEGES FOR ROLE "app_owner" IN SCHEMA "public" GRANT SELECT, INSERT ON TABLES TO "app"`,
+		`GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA "public" TO "app"`,
+		`ALTER DEFAULT PRIVILEGES FOR ROLE "app_owner" IN SCHEMA "public" GRANT USAGE, SELECT ON SEQUENCES TO "app"`,
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("unexpected statements:\n%s", strings.Join(executed, "\n"))
+	}
+	if recorded := recorder.String(); strings.Contains(recorded, "secret") || !strings.Contains(recorded, `CREATE ROLE "app" LOGIN PASSWORD '[REDACTED]'`) {
+		t.Errorf("expected the password to be redacted, got:\n%s", recorded)
+	}
+
+	fake.Reset()
+	fake.OnQuery("pg_roles", NewRows("?column?").AddRow(int64(1)))
+	if err := connector.Bootstrap(db.BootstrapPlan{Roles: []db.BootstrapRole{{Name: "app"}}}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); strings.HasPrefix(last.SQL, "CREATE ROLE") {
+		t.Errorf("expected an existing role to be kept, got %s", last.SQL)
+	}
+	if err := connector.Bootstrap(db.BootstrapPlan{Roles: []db.BootstrapRole{{Name: "app", Privileges: []string{"DROP"}}}}); err == nil {
+		t.Error("expected an error for an unknown privilege")
+	}
+}
+
+func TestGrantAndRevokeOnModel(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.GrantOnModel(Account{}, "reporting", []string{"select", "UPDATE"}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); last.SQL != `GRANT SELECT, UPDATE ON TABLE gpo_account TO "reporting"` {
+		t.Errorf("unexpected statement: %s", last.SQL)
+	}
+	if err := connector.RevokeOnModel("audit.events", `odd"role`, []string{"ALL"}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); last.SQL != `REVOKE ALL ON TABLE audit.events FROM "odd""role"` {
+		t.Errorf("unexpected statement: %s", last.SQL)
+	}
+	if err := connector.GrantOnModel(Account{}, "reporting", []string{"SELECT; DROP TABLE gpo_account"}); err == nil {
+		t.Error("expected an error for an unknown privilege")
+	}
+	if err := connector.GrantOnModel(`events"; DROP TABLE gpo_account; --`, "reporting", []string{"SELECT"}); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+
+	fake.Reset()
+	var plan []db.PlannedStatement
+	if err := connector.GrantOnModel(Account{}, "reporting", []string{"SELECT"}, db.WithDryRun(&plan)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(fake.Statements()) != 0 || len(plan) != 1 || !strings.HasPrefix(plan[0].SQL, "GRANT SELECT") {
+		t.Errorf("expected the grant to be planned, got %+v, executed %+v", plan, fake.Statements())
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("reltuples", NewRows("int8").AddRow(int64(1234567)))
+	count, err := connector.EstimateCount(Account{}, db.WithAnalyze())
+	if err != nil || count != 1234567 {
+		t.Fatalf("expected the estimate, got %d, error: %v", count, err)
+	}
+	if statements := fake.Statements(); statements[0].SQL != "ANALYZE gpo_account" || !reflect.DeepEqual(statements[1].Args, []interface{}{"gpo_account"}) {
+		t.Errorf("unexpected statements: %+v", statements)
+	}
+
+	fake.Reset()
+	fake.OnQuery("reltuples", NewRows("int8").AddRow(nil))
+	fake.OnQuery("COUNT(*)", NewRows("count").AddRow(int64(42)))
+	if count, err := connector.EstimateCount("gpo_account"); err != nil || count != 42 {
+		t.Errorf("expected an exact count for a table never analyzed, got %d, error: %v", count, err)
+	}
+}
+
+func TestTableStats(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	analyzed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake.OnQuery("pg_total_relation_size", NewRows("reltuples", "total", "table", "indexes", "toast", "live", "dead",
+		"last_vacuum", "last_autovacuum", "last_analyze", "last_autoanalyze").
+		AddRow(int64(9000), int64(4096000), int64(3072000), int64(819200), int64(204800), int64(8000), int64(2000), nil, nil, nil, analyzed))
+	stats, err := connector.TableStats(Account{})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if stats.Table != "gpo_account" || stats.EstimatedRows != 9000 || stats.IndexBytes != 819200 || stats.ToastBytes != 204800 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.DeadTupleRatio != 0.2 || stats.EstimatedBloatBytes != 614400 || !stats.NeedsVacuum {
+		t.Errorf("unexpected dead tuple assessment: %+v", stats.DeadTupleStats)
+	}
+	if stats.LastAnalyze != nil || stats.LastAutoanalyze == nil || !stats.LastAutoanalyze.Equal(analyzed) {
+		t.Errorf("unexpected analyze times: %v %v", stats.LastAnalyze, stats.LastAutoanalyze)
+	}
+	fake.Reset()
+	if _, err := connector.TableStats("gpo_missing"); err == nil {
+		t.Error("expected an error for a table without statistics")
+	}
+}
+
+func TestMaintenanceHelpers(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.Analyze(Account{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.Vacuum(Account{}, false); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.Vacuum("archive.events", true); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.Reindex(Account{}, true); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var executed []string
+	for _, statement := range fake.Statements() {
+		executed = append(executed, statement.SQL)
+	}
+	want := []string{
+		`ANALYZE gpo_account`,
+		`VACUUM (ANALYZE) gpo_account`,
+		`VACUUM (FULL, ANALYZE) archive.events`,
+		`REINDEX TABLE CONCURRENTLY gpo_account`,
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("unexpected statements:\n%s", strings.Join(executed, "\n"))
+	}
+
+	fake.Reset()
+	tx, err := connector.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	defer tx.Rollback()
+	if err := connector.Analyze(Account{}, db.WithTransaction(tx)); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if err := connector.Vacuum(Account{}, false, db.WithTransaction(tx)); err == nil {
+		t.Error("expected VACUUM to refuse the transaction")
+	}
+	var plan []db.PlannedStatement
+	if err := connector.Vacuum(Account{}, true, db.WithDryRun(&plan)); err != nil || len(plan) != 1 {
+		t.Errorf("expected the vacuum to be planned, got %+v, error: %v", plan, err)
+	}
+	if statements := fake.Statements(); len(statements) != 2 || statements[1].SQL != "ANALYZE gpo_account" {
+		t.Errorf("expected only the analyze to run in the transaction, got %+v", statements)
+	}
+}
+
+func TestTopQueries(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	connector.TrackQueries = true
+	var accounts []Account
+	if err := connector.FindAll(&accounts, &db.DatabaseQuery{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	own := fake.LastStatement().SQL
+	fake.OnQuery("pg_stat_statements", NewRows("query", "calls", "total_exec_time", "mean_exec_time", "rows").
+		AddRow(own, int64(200), 1500.0, 7.5, int64(4000)).
+		AddRow("SELECT * FROM legacy_report", int64(3), 900.0, 300.0, int64(3)))
+	top, err := connector.TopQueries(10)
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if len(top) != 2 || !top[0].Tracked || top[1].Tracked {
+		t.Fatalf("expected only the statement of the connector to be tracked, got %+v", top)
+	}
+	if top[0].TotalTime != 1500*time.Millisecond || top[0].MeanTime != 7500*time.Microsecond || top[0].Calls != 200 {
+		t.Errorf("unexpected statistics: %+v", top[0])
+	}
+
+	fake.Reset()
+	fake.OnError("pg_stat_statements", &pq.Error{Code: "42P01", Message: `relation "pg_stat_statements" does not exist`})
+	if _, err := connector.TopQueries(10); !errors.Is(err, db.ErrExtensionMissing) {
+		t.Errorf("expected ErrExtensionMissing, got %v", err)
+	}
+}
+
+func TestSnapshotAndRestoreTables(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	id := uuid.New().String()
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").
+		AddRow(id, "a\tb@example.com", nil))
+	var snapshot strings.Builder
+	if err := connector.SnapshotTables(&snapshot, Account{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if begin := fake.Statements()[0].SQL; begin != "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY" {
+		t.Errorf("unexpected transaction start: %s", begin)
+	}
+	want := "-- go-postgresql-orm snapshot\nCOPY gpo_account (id, email, age) FROM stdin;\n" +
+		id + "\ta\\tb@example.com\t\\N\n\\.\n"
+	if snapshot.String() != want {
+		t.Errorf("unexpected snapshot:\n%s", snapshot.String())
+	}
+
+	fake.Reset()
+	fake.OnQuery("pg_get_serial_sequence($1, a.attname)", NewRows("attname").AddRow("age"))
+	restored, err := connector.RestoreTables(strings.NewReader(snapshot.String()))
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	statements := fake.Statements()
+	if restored != 1 || len(statements) != 6 || statements[1].SQL != "COPY gpo_account (id, email, age) FROM STDIN" {
+		t.Fatalf("unexpected restore of %d rows: %+v", restored, statements)
+	}
+	if !reflect.DeepEqual(statements[1].Args, []interface{}{id, "a\tb@example.com", nil}) {
+		t.Errorf("unexpected restored row: %+v", statements[1].Args)
+	}
+	want = `SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX("age"), 0) + 1, false) FROM gpo_account`
+	if statements[4].SQL != want || !reflect.DeepEqual(statements[4].Args, []interface{}{"gpo_account", "age"}) {
+		t.Errorf("expected the sequence to be advanced, got %+v", statements[4])
+	}
+
+	if _, err := connector.RestoreTables(strings.NewReader("COPY gpo_account (id) FROM stdin;\n" + id + "\n")); err == nil {
+		t.Errorf("expected an error for a truncated snapshot")
+	}
+}
+
+type Document struct {
+	ID    uuid.UUID `gpo:"id,pk"`
+	Title string    `gpo:"title"`
+}
+
+func (Document) KeepHistory() bool { return true }
+
+func TestHistoryTables(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTable(Document{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var executed []string
+	for _, statement := range fake.Statements() {
+		executed = append(executed, statement.SQL)
+	}
+	history := strings.Join(executed[1:], "\n")
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS gpo_document_history (valid_from TIMESTAMPTZ NOT NULL, valid_to TIMESTAMPTZ)",
+		"ALTER TABLE gpo_document_history ADD COLUMN IF NOT EXISTS id UUID, ADD COLUMN IF NOT EXISTS title VARCHAR(255)",
+		"UPDATE gpo_document_history SET valid_to = now() WHERE id = OLD.id AND valid_to IS NULL",
+		"INSERT INTO gpo_document_history (id, title, valid_from) VALUES (NEW.id, NEW.title, now())",
+		"CREATE TRIGGER record_history AFTER INSERT OR UPDATE OR DELETE ON gpo_document FOR EACH ROW EXECUTE FUNCTION gpo_document_record_history()",
+		"INSERT INTO gpo_document_history (id, title, valid_from) SELECT t.id, t.title, now() FROM gpo_document t " +
+			"WHERE NOT EXISTS (SELECT 1 FROM gpo_document_history h WHERE h.id = t.id AND h.valid_to IS NULL)",
+	} {
+		if !strings.Contains(history, want) {
+			t.Errorf("expected history statements to contain %q, got:\n%s", want, history)
+		}
+	}
+
+	fake.Reset()
+	id := uuid.New()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fake.OnQuery("FROM gpo_document_history", NewRows("id", "title").AddRow(id, "Draft"))
+	document := Document{ID: id}
+	if err := connector.FindAsOf(&document, at); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	last := fake.LastStatement()
+	if document.Title != "Draft" || !strings.Contains(last.SQL, "valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)") ||
+		!reflect.DeepEqual(last.Args, []interface{}{id, at}) {
+		t.Errorf("unexpected version %+v from %+v", document, last)
+	}
+
+	fake.Reset()
+	if err := connector.FindAsOf(&Document{ID: id}, at); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+	if err := connector.FindAsOf(&Account{ID: id}, at); err == nil {
+		t.Errorf("expected an error for a model without history")
+	}
+}
+
+func TestCacheInvalidatedAfterCommit(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	cache := db.NewMemoryCache()
+	connector.Cache = cache
+	connector.DefaultCacheTTL = time.Minute
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").AddRow(uuid.New(), "a@example.com", 30))
+	err := connector.WithinTransaction(func(tx *sql.Tx) error {
+		if _, err := connector.UpdateModel(&Account{ID: uuid.New(), Email: "b@example.com"}, nil, db.WithTransaction(tx)); err != nil {
+			return err
+		}
+		// Another session reads and caches the rows before the commit
+		var accounts []Account
+		return connector.FindAll(&accounts, &db.DatabaseQuery{})
+	})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("expected the results cached before the commit to be dropped, %d entries left", cache.Len())
+	}
+}
+
+func TestCircuitBreakerServesStaleResults(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	clock := db.NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := db.NewMemoryCache()
+	cache.Clock = clock
+	cache.StaleTTL = time.Hour
+	connector.Cache = cache
+	connector.DefaultCacheTTL = time.Minute
+	breaker := &db.CircuitBreaker{FailureRatio: 0.5, MinRequests: 1, Window: time.Minute, OpenDuration: time.Minute, ServeStale: true}
+	connector.CircuitBreaker = breaker
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").AddRow(uuid.New(), "a@example.com", 30))
+	query := &db.DatabaseQuery{}
+	var accounts []Account
+	if err := connector.FindAll(&accounts, query); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	fake.Reset()
+	fake.OnError("FROM gpo_account", &pq.Error{Code: "08006", Message: "connection refused"})
+	if err := connector.FindAll(&accounts, query); err == nil || !breaker.IsOpen() {
+		t.Fatalf("expected the failed read to open the circuit, got %v", err)
+	}
+	var stale []Account
+	if err := connector.FindAll(&stale, query); err != nil || len(stale) != 1 || stale[0].Email != "a@example.com" {
+		t.Errorf("expected the expired result to be served, got %+v, error: %v", stale, err)
+	}
+	breaker.ServeStale = false
+	if err := connector.FindAll(&stale, query); err == nil {
+		t.Error("expected ErrCircuitOpen without ServeStale")
+	}
+}
+
+func TestDeleteModelAcceptsTheOperatorsOfReads(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnExec("DELETE FROM gpo_account", 2)
+	deleted, err := connector.DeleteModel(&Account{}, []db.Condition{{Field: "email", Operator: "~~*", Value: "%@example.com"}})
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 deleted rows, got %d, error: %v", deleted, err)
+	}
+	if last := fake.LastStatement(); last.SQL != "DELETE FROM gpo_account WHERE email ~~* $1" {
+		t.Errorf("unexpected statement: %s", last.SQL)
+	}
+}
+
+// failingWriter fails every write, e.g. a full disk
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestExportTableEncodesNullAndReportsWriteErrors(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").
+		AddRow("7a1f3c2e-1111-4c43-9a3e-3c1f5d0b7e21", "", "30").
+		AddRow("7a1f3c2e-2222-4c43-9a3e-3c1f5d0b7e21", nil, "31"))
+	var buf strings.Builder
+	if err := connector.ExportTable(&Account{}, &buf, db.FormatCSV); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	want := "id,email,age\n7a1f3c2e-1111-4c43-9a3e-3c1f5d0b7e21,,30\n7a1f3c2e-2222-4c43-9a3e-3c1f5d0b7e21,\\N,31\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+	for _, format := range []db.TransferFormat{db.FormatCSV, db.FormatCopyText} {
+		if err := connector.ExportTable(&Account{}, failingWriter{}, format); err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Errorf("expected the write error of the %s export, got %v", format, err)
+		}
+	}
+}
+
+func TestFuzzyOperatorInUpdatesAndDeletes(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fuzzy := []db.Condition{{Field: "email", Operator: db.FuzzyOperator, Value: "alice"}}
+	if _, err := connector.UpdateModel(&Account{Email: "alice@example.com"}, fuzzy); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); !strings.Contains(last.SQL, "WHERE email ILIKE $") {
+		t.Errorf("expected the ILIKE fallback in the update, got %s", last.SQL)
+	}
+	if _, err := connector.DeleteModel(&Account{}, fuzzy); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	if last := fake.LastStatement(); last.SQL != "DELETE FROM gpo_account WHERE email ILIKE $1" || last.Args[0] != "%alice%" {
+		t.Errorf("expected the ILIKE fallback in the delete, got %s %v", last.SQL, last.Args)
+	}
+}
+
+type Tag struct {
+	ID   uuid.UUID `gpo:"id,pk"`
+	Name string    `gpo:"name,notempty"`
+}
+
+func TestSeedValidatesModels(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	err := connector.Seed(&[]Tag{{ID: uuid.New(), Name: "go"}, {ID: uuid.New()}})
+	var failures db.ValidationErrors
+	if !errors.As(err, &failures) || failures[0].Field != "name" {
+		t.Fatalf("expected the validation failure of the second tag, got %v", err)
+	}
+	var statements []string
+	for _, statement := range fake.Statements() {
+		statements = append(statements, strings.Fields(statement.SQL)[0])
+	}
+	if want := []string{"BEGIN", "INSERT", "ROLLBACK"}; !reflect.DeepEqual(statements, want) {
+		t.Errorf("expected %v, got %v", want, statements)
+	}
+}
+
+func TestQueueUsesDatabaseClock(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	queue := db.NewQueue(connector, "mail")
+	job, err := queue.Dequeue()
+	if err != nil || job != nil {
+		t.Fatalf("expected no job, got %v %v", job, err)
+	}
+	statements := fake.Statements()
+	if len(statements) != 3 {
+		t.Fatalf("expected the table, the timed out jobs and the claim, got %v", statements)
+	}
+	if create := statements[0].SQL; !strings.Contains(create, "run_at TIMESTAMPTZ") || strings.Contains(create, "ALTER TABLE") {
+		t.Errorf("unexpected jobs table %s", create)
+	}
+	if expire := statements[1]; !strings.Contains(expire.SQL, "locked_until <= now() AND attempts >= max_attempts") || expire.Args[0] != db.JobFailed {
+		t.Errorf("expected timed out last attempts to fail, got %s %v", expire.SQL, expire.Args)
+	}
+	if claim := statements[2].SQL; !strings.Contains(claim, "locked_until = now() + $2") || !strings.Contains(claim, "run_at <= now()") {
+		t.Errorf("expected the claim to use the database clock, got %s", claim)
+	}
+}
+
+type Invoice struct {
+	ID    uuid.UUID `gpo:"id,pk"`
+	Total int64     `gpo:"total,comment(Total in cents)"`
+}
+
+func TestMigrateTableUpdatesComments(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).AddRow("total", "bigint", "int8", nil, nil, nil))
+	fake.OnQuery("table_constraints", NewRows("constraint_name", "constraint_type", "column_name").AddRow("gpo_invoice_pkey", "PRIMARY KEY", "id"))
+	fake.OnQuery("col_description", NewRows("name", "comment").AddRow("", "").AddRow("id", "").AddRow("total", "Total"))
+	if err := connector.MigrateTable(Invoice{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var comments []string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "COMMENT ON") {
+			comments = append(comments, statement.SQL)
+		}
+	}
+	if want := []string{"COMMENT ON COLUMN gpo_invoice.total IS 'Total in cents'"}; !reflect.DeepEqual(comments, want) {
+		t.Errorf("expected %v, got %v", want, comments)
+	}
+}
+
+type Event struct {
+	ID        uuid.UUID `gpo:"id,pk"`
+	Slug      string    `gpo:"slug,unique"`
+	CreatedAt time.Time `gpo:"created_at"`
+}
+
+func (Event) Partitioning() db.Partitioning {
+	return db.Partitioning{Strategy: db.PartitionByRange, Columns: []string{"created_at"}}
+}
+
+func TestPartitionedTableKeysIncludePartitionColumns(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	if err := connector.CreateTable(Event{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var create string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "CREATE TABLE") {
+			create = statement.SQL
+		}
+	}
+	if !strings.Contains(create, "PRIMARY KEY (id, created_at),UNIQUE (slug, created_at))") || strings.Contains(create, "NOT NULL UNIQUE") {
+		t.Errorf("expected the keys to include the partition column, got %s", create)
+	}
+
+	fake.Reset()
+	fake.OnQuery("information_schema.columns", NewRows("column_name", "data_type", "udt_name", "character_maximum_length", "collation_name", "column_default").
+		AddRow("id", "uuid", "uuid", nil, nil, nil).AddRow("slug", "character varying", "varchar", 255, nil, nil).
+		AddRow("created_at", "timestamp without time zone", "timestamp", nil, nil, nil))
+	fake.OnQuery("table_constraints", NewRows("constraint_name", "constraint_type", "column_name").
+		AddRow("gpo_event_pkey", "PRIMARY KEY", "id").AddRow("gpo_event_pkey", "PRIMARY KEY", "created_at"))
+	if err := connector.MigrateTable(Event{}); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var altered []string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "ALTER TABLE") {
+			altered = append(altered, statement.SQL)
+		}
+	}
+	want := []string{"ALTER TABLE gpo_event ADD CONSTRAINT gpo_event_slug_created_at_key UNIQUE (slug, created_at)"}
+	if !reflect.DeepEqual(altered, want) {
+		t.Errorf("expected %v, got %v", want, altered)
+	}
+}
+
+func TestRepositoryFindAndDelete(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	accounts := db.NewRepository[Account](connector)
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	var hookValue interface{}
+	accounts.Hooks.AfterFind = func(ctx context.Context, account *Account) error {
+		hookValue = ctx.Value(ctxKey{})
+		return nil
+	}
+	if _, err := accounts.Find(uuid.New(), db.WithContext(ctx)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing id, got %v", err)
+	}
+	fake.OnQuery("FROM gpo_account", NewRows("id", "email", "age").AddRow(uuid.New(), "a@example.com", 30))
+	if account, err := accounts.FindFirst([]db.Condition{{Field: "age", Operator: ">", Value: 18}}, db.WithContext(ctx)); err != nil || account.Email != "a@example.com" {
+		t.Errorf("expected the account, got %+v, error: %v", account, err)
+	}
+	if hookValue != "request" {
+		t.Errorf("expected the hook to get the context of the operation, got %v", hookValue)
+	}
+
+	fake.Reset()
+	if _, err := accounts.Delete(nil); !errors.Is(err, db.ErrNoConditions) {
+		t.Errorf("expected ErrNoConditions, got %v", err)
+	}
+	if len(fake.Statements()) != 0 {
+		t.Errorf("expected no statement, got %v", fake.Statements())
+	}
+	if _, err := accounts.DeleteAll(); err != nil || fake.LastStatement().SQL != "DELETE FROM gpo_account" {
+		t.Errorf("expected an explicit delete of all rows, got %v, error: %v", fake.LastStatement(), err)
+	}
+}
+
+func TestEnsureUniqueValueGivesUp(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	fake.OnQuery("WHERE email LIKE", NewRows("email").AddRow("jane").AddRow("jane-2"))
+	account := &Account{ID: uuid.New()}
+	if value, err := connector.EnsureUniqueValue(account, "email", "jane", nil); err != nil || value != "jane-3" {
+		t.Errorf("expected jane-3, got %q, error: %v", value, err)
+	}
+
+	fake.Reset()
+	fake.OnQuery("WHERE email LIKE", NewRows("email").AddRow("jane").AddRow("jane-2"))
+	repeat := func(base string, n int) string { return base }
+	if _, err := connector.EnsureUniqueValue(account, "email", "jane", repeat); err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected the search to give up, got %v", err)
+	}
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "INSERT") {
+			t.Errorf("expected no insert, got %s", statement.SQL)
+		}
+	}
+}
+
+func TestAuditUpdateComparesEachRow(t *testing.T) {
+	connector, fake := NewFakeConnector()
+	connector.AuditLog = true
+	alice, bob := uuid.New(), uuid.New()
+	fake.OnQuery("id IN", NewRows("id", "email", "age").
+		AddRow(alice, "alice@example.com", 40).
+		AddRow(bob, "bob@example.com", 40))
+	fake.OnQuery("FOR UPDATE", NewRows("id", "email", "age").
+		AddRow(alice, "alice@example.com", 30).
+		AddRow(bob, "bob@example.com", 30))
+	fake.OnExec("UPDATE gpo_account", 2)
+	conditions := []db.Condition{{Field: "age", Operator: "=", Value: 30}}
+	if _, err := connector.UpdateModel(&Account{Age: 40}, conditions); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	var changes []string
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.SQL, "INSERT INTO gpo_audit_log") {
+			changes = append(changes, statement.Args[3].(string))
+		}
+	}
+	want := []string{`{"age":{"old":30,"new":40}}`, `{"age":{"old":30,"new":40}}`}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected only the age of each row to change, got %v", changes)
+	}
+}
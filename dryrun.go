@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// PlannedStatement is a statement that an operation called WithDryRun would
+// have executed, or that a SQLRecorder captured
+type PlannedStatement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// WithDryRun makes InsertModel(s), UpdateModel, DeleteModel, CreateTable(s),
+// MigrateTable(s), DropTable(s), TruncateTable(s), GrantOnModel, RevokeOnModel
+// and the ALTER TABLE helpers append the statements they would execute to plan instead of executing them,
+// e.g. to preview or debug them. Validation and the schema reads of migrations
+// still run, but no transaction is begun, no audit log is written, no events are
+// emitted and affected row counts are 0.
+func WithDryRun(plan *[]PlannedStatement) Option {
+	return func(c *Config) { c.dryRun = &dryRun{plan: plan} }
+}
+
+// dryRun records the statements of a dry run
+type dryRun struct {
+	plan *[]PlannedStatement
+}
+
+type dryRunKey struct{}
+
+// errPlanned is returned by queryStatement in a dry run, the statement is added
+// to the plan and there are no rows to return
+var errPlanned = errors.New("statement planned by dry run")
+
+// ExecContext records the statement, dryRun is the execer of dry run DDL
+func (d *dryRun) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	*d.plan = append(*d.plan, PlannedStatement{SQL: query, Args: args})
+	return driver.RowsAffected(0), nil
+}
+
+// dryRunOf returns the dry run of the operation of ctx, or nil when it executes
+func dryRunOf(ctx context.Context) *dryRun {
+	d, _ := ctx.Value(dryRunKey{}).(*dryRun)
+	return d
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// ChangeEvent describes a committed mutation of a table
+type ChangeEvent struct {
+	// Table is the table name with prefix
+	Table string
+	// Operation is "insert", "update", "delete" or "truncate"
+	Operation string
+	// Model is the model passed to the mutation, nil for truncate
+	Model interface{}
+	// PrimaryKey is the primary key value of the changed row, nil when the
+	// mutation was not restricted to one row by primary key
+	PrimaryKey interface{}
+}
+
+// eventBus holds the OnCommit listeners and the events of open transactions
+type eventBus struct {
+	mu        sync.Mutex
+	listeners []func(ctx context.Context, event ChangeEvent)
+	pending   map[*sql.Tx][]pendingEvent
+}
+
+// pendingEvent is an event waiting for its transaction to commit
+type pendingEvent struct {
+	ctx   context.Context
+	event ChangeEvent
+	// afterCommit runs instead of the listeners when set, e.g. to invalidate the Cache
+	afterCommit func()
+}
+
+// eventBusInit guards the creation of the event bus of connectors
+var eventBusInit sync.Mutex
+
+// OnCommit registers a listener receiving InsertModel, UpdateModel, DeleteModel
+// and TruncateTables events once the enclosing transaction committed, e.g. for
+// cache invalidation, search indexing or webhooks. Events of rolled back
+// transactions are never delivered. Listeners run in the committing goroutine.
+//
+// Transactions must end with WithinTransaction, CommitTx or RollbackTx, events
+// of transactions committed directly on the *sql.Tx are not delivered and are
+// dropped. Register listeners before copying the connector, copies share the
+// listeners only then.
+func (s *PostgreSQLConnector) OnCommit(listener func(ctx context.Context, event ChangeEvent)) {
+	bus := s.initEventBus()
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.listeners = append(bus.listeners, listener)
+}
+
+// initEventBus creates the event bus of the connector unless it has one
+func (s *PostgreSQLConnector) initEventBus() *eventBus {
+	eventBusInit.Lock()
+	defer eventBusInit.Unlock()
+	if s.events == nil {
+		s.events = &eventBus{pending: make(map[*sql.Tx][]pendingEvent)}
+	}
+	return s.events
+}
+
+// eventBus returns the event bus of the connector, nil before it connected or
+// registered OnCommit listeners
+func (s *PostgreSQLConnector) eventBus() *eventBus {
+	eventBusInit.Lock()
+	defer eventBusInit.Unlock()
+	return s.events
+}
+
+// emit delivers the event now outside of transactions, or when tx commits
+func (s *PostgreSQLConnector) emit(ctx context.Context, tx *sql.Tx, event ChangeEvent) {
+	bus := s.eventBus()
+	if bus == nil || dryRunOf(ctx) != nil {
+		return
+	}
+	if tx == nil {
+		bus.deliver([]pendingEvent{{ctx: ctx, event: event}})
+		return
+	}
+	if bus.listening() {
+		bus.queue(tx, pendingEvent{ctx: ctx, event: event})
+	}
+}
+
+// listening reports whether OnCommit listeners are registered
+func (b *eventBus) listening() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.listeners) > 0
+}
+
+// queue adds an event to the pending events of tx
+func (b *eventBus) queue(tx *sql.Tx, event pendingEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[tx]; !ok {
+		b.dropEnded()
+	}
+	b.pending[tx] = append(b.pending[tx], event)
+}
+
+// dropEnded drops the events of transactions that were committed or rolled back
+// directly on the *sql.Tx, which would otherwise be kept forever. The caller
+// holds b.mu.
+func (b *eventBus) dropEnded() {
+	for tx := range b.pending {
+		if txDone(tx) {
+			delete(b.pending, tx)
+		}
+	}
+}
+
+// txDone reports whether tx was committed or rolled back. Tx.Stmt fails with
+// ErrTxDone for ended transactions, for others it fails without using the
+// connection because the statement belongs to no database.
+func txDone(tx *sql.Tx) bool {
+	return errors.Is(tx.StmtContext(context.Background(), &sql.Stmt{}).Close(), sql.ErrTxDone)
+}
+
+// take removes and returns the pending events of a transaction
+func (b *eventBus) take(tx *sql.Tx) []pendingEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := b.pending[tx]
+	delete(b.pending, tx)
+	return events
+}
+
+func (b *eventBus) deliver(events []pendingEvent) {
+	b.mu.Lock()
+	listeners := b.listeners
+	b.mu.Unlock()
+	for _, pending := range events {
+		if pending.afterCommit != nil {
+			pending.afterCommit()
+			continue
+		}
+		for _, listener := range listeners {
+			listener(pending.ctx, pending.event)
+		}
+	}
+}
+
+// changedPrimaryKey returns the primary key value of conditions selecting one row by primary key
+func changedPrimaryKey(model interface{}, naming NamingStrategy, conditions []Condition) interface{} {
+	if len(conditions) == 0 {
+		return nil
+	}
+	pk := modelMetadataOf(model, naming).primaryKey
+	if len(conditions) != 1 || pk == nil || conditions[0].Field != pk.tag.ColumnName || conditions[0].Operator != "=" {
+		return nil
+	}
+	return conditions[0].Value
+}
+
+// modelPrimaryKey returns the primary key value of a model, nil when it is the zero value
+func modelPrimaryKey(model interface{}) interface{} {
+	value := primaryKeyValue(model)
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return nil
+	}
+	return value
+}
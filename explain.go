@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// PlanNode is a node of a query plan as reported by EXPLAIN (FORMAT JSON).
+// The Actual* fields are only set by EXPLAIN ANALYZE.
+type PlanNode struct {
+	NodeType          string     `json:"Node Type"`
+	RelationName      string     `json:"Relation Name,omitempty"`
+	Alias             string     `json:"Alias,omitempty"`
+	IndexName         string     `json:"Index Name,omitempty"`
+	IndexCond         string     `json:"Index Cond,omitempty"`
+	Filter            string     `json:"Filter,omitempty"`
+	StartupCost       float64    `json:"Startup Cost"`
+	TotalCost         float64    `json:"Total Cost"`
+	PlanRows          float64    `json:"Plan Rows"`
+	PlanWidth         int        `json:"Plan Width"`
+	ActualStartupTime float64    `json:"Actual Startup Time,omitempty"`
+	ActualTotalTime   float64    `json:"Actual Total Time,omitempty"`
+	ActualRows        float64    `json:"Actual Rows,omitempty"`
+	ActualLoops       float64    `json:"Actual Loops,omitempty"`
+	Plans             []PlanNode `json:"Plans,omitempty"`
+}
+
+// QueryPlan is the parsed output of EXPLAIN (FORMAT JSON), times are in milliseconds
+type QueryPlan struct {
+	Plan          PlanNode `json:"Plan"`
+	PlanningTime  float64  `json:"Planning Time,omitempty"`
+	ExecutionTime float64  `json:"Execution Time,omitempty"`
+	// Raw is the unparsed EXPLAIN output
+	Raw json.RawMessage `json:"-"`
+}
+
+// SeqScans returns the relations read with a sequential scan anywhere in the plan
+func (p *QueryPlan) SeqScans() []string {
+	var relations []string
+	var walk func(node PlanNode)
+	walk = func(node PlanNode) {
+		if node.NodeType == "Seq Scan" {
+			relations = append(relations, node.RelationName)
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	walk(p.Plan)
+	return relations
+}
+
+// ParseQueryPlan parses the output of EXPLAIN (FORMAT JSON)
+func ParseQueryPlan(data []byte) (*QueryPlan, error) {
+	var plans []QueryPlan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("error parsing query plan: %v", err)
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("error parsing query plan: empty plan")
+	}
+	plan := plans[0]
+	plan.Raw = append(json.RawMessage{}, data...)
+	return &plan, nil
+}
+
+// explainPrefix returns the EXPLAIN prefix of a query
+func explainPrefix(analyze bool) string {
+	if analyze {
+		return "EXPLAIN (ANALYZE, FORMAT JSON) "
+	}
+	return "EXPLAIN (FORMAT JSON) "
+}
+
+// WithAnalyze makes Explain run EXPLAIN ANALYZE. The query is executed, so
+// only analyze statements without side effects or run them in a rolled back transaction.
+// EstimateCount runs ANALYZE on the table first.
+func WithAnalyze() Option {
+	return func(c *Config) { c.analyze = true }
+}
+
+// ExplainSQL returns the plan of a query, e.g. one built with QueryBuilder.Build
+func (s *PostgreSQLConnector) ExplainSQL(query string, args []interface{}, opts ...Option) (*QueryPlan, error) {
+	config := processOptions(opts)
+	defer config.release()
+	return s.explain(config, query, args...)
+}
+
+// Explain returns the plan of the query FindAll would run for queryProps.
+// Table must be set; all columns are selected unless the query has a projection.
+func (s *PostgreSQLConnector) Explain(queryProps *DatabaseQuery, opts ...Option) (*QueryPlan, error) {
+	if queryProps == nil || queryProps.Table == "" {
+		return nil, fmt.Errorf("table name is required for EXPLAIN")
+	}
+	config := processOptions(opts)
+	defer config.release()
+	props := *queryProps
+	if len(props.fields) == 0 {
+		props.fields = Fields{"*"}
+	}
+	if len(props.columns) > 0 {
+		props.fields = append(Fields{}, props.columns...)
+	}
+	query, args := s.buildReadQuery(config, &props)
+	return s.explain(config, query, args...)
+}
+
+func (s *PostgreSQLConnector) explain(config *Config, query string, args ...interface{}) (*QueryPlan, error) {
+	rows, err := s.readRows(config, explainPrefix(config.analyze)+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error explaining query: %v", err)
+	}
+	return scanQueryPlan(rows)
+}
+
+// scanQueryPlan parses the plan of EXPLAIN (FORMAT JSON) and closes the rows
+func scanQueryPlan(rows *sql.Rows) (*QueryPlan, error) {
+	defer rows.Close()
+	var data []byte
+	if rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("error scanning query plan: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error explaining query: %v", err)
+	}
+	return ParseQueryPlan(data)
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrExtensionMissing is matched by errors of features requiring an extension
+// that is not installed, use errors.As with *ExtensionMissingError for details
+var ErrExtensionMissing = errors.New("required extension is not installed")
+
+// ExtensionMissingError reports the missing extension and the feature needing it
+type ExtensionMissingError struct {
+	Extension string
+	Feature   string
+}
+
+func (e *ExtensionMissingError) Error() string {
+	return fmt.Sprintf("%s requires the %s extension, install it with CREATE EXTENSION %s", e.Feature, e.Extension, e.Extension)
+}
+
+// Is makes errors.Is(err, ErrExtensionMissing) match
+func (e *ExtensionMissingError) Is(target error) bool {
+	return target == ErrExtensionMissing
+}
+
+// FuzzyOperator matches similar strings: with pg_trgm it renders the trigram
+// similarity operator (field % value), without it a case-insensitive contains
+// match (field ILIKE '%value%')
+const FuzzyOperator = "FUZZY"
+
+// extensionSet holds the extensions found by DetectExtensions
+type extensionSet struct {
+	// installed are the extensions created in the database, available those
+	// that the server could create
+	installed, available map[string]bool
+}
+
+// extensionsMu guards the extensions of connectors, DetectExtensions may run
+// while statements of other goroutines read them
+var extensionsMu sync.RWMutex
+
+// DetectExtensions reads the installed and available extensions, call it once
+// after Connect. Features with fallbacks use them until extensions have been
+// detected.
+func (s *PostgreSQLConnector) DetectExtensions(opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	rows, err := s.GetConnection().QueryContext(config.ctx, "SELECT name, installed_version IS NOT NULL FROM pg_available_extensions")
+	if err != nil {
+		return fmt.Errorf("error reading extensions: %v", err)
+	}
+	defer rows.Close()
+	extensions := &extensionSet{installed: make(map[string]bool), available: make(map[string]bool)}
+	for rows.Next() {
+		var name string
+		var installed bool
+		if err := rows.Scan(&name, &installed); err != nil {
+			return err
+		}
+		extensions.available[name] = true
+		extensions.installed[name] = installed
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+	s.extensions = extensions
+	return nil
+}
+
+// detectedExtensions returns the extensions found by DetectExtensions, nil before
+func (s *PostgreSQLConnector) detectedExtensions() *extensionSet {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+	return s.extensions
+}
+
+// HasExtension reports whether DetectExtensions found the extension installed
+func (s *PostgreSQLConnector) HasExtension(name string) bool {
+	extensions := s.detectedExtensions()
+	return extensions != nil && extensions.installed[name]
+}
+
+// RequireExtensions returns an *ExtensionMissingError for the first of the
+// extensions needed by feature that is not installed
+func (s *PostgreSQLConnector) RequireExtensions(feature string, names ...string) error {
+	extensions := s.detectedExtensions()
+	if extensions == nil {
+		return fmt.Errorf("extensions have not been detected, call DetectExtensions first")
+	}
+	for _, name := range names {
+		if !extensions.installed[name] {
+			return &ExtensionMissingError{Extension: name, Feature: feature}
+		}
+	}
+	return nil
+}
+
+// unavailableExtension reports whether DetectExtensions found that the server
+// cannot create the extension, it is neither installed nor available
+func (s *PostgreSQLConnector) unavailableExtension(name string) bool {
+	extensions := s.detectedExtensions()
+	return extensions != nil && !extensions.installed[name] && !extensions.available[name]
+}
+
+// applyExtensionFallbacks renders operators depending on extensions, the given slice is not modified
+func (s *PostgreSQLConnector) applyExtensionFallbacks(conditions []Condition) []Condition {
+	if len(conditions) == 0 {
+		return conditions
+	}
+	result := make([]Condition, len(conditions))
+	for i, condition := range conditions {
+		switch strings.ToUpper(condition.Operator) {
+		case "AND", "OR":
+			if group, ok := condition.Value.([]Condition); ok {
+				condition.Value = s.applyExtensionFallbacks(group)
+			}
+		case FuzzyOperator:
+			if s.HasExtension("pg_trgm") {
+				condition.Operator = "%"
+			} else {
+				condition.Operator = "ILIKE"
+			}
+		}
+		result[i] = condition
+	}
+	return result
+}
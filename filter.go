@@ -0,0 +1,361 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filterOperators maps the operators of the filter grammar to SQL operators
+var filterOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "!=",
+	"gt":   ">",
+	"ge":   ">=",
+	"lt":   "<",
+	"le":   "<=",
+	"like": "LIKE",
+	"in":   "IN",
+}
+
+type filterTokenKind int
+
+const (
+	tokenIdent filterTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenEOF
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// tokenizeFilter splits a filter expression into identifiers, literals and punctuation
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case r == '\'':
+			// Quoted string, a doubled quote escapes a quote
+			start := i
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string starting at position %d", start)
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), value: sb.String(), pos: start})
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			var value interface{}
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number %q at position %d", text, start)
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: text, value: value, pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// filterParser is a recursive descent parser for the filter grammar:
+//
+//	expr       = term { "or" term }
+//	term       = factor { "and" factor }
+//	factor     = "(" expr ")" | comparison
+//	comparison = field operator value
+//	value      = number | 'string' | true | false | "(" value { "," value } ")"
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	allowed map[string]bool
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == tokenIdent && strings.EqualFold(token.text, keyword)
+}
+
+func (p *filterParser) parseExpr() (Condition, error) {
+	return p.parseLogical("or", p.parseTerm)
+}
+
+func (p *filterParser) parseTerm() (Condition, error) {
+	return p.parseLogical("and", p.parseFactor)
+}
+
+// parseLogical parses operands separated by the given keyword and groups them
+func (p *filterParser) parseLogical(keyword string, operand func() (Condition, error)) (Condition, error) {
+	first, err := operand()
+	if err != nil {
+		return Condition{}, err
+	}
+	conditions := []Condition{first}
+	for p.isKeyword(keyword) {
+		p.next()
+		condition, err := operand()
+		if err != nil {
+			return Condition{}, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 1 {
+		return first, nil
+	}
+	if keyword == "or" {
+		return Or(conditions...), nil
+	}
+	return And(conditions...), nil
+}
+
+func (p *filterParser) parseFactor() (Condition, error) {
+	if p.peek().kind == tokenLParen {
+		p.next()
+		condition, err := p.parseExpr()
+		if err != nil {
+			return Condition{}, err
+		}
+		if token := p.next(); token.kind != tokenRParen {
+			return Condition{}, fmt.Errorf("expected ')' at position %d", token.pos)
+		}
+		return condition, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Condition, error) {
+	field := p.next()
+	if field.kind != tokenIdent {
+		return Condition{}, fmt.Errorf("expected field name at position %d", field.pos)
+	}
+	if !p.allowed[field.text] {
+		return Condition{}, fmt.Errorf("filtering on field %q is not allowed", field.text)
+	}
+
+	operatorToken := p.next()
+	operator, ok := filterOperators[strings.ToLower(operatorToken.text)]
+	if operatorToken.kind != tokenIdent || !ok {
+		return Condition{}, fmt.Errorf("unknown operator %q at position %d", operatorToken.text, operatorToken.pos)
+	}
+
+	var value interface{}
+	var err error
+	if operator == "IN" {
+		value, err = p.parseList()
+	} else {
+		value, err = p.parseValue()
+		if _, isString := value.(string); err == nil && operator == "LIKE" && !isString {
+			err = fmt.Errorf("like requires a string value at position %d", operatorToken.pos)
+		}
+	}
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{Field: field.text, Operator: operator, Value: value}, nil
+}
+
+func (p *filterParser) parseValue() (interface{}, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenString, tokenNumber:
+		return token.value, nil
+	case tokenIdent:
+		if strings.EqualFold(token.text, "true") {
+			return true, nil
+		}
+		if strings.EqualFold(token.text, "false") {
+			return false, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a value at position %d", token.pos)
+}
+
+func (p *filterParser) parseList() ([]interface{}, error) {
+	if token := p.next(); token.kind != tokenLParen {
+		return nil, fmt.Errorf("expected '(' at position %d", token.pos)
+	}
+	var values []interface{}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		token := p.next()
+		if token.kind == tokenRParen {
+			return values, nil
+		}
+		if token.kind != tokenComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", token.pos)
+		}
+	}
+}
+
+// ParseFilterExpression parses a filter expression such as
+// "(user_type ge 1) and (email like 'test')" into conditions. Only fields in
+// allowedFields may be referenced. Supported operators are eq, ne, gt, ge, lt,
+// le, like (contains match) and in with a parenthesized list of values.
+func ParseFilterExpression(expr string, allowedFields []string) ([]Condition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	parser := &filterParser{tokens: tokens, allowed: make(map[string]bool)}
+	for _, field := range allowedFields {
+		parser.allowed[field] = true
+	}
+
+	condition, err := parser.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	if token := parser.peek(); token.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid filter: unexpected %q at position %d", token.text, token.pos)
+	}
+	return []Condition{condition}, nil
+}
+
+// ParseFilterFromRequest parses the filter expression in the "q" query parameter
+func ParseFilterFromRequest(r *http.Request, allowedFields []string) ([]Condition, error) {
+	return ParseFilterExpression(r.URL.Query().Get("q"), allowedFields)
+}
+
+// filterParamAliases are operators of filter query parameters in addition to filterOperators
+var filterParamAliases = map[string]string{
+	"gte": ">=",
+	"lte": "<=",
+}
+
+// ParseFilterParams parses filter parameters of the form field:operator:value,
+// e.g. "age:gte:18" or "name:like:jo", into conditions. Only fields in
+// allowedFields may be referenced. The operators are those of filter expressions
+// plus gte and lte; in takes comma separated values, e.g. "status:in:new,open".
+func ParseFilterParams(params []string, allowedFields []string) ([]Condition, error) {
+	allowed := make(map[string]bool, len(allowedFields))
+	for _, field := range allowedFields {
+		allowed[field] = true
+	}
+	conditions := make([]Condition, 0, len(params))
+	for _, param := range params {
+		// The value may contain colons itself
+		parts := strings.SplitN(param, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid filter %q, expected field:operator:value", param)
+		}
+		field, name, value := parts[0], strings.ToLower(parts[1]), parts[2]
+		if !allowed[field] {
+			return nil, fmt.Errorf("filtering on field %q is not allowed", field)
+		}
+		operator, ok := filterOperators[name]
+		if !ok {
+			operator, ok = filterParamAliases[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown operator %q in filter %q", parts[1], param)
+		}
+		condition := Condition{Field: field, Operator: operator, Value: value}
+		if operator == "IN" {
+			condition.Value = strings.Split(value, ",")
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// ParseQueryParamsForModel extends ParseQueryParamsFromRequest with validation
+// against the columns of the model: order_by must be a column, and every filter
+// query parameter (?filter=age:gte:18&filter=name:like:jo) is parsed with
+// ParseFilterParams and added to the conditions of the query. Columns are named
+// with DefaultNaming, see the method of the connector for its NamingStrategy.
+func ParseQueryParamsForModel(r *http.Request, model interface{}, query *DatabaseQuery) error {
+	return parseQueryParamsForModel(r, model, nil, query)
+}
+
+// ParseQueryParamsForModel is ParseQueryParamsForModel with the columns named by
+// the NamingStrategy of the connector
+func (s *PostgreSQLConnector) ParseQueryParamsForModel(r *http.Request, model interface{}, query *DatabaseQuery) error {
+	return parseQueryParamsForModel(r, model, s.naming(), query)
+}
+
+func parseQueryParamsForModel(r *http.Request, model interface{}, naming NamingStrategy, query *DatabaseQuery) error {
+	ParseQueryParamsFromRequest(r, query)
+	return applyFilterParams(r, modelMetadataOf(model, naming).columns, query)
+}
+
+// applyFilterParams validates order_by and adds the filter query parameters to the query
+func applyFilterParams(r *http.Request, allowedFields []string, query *DatabaseQuery) error {
+	if query.OrderBy != "" && !contains(allowedFields, query.OrderBy) {
+		return fmt.Errorf("ordering by field %q is not allowed", query.OrderBy)
+	}
+	conditions, err := ParseFilterParams(r.URL.Query()["filter"], allowedFields)
+	if err != nil {
+		return err
+	}
+	query.Conditions = append(query.Conditions, conditions...)
+	return nil
+}
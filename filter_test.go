@@ -0,0 +1,73 @@
+package db
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilterExpression(t *testing.T) {
+	conditions, err := ParseFilterExpression("(user_type ge 1) and (email like 'test' or name in ('a', 'O''Brien'))",
+		[]string{"user_type", "email", "name"})
+	if err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	where, args := buildConditions(conditions, nil)
+	if where != "(user_type >= $1 AND (email LIKE $2 OR name IN ($3,$4)))" {
+		t.Errorf("unexpected where clause: %s", where)
+	}
+	want := []interface{}{int64(1), "%test%", "a", "O'Brien"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("expected args %v, got %v", want, args)
+	}
+}
+
+func TestParseFilterExpressionRejectsInvalidInput(t *testing.T) {
+	for _, expr := range []string{
+		"password eq 'x'",
+		"user_type between 1",
+		"(user_type eq 1",
+		"user_type eq 1 user_type eq 2",
+		"email like 1",
+		"email eq 'unterminated",
+	} {
+		if _, err := ParseFilterExpression(expr, []string{"user_type", "email"}); err == nil {
+			t.Errorf("expected an error for %q", expr)
+		}
+	}
+}
+
+func TestParseQueryParamsForModel(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?filter=user_type:gte:1&filter=email:like:a:b&filter=id:in:1,2&order_by=email", nil)
+	var query DatabaseQuery
+	if err := ParseQueryParamsForModel(r, &TestUser{}, &query); err != nil {
+		t.Fatalf("error should be nil, but was: %s", err)
+	}
+	where, args := buildConditions(query.Conditions, nil)
+	if where != "user_type >= $1 AND email LIKE $2 AND id IN ($3,$4)" {
+		t.Errorf("unexpected where clause: %s", where)
+	}
+	if want := []interface{}{"1", "%a:b%", "1", "2"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("expected args %v, got %v", want, args)
+	}
+
+	for _, target := range []string{
+		"/?filter=secret:eq:x",
+		"/?filter=email:between:x",
+		"/?filter=email",
+		"/?order_by=secret",
+	} {
+		if err := ParseQueryParamsForModel(httptest.NewRequest("GET", target, nil), &TestUser{}, &DatabaseQuery{}); err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+	connector := &PostgreSQLConnector{NamingStrategy: SnakeCaseNaming}
+	query = DatabaseQuery{}
+	r = httptest.NewRequest("GET", "/?filter=country_code:eq:FI&order_by=shipment_id", nil)
+	if err := connector.ParseQueryParamsForModel(r, &shipmentAddress{}, &query); err != nil {
+		t.Fatalf("expected the columns of the naming strategy, got %s", err)
+	}
+	if len(query.Conditions) != 1 || query.Conditions[0].Field != "country_code" || query.OrderBy != "shipment_id" {
+		t.Errorf("unexpected query %+v", query)
+	}
+}
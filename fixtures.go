@@ -0,0 +1,296 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureNamespace derives the UUIDs of named {{ uuid "name" }} fixture values
+var fixtureNamespace = uuid.MustParse("6f1f0a52-8c2b-4c43-9a3e-3c1f5d0b7e21")
+
+// fixtureTable holds the rows of one table in a fixture file
+type fixtureTable struct {
+	name string
+	rows []map[string]interface{}
+}
+
+// fixtureFuncs are the template functions available in fixture files:
+//
+//	{{ uuid }}          a random UUID
+//	{{ uuid "alice" }}  the same UUID for the same name, to reference rows
+//	{{ now }}           the current time of the connector Clock
+//	{{ nowAdd "-24h" }} the current time moved by a duration
+func (s *PostgreSQLConnector) fixtureFuncs() template.FuncMap {
+	return template.FuncMap{
+		"uuid": func(names ...string) string {
+			if len(names) == 0 {
+				return uuid.New().String()
+			}
+			return uuid.NewSHA1(fixtureNamespace, []byte(strings.Join(names, "/"))).String()
+		},
+		"now": func() string {
+			return s.now().UTC().Format(time.RFC3339Nano)
+		},
+		"nowAdd": func(duration string) (string, error) {
+			d, err := time.ParseDuration(duration)
+			if err != nil {
+				return "", err
+			}
+			return s.now().Add(d).UTC().Format(time.RFC3339Nano), nil
+		},
+	}
+}
+
+// parseFixtures renders a fixture file and returns its tables in file order.
+// JSON files are parsed as YAML, which keeps the order of the tables.
+func (s *PostgreSQLConnector) parseFixtures(name string, data []byte) ([]fixtureTable, error) {
+	tmpl, err := template.New(name).Funcs(s.fixtureFuncs()).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fixtures %s: %v", name, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, nil); err != nil {
+		return nil, fmt.Errorf("error rendering fixtures %s: %v", name, err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(rendered.Bytes(), &document); err != nil {
+		return nil, fmt.Errorf("error parsing fixtures %s: %v", name, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("error parsing fixtures %s: expected a mapping of table names to rows", name)
+	}
+	var tables []fixtureTable
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		table := fixtureTable{name: root.Content[i].Value}
+		if err := root.Content[i+1].Decode(&table.rows); err != nil {
+			return nil, fmt.Errorf("error parsing fixtures %s, table %s: %v", name, table.name, err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// fixtureFiles returns the fixture file at path, or the .json, .yaml and .yml files of a directory sorted by name
+func fixtureFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// fixtureValue converts nested fixture values to JSON, e.g. for JSONB columns
+func fixtureValue(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return value, nil
+}
+
+// LoadFixtures inserts the rows of a fixture file, or of all fixture files in a
+// directory in name order, in one transaction. Fixture files are JSON or YAML
+// mapping table names without prefix to lists of rows, which are inserted in
+// file order so rows can reference rows of earlier tables:
+//
+//	company:
+//	  - id: {{ uuid "acme" }}
+//	    name: Acme
+//	user:
+//	  - id: {{ uuid "alice" }}
+//	    company_id: {{ uuid "acme" }}
+//	    created_at: {{ now }}
+func (s *PostgreSQLConnector) LoadFixtures(ctx context.Context, path string) error {
+	files, err := fixtureFiles(path)
+	if err != nil {
+		return fmt.Errorf("error reading fixtures: %v", err)
+	}
+	var tables []fixtureTable
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading fixtures: %v", err)
+		}
+		parsed, err := s.parseFixtures(filepath.Base(file), data)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, parsed...)
+	}
+
+	var inserted []string
+	err = s.WithinTransaction(func(tx *sql.Tx) error {
+		for _, table := range tables {
+			name := s.tablePrefix() + table.name
+			for _, row := range table.rows {
+				query, args, err := buildFixtureInsert(name, row)
+				if err != nil {
+					return fmt.Errorf("error loading fixtures for %s: %v", table.name, err)
+				}
+				if _, err := s.execStatement(ctx, tx, query, args...); err != nil {
+					return fmt.Errorf("error loading fixtures for %s: %v", table.name, err)
+				}
+			}
+			inserted = append(inserted, name)
+		}
+		return nil
+	}, WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	s.invalidateCache(ctx, nil, inserted...)
+	return nil
+}
+
+// buildFixtureInsert builds the INSERT statement of a fixture row, columns are sorted by name
+func buildFixtureInsert(table string, row map[string]interface{}) (string, []interface{}, error) {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	placeholders := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value, err := fixtureValue(row[column])
+		if err != nil {
+			return "", nil, err
+		}
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = value
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", ")), args, nil
+}
+
+// Seed inserts the models in one transaction, in argument order. Pointers to
+// slices insert every element. Every model is inserted with InsertModel, so it
+// is validated and audited like other inserts; the hooks of repositories do not
+// run. Options such as WithContext can be mixed in.
+func (s *PostgreSQLConnector) Seed(modelsAndOptions ...interface{}) error {
+	models, opts := splitModelsAndOptions(modelsAndOptions)
+	config := processOptions(opts)
+	defer config.release()
+	return s.WithinTransaction(func(tx *sql.Tx) error {
+		insert := func(model interface{}) error {
+			if err := s.InsertModel(model, WithContext(config.ctx), WithTransaction(tx)); err != nil {
+				return fmt.Errorf("error seeding %s: %w", indirectType(model).Name(), err)
+			}
+			return nil
+		}
+		for _, model := range models {
+			val := reflect.ValueOf(model)
+			if val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Slice {
+				for i := 0; i < val.Elem().Len(); i++ {
+					if err := insert(val.Elem().Index(i).Addr().Interface()); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := insert(model); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts...)
+}
+
+// Seeder is implemented by models with reference data, e.g. roles, countries or
+// statuses, that CreateTables and MigrateTables insert when called WithSeedData
+type Seeder interface {
+	SeedData() []interface{}
+}
+
+// seedTables upserts the SeedData of the models implementing Seeder in tx, in
+// the given order. Rows are matched on their primary key, so seeding again
+// updates changed rows instead of duplicating them.
+func (s *PostgreSQLConnector) seedTables(ctx context.Context, tx *sql.Tx, models []interface{}) error {
+	for _, model := range models {
+		seeder, ok := model.(Seeder)
+		if !ok {
+			continue
+		}
+		for _, row := range seeder.SeedData() {
+			query, args, err := s.buildSeedStmt(row)
+			if err != nil {
+				return fmt.Errorf("error seeding %s: %v", indirectType(row).Name(), err)
+			}
+			if _, err := s.execStatement(ctx, tx, query, args...); err != nil {
+				return fmt.Errorf("error seeding %s: %v", indirectType(row).Name(), err)
+			}
+			s.invalidateCache(ctx, tx, s.tableName(row))
+		}
+	}
+	return nil
+}
+
+// buildSeedStmt returns the INSERT ... ON CONFLICT statement upserting a seed row
+func (s *PostgreSQLConnector) buildSeedStmt(row interface{}) (string, []interface{}, error) {
+	val := reflect.ValueOf(row)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return "", nil, fmt.Errorf("seed data must be structs, got %T", row)
+	}
+	meta := metadataOf(val.Type(), s.naming())
+	if meta.primaryKey == nil || meta.primaryKey.tag.IsReadOnly {
+		return "", nil, fmt.Errorf("seed data needs a writable primary key field")
+	}
+	key := meta.primaryKey.tag.ColumnName
+	placeholders := make([]string, len(meta.writable))
+	args := make([]interface{}, len(meta.writable))
+	var updates []string
+	for i, column := range meta.writable {
+		field := meta.byColumn[column]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = columnArg(field, val.Field(field.index))
+		if column != key {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
+	}
+	conflict := "DO NOTHING"
+	if len(updates) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		s.tableName(row), strings.Join(meta.writable, ", "), strings.Join(placeholders, ", "), key, conflict), args, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"text/template"
+)
+
+// ImportPath is the import path of this package, used in generated code
+const ImportPath = "github.com/phasi/go-postgresql-orm"
+
+var repositoryTemplate = template.Must(template.New("repositories").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+package {{ .Package }}
+
+import db "{{ .ImportPath }}"
+{{ range .Types }}
+// {{ . }}Repository provides CRUD for {{ . }}, add domain-specific queries as methods
+type {{ . }}Repository struct {
+	*db.Repository[{{ . }}]
+}
+
+// New{{ . }}Repository creates a {{ . }}Repository
+func New{{ . }}Repository(connector *db.PostgreSQLConnector) *{{ . }}Repository {
+	return &{{ . }}Repository{Repository: db.NewRepository[{{ . }}](connector)}
+}
+{{ end }}`))
+
+// GenerateRepositories returns the Go source of a repository type embedding
+// Repository for each of the models, e.g. UserRepository and NewUserRepository.
+// The models must be declared in one package, which the file is generated for.
+func GenerateRepositories(packageName string, models ...interface{}) ([]byte, error) {
+	var types []string
+	pkgPath := ""
+	for _, model := range models {
+		t := indirectType(model)
+		if t.Kind() != reflect.Struct || t.Name() == "" {
+			return nil, fmt.Errorf("cannot generate a repository for %s: not a named struct", t)
+		}
+		if pkgPath != "" && t.PkgPath() != pkgPath {
+			return nil, fmt.Errorf("models must be declared in one package, got %s and %s", pkgPath, t.PkgPath())
+		}
+		pkgPath = t.PkgPath()
+		types = append(types, t.Name())
+	}
+
+	var buf bytes.Buffer
+	err := repositoryTemplate.Execute(&buf, map[string]interface{}{
+		"Package":    packageName,
+		"ImportPath": ImportPath,
+		"Types":      types,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
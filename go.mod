@@ -5,4 +5,5 @@ go 1.22.3
 require (
 	github.com/google/uuid v1.2.0
 	github.com/lib/pq v1.10.9
+	gopkg.in/yaml.v3 v3.0.1
 )
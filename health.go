@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HealthStatus reports the state of a connection pool for health endpoints
+type HealthStatus struct {
+	Host string `json:"host"`
+	// Latency is the round trip time of a ping
+	Latency time.Duration `json:"latency"`
+	// Pool holds the connection pool statistics
+	Pool          sql.DBStats `json:"pool"`
+	ServerVersion string      `json:"server_version"`
+	// InRecovery is set for standby servers
+	InRecovery bool `json:"in_recovery"`
+	// ReplicationLag is the age of the last replayed transaction on a standby,
+	// nil on primaries or when it cannot be read
+	ReplicationLag *time.Duration `json:"replication_lag,omitempty"`
+	// Replicas holds the status of the configured read replicas
+	Replicas []HealthStatus `json:"replicas,omitempty"`
+}
+
+// HealthCheck pings the database and collects latency, pool statistics, server
+// version and replication lag, including those of the configured replicas.
+// The returned status is filled as far as possible also when an error is returned.
+func (s *PostgreSQLConnector) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	status, err := s.healthStatus(ctx)
+	for i := range s.Replicas {
+		replicaStatus, replicaErr := s.Replicas[i].healthStatus(ctx)
+		status.Replicas = append(status.Replicas, replicaStatus)
+		if err == nil && replicaErr != nil {
+			err = fmt.Errorf("replica %s: %v", s.Replicas[i].Host, replicaErr)
+		}
+	}
+	return status, err
+}
+
+func (s *PostgreSQLConnector) healthStatus(ctx context.Context) (HealthStatus, error) {
+	status := HealthStatus{Host: s.Host}
+	db := s.GetConnection()
+	if db == nil {
+		return status, fmt.Errorf("not connected")
+	}
+	status.Pool = db.Stats()
+
+	start := s.now()
+	if err := db.PingContext(ctx); err != nil {
+		return status, fmt.Errorf("error pinging database: %v", err)
+	}
+	status.Latency = s.now().Sub(start)
+
+	var lagSeconds sql.NullFloat64
+	err := db.QueryRowContext(ctx, `SELECT current_setting('server_version'), pg_is_in_recovery(),
+		EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())`).
+		Scan(&status.ServerVersion, &status.InRecovery, &lagSeconds)
+	if err != nil {
+		return status, fmt.Errorf("error reading server status: %v", err)
+	}
+	if status.InRecovery && lagSeconds.Valid {
+		lag := time.Duration(lagSeconds.Float64 * float64(time.Second))
+		status.ReplicationLag = &lag
+	}
+	return status, nil
+}
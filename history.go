@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// HistoryModel is implemented by models keeping the past versions of their rows
+// in a history table, see FindAsOf
+type HistoryModel interface {
+	KeepHistory() bool
+}
+
+// HistoryTableSuffix is appended to the table name of a HistoryModel to name its history table
+const HistoryTableSuffix = "_history"
+
+// keepsHistory reports whether the model is a HistoryModel keeping history
+func keepsHistory(model interface{}) bool {
+	historyModel, ok := reflect.New(indirectType(model)).Interface().(HistoryModel)
+	return ok && historyModel.KeepHistory()
+}
+
+// historyColumnType returns the type of a column in the history table, serial
+// types become their integer type so the history table creates no sequences
+func historyColumnType(column Column) string {
+	switch strings.ToUpper(column.Type) {
+	case "SMALLSERIAL":
+		return "SMALLINT"
+	case "SERIAL":
+		return "INTEGER"
+	case "BIGSERIAL":
+		return "BIGINT"
+	}
+	return columnTypeText(column)
+}
+
+// historyStmts returns the statements creating or updating the history table of
+// a table and the trigger maintaining it. Each row version is stored with the
+// time range it was current in, valid_from inclusive and valid_to exclusive,
+// NULL while the version is current. The statements are idempotent, columns
+// added to the table are added to the history table. Rows without a current
+// version, e.g. when history is turned on for a populated table, get one valid
+// from now.
+func historyStmts(table Table) []string {
+	history := table.Name + HistoryTableSuffix
+	addColumns := make([]string, len(table.Columns))
+	columns := make([]string, len(table.Columns))
+	values := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		addColumns[i] = fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s", column.Name, historyColumnType(column))
+		columns[i] = column.Name
+		values[i] = "NEW." + column.Name
+	}
+	keyColumns := primaryKeyColumns(table)
+	matches := make([]string, len(keyColumns))
+	current := make([]string, len(keyColumns))
+	for i, column := range keyColumns {
+		matches[i] = fmt.Sprintf("%s = OLD.%s", column, column)
+		current[i] = fmt.Sprintf("h.%s = t.%s", column, column)
+	}
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (valid_from TIMESTAMPTZ NOT NULL, valid_to TIMESTAMPTZ)", history),
+		fmt.Sprintf("ALTER TABLE %s %s", history, strings.Join(addColumns, ", ")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s, valid_from)",
+			DefaultConstraintName(unqualifiedName(history), keyColumns, "idx"), history, strings.Join(keyColumns, ", ")),
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s_record_history() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE %s SET valid_to = now() WHERE %s AND valid_to IS NULL;
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		INSERT INTO %s (%s, valid_from) VALUES (%s, now());
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql`, table.Name, history, strings.Join(matches, " AND "), history, strings.Join(columns, ", "), strings.Join(values, ", ")),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS record_history ON %s", table.Name),
+		fmt.Sprintf("CREATE TRIGGER record_history AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s_record_history()",
+			table.Name, table.Name),
+		fmt.Sprintf("INSERT INTO %s (%s, valid_from) SELECT %s, now() FROM %s t WHERE NOT EXISTS (SELECT 1 FROM %s h WHERE %s AND h.valid_to IS NULL)",
+			history, strings.Join(columns, ", "), "t."+strings.Join(columns, ", t."), table.Name, history, strings.Join(current, " AND ")),
+	}
+}
+
+// historyChanged reports whether MigrateTable has to update the history of a
+// table: the history table lacks a column of the model, or the columns of the
+// table change, so the trigger has to record other columns
+func historyChanged(table Table, existing []string, historyColumns []string) bool {
+	if len(existing) != len(table.Columns) {
+		return true
+	}
+	for _, column := range table.Columns {
+		if !contains(historyColumns, column.Name) || !contains(existing, column.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unqualifiedName returns a table name without its schema
+func unqualifiedName(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[i+1:]
+	}
+	return table
+}
+
+// FindAsOf loads the version of model, a pointer to a HistoryModel, that was
+// current at the given time from its history table. The row is identified by
+// the primary key of model. It returns sql.ErrNoRows when the row did not exist
+// at that time.
+func (s PostgreSQLConnector) FindAsOf(model interface{}, at time.Time, opts ...Option) error {
+	if !keepsHistory(model) {
+		return fmt.Errorf("%T does not keep history", model)
+	}
+	config := processOptions(opts)
+	defer config.release()
+	var fields Fields
+	parseTags(model, s.naming(), &fields)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)",
+		strings.Join(fields, ", "), s.tableName(model)+HistoryTableSuffix, getPrimaryKeyField(model, s.naming()))
+	rows, err := s.readRows(config, query, primaryKeyValue(model), at)
+	if err != nil {
+		return fmt.Errorf("error querying history: %v", err)
+	}
+	return s.ScanRow(rows, model)
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Message keys of validation and constraint errors
+const (
+	MsgTaken     = "validation.taken"
+	MsgNotExists = "validation.not_exists"
+	MsgRequired  = "validation.required"
+	MsgInvalid   = "validation.invalid"
+	MsgTooShort  = "validation.too_short"
+	MsgTooLong   = "validation.too_long"
+	MsgTooSmall  = "validation.too_small"
+	MsgTooLarge  = "validation.too_large"
+	MsgEmail     = "validation.email"
+)
+
+// DefaultMessages are the English messages used when no translation is found
+var DefaultMessages = map[string]string{
+	MsgTaken:     "is already taken",
+	MsgNotExists: "does not exist",
+	MsgRequired:  "is required",
+	MsgInvalid:   "is invalid",
+	MsgTooShort:  "must be at least {min} characters long",
+	MsgTooLong:   "must be at most {max} characters long",
+	MsgTooSmall:  "must be at least {min}",
+	MsgTooLarge:  "must be at most {max}",
+	MsgEmail:     "is not a valid email address",
+}
+
+// Translator translates a message key with its parameters into the given locale
+type Translator interface {
+	Translate(locale string, key string, params map[string]interface{}) (string, bool)
+}
+
+// MapTranslator is a Translator backed by messages per locale and key. Messages
+// may reference parameters as {name}.
+type MapTranslator map[string]map[string]string
+
+// Translate implements Translator
+func (t MapTranslator) Translate(locale string, key string, params map[string]interface{}) (string, bool) {
+	message, ok := t[locale][key]
+	if !ok {
+		return "", false
+	}
+	return formatMessage(message, params), true
+}
+
+// formatMessage replaces {name} placeholders with the parameter values
+func formatMessage(message string, params map[string]interface{}) string {
+	for name, value := range params {
+		message = strings.ReplaceAll(message, "{"+name+"}", fmt.Sprint(value))
+	}
+	return message
+}
+
+type localeKey struct{}
+
+// WithLocale returns a context carrying the locale used for error messages
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFromContext returns the locale set with WithLocale, or "" when none was set
+func LocaleFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// message resolves a message key for the locale of the context, falling back to DefaultMessages
+func (s *PostgreSQLConnector) message(ctx context.Context, key string, params map[string]interface{}) string {
+	if s.Translator != nil {
+		if message, ok := s.Translator.Translate(LocaleFromContext(ctx), key, params); ok {
+			return message
+		}
+	}
+	if message, ok := DefaultMessages[key]; ok {
+		return formatMessage(message, params)
+	}
+	return key
+}
+
+// validationError creates a ValidationError with the message translated for the context locale
+func (s *PostgreSQLConnector) validationError(ctx context.Context, field string, key string, params map[string]interface{}) *ValidationError {
+	return &ValidationError{Field: field, Message: s.message(ctx, key, params), Key: key, Params: params}
+}
+
+// constraintDetailColumn extracts the column from details like "Key (email)=(a@b.c) already exists."
+var constraintDetailColumn = regexp.MustCompile(`^Key \(([^)]+)\)=\((.*)\)`)
+
+// MapConstraintError converts unique, foreign key, not null and check violations
+// into a ValidationError with its message translated for the context locale.
+// Other errors are returned unchanged.
+func (s *PostgreSQLConnector) MapConstraintError(ctx context.Context, err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	var key string
+	switch pqErr.Code.Name() {
+	case "unique_violation":
+		key = MsgTaken
+	case "foreign_key_violation":
+		key = MsgNotExists
+	case "not_null_violation":
+		key = MsgRequired
+	case "check_violation":
+		key = MsgInvalid
+	default:
+		return err
+	}
+
+	field := pqErr.Column
+	params := map[string]interface{}{"table": pqErr.Table, "constraint": pqErr.Constraint}
+	if match := constraintDetailColumn.FindStringSubmatch(pqErr.Detail); match != nil {
+		field = match[1]
+		params["value"] = match[2]
+	}
+	if field == "" {
+		field = pqErr.Constraint
+	}
+	params["field"] = field
+	return s.validationError(ctx, field, key, params)
+}
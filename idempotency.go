@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyTable is the managed table storing idempotency keys
+const IdempotencyTable = "gpo_idempotency_keys"
+
+// ErrIdempotencyKeyReused is returned when a key is retried with a different payload
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// idempotencyTables remembers which connection pools already have the managed table
+var idempotencyTables sync.Map
+
+// WithIdempotencyKey makes InsertModel/UpdateModel record the key in the managed
+// idempotency table so a retried call with the same key is not applied twice
+func WithIdempotencyKey(key string) Option {
+	return func(c *Config) { c.idempotencyKey = key }
+}
+
+func (s PostgreSQLConnector) ensureIdempotencyTable(ctx context.Context) error {
+	db := s.GetConnection()
+	if _, ok := idempotencyTables.Load(db); ok {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key TEXT PRIMARY KEY,
+		operation TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		rows_affected BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW())`, IdempotencyTable))
+	if err != nil {
+		return fmt.Errorf("error creating idempotency table: %v", err)
+	}
+	idempotencyTables.Store(db, true)
+	return nil
+}
+
+// idempotencyHash fingerprints the request so a reused key with a different payload is detected
+func idempotencyHash(operation string, table string, model interface{}, conditions interface{}) string {
+	payload, err := json.Marshal([]interface{}{operation, table, model, conditions})
+	if err != nil {
+		payload = []byte(fmt.Sprintf("%s|%s|%+v|%+v", operation, table, model, conditions))
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// runIdempotent claims the idempotency key and runs the mutation inside the same
+// transaction. When the key was claimed before, the stored outcome is returned instead.
+func (s PostgreSQLConnector) runIdempotent(config *Config, operation string, model interface{}, conditions interface{}, run func(ctx context.Context, tx *sql.Tx) (int64, error)) (affected int64, err error) {
+	ctx := config.ctx
+	if err = s.ensureIdempotencyTable(ctx); err != nil {
+		return 0, err
+	}
+
+	tx := config.tx
+	if tx == nil {
+		tx, err = s.beginTx(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			if err != nil {
+				s.RollbackTx(tx)
+				return
+			}
+			err = s.CommitTx(tx)
+		}()
+	}
+
+	table := s.tableName(model)
+	hash := idempotencyHash(operation, table, model, conditions)
+
+	// A concurrent retry blocks here until the first attempt commits or rolls back
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (key, operation, table_name, request_hash, created_at) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (key) DO NOTHING",
+		IdempotencyTable), config.idempotencyKey, operation, table, hash, s.now())
+	if err != nil {
+		return 0, fmt.Errorf("error claiming idempotency key: %v", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if claimed == 0 {
+		var storedHash string
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT request_hash, rows_affected FROM %s WHERE key = $1", IdempotencyTable),
+			config.idempotencyKey).Scan(&storedHash, &affected)
+		if err != nil {
+			return 0, fmt.Errorf("error reading idempotency key: %v", err)
+		}
+		if storedHash != hash {
+			return 0, ErrIdempotencyKeyReused
+		}
+		return affected, nil
+	}
+
+	affected, err = run(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET rows_affected = $1 WHERE key = $2", IdempotencyTable),
+		affected, config.idempotencyKey)
+	return affected, err
+}
+
+// PurgeIdempotencyKeys deletes idempotency keys older than the given retention,
+// measured with the connector Clock
+func (s PostgreSQLConnector) PurgeIdempotencyKeys(retention time.Duration, opts ...Option) (int64, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if err := s.ensureIdempotencyTable(config.ctx); err != nil {
+		return 0, err
+	}
+	query, args, err := NewQueryBuilder().
+		DeleteFrom(IdempotencyTable).
+		Where("created_at", "<", s.now().Add(-retention)).
+		Build()
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.CustomMutate(config.ctx, config.tx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return (*result).RowsAffected()
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs, in ASCII order so the text sorts like the bytes
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID is a lexicographically sortable identifier of 48 bits of milliseconds
+// since the epoch followed by 80 random bits. It is stored as its 26 character
+// text in a CHAR(26) column with the "C" collation. ULIDs generated by the same
+// process are strictly increasing, also within a millisecond.
+type ULID [16]byte
+
+// NewULID returns a ULID for the current time
+func NewULID() ULID {
+	return nextULID(time.Now())
+}
+
+// ParseULID parses the 26 character text of a ULID, case insensitively
+func ParseULID(s string) (ULID, error) {
+	var id ULID
+	if len(s) != 26 {
+		return id, fmt.Errorf("invalid ULID %q: expected 26 characters", s)
+	}
+	for i := 0; i < len(s); i++ {
+		char := s[i]
+		if 'a' <= char && char <= 'z' {
+			char -= 'a' - 'A'
+		}
+		c := strings.IndexByte(crockford, char)
+		if c < 0 || (i == 0 && c > 7) {
+			return ULID{}, fmt.Errorf("invalid ULID %q", s)
+		}
+		// Each character holds 5 bits, the two leading bits of the text are unused
+		for b := 0; b < 5; b++ {
+			n := i*5 + b - 2
+			if n >= 0 && c&(0x10>>b) != 0 {
+				id[n/8] |= 0x80 >> (n % 8)
+			}
+		}
+	}
+	return id, nil
+}
+
+func (u ULID) String() string {
+	var out [26]byte
+	for i := range out {
+		var c byte
+		for b := 0; b < 5; b++ {
+			n := i*5 + b - 2
+			c <<= 1
+			if n >= 0 && u[n/8]&(0x80>>(n%8)) != 0 {
+				c |= 1
+			}
+		}
+		out[i] = crockford[c]
+	}
+	return string(out[:])
+}
+
+// Time returns the creation time of the ULID with millisecond precision
+func (u ULID) Time() time.Time {
+	var ms [8]byte
+	copy(ms[2:], u[:6])
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(ms[:])))
+}
+
+// IsZero reports whether the ULID is unset
+func (u ULID) IsZero() bool {
+	return u == ULID{}
+}
+
+func (u ULID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+func (u *ULID) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*u = ULID{}
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into a ULID", src)
+	}
+	id, err := ParseULID(text)
+	if err != nil {
+		return err
+	}
+	*u = id
+	return nil
+}
+
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *ULID) UnmarshalText(text []byte) error {
+	id, err := ParseULID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = id
+	return nil
+}
+
+var ulidState struct {
+	sync.Mutex
+	last ULID
+}
+
+// nextULID returns a ULID for t which is greater than all ULIDs returned before,
+// within the same millisecond (or when the clock went back) the previous one is incremented
+func nextULID(t time.Time) ULID {
+	var id ULID
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(id[:6], ms[2:])
+
+	ulidState.Lock()
+	defer ulidState.Unlock()
+	if string(id[:6]) <= string(ulidState.last[:6]) {
+		id = ulidState.last
+		for i := len(id) - 1; i >= 0; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	} else if _, err := rand.Read(id[6:]); err != nil {
+		panic(err)
+	}
+	ulidState.last = id
+	return id
+}
+
+// SnowflakeEpoch is the start of the timestamps of Snowflake ids
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// MaxSnowflakeNode is the largest node number of Snowflake ids
+const MaxSnowflakeNode = 1<<10 - 1
+
+// Snowflake is a 64 bit identifier of 41 bits of milliseconds since
+// SnowflakeEpoch, 10 bits of node number (see PostgreSQLConnector.SnowflakeNode)
+// and a 12 bit sequence. It is stored in a BIGINT column. Snowflakes generated by
+// the same process are strictly increasing.
+type Snowflake int64
+
+// Time returns the creation time of the Snowflake with millisecond precision
+func (id Snowflake) Time() time.Time {
+	return SnowflakeEpoch.Add(time.Duration(id>>22) * time.Millisecond)
+}
+
+// Node returns the node number the Snowflake was generated on
+func (id Snowflake) Node() int {
+	return int(id>>12) & MaxSnowflakeNode
+}
+
+var snowflakeState struct {
+	sync.Mutex
+	ms       int64
+	sequence int64
+}
+
+// nextSnowflake returns a Snowflake for t and node which is greater than all
+// Snowflakes of the node returned before. When the sequence of a millisecond is
+// exhausted (or the clock went back) the timestamp is moved forward.
+func nextSnowflake(t time.Time, node int) Snowflake {
+	ms := t.Sub(SnowflakeEpoch).Milliseconds()
+
+	snowflakeState.Lock()
+	defer snowflakeState.Unlock()
+	if ms <= snowflakeState.ms {
+		ms = snowflakeState.ms
+		snowflakeState.sequence++
+		if snowflakeState.sequence > 1<<12-1 {
+			ms++
+			snowflakeState.sequence = 0
+		}
+	} else {
+		snowflakeState.sequence = 0
+	}
+	snowflakeState.ms = ms
+	return Snowflake(ms<<22 | int64(node)<<12 | snowflakeState.sequence)
+}
+
+var (
+	ulidType      = reflect.TypeOf(ULID{})
+	snowflakeType = reflect.TypeOf(Snowflake(0))
+)
+
+// generatePrimaryKey sets an unset ULID or Snowflake primary key of the model
+// before it is inserted
+func (s *PostgreSQLConnector) generatePrimaryKey(model interface{}) error {
+	pk := modelMetadataOf(model, nil).primaryKey
+	if pk == nil {
+		return nil
+	}
+	val := reflect.ValueOf(model)
+	t := indirectType(model)
+	if fieldType := t.Field(pk.index).Type; fieldType != ulidType && fieldType != snowflakeType {
+		return nil
+	}
+	if val.Kind() != reflect.Ptr {
+		if val.Field(pk.index).IsZero() {
+			return fmt.Errorf("error handling %s: model must be a pointer to generate its primary key", t)
+		}
+		return nil
+	}
+	field := val.Elem().Field(pk.index)
+	if !field.IsZero() {
+		return nil
+	}
+	if field.Type() == ulidType {
+		field.Set(reflect.ValueOf(nextULID(s.now())))
+		return nil
+	}
+	if s.SnowflakeNode < 0 || s.SnowflakeNode > MaxSnowflakeNode {
+		return fmt.Errorf("SnowflakeNode must be between 0 and %d, got %d", MaxSnowflakeNode, s.SnowflakeNode)
+	}
+	field.Set(reflect.ValueOf(nextSnowflake(s.now(), s.SnowflakeNode)))
+	return nil
+}
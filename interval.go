@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Durations of the calendar units of intervals, which have no fixed length
+const (
+	intervalDay   = 24 * time.Hour
+	intervalMonth = 30 * intervalDay
+	intervalYear  = 365 * intervalDay
+)
+
+// formatInterval formats a duration as an interval literal with microsecond precision
+func formatInterval(d time.Duration) string {
+	return fmt.Sprintf("%d microseconds", d.Microseconds())
+}
+
+// parseInterval parses an interval in the default postgres output style,
+// e.g. "1 day 02:03:04.5" or "-1 mons +00:00:10". Days count as 24 hours,
+// months as 30 days and years as 365 days.
+func parseInterval(s string) (time.Duration, error) {
+	fields := strings.Fields(s)
+	var total time.Duration
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if strings.Contains(field, ":") {
+			d, err := parseIntervalClock(field)
+			if err != nil {
+				return 0, fmt.Errorf("invalid interval %q: %v", s, err)
+			}
+			total += d
+			continue
+		}
+		if i+1 >= len(fields) {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q: %v", s, err)
+		}
+		i++
+		switch strings.TrimSuffix(fields[i], "s") {
+		case "year":
+			total += time.Duration(n) * intervalYear
+		case "mon":
+			total += time.Duration(n) * intervalMonth
+		case "day":
+			total += time.Duration(n) * intervalDay
+		default:
+			return 0, fmt.Errorf("invalid interval %q: unknown unit %s", s, fields[i])
+		}
+	}
+	return total, nil
+}
+
+// parseIntervalClock parses the [+-]HH:MM:SS[.ffffff] part of an interval
+func parseIntervalClock(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	parts := strings.Split(strings.TrimLeft(s, "+-"), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)+0.5)
+	return sign * d, nil
+}
+
+// intervalScanner scans an interval column into a time.Duration or *time.Duration field
+type intervalScanner struct {
+	dst reflect.Value
+}
+
+func (s intervalScanner) Scan(src interface{}) error {
+	if src == nil {
+		s.dst.Set(reflect.Zero(s.dst.Type()))
+		return nil
+	}
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into an interval", src)
+	}
+	d, err := parseInterval(text)
+	if err != nil {
+		return err
+	}
+	if s.dst.Kind() == reflect.Ptr {
+		s.dst.Set(reflect.New(s.dst.Type().Elem()))
+		s.dst.Elem().SetInt(int64(d))
+		return nil
+	}
+	s.dst.SetInt(int64(d))
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import "github.com/google/uuid"
+
+// KeyGeneration selects how the value of the default id column of models
+// without a primary key field is generated
+type KeyGeneration int
+
+const (
+	// ServerUUID declares the id column with DEFAULT gen_random_uuid(), which
+	// needs Postgres 13 or the pgcrypto extension
+	ServerUUID KeyGeneration = iota
+	// ClientUUID inserts a random UUID generated with uuid.New
+	ClientUUID
+	// ClientULID inserts the bytes of a ULID (see ULID) as UUID, so the ids sort
+	// in creation order
+	ClientULID
+)
+
+// KeyGenerator is implemented by models choosing the KeyGeneration of their
+// default id column, overriding PostgreSQLConnector.KeyGeneration
+type KeyGenerator interface {
+	KeyGeneration() KeyGeneration
+}
+
+// keyGeneration returns the KeyGeneration of the model
+func (s *PostgreSQLConnector) keyGeneration(model interface{}) KeyGeneration {
+	if generator, ok := model.(KeyGenerator); ok {
+		return generator.KeyGeneration()
+	}
+	return s.KeyGeneration
+}
+
+// hasDefaultID reports whether the table of the model gets the default id column
+func hasDefaultID(model interface{}) bool {
+	return modelMetadataOf(model, nil).primaryKey == nil
+}
+
+// generateKey returns the client generated value of the default id column of
+// the model, or nil when the model has a primary key field or the database
+// generates the key
+func (s *PostgreSQLConnector) generateKey(model interface{}) interface{} {
+	if !hasDefaultID(model) {
+		return nil
+	}
+	switch s.keyGeneration(model) {
+	case ClientUUID:
+		return uuid.New()
+	case ClientULID:
+		return uuid.UUID(nextULID(s.now()))
+	}
+	return nil
+}
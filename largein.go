@@ -0,0 +1,125 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// LargeInStrategy selects how IN/NOT IN conditions with many values are rendered
+type LargeInStrategy int
+
+const (
+	// InArray binds all values as a single array parameter: col = ANY($1).
+	// It is the default strategy.
+	InArray LargeInStrategy = iota
+	// InValues compares against a VALUES list: col IN (VALUES ($1),($2),...),
+	// which the planner executes as a hashed join instead of a long list of comparisons
+	InValues
+	// InList renders one placeholder per value: col IN ($1,$2,...)
+	InList
+)
+
+// DefaultLargeInThreshold is the number of values from which the large IN strategy applies
+const DefaultLargeInThreshold = 500
+
+// WithLargeInStrategy renders IN/NOT IN conditions with at least threshold values
+// using the given strategy instead of InArray above DefaultLargeInThreshold.
+// A threshold <= 0 uses DefaultLargeInThreshold, InList turns the rewrite off.
+func WithLargeInStrategy(strategy LargeInStrategy, threshold int) Option {
+	return func(c *Config) {
+		c.inStrategy = strategy
+		c.inThreshold = threshold
+	}
+}
+
+// largeInList marks the values of an IN condition to be rendered with a large IN strategy
+type largeInList struct {
+	values   reflect.Value
+	strategy LargeInStrategy
+}
+
+// applyLargeInStrategy returns the conditions with large IN lists marked for the
+// configured strategy, the given slice is not modified
+func applyLargeInStrategy(conditions []Condition, config *Config) []Condition {
+	if config == nil || config.inStrategy == InList || len(conditions) == 0 {
+		return conditions
+	}
+	threshold := config.inThreshold
+	if threshold <= 0 {
+		threshold = DefaultLargeInThreshold
+	}
+
+	result := make([]Condition, len(conditions))
+	for i, condition := range conditions {
+		switch condition.Operator {
+		case "AND", "OR":
+			if group, ok := condition.Value.([]Condition); ok {
+				condition.Value = applyLargeInStrategy(group, config)
+			}
+		case "IN", "NOT IN":
+			v := reflect.ValueOf(condition.Value)
+			if v.Kind() == reflect.Slice && v.Len() >= threshold {
+				condition.Value = largeInList{values: v, strategy: config.inStrategy}
+			}
+		}
+		result[i] = condition
+	}
+	return result
+}
+
+// buildLargeInCondition renders an IN/NOT IN condition marked by applyLargeInStrategy
+func buildLargeInCondition(condition Condition, list largeInList, args []interface{}) (string, []interface{}) {
+	if list.strategy == InArray {
+		args = append(args, pq.Array(list.values.Interface()))
+		if condition.Operator == "NOT IN" {
+			return fmt.Sprintf("%s <> ALL($%d)", condition.Field, len(args)), args
+		}
+		return fmt.Sprintf("%s = ANY($%d)", condition.Field, len(args)), args
+	}
+
+	rows := make([]string, list.values.Len())
+	for i := range rows {
+		value := list.values.Index(i).Interface()
+		args = append(args, value)
+		rows[i] = fmt.Sprintf("($%d)", len(args))
+		if i == 0 {
+			// Type the list by its first row, untyped VALUES would default to text
+			if cast := inValuesCast(value); cast != "" {
+				rows[i] = fmt.Sprintf("($%d::%s)", len(args), cast)
+			}
+		}
+	}
+	return fmt.Sprintf("%s %s (VALUES %s)", condition.Field, condition.Operator, strings.Join(rows, ",")), args
+}
+
+// inValuesCast returns the SQL type of a Go value for typing a VALUES list, or "" when unknown
+func inValuesCast(value interface{}) string {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Name() {
+	case "UUID":
+		return "UUID"
+	case "Time":
+		return "TIMESTAMP"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "TEXT"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "BIGINT"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE PRECISION"
+	case reflect.Bool:
+		return "BOOLEAN"
+	}
+	return ""
+}
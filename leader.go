@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LeasesTable is the managed table storing the leases of leader elections
+const LeasesTable = "gpo_leases"
+
+// leasesTables remembers which connection pools already have the managed table
+var leasesTables sync.Map
+
+// LeaderElector elects one leader among the instances using the same name, e.g.
+// to run schedulers or migrations only once per deployment. The leader holds a
+// lease in LeasesTable which it renews every RenewInterval; when it stops
+// renewing, another instance takes over once the TTL expired.
+type LeaderElector struct {
+	Name string
+	TTL  time.Duration
+	// RenewInterval is how often Run renews or tries to acquire the lease, TTL/3 by default
+	RenewInterval time.Duration
+	// Identity names this instance in the lease, the host name and a random suffix by default
+	Identity string
+	// OnAcquire is called when this instance became the leader, ctx is cancelled when
+	// it loses the lease. It must not block, start long-running work in a goroutine.
+	OnAcquire func(ctx context.Context)
+	// OnLose is called when this instance stopped being the leader
+	OnLose func()
+
+	connector *PostgreSQLConnector
+	mu        sync.Mutex
+	expiresAt time.Time
+	cancel    context.CancelFunc
+}
+
+// NewLeaderElector creates an elector for the named lease with the given TTL
+func NewLeaderElector(connector *PostgreSQLConnector, name string, ttl time.Duration) *LeaderElector {
+	host, _ := os.Hostname()
+	return &LeaderElector{
+		Name:          name,
+		TTL:           ttl,
+		RenewInterval: ttl / 3,
+		Identity:      fmt.Sprintf("%s-%s", host, uuid.NewString()[:8]),
+		connector:     connector,
+	}
+}
+
+func (e *LeaderElector) ensureTable(ctx context.Context) error {
+	db := e.connector.GetConnection()
+	if _, ok := leasesTables.Load(db); ok {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL)`, LeasesTable))
+	if err != nil {
+		return fmt.Errorf("error creating leases table: %v", err)
+	}
+	leasesTables.Store(db, true)
+	return nil
+}
+
+// TryAcquire acquires the lease when it is free or expired, or renews it when
+// this instance holds it, and reports whether this instance is the leader.
+// Expiry is decided by the database clock, so the clocks of the instances may drift.
+func (e *LeaderElector) TryAcquire(ctx context.Context) (bool, error) {
+	if err := e.ensureTable(ctx); err != nil {
+		return false, err
+	}
+	// Taken before the statement, so the local view of the lease never outlives the stored one
+	expiresAt := e.connector.now().Add(e.TTL)
+	rows, err := e.connector.queryStatement(ctx, nil, fmt.Sprintf(`INSERT INTO %[1]s (name, holder, expires_at)
+		VALUES ($1, $2, now() + $3 * interval '1 microsecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE %[1]s.holder = EXCLUDED.holder OR %[1]s.expires_at <= now()
+		RETURNING holder`, LeasesTable), e.Name, e.Identity, e.TTL.Microseconds())
+	if err != nil {
+		return false, fmt.Errorf("error acquiring lease %s: %v", e.Name, err)
+	}
+	defer rows.Close()
+	acquired := rows.Next()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error acquiring lease %s: %v", e.Name, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if acquired {
+		e.expiresAt = expiresAt
+	} else {
+		e.expiresAt = time.Time{}
+	}
+	return acquired, nil
+}
+
+// Release gives up the lease if this instance holds it, so another instance can take over immediately
+func (e *LeaderElector) Release(ctx context.Context) error {
+	if err := e.ensureTable(ctx); err != nil {
+		return err
+	}
+	_, err := e.connector.execStatement(ctx, nil, fmt.Sprintf("DELETE FROM %s WHERE name = $1 AND holder = $2", LeasesTable),
+		e.Name, e.Identity)
+	if err != nil {
+		return fmt.Errorf("error releasing lease %s: %v", e.Name, err)
+	}
+	e.mu.Lock()
+	e.expiresAt = time.Time{}
+	e.mu.Unlock()
+	return nil
+}
+
+// IsLeader reports whether this instance holds an unexpired lease
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.connector.now().Before(e.expiresAt)
+}
+
+// Run campaigns for the lease until ctx is cancelled, calling OnAcquire and
+// OnLose on changes. Errors renewing the lease are tolerated until it expires.
+// The lease is released when Run returns.
+func (e *LeaderElector) Run(ctx context.Context) error {
+	interval := e.RenewInterval
+	if interval <= 0 {
+		interval = e.TTL / 3
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid TTL %s for lease %s", e.TTL, e.Name)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	leader := false
+	for {
+		acquired, err := e.TryAcquire(ctx)
+		if err != nil {
+			// Keep the leadership while the lease has not expired
+			acquired = e.IsLeader()
+		}
+		if acquired && !leader {
+			leader = true
+			e.acquired(ctx)
+		} else if !acquired && leader {
+			leader = false
+			e.lost()
+		}
+
+		select {
+		case <-ctx.Done():
+			if leader {
+				e.lost()
+				// ctx is done, release with a fresh context
+				releaseCtx, cancel := context.WithTimeout(context.Background(), interval)
+				defer cancel()
+				return e.Release(releaseCtx)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) acquired(ctx context.Context) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	e.mu.Lock()
+	e.cancel = cancel
+	e.mu.Unlock()
+	if e.OnAcquire != nil {
+		e.OnAcquire(leaderCtx)
+	}
+}
+
+func (e *LeaderElector) lost() {
+	e.mu.Lock()
+	cancel := e.cancel
+	e.cancel = nil
+	e.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if e.OnLose != nil {
+		e.OnLose()
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLoaderWait is how long a Loader collects IDs before querying them
+const DefaultLoaderWait = time.Millisecond
+
+// DefaultLoaderMaxBatch is the maximum number of IDs a Loader queries at once
+const DefaultLoaderMaxBatch = 500
+
+// Loader coalesces concurrent loads of T by primary key into a single
+// WHERE id IN (...) query, e.g. for GraphQL resolvers. Create one per request:
+// results, including missing rows, are remembered for the loader's lifetime.
+type Loader[T any] struct {
+	// Wait is how long IDs are collected before a batch is queried
+	Wait time.Duration
+	// MaxBatch queries a batch as soon as it holds this many IDs
+	MaxBatch int
+
+	connector *PostgreSQLConnector
+	opts      []Option
+	mu        sync.Mutex
+	batch     *loaderBatch[T]
+	keys      map[string]*loaderBatch[T]
+}
+
+type loaderBatch[T any] struct {
+	ids     []interface{}
+	timer   *time.Timer
+	done    chan struct{}
+	results map[string]*T
+	err     error
+}
+
+// NewLoader creates a loader for T, the options (e.g. WithContext) apply to every batch query
+func NewLoader[T any](connector *PostgreSQLConnector, opts ...Option) *Loader[T] {
+	return &Loader[T]{
+		Wait:      DefaultLoaderWait,
+		MaxBatch:  DefaultLoaderMaxBatch,
+		connector: connector,
+		opts:      opts,
+		keys:      make(map[string]*loaderBatch[T]),
+	}
+}
+
+// loaderKey identifies an ID independent of its type, e.g. a uuid.UUID and its string
+func loaderKey(id interface{}) string {
+	return fmt.Sprint(id)
+}
+
+// Load returns the model with the primary key value id, or nil when there is none
+func (l *Loader[T]) Load(id interface{}) (*T, error) {
+	batch := l.enqueue(id)
+	<-batch.done
+	return batch.results[loaderKey(id)], batch.err
+}
+
+// LoadMany returns the models with the primary key values in the order of ids,
+// with nil for missing rows
+func (l *Loader[T]) LoadMany(ids ...interface{}) ([]*T, error) {
+	batches := make([]*loaderBatch[T], len(ids))
+	for i, id := range ids {
+		batches[i] = l.enqueue(id)
+	}
+	models := make([]*T, len(ids))
+	for i, batch := range batches {
+		<-batch.done
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		models[i] = batch.results[loaderKey(ids[i])]
+	}
+	return models, nil
+}
+
+// enqueue returns the batch loading id, adding it to the pending batch unless it was requested before
+func (l *Loader[T]) enqueue(id interface{}) *loaderBatch[T] {
+	key := loaderKey(id)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if batch, ok := l.keys[key]; ok {
+		return batch
+	}
+	batch := l.batch
+	if batch == nil {
+		batch = &loaderBatch[T]{done: make(chan struct{})}
+		batch.timer = time.AfterFunc(l.Wait, func() { l.dispatch(batch) })
+		l.batch = batch
+	}
+	batch.ids = append(batch.ids, id)
+	l.keys[key] = batch
+	if l.MaxBatch > 0 && len(batch.ids) >= l.MaxBatch {
+		batch.timer.Stop()
+		l.batch = nil
+		go l.run(batch)
+	}
+	return batch
+}
+
+// dispatch runs the batch when it is still pending
+func (l *Loader[T]) dispatch(batch *loaderBatch[T]) {
+	l.mu.Lock()
+	if l.batch != batch {
+		l.mu.Unlock()
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+	l.run(batch)
+}
+
+func (l *Loader[T]) run(batch *loaderBatch[T]) {
+	defer close(batch.done)
+	var model T
+	pk := getPrimaryKeyField(&model, l.connector.naming())
+	var models []T
+	err := l.connector.FindAll(&models, &DatabaseQuery{
+		Conditions: []Condition{{Field: pk, Operator: "IN", Value: batch.ids}},
+	}, l.opts...)
+	if err != nil {
+		batch.err = err
+		// Forget the failed IDs so they can be loaded again
+		l.mu.Lock()
+		for _, id := range batch.ids {
+			delete(l.keys, loaderKey(id))
+		}
+		l.mu.Unlock()
+		return
+	}
+	batch.results = make(map[string]*T, len(models))
+	for i := range models {
+		id, err := columnValue(&models[i], l.connector.naming(), pk)
+		if err != nil {
+			batch.err = err
+			return
+		}
+		batch.results[loaderKey(id)] = &models[i]
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// QueryToMaps runs a query and returns its rows as maps of column name to value,
+// for reports and other dynamic queries without a model. UUID, BYTEA and text
+// values are strings, NUMERIC values float64, JSON values json.RawMessage and
+// timestamps time.Time, converted to Location when set.
+func (s PostgreSQLConnector) QueryToMaps(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := s.queryStatement(ctx, nil, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	pointers := make([]interface{}, len(columnTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result := make(map[string]interface{}, len(columnTypes))
+		for i, columnType := range columnTypes {
+			result[columnType.Name()] = s.mapValue(columnType.DatabaseTypeName(), values[i])
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// mapValue converts a value scanned from a column of the given database type for QueryToMaps
+func (s *PostgreSQLConnector) mapValue(databaseType string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		switch databaseType {
+		case "NUMERIC":
+			if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+				return f
+			}
+		case "JSON", "JSONB":
+			return json.RawMessage(append([]byte{}, v...))
+		}
+		return string(v)
+	case time.Time:
+		if s.Location != nil {
+			return v.In(s.Location)
+		}
+	}
+	return value
+}
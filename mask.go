@@ -0,0 +1,114 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+)
+
+// maskVisible is the number of trailing characters left readable by maskString
+const maskVisible = 4
+
+// WithUnmasked makes FindAll return the values of columns tagged masked
+func WithUnmasked() Option {
+	return func(c *Config) { c.unmasked = true }
+}
+
+// maskString replaces all but the last four characters with asterisks, strings
+// shorter than eight characters are masked completely
+func maskString(value string) string {
+	runes := []rune(value)
+	if len(runes) < 2*maskVisible {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-maskVisible) + string(runes[len(runes)-maskVisible:])
+}
+
+// maskValue masks strings and string pointers with maskString and sets other types to their zero value
+func maskValue(value reflect.Value) {
+	switch {
+	case value.Kind() == reflect.String:
+		value.SetString(maskString(value.String()))
+	case value.Kind() == reflect.Ptr && value.Type().Elem().Kind() == reflect.String:
+		if !value.IsNil() {
+			masked := reflect.New(value.Type().Elem())
+			masked.Elem().SetString(maskString(value.Elem().String()))
+			value.Set(masked)
+		}
+	default:
+		value.Set(reflect.Zero(value.Type()))
+	}
+}
+
+// Masked returns a copy of a model, a model pointer or a slice of models, also
+// given as pointer, with the fields tagged masked masked, e.g. to serialize them
+// in responses. The argument is left unchanged, so models read from the
+// database can still be written back.
+func Masked(models interface{}) interface{} {
+	val := reflect.ValueOf(models)
+	switch {
+	case val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Struct:
+		masked := reflect.New(val.Elem().Type())
+		masked.Elem().Set(val.Elem())
+		maskStruct(masked.Elem())
+		return masked.Interface()
+	case val.Kind() == reflect.Struct:
+		masked := reflect.New(val.Type()).Elem()
+		masked.Set(val)
+		maskStruct(masked)
+		return masked.Interface()
+	case val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Slice:
+		val = val.Elem()
+		fallthrough
+	case val.Kind() == reflect.Slice:
+		masked := reflect.New(val.Type())
+		masked.Elem().Set(reflect.MakeSlice(val.Type(), val.Len(), val.Len()))
+		reflect.Copy(masked.Elem(), val)
+		if val.Type().Elem().Kind() == reflect.Ptr {
+			// Copy the models as well, masking them must not change the argument
+			for i := 0; i < val.Len(); i++ {
+				if element := val.Index(i); !element.IsNil() {
+					model := reflect.New(element.Type().Elem())
+					model.Elem().Set(element.Elem())
+					masked.Elem().Index(i).Set(model)
+				}
+			}
+		}
+		maskModels(masked.Interface())
+		return masked.Elem().Interface()
+	}
+	return models
+}
+
+// maskStruct masks the fields tagged masked of a model
+func maskStruct(model reflect.Value) {
+	for _, field := range metadataOf(model.Type(), nil).masked {
+		maskValue(model.Field(field.index))
+	}
+}
+
+// maskModels masks the fields tagged masked of the elements of a model slice
+// pointer, the elements may be models or model pointers
+func maskModels(models interface{}) {
+	slice := reflect.ValueOf(models).Elem()
+	if slice.Kind() != reflect.Slice {
+		return
+	}
+	elemType := slice.Type().Elem()
+	pointers := elemType.Kind() == reflect.Ptr
+	if pointers {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct || len(metadataOf(elemType, nil).masked) == 0 {
+		return
+	}
+	for i := 0; i < slice.Len(); i++ {
+		element := slice.Index(i)
+		if pointers {
+			if element.IsNil() {
+				continue
+			}
+			element = element.Elem()
+		}
+		maskStruct(element)
+	}
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"reflect"
+	"sync"
+)
+
+// fieldMetadata is a struct field with a gpo tag
+type fieldMetadata struct {
+	// index is the index of the field in the struct
+	index int
+	name  string
+	tag   *GPOField
+}
+
+// modelMetadata holds the parsed gpo tags of a model type. It is built once per
+// type and shared, so it must not be modified.
+type modelMetadata struct {
+	// fields are the tagged fields in declaration order
+	fields   []fieldMetadata
+	columns  Fields
+	fieldMap FieldMap
+	byColumn map[string]*fieldMetadata
+	// primaryKey is nil when no field is tagged pk
+	primaryKey *fieldMetadata
+	// masked are the fields tagged masked
+	masked []*fieldMetadata
+	// tableName is the table name of a TableNamer model, empty otherwise
+	tableName string
+
+	// selectable are the columns without writeonly, writable those without readonly
+	selectable Fields
+	writable   Fields
+}
+
+// metadataKey identifies the metadata of a struct type, column names omitted in
+// the gpo tags depend on the naming strategy
+type metadataKey struct {
+	t      reflect.Type
+	naming NamingStrategy
+}
+
+var modelMetadataCache sync.Map // metadataKey -> *modelMetadata
+
+// metadataOf returns the cached metadata of a struct type, nil naming means DefaultNaming
+func metadataOf(t reflect.Type, naming NamingStrategy) *modelMetadata {
+	if naming == nil {
+		naming = DefaultNaming
+	}
+	key := metadataKey{t: t, naming: naming}
+	if cached, ok := modelMetadataCache.Load(key); ok {
+		return cached.(*modelMetadata)
+	}
+	meta := &modelMetadata{
+		fieldMap: make(FieldMap),
+		byColumn: make(map[string]*fieldMetadata),
+	}
+	_, autoColumns := naming.(autoColumnsNaming)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := parseGPOTag(field)
+		if tag == nil && autoColumns && isAutoColumn(field) {
+			tag = &GPOField{}
+		}
+		if tag == nil || tag.ColumnName == "-" {
+			continue
+		}
+		if tag.ColumnName == "" {
+			tag.ColumnName = naming.ColumnName(field.Name)
+		}
+		meta.fields = append(meta.fields, fieldMetadata{index: i, name: field.Name, tag: tag})
+	}
+	for i := range meta.fields {
+		field := &meta.fields[i]
+		meta.columns = append(meta.columns, field.tag.ColumnName)
+		if !field.tag.IsWriteOnly {
+			meta.selectable = append(meta.selectable, field.tag.ColumnName)
+		}
+		if !field.tag.IsReadOnly {
+			meta.writable = append(meta.writable, field.tag.ColumnName)
+		}
+		meta.fieldMap[field.tag.ColumnName] = field.name
+		meta.byColumn[field.tag.ColumnName] = field
+		if field.tag.IsPrimaryKey && meta.primaryKey == nil {
+			meta.primaryKey = field
+		}
+		if field.tag.IsMasked {
+			meta.masked = append(meta.masked, field)
+		}
+	}
+	if namer, ok := reflect.New(t).Interface().(TableNamer); ok {
+		meta.tableName = namer.TableName()
+	}
+	cached, _ := modelMetadataCache.LoadOrStore(key, meta)
+	return cached.(*modelMetadata)
+}
+
+// modelMetadataOf returns the metadata of a model or model pointer
+func modelMetadataOf(model interface{}, naming NamingStrategy) *modelMetadata {
+	return metadataOf(indirectType(model), naming)
+}
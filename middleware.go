@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Operation is a statement about to be executed by the connector
+type Operation struct {
+	// Kind is "exec" for statements executed for their effect and "query" for
+	// statements returning rows
+	Kind string
+	SQL  string
+	Args []interface{}
+	// Tx is the transaction of the statement, nil outside of transactions
+	Tx *sql.Tx
+}
+
+// OperationFunc executes an operation. Middleware may change the SQL and the
+// arguments of the operation before passing it on.
+type OperationFunc func(ctx context.Context, op Operation) error
+
+// Middleware wraps the execution of every statement of the connector, e.g. for
+// logging, metrics, tenant scoping or fault injection. It calls next to execute
+// the operation, or returns an error instead to fail it.
+type Middleware func(next OperationFunc) OperationFunc
+
+// Use adds middleware around every query and exec of the connector. The first
+// middleware added is the outermost. Use it while setting up the connector,
+// before it runs statements.
+func (s *PostgreSQLConnector) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// runOperation runs op through the middleware chain, with execute at its end
+func (s *PostgreSQLConnector) runOperation(ctx context.Context, op Operation, execute OperationFunc) error {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		execute = s.middleware[i](execute)
+	}
+	return execute(ctx, op)
+}
+
+// observedExecer runs the statements of an execer, e.g. DDL, through the
+// middleware chain and reports them like execStatement
+type observedExecer struct {
+	execer
+	connector *PostgreSQLConnector
+}
+
+func (e observedExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = e.connector.runOperation(ctx, Operation{Kind: "exec", SQL: query, Args: args}, func(ctx context.Context, op Operation) (err error) {
+		defer e.connector.observeQuery(e.connector.now(), op.SQL, op.Args)
+		result, err = e.execer.ExecContext(ctx, op.SQL, op.Args...)
+		return err
+	})
+	return result, err
+}
+
+// observed wraps db to run its statements through the middleware and the Recorder
+func (s *PostgreSQLConnector) observed(db execer) execer {
+	return observedExecer{execer: db, connector: s}
+}
+
+// observedQuerier is observedExecer for queries, e.g. the schema reads of migrations
+type observedQuerier struct {
+	querier
+	connector *PostgreSQLConnector
+}
+
+func (q observedQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = q.connector.runOperation(ctx, Operation{Kind: "query", SQL: query, Args: args}, func(ctx context.Context, op Operation) (err error) {
+		defer q.connector.observeQuery(q.connector.now(), op.SQL, op.Args)
+		rows, err = q.querier.QueryContext(ctx, op.SQL, op.Args...)
+		return err
+	})
+	return closeOnError(rows, err)
+}
+
+// observedQueries wraps db to run its queries through the middleware and the Recorder
+func (s *PostgreSQLConnector) observedQueries(db querier) querier {
+	return observedQuerier{querier: db, connector: s}
+}
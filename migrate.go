@@ -0,0 +1,770 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DestructiveMode decides how MigrateTable handles changes that can lose data:
+// dropping columns without field and changing column types, other than widening
+// e.g. VARCHAR(50) to VARCHAR(255) or INTEGER to BIGINT
+type DestructiveMode int
+
+const (
+	// RefuseDestructive fails the migration with ErrDestructiveMigration
+	RefuseDestructive DestructiveMode = iota
+	// AllowDestructive applies destructive changes
+	AllowDestructive
+	// BackupDestructive copies the affected columns and the primary key to a
+	// table named <table>_backup_<unix time> before applying destructive changes
+	BackupDestructive
+)
+
+// MigrationLockName names the session advisory lock held while migrating, so
+// instances starting at the same time apply their migrations one after another
+const MigrationLockName = "gpo_migrations"
+
+// ErrDestructiveMigration is matched by the errors of migrations refused by RefuseDestructive
+var ErrDestructiveMigration = errors.New("destructive migration refused")
+
+// ConstraintNaming names the constraints added by MigrateTable. kind is "fkey"
+// for foreign keys, "key" for unique and "pkey" for primary key constraints.
+// The columns of primary keys are not passed, like in their Postgres names.
+type ConstraintNaming func(table string, columns []string, kind string) string
+
+// DefaultConstraintName follows the Postgres convention, e.g. orm_post_author_id_fkey
+func DefaultConstraintName(table string, columns []string, kind string) string {
+	parts := append([]string{table}, columns...)
+	if kind == "pkey" {
+		parts = []string{table}
+	}
+	return strings.Join(append(parts, kind), "_")
+}
+
+func (s *PostgreSQLConnector) constraintName(table string, columns []string, kind string) string {
+	if s.ConstraintNaming != nil {
+		return s.ConstraintNaming(table, columns, kind)
+	}
+	return DefaultConstraintName(table, columns, kind)
+}
+
+// existingForeignKey is a foreign key constraint read from pg_constraint
+type existingForeignKey struct {
+	Name string
+	ForeignKey
+}
+
+// existingConstraint is a unique or primary key constraint read from information_schema
+type existingConstraint struct {
+	Name string
+	// Type is UNIQUE or PRIMARY KEY
+	Type    string
+	Columns []string
+}
+
+// dependentForeignKey is a foreign key of another table referencing a table
+type dependentForeignKey struct {
+	Name  string
+	Table string
+	// Columns are the referenced columns, sorted
+	Columns []string
+}
+
+// tableSchema is the state of an existing table compared by MigrateTable
+type tableSchema struct {
+	columns []string
+	// types are the column types as named by Postgres, e.g. character varying(255),
+	// collations the collations of the columns not using the default one
+	types      map[string]string
+	collations map[string]string
+	// defaults are the DEFAULT expressions of the columns having one
+	defaults    map[string]string
+	foreignKeys []existingForeignKey
+	constraints []existingConstraint
+	// dependents are the foreign keys of other tables referencing the table
+	dependents []dependentForeignKey
+}
+
+// MigrateTable creates the table of the model when it does not exist yet.
+// Otherwise the columns missing from the table are added, see addColumnStmts, and
+// the constraints are reconciled with the tags: foreign keys with fk(...), unique
+// constraints of single columns with unique and the primary key with pk.
+// Constraints for new or changed tags are added, constraints without tag are
+// dropped unless they are foreign keys not named by the ORM. Widened column
+// types are altered, other type changes and columns without field are handled
+// according to DestructiveMigrations. All statements run in one transaction,
+// while holding the MigrationLockName advisory lock.
+func (s *PostgreSQLConnector) MigrateTable(model interface{}, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	return s.withMigrationLock(config.ctx, func(conn *sql.Conn) error {
+		return s.ddlTransaction(config.ctx, config.tx, conn.BeginTx, func(tx *sql.Tx) error {
+			db, exec := s.migrationTarget(conn, tx, config.dryRun)
+			return s.migrateTable(config.ctx, db, exec, model, nil)
+		})
+	})
+}
+
+// MigrateTables migrates the tables of the given models in foreign key dependency
+// order, in one transaction and while holding the MigrationLockName advisory
+// lock. A failure leaves all tables unchanged. Option values in the list, e.g.
+// WithContext or WithTransaction, apply to every statement. WithSeedData also
+// upserts the SeedData of the models implementing Seeder.
+func (s *PostgreSQLConnector) MigrateTables(models ...interface{}) error {
+	models, opts := splitModelsAndOptions(models)
+	models, err := sortModelsByDependencies(models, s.naming())
+	if err != nil {
+		return err
+	}
+	config := processOptions(opts)
+	defer config.release()
+	return s.withMigrationLock(config.ctx, func(conn *sql.Conn) error {
+		return s.ddlTransaction(config.ctx, config.tx, conn.BeginTx, func(tx *sql.Tx) error {
+			db, exec := s.migrationTarget(conn, tx, config.dryRun)
+			for _, model := range models {
+				if err := s.migrateTable(config.ctx, db, exec, model, models); err != nil {
+					return err
+				}
+			}
+			if config.seed {
+				return s.seedTables(config.ctx, tx, models)
+			}
+			return nil
+		})
+	})
+}
+
+// withMigrationLock runs fn holding the session advisory lock MigrationLockName
+// on a dedicated connection, waiting for other instances to release it first.
+// The connection is passed to fn to run the migration transaction.
+func (s *PostgreSQLConnector) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.GetConnection().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", MigrationLockName); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer func() {
+		// The lock belongs to the session, a connection still holding it must not return to the pool
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", MigrationLockName); err != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	return fn(conn)
+}
+
+// migrationTarget returns where a migration reads the schema and executes its
+// statements: the migration transaction, or in a dry run without transaction the
+// connection holding the migration lock and the plan. Schema reads and executed
+// statements run through the middleware and the Recorder.
+func (s *PostgreSQLConnector) migrationTarget(conn *sql.Conn, tx *sql.Tx, dry *dryRun) (querier, execer) {
+	var db querier = conn
+	var exec execer = dry
+	if tx != nil {
+		db = tx
+	}
+	if dry == nil {
+		exec = s.observed(tx)
+	}
+	return s.observedQueries(db), exec
+}
+
+// migrateTable migrates the table of one model, reading its schema with db and
+// executing the statements with exec, see MigrateTable
+func (s *PostgreSQLConnector) migrateTable(ctx context.Context, db querier, exec execer, model interface{}, related []interface{}) error {
+	table := s.tableDefinition(model, related)
+
+	schema, err := existingColumns(ctx, db, table.Name)
+	if err != nil {
+		return err
+	}
+	if len(schema.columns) == 0 {
+		s.warnUnsignedColumns(table.Name, model)
+		return _createTable(ctx, exec, table)
+	}
+	if err := checkComments(table); err != nil {
+		return err
+	}
+	if schema.foreignKeys, err = existingForeignKeys(ctx, db, table.Name); err != nil {
+		return err
+	}
+	if schema.constraints, err = existingConstraints(ctx, db, table.Name); err != nil {
+		return err
+	}
+	if schema.dependents, err = dependentForeignKeys(ctx, db, table.Name); err != nil {
+		return err
+	}
+	stmts, err := s.migrationStmts(table, schema)
+	if err != nil {
+		return err
+	}
+	comments, err := existingComments(ctx, db, table.Name)
+	if err != nil {
+		return err
+	}
+	stmts = append(stmts, buildCommentStmts(table, comments)...)
+	if table.History {
+		history, err := existingColumns(ctx, db, table.Name+HistoryTableSuffix)
+		if err != nil {
+			return err
+		}
+		if historyChanged(table, schema.columns, history.columns) {
+			stmts = append(stmts, historyStmts(table)...)
+		}
+	}
+	if len(stmts) > 0 {
+		if err := createColumnExtensions(ctx, exec, table.Columns); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := exec.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error migrating %s: %s: %v", table.Name, stmt, err)
+		}
+	}
+	return nil
+}
+
+// migrationStmts returns the ALTER TABLE statements migrating an existing table
+// to its definition. Foreign keys of the table are dropped first and added last,
+// so they do not block changes of the keys they reference. Keys referenced by
+// foreign keys of other tables are not dropped, the migration fails instead.
+func (s *PostgreSQLConnector) migrationStmts(table Table, schema tableSchema) ([]string, error) {
+	var stmts, destructive, affected []string
+	for _, column := range table.Columns {
+		existingType := schema.types[column.Name]
+		declaredType, known := postgresTypeName(column.Type)
+		if existingType == "" || !known || existingType == "ARRAY" {
+			continue
+		}
+		typeChanged := existingType != declaredType
+		collation := columnCollation(column)
+		collatable := declaredType == "text" || strings.HasPrefix(declaredType, "character")
+		collationChanged := collatable && schema.collations[column.Name] != collation
+		if !typeChanged && !collationChanged {
+			continue
+		}
+		baseType := column.Type
+		if i := strings.Index(baseType, " COLLATE "); i >= 0 {
+			baseType = baseType[:i]
+		}
+		typeText := baseType
+		if collation != "" {
+			typeText += " COLLATE " + quoteIdentifier(collation)
+		} else if collationChanged {
+			typeText += ` COLLATE "default"`
+		}
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+			table.Name, column.Name, typeText, column.Name, baseType))
+		if typeChanged && !widensType(existingType, declaredType) {
+			destructive = append(destructive, fmt.Sprintf("change column %s from %s to %s", column.Name, existingType, declaredType))
+			affected = append(affected, column.Name)
+		}
+	}
+	var drops []string
+	for _, name := range schema.columns {
+		declared := false
+		for _, column := range table.Columns {
+			declared = declared || column.Name == name
+		}
+		if !declared {
+			drops = append(drops, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table.Name, name))
+			destructive = append(destructive, "drop column "+name)
+			affected = append(affected, name)
+		}
+	}
+	if len(destructive) > 0 {
+		switch s.DestructiveMigrations {
+		case RefuseDestructive:
+			return nil, fmt.Errorf("%w for %s: %s, set DestructiveMigrations to apply it",
+				ErrDestructiveMigration, table.Name, strings.Join(destructive, "; "))
+		case BackupDestructive:
+			stmts = append([]string{s.backupStmt(table.Name, schema, affected)}, stmts...)
+		}
+	}
+
+	for _, column := range table.Columns {
+		if !contains(schema.columns, column.Name) {
+			stmts = append(stmts, addColumnStmts(table.Name, column)...)
+		} else if column.Default != "" && schema.defaults[column.Name] == "" {
+			// e.g. the gen_random_uuid() of id columns created before ServerUUID
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table.Name, column.Name, column.Default))
+		}
+	}
+
+	// Drop the constraints without matching tag first, a changed tag replaces its constraint.
+	// Foreign keys not named like the ones created by the ORM were added by hand and are kept.
+	declared := make(map[string]bool)
+	for _, existing := range schema.foreignKeys {
+		keep := false
+		for _, fk := range table.ForeignKeys {
+			if sameForeignKey(fk, existing.ForeignKey) {
+				keep = true
+				declared[fk.ColumnName] = true
+				break
+			}
+		}
+		if !keep && s.createdForeignKey(table.Name, existing) {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table.Name, existing.Name))
+		}
+	}
+	keyStmts, err := s.keyConstraintStmts(table, schema)
+	if err != nil {
+		return nil, err
+	}
+	stmts = append(stmts, keyStmts...)
+	stmts = append(stmts, drops...)
+	for _, fk := range table.ForeignKeys {
+		if declared[fk.ColumnName] {
+			continue
+		}
+		clause, err := foreignKeyClause(fk)
+		if err != nil {
+			return nil, err
+		}
+		name := s.constraintName(table.Name, []string{fk.ColumnName}, "fkey")
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table.Name, name, clause))
+	}
+	return stmts, nil
+}
+
+// addColumnStmts returns the statements adding a column to an existing table.
+// A NOT NULL column without default is added with the zero value of its type as
+// temporary default, so the existing rows are backfilled like the ORM would have
+// inserted them. Without known zero value it is added nullable and then set NOT
+// NULL, which fails when the table has rows.
+func addColumnStmts(table string, column Column) []string {
+	definition := column.Name + " " + columnTypeText(column)
+	switch {
+	case column.Null:
+		definition += " NULL"
+	case column.Default != "" || strings.Contains(strings.ToUpper(column.Type), "SERIAL"):
+		definition += " NOT NULL"
+	default:
+		typeName, _ := postgresTypeName(column.Type)
+		if zero, ok := zeroLiterals[strings.SplitN(typeName, "(", 2)[0]]; ok {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s NOT NULL DEFAULT %s", table, definition, zero),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, column.Name),
+			}
+		}
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s NULL", table, definition),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column.Name),
+		}
+	}
+	if column.Default != "" {
+		definition += " DEFAULT " + column.Default
+	}
+	return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, definition)}
+}
+
+// zeroLiterals are the zero values of Go fields stored in columns of the types
+// named by postgresTypeName, without length
+var zeroLiterals = map[string]string{
+	"text": "''", "character varying": "''", "character": "''", "citext": "''",
+	"smallint": "0", "integer": "0", "bigint": "0", "real": "0",
+	"boolean":                     "false",
+	"uuid":                        "'00000000-0000-0000-0000-000000000000'",
+	"timestamp without time zone": "'0001-01-01 00:00:00'",
+	"timestamp with time zone":    "'0001-01-01 00:00:00+00'",
+	"date":                        "'0001-01-01'",
+	"time without time zone":      "'00:00:00'",
+	"interval":                    "'0'",
+}
+
+// createdForeignKey reports whether an existing foreign key has the name the ORM
+// gives the constraint of its column, by CreateTable or by MigrateTable
+func (s *PostgreSQLConnector) createdForeignKey(table string, existing existingForeignKey) bool {
+	columns := []string{existing.ColumnName}
+	return existing.Name == DefaultConstraintName(table, columns, "fkey") ||
+		existing.Name == s.constraintName(table, columns, "fkey")
+}
+
+// backupStmt returns the statement copying the affected columns of a table, with
+// its primary key, to a backup table
+func (s *PostgreSQLConnector) backupStmt(table string, schema tableSchema, affected []string) string {
+	var columns []string
+	for _, constraint := range schema.constraints {
+		if constraint.Type == "PRIMARY KEY" {
+			columns = append(columns, constraint.Columns...)
+		}
+	}
+	for _, column := range affected {
+		if !contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE %s_backup_%d AS SELECT %s FROM %s",
+		table, s.now().Unix(), strings.Join(columns, ", "), table)
+}
+
+// postgresTypeNames maps the column types of models to the names used by
+// information_schema, VARCHAR and CHAR are handled by postgresTypeName
+var postgresTypeNames = map[string]string{
+	"TEXT":        "text",
+	"SMALLINT":    "smallint",
+	"INTEGER":     "integer",
+	"BIGINT":      "bigint",
+	"REAL":        "real",
+	"BOOLEAN":     "boolean",
+	"UUID":        "uuid",
+	"TIMESTAMP":   "timestamp without time zone",
+	"TIMESTAMPTZ": "timestamp with time zone",
+	"DATE":        "date",
+	"TIME":        "time without time zone",
+	"INTERVAL":    "interval",
+	"INET":        "inet",
+	"CIDR":        "cidr",
+	"MACADDR":     "macaddr",
+	"CITEXT":      "citext",
+}
+
+// postgresTypeName returns the name of a column type as read by existingColumns,
+// false for types that are not compared
+func postgresTypeName(columnType string) (string, bool) {
+	columnType = strings.ToUpper(strings.TrimSpace(columnType))
+	if i := strings.Index(columnType, " COLLATE "); i >= 0 {
+		columnType = columnType[:i]
+	}
+	if strings.HasPrefix(columnType, "VARCHAR(") {
+		return "character varying" + columnType[7:], true
+	}
+	if strings.HasPrefix(columnType, "CHAR(") {
+		return "character" + columnType[4:], true
+	}
+	name, ok := postgresTypeNames[columnType]
+	return name, ok
+}
+
+// widensType reports whether changing a column type keeps all values
+func widensType(from, to string) bool {
+	integers := map[string]int{"smallint": 1, "integer": 2, "bigint": 3}
+	if integers[from] > 0 && integers[to] > integers[from] {
+		return true
+	}
+	if from == "text" && to == "citext" {
+		return true
+	}
+	if strings.HasPrefix(from, "character varying") {
+		if to == "text" || to == "citext" {
+			return true
+		}
+		// A character varying without length is unlimited like text
+		fromLength, toLength := typeLength(from), typeLength(to)
+		return strings.HasPrefix(to, "character varying") && (toLength == 0 || fromLength != 0 && toLength >= fromLength)
+	}
+	return false
+}
+
+// typeLength returns the length of a type such as character varying(255)
+func typeLength(typeName string) int {
+	start, end := strings.Index(typeName, "("), strings.Index(typeName, ")")
+	if start < 0 || end < start {
+		return 0
+	}
+	length, _ := strconv.Atoi(typeName[start+1 : end])
+	return length
+}
+
+// keyConstraintStmts returns the statements reconciling the unique and primary
+// key constraints of a table. Unique constraints of several columns cannot be
+// declared with tags and are kept, except for the ones of unique columns of a
+// partitioned table, which include the partition columns. Dropping a constraint referenced by a foreign
+// key of another table fails with an error naming that foreign key.
+func (s *PostgreSQLConnector) keyConstraintStmts(table Table, schema tableSchema) ([]string, error) {
+	var drops, adds []string
+	primaryKey := primaryKeyColumns(table)
+	hasPrimaryKey := false
+	unique := make(map[string]bool)
+	drop := func(constraint existingConstraint) error {
+		if dependent := referencingForeignKey(schema.dependents, constraint.Columns); dependent != nil {
+			return fmt.Errorf("cannot drop constraint %s of %s: it is referenced by foreign key %s of %s, drop or change that foreign key first",
+				constraint.Name, table.Name, dependent.Name, dependent.Table)
+		}
+		drops = append(drops, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table.Name, constraint.Name))
+		return nil
+	}
+	for _, constraint := range schema.constraints {
+		switch {
+		case constraint.Type == "PRIMARY KEY":
+			hasPrimaryKey = strings.Join(constraint.Columns, ",") == strings.Join(primaryKey, ",")
+			if !hasPrimaryKey {
+				if err := drop(constraint); err != nil {
+					return nil, err
+				}
+			}
+		case declaredUnique(table, constraint.Columns[0]) && !unique[constraint.Columns[0]] &&
+			strings.Join(constraint.Columns, ",") == strings.Join(uniqueKeyColumns(table, constraint.Columns[0]), ","):
+			unique[constraint.Columns[0]] = true
+		case len(constraint.Columns) == 1:
+			if err := drop(constraint); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !hasPrimaryKey && len(primaryKey) > 0 {
+		adds = append(adds, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY (%s)",
+			table.Name, s.constraintName(table.Name, primaryKey, "pkey"), strings.Join(primaryKey, ", ")))
+	}
+	for _, column := range table.Columns {
+		if column.Unique && !unique[column.Name] {
+			keyColumns := uniqueKeyColumns(table, column.Name)
+			adds = append(adds, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s)",
+				table.Name, s.constraintName(table.Name, keyColumns, "key"), strings.Join(keyColumns, ", ")))
+		}
+	}
+	return append(drops, adds...), nil
+}
+
+// referencingForeignKey returns the foreign key referencing exactly the given columns, if any
+func referencingForeignKey(dependents []dependentForeignKey, columns []string) *dependentForeignKey {
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+	for i, dependent := range dependents {
+		if strings.Join(dependent.Columns, ",") == strings.Join(sorted, ",") {
+			return &dependents[i]
+		}
+	}
+	return nil
+}
+
+// declaredUnique reports whether the column is tagged unique
+func declaredUnique(table Table, column string) bool {
+	for _, c := range table.Columns {
+		if c.Name == column {
+			return c.Unique
+		}
+	}
+	return false
+}
+
+// sameForeignKey reports whether two foreign keys reference the same column with the same options
+func sameForeignKey(a, b ForeignKey) bool {
+	onDelete := func(fk ForeignKey) string {
+		if action := strings.ToUpper(fk.OnDelete); action != "NO ACTION" {
+			return action
+		}
+		return ""
+	}
+	return a.ColumnName == b.ColumnName && a.References == b.References && onDelete(a) == onDelete(b) &&
+		a.Deferrable == b.Deferrable && a.InitiallyDeferred == b.InitiallyDeferred
+}
+
+// existingColumns returns the columns of a table with their types, collations and
+// defaults, none when it does not exist
+func existingColumns(ctx context.Context, db querier, table string) (tableSchema, error) {
+	schema := tableSchema{types: make(map[string]string), collations: make(map[string]string), defaults: make(map[string]string)}
+	rows, err := db.QueryContext(ctx,
+		`SELECT column_name, data_type, udt_name, character_maximum_length, collation_name, column_default
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+	if err != nil {
+		return schema, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column, dataType, udtName string
+		var length sql.NullInt64
+		var collation, columnDefault sql.NullString
+		if err := rows.Scan(&column, &dataType, &udtName, &length, &collation, &columnDefault); err != nil {
+			return schema, err
+		}
+		// Types of extensions such as citext are named by their udt_name
+		if dataType == "USER-DEFINED" {
+			dataType = udtName
+		}
+		if length.Valid {
+			dataType = fmt.Sprintf("%s(%d)", dataType, length.Int64)
+		}
+		schema.columns = append(schema.columns, column)
+		schema.types[column] = dataType
+		if collation.Valid {
+			schema.collations[column] = collation.String
+		}
+		if columnDefault.Valid {
+			schema.defaults[column] = columnDefault.String
+		}
+	}
+	return schema, rows.Err()
+}
+
+// existingComments returns the comments of a table and its columns from
+// obj_description and col_description, keyed by column name and by "" for the table
+func existingComments(ctx context.Context, db querier, table string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT '', COALESCE(obj_description(c.oid, 'pg_class'), '')
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relname = $1
+		UNION ALL
+		SELECT a.attname, COALESCE(col_description(c.oid, a.attnum), '')
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE n.nspname = current_schema() AND c.relname = $1`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading comments of %s: %v", table, err)
+	}
+	defer rows.Close()
+	comments := make(map[string]string)
+	for rows.Next() {
+		var name, comment string
+		if err := rows.Scan(&name, &comment); err != nil {
+			return nil, err
+		}
+		comments[name] = comment
+	}
+	return comments, rows.Err()
+}
+
+// existingConstraints reads the unique and primary key constraints of a table
+func existingConstraints(ctx context.Context, db querier, table string) ([]existingConstraint, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT tc.constraint_name, tc.constraint_type, kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu ON kcu.constraint_schema = tc.constraint_schema
+		AND kcu.constraint_name = tc.constraint_name AND kcu.table_name = tc.table_name
+		WHERE tc.table_schema = current_schema() AND tc.table_name = $1
+		AND tc.constraint_type IN ('PRIMARY KEY', 'UNIQUE')
+		ORDER BY tc.constraint_name, kcu.ordinal_position`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading constraints of %s: %v", table, err)
+	}
+	defer rows.Close()
+	var constraints []existingConstraint
+	for rows.Next() {
+		var name, constraintType, column string
+		if err := rows.Scan(&name, &constraintType, &column); err != nil {
+			return nil, err
+		}
+		if n := len(constraints); n > 0 && constraints[n-1].Name == name {
+			constraints[n-1].Columns = append(constraints[n-1].Columns, column)
+			continue
+		}
+		constraints = append(constraints, existingConstraint{Name: name, Type: constraintType, Columns: []string{column}})
+	}
+	return constraints, rows.Err()
+}
+
+// onDeleteActions maps pg_constraint.confdeltype to ON DELETE actions
+var onDeleteActions = map[string]string{"a": "", "r": "RESTRICT", "c": "CASCADE", "n": "SET NULL", "d": "SET DEFAULT"}
+
+// existingForeignKeys reads the single column foreign key constraints of a table
+func existingForeignKeys(ctx context.Context, db querier, table string) ([]existingForeignKey, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT c.conname, a.attname, rt.relname, ra.attname, c.confdeltype, c.condeferrable, c.condeferred
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_class rt ON rt.oid = c.confrelid
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = c.confkey[1]
+		WHERE c.contype = 'f' AND n.nspname = current_schema() AND t.relname = $1
+		AND array_length(c.conkey, 1) = 1`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading foreign keys of %s: %v", table, err)
+	}
+	defer rows.Close()
+	var foreignKeys []existingForeignKey
+	for rows.Next() {
+		var fk existingForeignKey
+		var referencedTable, referencedColumn, onDelete string
+		if err := rows.Scan(&fk.Name, &fk.ColumnName, &referencedTable, &referencedColumn, &onDelete,
+			&fk.Deferrable, &fk.InitiallyDeferred); err != nil {
+			return nil, err
+		}
+		fk.References = fmt.Sprintf("%s(%s)", referencedTable, referencedColumn)
+		fk.OnDelete = onDeleteActions[onDelete]
+		foreignKeys = append(foreignKeys, fk)
+	}
+	return foreignKeys, rows.Err()
+}
+
+// dependentForeignKeys reads the foreign keys of other tables referencing a table
+func dependentForeignKeys(ctx context.Context, db querier, table string) ([]dependentForeignKey, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT c.conname, t.relname, string_agg(a.attname, ',')
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_class rt ON rt.oid = c.confrelid
+		JOIN pg_namespace n ON n.oid = rt.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = ANY (c.confkey)
+		WHERE c.contype = 'f' AND n.nspname = current_schema() AND rt.relname = $1 AND c.conrelid <> c.confrelid
+		GROUP BY c.conname, t.relname`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading foreign keys referencing %s: %v", table, err)
+	}
+	defer rows.Close()
+	var dependents []dependentForeignKey
+	for rows.Next() {
+		var dependent dependentForeignKey
+		var columns string
+		if err := rows.Scan(&dependent.Name, &dependent.Table, &columns); err != nil {
+			return nil, err
+		}
+		dependent.Columns = strings.Split(columns, ",")
+		sort.Strings(dependent.Columns)
+		dependents = append(dependents, dependent)
+	}
+	return dependents, rows.Err()
+}
+
+// validIdentifier reports whether name can be used unquoted as table or column
+// name in the schema helpers, optionally qualified with a schema
+func validIdentifier(name string) bool {
+	for _, part := range strings.SplitN(name, ".", 2) {
+		if part == "" || !(part[0] == '_' || part[0] >= 'a' && part[0] <= 'z' || part[0] >= 'A' && part[0] <= 'Z') {
+			return false
+		}
+		for _, c := range part {
+			if !(c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// alterTable runs an ALTER TABLE statement of the schema helpers after checking the names
+func (s *PostgreSQLConnector) alterTable(opts []Option, table string, clause string, names ...string) error {
+	for _, name := range append([]string{table}, names...) {
+		if !validIdentifier(name) {
+			return fmt.Errorf("invalid identifier %q", name)
+		}
+	}
+	config := processOptions(opts)
+	defer config.release()
+	if _, err := s.execStatement(config.ctx, config.tx, fmt.Sprintf("ALTER TABLE %s %s", table, clause)); err != nil {
+		return err
+	}
+	s.invalidateCache(config.ctx, config.tx, table)
+	return nil
+}
+
+// DropColumn drops a column of the table of a model or table name, e.g. after
+// removing the field when DestructiveMigrations refuses to drop it
+func (s *PostgreSQLConnector) DropColumn(modelOrTableName interface{}, column string, opts ...Option) error {
+	table := s.tableNameFromModelOrName(modelOrTableName)
+	return s.alterTable(opts, table, "DROP COLUMN "+column, column)
+}
+
+// RenameColumn renames a column of the table of a model or table name, keeping its data
+func (s *PostgreSQLConnector) RenameColumn(modelOrTableName interface{}, oldName, newName string, opts ...Option) error {
+	table := s.tableNameFromModelOrName(modelOrTableName)
+	return s.alterTable(opts, table, fmt.Sprintf("RENAME COLUMN %s TO %s", oldName, newName), oldName, newName)
+}
+
+// RenameTable renames a table, the new name is given without schema
+func (s *PostgreSQLConnector) RenameTable(oldName, newName string, opts ...Option) error {
+	if strings.Contains(newName, ".") {
+		return fmt.Errorf("invalid identifier %q: the new name cannot have a schema", newName)
+	}
+	return s.alterTable(opts, oldName, "RENAME TO "+newName, newName)
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 const (
@@ -20,6 +21,38 @@ type GPOField struct {
 	IsNullable   bool
 	Length       int
 	ForeignKey   *ForeignKeyInfo
+	Indexes      []IndexInfo
+	Comment      string
+	// IsMasked masks the column in FindAll results unless WithUnmasked is given,
+	// and in copies returned by Masked
+	IsMasked bool
+	// Validations are checked by InsertModel and UpdateModel before writing
+	Validations []ValidationRule
+	// Type overrides the column type mapped from the Go type, e.g. BIGINT
+	Type string
+	// IsReadOnly columns are only read, e.g. computed by the database
+	IsReadOnly bool
+	// IsWriteOnly columns are written but not selected, e.g. password hashes
+	IsWriteOnly bool
+	// Polymorphic is set on relation fields tagged gpo:"-,polymorphic(type,id)"
+	Polymorphic *PolymorphicInfo
+	// Collation of text columns, e.g. und-x-icu or C
+	Collation string
+	// commentErr reports a comment(...) option that cannot be parsed
+	commentErr error
+}
+
+// TableNamer is implemented by models mapping to a table name that does not
+// follow the convention, e.g. a legacy table. The name is used as is, without
+// TablePrefix.
+type TableNamer interface {
+	TableName() string
+}
+
+// ValidationRule is a validation tag option such as notempty, email, min(1) or max(100)
+type ValidationRule struct {
+	Name  string
+	Param string
 }
 
 // ForeignKeyInfo represents foreign key relationship information
@@ -27,6 +60,25 @@ type ForeignKeyInfo struct {
 	Table    string
 	Column   string
 	OnDelete string
+	// Deferrable constraints can be deferred with SetConstraintsDeferred,
+	// InitiallyDeferred ones are checked at commit by default
+	Deferrable        bool
+	InitiallyDeferred bool
+}
+
+// PolymorphicInfo names the columns of a polymorphic association: the type
+// column holds the table name (without prefix) of the owner, the id column its key
+type PolymorphicInfo struct {
+	TypeColumn string
+	IDColumn   string
+}
+
+// IndexInfo represents an index declared with the index(...) tag option
+type IndexInfo struct {
+	Name   string
+	Unique bool
+	// Where makes the index partial, e.g. "deleted_at IS NULL"
+	Where string
 }
 
 // Option represents a configuration option for database operations
@@ -34,8 +86,36 @@ type Option func(*Config)
 
 // Config holds configuration for database operations
 type Config struct {
-	ctx context.Context
-	tx  *sql.Tx
+	ctx             context.Context
+	tx              *sql.Tx
+	preloads        []string
+	associations    bool
+	idempotencyKey  string
+	view            string
+	primary         bool
+	inStrategy      LargeInStrategy
+	inThreshold     int
+	timeout         time.Duration
+	cancel          context.CancelFunc
+	restartIdentity bool
+	cascade         bool
+	analyze         bool
+	noCache         bool
+	recreate        bool
+	unmasked        bool
+	related         []interface{}
+	requireRow      bool
+	seed            bool
+	dryRun          *dryRun
+	unscoped        bool
+	txOptions       *sql.TxOptions
+}
+
+// release cancels the timeout context of the operation, if any
+func (c *Config) release() {
+	if c.cancel != nil {
+		c.cancel()
+	}
 }
 
 // WithContext sets the context for database operations
@@ -48,12 +128,105 @@ func WithTransaction(tx *sql.Tx) Option {
 	return func(c *Config) { c.tx = tx }
 }
 
+// Preload eagerly loads the named relation fields after FindFirst/FindAll.
+// Nested relations are separated by dots, e.g. "Departments.Employees"
+func Preload(paths ...string) Option {
+	return func(c *Config) { c.preloads = append(c.preloads, paths...) }
+}
+
+// WithTimeout cancels the operation when it takes longer than d
+func WithTimeout(d time.Duration) Option {
+	return func(c *Config) { c.timeout = d }
+}
+
+// WithRestartIdentity makes TruncateTable(s) reset the sequences of the truncated tables
+func WithRestartIdentity() Option {
+	return func(c *Config) { c.restartIdentity = true }
+}
+
+// WithCascade makes TruncateTable(s) also truncate tables referencing the truncated tables
+func WithCascade() Option {
+	return func(c *Config) { c.cascade = true }
+}
+
+// WithRecreate makes ResetDatabase drop and create the tables instead of truncating them
+func WithRecreate() Option {
+	return func(c *Config) { c.recreate = true }
+}
+
+// WithSeedData makes CreateTables and MigrateTables upsert the SeedData of the
+// models implementing Seeder, in the same transaction
+func WithSeedData() Option {
+	return func(c *Config) { c.seed = true }
+}
+
+// withRequiredRow makes FindFirst return sql.ErrNoRows when no row matches
+func withRequiredRow() Option {
+	return func(c *Config) { c.requireRow = true }
+}
+
+// withRelatedModels passes the models of a CreateTables call to CreateTable, so
+// fk(...) declarations can reference their TableName
+func withRelatedModels(models []interface{}) Option {
+	return func(c *Config) { c.related = models }
+}
+
+// WithIsolation sets the isolation level of the transactions begun by the
+// operation, e.g. by WithinTransaction. In sql.LevelSerializable WithinTransaction
+// retries serialization failures even without RetryPolicy, see SerializationRetryPolicy.
+func WithIsolation(level sql.IsolationLevel) Option {
+	return func(c *Config) {
+		if c.txOptions == nil {
+			c.txOptions = &sql.TxOptions{}
+		}
+		c.txOptions.Isolation = level
+	}
+}
+
+// WithReadOnly begins the transactions of the operation, e.g. by
+// WithinTransaction, in read-only mode
+func WithReadOnly() Option {
+	return func(c *Config) {
+		if c.txOptions == nil {
+			c.txOptions = &sql.TxOptions{}
+		}
+		c.txOptions.ReadOnly = true
+	}
+}
+
+// WithPrimary forces reads to the primary even when replicas are configured,
+// e.g. to read your own writes
+func WithPrimary() Option {
+	return func(c *Config) { c.primary = true }
+}
+
+// WithView selects a projection preset declared by the model (see ProjectionProvider) for FindAll
+func WithView(name string) Option {
+	return func(c *Config) { c.view = name }
+}
+
+// WithAssociations makes InsertModel also insert populated has-many and has-one
+// relation fields, filling their foreign key columns from the parent, in a single transaction
+func WithAssociations() Option {
+	return func(c *Config) { c.associations = true }
+}
+
 type Condition struct {
 	Field    string
 	Operator string
 	Value    interface{}
 }
 
+// And groups conditions that must all match, for nesting inside Or
+func And(conditions ...Condition) Condition {
+	return Condition{Operator: "AND", Value: conditions}
+}
+
+// Or groups conditions of which at least one must match
+func Or(conditions ...Condition) Condition {
+	return Condition{Operator: "OR", Value: conditions}
+}
+
 type DatabaseQuery struct {
 	Table string
 	// Fields is a slice of strings that represent the fields to be selected
@@ -67,6 +240,29 @@ type DatabaseQuery struct {
 	AllowSearch     bool
 	SearchText      string
 	SearchFields    Fields
+	// View selects a projection preset of the model, see ProjectionProvider
+	View string
+	// columns restricts the selected columns, set from the View projection
+	columns Fields
+	// tieBreaker is ordered by after OrderBy, set by FindPage for a stable keyset order
+	tieBreaker string
+	// GroupBy, Aggregates and Having build report queries, the results are scanned
+	// into structs tagged with the group columns and the aggregate aliases
+	GroupBy    []string
+	Aggregates []Aggregate // Selected instead of the fields tagged with their alias
+	Having     []Condition // Field may be an aggregate expression, e.g. "COUNT(*)"
+}
+
+// Projection is a named preset of columns and relations to load for a model
+type Projection struct {
+	Columns  []string
+	Preloads []string
+}
+
+// ProjectionProvider is implemented by models declaring projection presets
+// such as "summary" and "detail", selected with WithView or DatabaseQuery.View
+type ProjectionProvider interface {
+	Projections() map[string]Projection
 }
 
 type DatabaseDelete struct {
@@ -96,6 +292,15 @@ type Column struct {
 	Unique bool
 	// Length is the length of the column, for example 255, only used for VARCHAR columns (string)
 	Length int
+	// Comment documents the column with COMMENT ON COLUMN
+	Comment string
+	// Default is the DEFAULT expression of the column, e.g. gen_random_uuid()
+	Default string
+	// Collation is the COLLATE of a text column, see the collate tag option
+	Collation string
+	// commentErr reports a comment(...) option that cannot be parsed, returned
+	// when the table is created or migrated
+	commentErr error
 }
 
 type ForeignKey struct {
@@ -103,6 +308,9 @@ type ForeignKey struct {
 	References string // format: "table(column)"
 	// On delete
 	OnDelete string
+	// Deferrable and InitiallyDeferred, see ForeignKeyInfo
+	Deferrable        bool
+	InitiallyDeferred bool
 }
 
 // Table represents a database table
@@ -112,11 +320,33 @@ type Table struct {
 	// Columns is a slice of Column structs that represent the columns in the table
 	Columns     []Column
 	ForeignKeys []ForeignKey
+	Indexes     []Index
+	// Partitioning declares the table as partitioned, see PartitionedModel
+	Partitioning *Partitioning
+	// Comment documents the table with COMMENT ON TABLE, see TableCommenter
+	Comment string
+	// History keeps past row versions in a history table, see HistoryModel
+	History bool
+}
+
+// TableCommenter is implemented by models documenting their table
+type TableCommenter interface {
+	TableComment() string
+}
+
+// Index represents a (possibly partial or multi-column) index on a table
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Where   string
 }
 
 type DatabaseInsert struct {
 	Fields Fields `json:"fields"`
 	Table  string `json:"table"`
+	// key is the client generated value of the default id column, see KeyGenerator
+	key interface{}
 }
 
 type FieldType int
@@ -151,9 +381,20 @@ type JoinProps struct {
 	JoinTableModel  interface{}
 	MainTableCols   []string
 	JoinTableCols   []string
-	JoinCondition   string
+	JoinCondition   string // Optional: derived from fk tags (see AutoJoin) when empty
 	WhereConditions []Condition
 	JoinType        JoinType // Required field - no default
+	// GroupBy, Aggregates and Having build grouped queries, e.g. users with their permission count
+	GroupBy    []string    // e.g. "orm_user.id"
+	Aggregates []Aggregate // Selected in addition to MainTableCols/JoinTableCols
+	Having     []Condition // Field may be an aggregate expression, e.g. "COUNT(orm_permission.id)"
+}
+
+// Aggregate represents an aggregate selection, e.g. COUNT(orm_permission.id) AS permission_count
+type Aggregate struct {
+	Function string // COUNT, SUM, AVG, MIN or MAX
+	Column   string // Column to aggregate, "*" is allowed for COUNT
+	Alias    string // Result column name
 }
 
 // JoinResult represents the result of a join operation that can be scanned into structs
@@ -161,7 +402,7 @@ type JoinResult struct {
 	ResultModel     interface{} // The struct to scan results into (should be a slice pointer)
 	MainTableModel  interface{}
 	JoinTableModel  interface{}
-	JoinCondition   string
+	JoinCondition   string // Optional: derived from fk tags (see AutoJoin) when empty
 	WhereConditions []Condition
 	JoinType        JoinType // Required field - no default
 	// ColumnMappings maps database columns to struct field names for complex joins
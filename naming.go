@@ -0,0 +1,141 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NamingStrategy derives table names from model type names and column names
+// from field names whose gpo tag omits the column name. Strategies are used as
+// map keys, so implementations must be comparable, e.g. pointers.
+type NamingStrategy interface {
+	TableName(typeName string) string
+	ColumnName(fieldName string) string
+}
+
+// Naming strategies, DefaultNaming is used when the connector has none
+var (
+	// DefaultNaming lowercases the names, UserCompanyPermission becomes usercompanypermission
+	DefaultNaming NamingStrategy = defaultNaming{}
+	// SnakeCaseNaming converts the names to snake case, UserCompanyPermission becomes user_company_permission
+	SnakeCaseNaming NamingStrategy = snakeCaseNaming{}
+	// PluralSnakeCaseNaming is SnakeCaseNaming with plural table names, UserCompanyPermission becomes user_company_permissions
+	PluralSnakeCaseNaming NamingStrategy = snakeCaseNaming{plural: true}
+)
+
+type defaultNaming struct{}
+
+func (defaultNaming) TableName(typeName string) string {
+	return strings.ToLower(typeName)
+}
+
+func (defaultNaming) ColumnName(fieldName string) string {
+	return strings.ToLower(fieldName)
+}
+
+type snakeCaseNaming struct {
+	plural bool
+}
+
+func (n snakeCaseNaming) TableName(typeName string) string {
+	if n.plural {
+		return pluralize(toSnakeCase(typeName))
+	}
+	return toSnakeCase(typeName)
+}
+
+func (snakeCaseNaming) ColumnName(fieldName string) string {
+	return toSnakeCase(fieldName)
+}
+
+type funcNaming struct {
+	fn func(name string) string
+}
+
+func (n *funcNaming) TableName(typeName string) string {
+	return n.fn(typeName)
+}
+
+func (n *funcNaming) ColumnName(fieldName string) string {
+	return n.fn(fieldName)
+}
+
+// CustomNaming returns a NamingStrategy deriving both table and column names with fn
+func CustomNaming(fn func(name string) string) NamingStrategy {
+	return &funcNaming{fn: fn}
+}
+
+// autoColumnsNaming marks the naming strategy of a connector with AutoColumns,
+// so the metadata of both modes is cached separately
+type autoColumnsNaming struct {
+	NamingStrategy
+}
+
+// naming returns the naming strategy of the connector
+func (s *PostgreSQLConnector) naming() NamingStrategy {
+	naming := DefaultNaming
+	if s.NamingStrategy != nil {
+		naming = s.NamingStrategy
+	}
+	if s.AutoColumns {
+		return autoColumnsNaming{naming}
+	}
+	return naming
+}
+
+// isAutoColumn reports whether an untagged field is a column with AutoColumns.
+// Fields of struct types are relations, except time.Time and sql.Scanner types.
+func isAutoColumn(field reflect.StructField) bool {
+	if !field.IsExported() || field.Anonymous {
+		return false
+	}
+	t := field.Type
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	return reflect.PointerTo(t).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
+
+// toSnakeCase converts a Go identifier to snake case, keeping acronyms together,
+// e.g. UserID becomes user_id and HTTPRequest becomes http_request
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pluralize returns the English plural of a snake case name by pluralizing its last word
+func pluralize(name string) string {
+	switch {
+	case name == "":
+		return name
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "z"),
+		strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	}
+	return name + "s"
+}
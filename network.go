@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// Operators of the inet and cidr column types, e.g.
+// Condition{Field: "client_ip", Operator: NetContainedIn, Value: "10.0.0.0/8"}
+const (
+	NetContainedIn        = "<<"
+	NetContainedInOrEqual = "<<="
+	NetContains           = ">>"
+	NetContainsOrEqual    = ">>="
+	NetOverlaps           = "&&"
+)
+
+var (
+	ipType           = reflect.TypeOf(net.IP{})
+	ipNetType        = reflect.TypeOf(net.IPNet{})
+	hardwareAddrType = reflect.TypeOf(net.HardwareAddr{})
+)
+
+// isNetworkType reports whether t is net.IP, net.IPNet, *net.IPNet or net.HardwareAddr
+func isNetworkType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == ipType || t == ipNetType || t == hardwareAddrType
+}
+
+// networkArg converts network addresses to their text representation, which
+// Postgres accepts for inet, cidr and macaddr, other values are returned as is
+func networkArg(value interface{}) interface{} {
+	switch v := value.(type) {
+	case net.IP:
+		if v == nil {
+			return nil
+		}
+		return v.String()
+	case net.IPNet:
+		return v.String()
+	case *net.IPNet:
+		if v == nil {
+			return nil
+		}
+		return v.String()
+	case net.HardwareAddr:
+		if v == nil {
+			return nil
+		}
+		return v.String()
+	}
+	return value
+}
+
+// networkScanner scans an inet, cidr or macaddr column into a network address field
+type networkScanner struct {
+	dst reflect.Value
+}
+
+func (s networkScanner) Scan(src interface{}) error {
+	if src == nil {
+		s.dst.Set(reflect.Zero(s.dst.Type()))
+		return nil
+	}
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into %s", src, s.dst.Type())
+	}
+
+	dst := s.dst
+	if dst.Kind() == reflect.Ptr {
+		dst.Set(reflect.New(dst.Type().Elem()))
+		dst = dst.Elem()
+	}
+	switch dst.Type() {
+	case ipType:
+		// inet values with a netmask are written as address/bits
+		if slash := strings.IndexByte(text, '/'); slash >= 0 {
+			text = text[:slash]
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", text)
+		}
+		dst.Set(reflect.ValueOf(ip))
+	case ipNetType:
+		if !strings.Contains(text, "/") {
+			if strings.Contains(text, ":") {
+				text += "/128"
+			} else {
+				text += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(*network))
+	case hardwareAddrType:
+		addr, err := net.ParseMAC(text)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(addr))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrInvalidPageToken is returned by FindPage for tokens that were tampered with,
+// signed with another secret or issued for different filters
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// pageCursor is the signed content of a page token
+type pageCursor struct {
+	// Field is the ordering column and Value its value in the last row of the previous page
+	Field string      `json:"f"`
+	Value interface{} `json:"v"`
+	// Key is the primary key of the last row, ordering ties are broken by the primary key
+	Key interface{} `json:"k"`
+	// Filter is the hash of the query the token was issued for
+	Filter string `json:"h"`
+}
+
+// pageFilterHash fingerprints everything that decides which rows belong to the result
+func pageFilterHash(table string, queryProps *DatabaseQuery) string {
+	payload, err := json.Marshal([]interface{}{table, queryProps.Conditions, queryProps.OrderBy,
+		queryProps.Descending, queryProps.SearchFields, queryProps.SearchText})
+	if err != nil {
+		payload = []byte(fmt.Sprintf("%s|%+v", table, queryProps))
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s PostgreSQLConnector) signPageCursor(cursor pageCursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, s.PageTokenSecret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s PostgreSQLConnector) verifyPageToken(token string) (*pageCursor, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	mac := hmac.New(sha256.New, s.PageTokenSecret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	var cursor pageCursor
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// Keep large integers exact
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	return &cursor, nil
+}
+
+// FindPage loads the page following pageToken (empty for the first page) into
+// models using keyset pagination on OrderBy, with the primary key as tiebreaker
+// and as default ordering. It returns the signed token of the next page, which is
+// empty on the last page. A token is only accepted together with the same
+// conditions, ordering and search it was issued for. Requires PageTokenSecret.
+func (s PostgreSQLConnector) FindPage(models interface{}, queryProps *DatabaseQuery, pageToken string, opts ...Option) (string, error) {
+	if len(s.PageTokenSecret) == 0 {
+		return "", fmt.Errorf("PageTokenSecret is required for FindPage")
+	}
+	val := reflect.ValueOf(models)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("error handling %s: models must be a pointer to a slice", val.Type())
+	}
+	model := reflect.New(val.Elem().Type().Elem()).Interface()
+	table := queryProps.Table
+	if table == "" {
+		table = s.tableName(model)
+	}
+
+	pkField := getPrimaryKeyField(model, s.naming())
+	orderField := queryProps.OrderBy
+	if orderField == "" {
+		orderField = pkField
+	}
+	limit := queryProps.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	filter := pageFilterHash(table, queryProps)
+
+	page := *queryProps
+	page.Table = table
+	page.OrderBy = orderField
+	page.tieBreaker = pkField
+	page.Offset = 0
+	page.Limit = limit + 1
+	page.Conditions = append([]Condition{}, queryProps.Conditions...)
+
+	if pageToken != "" {
+		cursor, err := s.verifyPageToken(pageToken)
+		if err != nil {
+			return "", err
+		}
+		if cursor.Filter != filter || cursor.Field != orderField {
+			return "", fmt.Errorf("%w: the query changed since the token was issued", ErrInvalidPageToken)
+		}
+		operator := ">"
+		if page.Descending {
+			operator = "<"
+		}
+		if orderField == pkField {
+			page.Conditions = append(page.Conditions, Condition{Field: pkField, Operator: operator, Value: cursor.Key})
+		} else {
+			page.Conditions = append(page.Conditions, Or(
+				Condition{Field: orderField, Operator: operator, Value: cursor.Value},
+				And(
+					Condition{Field: orderField, Operator: "=", Value: cursor.Value},
+					Condition{Field: pkField, Operator: operator, Value: cursor.Key},
+				),
+			))
+		}
+	}
+
+	val.Elem().SetLen(0)
+	if err := s.FindAll(models, &page, opts...); err != nil {
+		return "", err
+	}
+	results := val.Elem()
+	if results.Len() <= limit {
+		return "", nil
+	}
+	results.Set(results.Slice(0, limit))
+
+	last := results.Index(limit - 1).Interface()
+	value, err := columnValue(last, s.naming(), orderField)
+	if err != nil {
+		return "", err
+	}
+	key, err := columnValue(last, s.naming(), pkField)
+	if err != nil {
+		return "", err
+	}
+	return s.signPageCursor(pageCursor{Field: orderField, Value: value, Key: key, Filter: filter})
+}
+
+// FindInBatches loads the records matching the query into models in batches of
+// batchSize ordered by primary key, e.g. for backfills and maintenance jobs, and
+// calls fn after loading each batch. Every batch is loaded and processed in its
+// own transaction, which is passed to fn, or all in the transaction of
+// WithTransaction. The iteration stops at the first error. OrderBy, Descending,
+// Limit and Offset of the query are ignored.
+func (s PostgreSQLConnector) FindInBatches(models interface{}, queryProps *DatabaseQuery, batchSize int, fn func(tx *sql.Tx) error, opts ...Option) error {
+	val := reflect.ValueOf(models)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("error handling %s: models must be a pointer to a slice", val.Type())
+	}
+	if batchSize <= 0 {
+		return fmt.Errorf("batchSize must be positive, got %d", batchSize)
+	}
+	pkField := getPrimaryKeyField(reflect.New(val.Elem().Type().Elem()).Interface(), s.naming())
+
+	var lastKey interface{}
+	for {
+		// Every batch starts from a fresh copy of the query
+		batch := *queryProps
+		batch.fields = nil
+		batch.OrderBy = pkField
+		batch.Descending = false
+		batch.Offset = 0
+		batch.Limit = batchSize
+		batch.Conditions = append([]Condition{}, queryProps.Conditions...)
+		if lastKey != nil {
+			batch.Conditions = append(batch.Conditions, Condition{Field: pkField, Operator: ">", Value: lastKey})
+		}
+		var loaded int
+		err := s.WithinTransaction(func(tx *sql.Tx) (err error) {
+			val.Elem().SetLen(0)
+			if err = s.FindAll(models, &batch, append(opts[:len(opts):len(opts)], WithTransaction(tx))...); err != nil {
+				return err
+			}
+			if loaded = val.Elem().Len(); loaded == 0 {
+				return nil
+			}
+			if lastKey, err = columnValue(val.Elem().Index(loaded-1).Interface(), s.naming(), pkField); err != nil {
+				return err
+			}
+			return fn(tx)
+		}, opts...)
+		if err != nil || loaded < batchSize {
+			return err
+		}
+	}
+}
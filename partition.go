@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Partitioning strategies
+const (
+	PartitionByRange = "RANGE"
+	PartitionByList  = "LIST"
+	PartitionByHash  = "HASH"
+)
+
+// Partitioning declares how a table is partitioned. Postgres requires primary
+// keys and unique constraints of partitioned tables to include the partition columns.
+type Partitioning struct {
+	Strategy string
+	Columns  []string
+}
+
+// PartitionedModel is implemented by models whose table is created partitioned
+type PartitionedModel interface {
+	Partitioning() Partitioning
+}
+
+func (p Partitioning) clause() (string, error) {
+	strategy := strings.ToUpper(p.Strategy)
+	switch strategy {
+	case PartitionByRange, PartitionByList, PartitionByHash:
+	default:
+		return "", fmt.Errorf("invalid partitioning strategy: %s", p.Strategy)
+	}
+	if len(p.Columns) == 0 {
+		return "", fmt.Errorf("partitioning requires at least one column")
+	}
+	return fmt.Sprintf(" PARTITION BY %s (%s)", strategy, strings.Join(p.Columns, ", ")), nil
+}
+
+// PartitionBound is the FOR VALUES clause of a partition
+type PartitionBound struct {
+	clause string
+	err    error
+}
+
+// RangeBound contains the values from (inclusive) to (exclusive)
+func RangeBound(from, to interface{}) PartitionBound {
+	fromLiteral, err := sqlLiteral(from)
+	if err != nil {
+		return PartitionBound{err: err}
+	}
+	toLiteral, err := sqlLiteral(to)
+	if err != nil {
+		return PartitionBound{err: err}
+	}
+	return PartitionBound{clause: fmt.Sprintf("FOR VALUES FROM (%s) TO (%s)", fromLiteral, toLiteral)}
+}
+
+// ListBound contains the listed values
+func ListBound(values ...interface{}) PartitionBound {
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literal, err := sqlLiteral(value)
+		if err != nil {
+			return PartitionBound{err: err}
+		}
+		literals[i] = literal
+	}
+	return PartitionBound{clause: fmt.Sprintf("FOR VALUES IN (%s)", strings.Join(literals, ", "))}
+}
+
+// HashBound contains the rows whose partition key hash has the given remainder
+func HashBound(modulus, remainder int) PartitionBound {
+	return PartitionBound{clause: fmt.Sprintf("FOR VALUES WITH (MODULUS %d, REMAINDER %d)", modulus, remainder)}
+}
+
+// DefaultBound contains the rows not matching any other partition
+func DefaultBound() PartitionBound {
+	return PartitionBound{clause: "DEFAULT"}
+}
+
+// sqlLiteral renders a partition bound value, bounds cannot be query parameters
+func sqlLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteLiteral(v), nil
+	case time.Time:
+		return quoteLiteral(v.Format(time.RFC3339Nano)), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case fmt.Stringer:
+		return quoteLiteral(v.String()), nil
+	}
+	return "", fmt.Errorf("unsupported partition bound value %v (%T)", value, value)
+}
+
+// CreatePartition creates the partition <table>_<suffix> of the model's partitioned table
+func (s *PostgreSQLConnector) CreatePartition(model interface{}, suffix string, bound PartitionBound, opts ...Option) error {
+	if bound.err != nil {
+		return bound.err
+	}
+	config := processOptions(opts)
+	defer config.release()
+	table := s.tableName(model)
+	_, err := s.execStatement(config.ctx, config.tx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_%s PARTITION OF %s %s",
+		table, suffix, table, bound.clause))
+	return err
+}
+
+// AttachPartition attaches an existing table as a partition of the model's table
+func (s *PostgreSQLConnector) AttachPartition(model interface{}, partitionTable string, bound PartitionBound, opts ...Option) error {
+	if bound.err != nil {
+		return bound.err
+	}
+	config := processOptions(opts)
+	defer config.release()
+	_, err := s.execStatement(config.ctx, config.tx, fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s %s",
+		s.tableName(model), partitionTable, bound.clause))
+	return err
+}
+
+// DetachPartition detaches a partition from the model's table, keeping it as a standalone table
+func (s *PostgreSQLConnector) DetachPartition(model interface{}, partitionTable string, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	_, err := s.execStatement(config.ctx, config.tx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s",
+		s.tableName(model), partitionTable))
+	return err
+}
+
+// CreateMonthlyPartitions creates range partitions <table>_yYYYYmMM for the given
+// number of months starting with the month of from, e.g. for event tables
+// partitioned by a timestamp column
+func (s *PostgreSQLConnector) CreateMonthlyPartitions(model interface{}, from time.Time, months int, opts ...Option) error {
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	for i := 0; i < months; i++ {
+		end := start.AddDate(0, 1, 0)
+		suffix := fmt.Sprintf("y%04dm%02d", start.Year(), int(start.Month()))
+		if err := s.CreatePartition(model, suffix, RangeBound(start, end), opts...); err != nil {
+			return fmt.Errorf("error creating partition %s: %v", suffix, err)
+		}
+		start = end
+	}
+	return nil
+}
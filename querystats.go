@@ -0,0 +1,85 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTrackedQueries bounds the statements remembered with TrackQueries
+const maxTrackedQueries = 10000
+
+// QueryStats is a statement of the pg_stat_statements extension, see TopQueries
+type QueryStats struct {
+	// Query is the normalized statement, constants are replaced by $n
+	Query     string
+	Calls     int64
+	TotalTime time.Duration
+	MeanTime  time.Duration
+	Rows      int64
+	// Tracked is set for statements executed by the connector, see TrackQueries
+	Tracked bool
+}
+
+// queryRegistry remembers the distinct statements executed by the connector,
+// shared by copies of the connector
+type queryRegistry struct {
+	mu      sync.Mutex
+	queries map[string]bool
+}
+
+// track remembers a statement until the registry is full
+func (r *queryRegistry) track(query string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queries == nil {
+		r.queries = make(map[string]bool)
+	}
+	if len(r.queries) < maxTrackedQueries {
+		r.queries[query] = true
+	}
+}
+
+// tracked reports whether the statement was executed by the connector
+func (r *queryRegistry) tracked(query string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queries[query]
+}
+
+// TopQueries returns the n statements of the current database with the highest
+// total execution time from pg_stat_statements, a lightweight overview of where
+// the database time goes. With TrackQueries the statements executed by the
+// connector are marked Tracked. Requires Postgres 13 and the pg_stat_statements
+// extension, its absence is reported as *ExtensionMissingError.
+func (s *PostgreSQLConnector) TopQueries(n int, opts ...Option) ([]QueryStats, error) {
+	config := processOptions(opts)
+	defer config.release()
+	rows, err := s.queryStatement(config.ctx, config.tx, `SELECT query, calls, total_exec_time, mean_exec_time, rows
+		FROM pg_stat_statements WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		ORDER BY total_exec_time DESC LIMIT $1`, n)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "42P01" { // undefined_table
+			return nil, &ExtensionMissingError{Extension: "pg_stat_statements", Feature: "TopQueries"}
+		}
+		return nil, fmt.Errorf("error reading pg_stat_statements: %v", err)
+	}
+	defer rows.Close()
+	var top []QueryStats
+	for rows.Next() {
+		var stats QueryStats
+		var totalMillis, meanMillis float64
+		if err := rows.Scan(&stats.Query, &stats.Calls, &totalMillis, &meanMillis, &stats.Rows); err != nil {
+			return nil, err
+		}
+		stats.TotalTime = time.Duration(totalMillis * float64(time.Millisecond))
+		stats.MeanTime = time.Duration(meanMillis * float64(time.Millisecond))
+		stats.Tracked = s.queries != nil && s.queries.tracked(stats.Query)
+		top = append(top, stats)
+	}
+	return top, rows.Err()
+}
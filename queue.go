@@ -0,0 +1,278 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobsTable is the managed table storing the jobs of all queues
+const JobsTable = "gpo_jobs"
+
+// Queue defaults, see the fields of Queue
+const (
+	DefaultVisibilityTimeout = 5 * time.Minute
+	DefaultMaxAttempts       = 5
+	DefaultRetryDelay        = 10 * time.Second
+)
+
+// Job states stored in the status column
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// ErrJobLost is returned by Complete and Fail when the job was delivered to
+// another worker after its visibility timeout expired
+var ErrJobLost = errors.New("job was delivered again after its visibility timeout")
+
+// jobsTables remembers which connection pools already have the managed table
+var jobsTables sync.Map
+
+// Job is a unit of work of a Queue
+type Job struct {
+	ID      int64
+	Queue   string
+	Payload json.RawMessage
+	// Attempts counts the deliveries, including the current one
+	Attempts    int
+	MaxAttempts int
+	CreatedAt   time.Time
+	// token identifies the delivery, Complete and Fail only apply to the latest one
+	token string
+}
+
+// Decode unmarshals the payload of the job
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Queue is a job queue stored in JobsTable. Workers dequeue jobs with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of them can poll concurrently
+// without delivering a job twice within its visibility timeout.
+type Queue struct {
+	Name string
+	// VisibilityTimeout is how long a dequeued job is hidden from other workers,
+	// a job neither completed nor failed in time is delivered again
+	VisibilityTimeout time.Duration
+	// MaxAttempts is how often a job is delivered before Fail marks it failed, a
+	// job whose last attempt timed out is marked failed by the next Dequeue
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry of a failed job, doubled with every attempt
+	RetryDelay time.Duration
+	connector  *PostgreSQLConnector
+}
+
+// NewQueue creates a queue with the given name and default settings
+func NewQueue(connector *PostgreSQLConnector, name string) *Queue {
+	return &Queue{
+		Name:              name,
+		VisibilityTimeout: DefaultVisibilityTimeout,
+		MaxAttempts:       DefaultMaxAttempts,
+		RetryDelay:        DefaultRetryDelay,
+		connector:         connector,
+	}
+}
+
+func (q *Queue) ensureTable(ctx context.Context) error {
+	db := q.connector.GetConnection()
+	if _, ok := jobsTables.Load(db); ok {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGSERIAL PRIMARY KEY,
+		queue TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		max_attempts INT NOT NULL,
+		run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		locked_until TIMESTAMPTZ,
+		locked_by TEXT,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());
+		CREATE INDEX IF NOT EXISTS %[1]s_queue_idx ON %[1]s (queue, status, run_at)`, JobsTable))
+	if err != nil {
+		return fmt.Errorf("error creating jobs table: %v", err)
+	}
+	jobsTables.Store(db, true)
+	return nil
+}
+
+// Enqueue adds a job with the JSON encoded payload. With WithTransaction the job
+// only becomes visible when the transaction commits.
+func (q *Queue) Enqueue(payload interface{}, opts ...Option) (*Job, error) {
+	return q.EnqueueAt(payload, time.Time{}, opts...)
+}
+
+// EnqueueAt adds a job which is not delivered before runAt, the zero time runs
+// it immediately. Due times are compared with the database clock.
+func (q *Queue) EnqueueAt(payload interface{}, runAt time.Time, opts ...Option) (*Job, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if err := q.ensureTable(config.ctx); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding job payload: %v", err)
+	}
+	job := &Job{Queue: q.Name, Payload: data, MaxAttempts: q.MaxAttempts}
+	rows, err := q.connector.queryStatement(config.ctx, config.tx, fmt.Sprintf(
+		"INSERT INTO %s (queue, payload, max_attempts, run_at) VALUES ($1, $2, $3, COALESCE($4::timestamptz, now())) RETURNING id, created_at",
+		JobsTable), q.Name, string(data), q.MaxAttempts, sql.NullTime{Time: runAt, Valid: !runAt.IsZero()})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueuing job: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, fmt.Errorf("error enqueuing job: %v", rows.Err())
+	}
+	if err := rows.Scan(&job.ID, &job.CreatedAt); err != nil {
+		return nil, fmt.Errorf("error enqueuing job: %v", err)
+	}
+	return job, nil
+}
+
+// Dequeue claims the next due job for the visibility timeout and returns it, or
+// nil when no job is due. The job must be passed to Complete or Fail. Jobs whose
+// last attempt timed out are marked failed first. Due times and visibility
+// timeouts are decided by the database clock.
+func (q *Queue) Dequeue(opts ...Option) (*Job, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if err := q.ensureTable(config.ctx); err != nil {
+		return nil, err
+	}
+	_, err := q.connector.execStatement(config.ctx, config.tx, fmt.Sprintf(`UPDATE %s
+		SET status = $1, locked_until = NULL, locked_by = NULL, last_error = $2
+		WHERE queue = $3 AND status = $4 AND locked_until <= now() AND attempts >= max_attempts`, JobsTable),
+		JobFailed, "visibility timeout expired", q.Name, JobRunning)
+	if err != nil {
+		return nil, fmt.Errorf("error failing timed out jobs: %v", err)
+	}
+	token := uuid.NewString()
+	rows, err := q.connector.queryStatement(config.ctx, config.tx, fmt.Sprintf(`UPDATE %[1]s
+		SET status = $1, attempts = attempts + 1, locked_until = now() + $2 * interval '1 microsecond', locked_by = $5
+		WHERE id = (
+			SELECT id FROM %[1]s
+			WHERE queue = $3 AND run_at <= now() AND attempts < max_attempts
+				AND (status = $4 OR (status = $1 AND locked_until <= now()))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, queue, payload, attempts, max_attempts, created_at`, JobsTable),
+		JobRunning, q.VisibilityTimeout.Microseconds(), q.Name, JobPending, token)
+	if err != nil {
+		return nil, fmt.Errorf("error dequeuing job: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	job := &Job{token: token}
+	var payload []byte
+	if err := rows.Scan(&job.ID, &job.Queue, &payload, &job.Attempts, &job.MaxAttempts, &job.CreatedAt); err != nil {
+		return nil, fmt.Errorf("error dequeuing job: %v", err)
+	}
+	job.Payload = payload
+	return job, nil
+}
+
+// Complete removes a finished job from the queue. It returns ErrJobLost when
+// the job was delivered again in the meantime, the job is then left to that delivery.
+func (q *Queue) Complete(job *Job, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	result, err := q.connector.execStatement(config.ctx, config.tx, fmt.Sprintf(
+		"DELETE FROM %s WHERE id = $1 AND locked_by = $2", JobsTable), job.ID, job.token)
+	if err != nil {
+		return fmt.Errorf("error completing job: %v", err)
+	}
+	return checkDelivery(config, result)
+}
+
+// Fail records the error of a job. The job is retried after the retry delay
+// unless it was delivered MaxAttempts times, then it is kept with status failed.
+// Like Complete it returns ErrJobLost when the job was delivered again.
+func (q *Queue) Fail(job *Job, cause error, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	message := ""
+	if cause != nil {
+		message = cause.Error()
+	}
+	status := JobPending
+	if job.Attempts >= job.MaxAttempts {
+		status = JobFailed
+	}
+	result, err := q.connector.execStatement(config.ctx, config.tx, fmt.Sprintf(
+		"UPDATE %s SET status = $1, run_at = now() + $2 * interval '1 microsecond', locked_until = NULL, locked_by = NULL, last_error = $3 WHERE id = $4 AND locked_by = $5",
+		JobsTable), status, q.retryDelay(job.Attempts).Microseconds(), message, job.ID, job.token)
+	if err != nil {
+		return fmt.Errorf("error failing job: %v", err)
+	}
+	return checkDelivery(config, result)
+}
+
+// checkDelivery returns ErrJobLost when the statement of Complete or Fail matched no job
+func checkDelivery(config *Config, result sql.Result) error {
+	if config.dryRun != nil {
+		return nil
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// retryDelay returns RetryDelay doubled for every attempt after the first
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	delay := q.RetryDelay
+	for i := 1; i < attempts && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// Work dequeues and runs jobs until the context of the options is cancelled,
+// polling every interval while the queue is empty. Jobs are completed when
+// handle returns nil and failed otherwise.
+func (q *Queue) Work(handle func(ctx context.Context, job *Job) error, interval time.Duration, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	ctx := config.ctx
+	for {
+		job, err := q.Dequeue(WithContext(ctx))
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			continue
+		}
+		if err := handle(ctx, job); err != nil {
+			err = q.Fail(job, err, WithContext(ctx))
+		} else {
+			err = q.Complete(job, WithContext(ctx))
+		}
+		if err != nil && !errors.Is(err, ErrJobLost) && ctx.Err() == nil {
+			return err
+		}
+	}
+}
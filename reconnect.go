@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// liveConnection holds the connection pool so Reconnect can replace it for all
+// copies of the connector
+type liveConnection struct {
+	mu sync.RWMutex
+	db *sql.DB
+}
+
+func (c *liveConnection) get() *sql.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.db
+}
+
+// swap replaces the pool and returns the previous one
+func (c *liveConnection) swap(db *sql.DB) *sql.DB {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.db
+	c.db = db
+	return old
+}
+
+// Reconnect opens a new connection pool and, once it answers a ping, replaces
+// the current pool with it and calls OnReconnect. The previous pool is closed.
+func (s *PostgreSQLConnector) Reconnect(ctx context.Context) error {
+	if s.live == nil {
+		return fmt.Errorf("not connected")
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return fmt.Errorf("error reconnecting: %v", err)
+	}
+	if old := s.live.swap(db); old != nil {
+		old.Close()
+	}
+	if s.OnReconnect != nil {
+		s.OnReconnect()
+	}
+	return nil
+}
+
+// MonitorConnection pings the database every interval and reconnects when the
+// ping fails, e.g. after a database restart. It blocks until ctx is done, so
+// run it in a goroutine.
+func (s *PostgreSQLConnector) MonitorConnection(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := s.GetConnection().PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		s.logger().Printf("gpo: database ping failed, reconnecting: %v", err)
+		if err := s.Reconnect(ctx); err != nil {
+			s.logger().Printf("gpo: %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SQLRecorder captures the statements executed through a connector, set as its
+// Recorder, e.g. to assert the generated SQL in golden tests. It records the
+// statements of the model methods, queries, table DDL and migrations in execution
+// order and is safe for concurrent use. The zero value is ready to use.
+type SQLRecorder struct {
+	mu         sync.Mutex
+	statements []PlannedStatement
+}
+
+// record appends a statement to the log
+func (r *SQLRecorder) record(query string, args []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, PlannedStatement{SQL: query, Args: append([]interface{}(nil), args...)})
+}
+
+// Statements returns the recorded statements in execution order
+func (r *SQLRecorder) Statements() []PlannedStatement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PlannedStatement(nil), r.statements...)
+}
+
+// Reset clears the recorded statements
+func (r *SQLRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = nil
+}
+
+// String returns the recorded statements one per line, followed by their
+// arguments, for comparison with a golden file
+func (r *SQLRecorder) String() string {
+	var b strings.Builder
+	for _, statement := range r.Statements() {
+		b.WriteString(statement.SQL)
+		if len(statement.Args) > 0 {
+			fmt.Fprintf(&b, " -- %v", statement.Args)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
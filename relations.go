@@ -0,0 +1,633 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type relationKind int
+
+const (
+	hasMany relationKind = iota
+	hasOne
+	belongsTo
+)
+
+// relation describes how a struct field of one model maps to another model
+// through a fk(...) declaration on either side
+type relation struct {
+	kind       relationKind
+	fieldIndex int
+	// target is the struct type of the related model
+	target reflect.Type
+	// localColumn is the column on the owning model used for matching
+	localColumn string
+	// foreignColumn is the column on the related model used for matching
+	foreignColumn string
+	// typeColumn is the type column of a polymorphic relation, on the model
+	// holding the id column, and typeValue the owner type it must match. The
+	// target of an interface typed polymorphic belongs-to is resolved per row.
+	typeColumn string
+	typeValue  string
+}
+
+// modelBaseName returns the unprefixed table name used in fk(...) declarations
+func modelBaseName(t reflect.Type, naming NamingStrategy) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		if name := metadataOf(t, nil).tableName; name != "" {
+			return name
+		}
+	}
+	if naming == nil {
+		naming = DefaultNaming
+	}
+	return naming.TableName(t.Name())
+}
+
+// columnFieldIndex returns the index of the struct field tagged with the given column name
+func columnFieldIndex(t reflect.Type, naming NamingStrategy, column string) (int, bool) {
+	if field, ok := metadataOf(t, naming).byColumn[column]; ok {
+		return field.index, true
+	}
+	return 0, false
+}
+
+// primaryKeyColumn returns the primary key column of t, the default id column without pk field
+func primaryKeyColumn(t reflect.Type, naming NamingStrategy) string {
+	if pk := metadataOf(t, naming).primaryKey; pk != nil {
+		return pk.tag.ColumnName
+	}
+	return DefaultIDField
+}
+
+// hasColumns reports whether t has fields for all of the columns
+func hasColumns(t reflect.Type, naming NamingStrategy, columns ...string) bool {
+	for _, column := range columns {
+		if _, ok := columnFieldIndex(t, naming, column); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// polymorphicTag returns the polymorphic(...) option of a relation field tagged
+// gpo:"-,polymorphic(type,id)", nil for other fields
+func polymorphicTag(field reflect.StructField) *PolymorphicInfo {
+	if tag := parseGPOTag(field); tag != nil && tag.ColumnName == "-" {
+		return tag.Polymorphic
+	}
+	return nil
+}
+
+// foreignKeyTo returns the gpo field of t that references the given table, if any
+func foreignKeyTo(t reflect.Type, naming NamingStrategy, table string) *GPOField {
+	for _, field := range metadataOf(t, naming).fields {
+		if field.tag.ForeignKey != nil && field.tag.ForeignKey.Table == table {
+			return field.tag
+		}
+	}
+	return nil
+}
+
+// resolveRelation infers the relation behind the named struct field of owner
+func resolveRelation(owner reflect.Type, naming NamingStrategy, name string) (*relation, error) {
+	field, ok := owner.FieldByName(name)
+	if !ok || len(field.Index) != 1 {
+		return nil, fmt.Errorf("%s has no relation field %s", owner.Name(), name)
+	}
+	if _, tagged := field.Tag.Lookup(GPOTag); tagged {
+		if polymorphic := polymorphicTag(field); polymorphic != nil {
+			return resolvePolymorphic(owner, naming, field, polymorphic)
+		}
+		return nil, fmt.Errorf("%s.%s is a column, not a relation", owner.Name(), name)
+	}
+
+	fieldType := field.Type
+	isSlice := fieldType.Kind() == reflect.Slice
+	if isSlice {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s.%s must be a struct, struct pointer or slice of structs", owner.Name(), name)
+	}
+
+	rel := &relation{fieldIndex: field.Index[0], target: fieldType}
+
+	// The owner holds the foreign key: belongs-to
+	if !isSlice {
+		if fk := foreignKeyTo(owner, naming, modelBaseName(fieldType, naming)); fk != nil {
+			rel.kind = belongsTo
+			rel.localColumn = fk.ColumnName
+			rel.foreignColumn = fk.ForeignKey.Column
+			return rel, nil
+		}
+	}
+
+	// The related model holds the foreign key: has-many or has-one
+	if fk := foreignKeyTo(fieldType, naming, modelBaseName(owner, naming)); fk != nil {
+		rel.kind = hasOne
+		if isSlice {
+			rel.kind = hasMany
+		}
+		rel.localColumn = fk.ForeignKey.Column
+		rel.foreignColumn = fk.ColumnName
+		return rel, nil
+	}
+
+	return nil, fmt.Errorf("no foreign key found between %s and %s for relation %s", owner.Name(), fieldType.Name(), name)
+}
+
+// resolvePolymorphic infers the relation behind a field tagged polymorphic(type,id).
+// When owner has both columns the field holds the owner of the row (belongs-to),
+// either a struct of one owner type or an interface resolved through
+// PolymorphicTypes. Otherwise the related model holds the columns (has-many or has-one).
+func resolvePolymorphic(owner reflect.Type, naming NamingStrategy, field reflect.StructField, polymorphic *PolymorphicInfo) (*relation, error) {
+	fieldType := field.Type
+	isSlice := fieldType.Kind() == reflect.Slice
+	if isSlice {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	rel := &relation{fieldIndex: field.Index[0], typeColumn: polymorphic.TypeColumn}
+
+	if !isSlice && hasColumns(owner, naming, polymorphic.TypeColumn, polymorphic.IDColumn) {
+		rel.kind = belongsTo
+		rel.localColumn = polymorphic.IDColumn
+		switch fieldType.Kind() {
+		case reflect.Interface:
+			return rel, nil
+		case reflect.Struct:
+			rel.target = fieldType
+			rel.foreignColumn = primaryKeyColumn(fieldType, naming)
+			rel.typeValue = modelBaseName(fieldType, naming)
+			return rel, nil
+		}
+		return nil, fmt.Errorf("%s.%s must be a struct, struct pointer or interface", owner.Name(), field.Name)
+	}
+
+	if fieldType.Kind() == reflect.Struct && hasColumns(fieldType, naming, polymorphic.TypeColumn, polymorphic.IDColumn) {
+		rel.kind = hasOne
+		if isSlice {
+			rel.kind = hasMany
+		}
+		rel.target = fieldType
+		rel.localColumn = primaryKeyColumn(owner, naming)
+		rel.foreignColumn = polymorphic.IDColumn
+		rel.typeValue = modelBaseName(owner, naming)
+		return rel, nil
+	}
+	return nil, fmt.Errorf("no columns %s and %s found for polymorphic relation %s.%s",
+		polymorphic.TypeColumn, polymorphic.IDColumn, owner.Name(), field.Name)
+}
+
+// groupPreloadPaths groups dotted preload paths by their first segment so each
+// level is loaded only once, e.g. ["A.B", "A.C"] becomes {"A": ["B", "C"]}
+func groupPreloadPaths(paths []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, path := range paths {
+		parts := strings.SplitN(strings.TrimSpace(path), ".", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if _, ok := groups[parts[0]]; !ok {
+			groups[parts[0]] = nil
+		}
+		if len(parts) == 2 && parts[1] != "" {
+			groups[parts[0]] = append(groups[parts[0]], parts[1])
+		}
+	}
+	return groups
+}
+
+// preloadModels loads the requested relations for a model pointer or a pointer to a slice of models
+func (s PostgreSQLConnector) preloadModels(config *Config, models interface{}, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	val := reflect.ValueOf(models)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("preload requires a pointer, got %s", val.Type())
+	}
+	val = val.Elem()
+
+	var owners []reflect.Value
+	if val.Kind() == reflect.Slice {
+		for i := 0; i < val.Len(); i++ {
+			owners = append(owners, reflect.Indirect(val.Index(i)))
+		}
+	} else {
+		owners = append(owners, val)
+	}
+	return s.preloadLevel(config, owners, paths)
+}
+
+// preloadLevel loads one level of relations for the given owners with a single
+// IN query per relation, then recurses into nested paths
+func (s PostgreSQLConnector) preloadLevel(config *Config, owners []reflect.Value, paths []string) error {
+	if len(owners) == 0 {
+		return nil
+	}
+	groups := groupPreloadPaths(paths)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ownerType := owners[0].Type()
+	for _, name := range names {
+		rel, err := resolveRelation(ownerType, s.naming(), name)
+		if err != nil {
+			return err
+		}
+		children, err := s.loadRelation(config, owners, rel)
+		if err != nil {
+			return fmt.Errorf("error preloading %s: %v", name, err)
+		}
+		// Children of interface typed polymorphic relations may differ in type
+		for _, group := range groupByType(children) {
+			if err := s.preloadLevel(config, group, groups[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groupByType groups values by their type, in order of first appearance
+func groupByType(values []reflect.Value) [][]reflect.Value {
+	var groups [][]reflect.Value
+	positions := make(map[reflect.Type]int)
+	for _, value := range values {
+		i, ok := positions[value.Type()]
+		if !ok {
+			i = len(groups)
+			positions[value.Type()] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], value)
+	}
+	return groups
+}
+
+// loadRelation fetches and assigns the related models, returning the assigned
+// values so nested relations can be loaded into them
+func (s PostgreSQLConnector) loadRelation(config *Config, owners []reflect.Value, rel *relation) ([]reflect.Value, error) {
+	if rel.target == nil {
+		return s.loadPolymorphicOwners(config, owners, rel)
+	}
+	if rel.kind == belongsTo && rel.typeColumn != "" {
+		var err error
+		if owners, err = ownersOfType(owners, s.naming(), rel); err != nil || len(owners) == 0 {
+			return nil, err
+		}
+	}
+	localIndex, ok := columnFieldIndex(owners[0].Type(), s.naming(), rel.localColumn)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field for column %s", owners[0].Type().Name(), rel.localColumn)
+	}
+	foreignIndex, ok := columnFieldIndex(rel.target, s.naming(), rel.foreignColumn)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field for column %s", rel.target.Name(), rel.foreignColumn)
+	}
+
+	// Collect distinct keys from owners
+	var keys []interface{}
+	seen := make(map[interface{}]bool)
+	for _, owner := range owners {
+		key := owner.Field(localIndex).Interface()
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	conditions := []Condition{{Field: rel.foreignColumn, Operator: "IN", Value: keys}}
+	if rel.typeColumn != "" && rel.kind != belongsTo {
+		conditions = append(conditions, Condition{Field: rel.typeColumn, Operator: "=", Value: rel.typeValue})
+	}
+	related := reflect.New(reflect.SliceOf(rel.target))
+	err := s.all(config, related.Interface(), &DatabaseQuery{Conditions: conditions})
+	if err != nil {
+		return nil, err
+	}
+
+	// Group related models by their matching column
+	byKey := make(map[interface{}][]reflect.Value)
+	for i := 0; i < related.Elem().Len(); i++ {
+		item := related.Elem().Index(i)
+		key := item.Field(foreignIndex).Interface()
+		byKey[key] = append(byKey[key], item)
+	}
+
+	var children []reflect.Value
+	for _, owner := range owners {
+		matches := byKey[owner.Field(localIndex).Interface()]
+		field := owner.Field(rel.fieldIndex)
+
+		if rel.kind == hasMany {
+			slice := reflect.MakeSlice(field.Type(), len(matches), len(matches))
+			for i, match := range matches {
+				setRelationValue(slice.Index(i), match)
+				children = append(children, reflect.Indirect(slice.Index(i)))
+			}
+			field.Set(slice)
+			continue
+		}
+
+		if len(matches) > 0 {
+			setRelationValue(field, matches[0])
+			if field.Kind() == reflect.Interface {
+				field = field.Elem()
+			}
+			children = append(children, reflect.Indirect(field))
+		}
+	}
+	return children, nil
+}
+
+// typeValueOf returns the value of a polymorphic type column as string
+func typeValueOf(value reflect.Value) string {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// ownersOfType returns the owners whose polymorphic type column matches the relation
+func ownersOfType(owners []reflect.Value, naming NamingStrategy, rel *relation) ([]reflect.Value, error) {
+	typeIndex, ok := columnFieldIndex(owners[0].Type(), naming, rel.typeColumn)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field for column %s", owners[0].Type().Name(), rel.typeColumn)
+	}
+	var matching []reflect.Value
+	for _, owner := range owners {
+		if typeValueOf(owner.Field(typeIndex)) == rel.typeValue {
+			matching = append(matching, owner)
+		}
+	}
+	return matching, nil
+}
+
+// loadPolymorphicOwners loads an interface typed polymorphic relation: for every
+// owner type found in the type column, the PolymorphicTypes model with that
+// table name is loaded as belongs-to relation of the owners of that type
+func (s PostgreSQLConnector) loadPolymorphicOwners(config *Config, owners []reflect.Value, rel *relation) ([]reflect.Value, error) {
+	typeIndex, ok := columnFieldIndex(owners[0].Type(), s.naming(), rel.typeColumn)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field for column %s", owners[0].Type().Name(), rel.typeColumn)
+	}
+	var typeValues []string
+	seen := make(map[string]bool)
+	for _, owner := range owners {
+		value := typeValueOf(owner.Field(typeIndex))
+		if value != "" && !seen[value] {
+			seen[value] = true
+			typeValues = append(typeValues, value)
+		}
+	}
+
+	var children []reflect.Value
+	for _, typeValue := range typeValues {
+		target := s.polymorphicType(typeValue)
+		if target == nil {
+			return nil, fmt.Errorf("no model in PolymorphicTypes for %s %q", rel.typeColumn, typeValue)
+		}
+		typed := *rel
+		typed.target = target
+		typed.foreignColumn = primaryKeyColumn(target, s.naming())
+		typed.typeValue = typeValue
+		loaded, err := s.loadRelation(config, owners, &typed)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, loaded...)
+	}
+	return children, nil
+}
+
+// polymorphicType returns the struct type of the PolymorphicTypes model with the given table name
+func (s *PostgreSQLConnector) polymorphicType(typeValue string) reflect.Type {
+	for _, model := range s.PolymorphicTypes {
+		t := indirectType(model)
+		if modelBaseName(t, s.naming()) == typeValue {
+			return t
+		}
+	}
+	return nil
+}
+
+// setRelationValue assigns a related struct to a struct, struct pointer or
+// interface destination, interfaces receive a pointer
+func setRelationValue(dst reflect.Value, src reflect.Value) {
+	if dst.Kind() == reflect.Ptr || dst.Kind() == reflect.Interface {
+		ptr := reflect.New(src.Type())
+		ptr.Elem().Set(src)
+		dst.Set(ptr)
+		return
+	}
+	dst.Set(src)
+}
+
+// childRelations returns the has-many and has-one relations declared on t
+func childRelations(t reflect.Type, naming NamingStrategy) []*relation {
+	var relations []*relation
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, tagged := field.Tag.Lookup(GPOTag); (tagged && polymorphicTag(field) == nil) || !field.IsExported() {
+			continue
+		}
+		rel, err := resolveRelation(t, naming, field.Name)
+		if err != nil || rel.kind == belongsTo {
+			continue
+		}
+		relations = append(relations, rel)
+	}
+	return relations
+}
+
+// insertWithAssociations inserts the model and its populated child relations,
+// starting a transaction when the caller did not provide one and it is no dry run
+func (s PostgreSQLConnector) insertWithAssociations(ctx context.Context, tx *sql.Tx, model interface{}) (err error) {
+	if tx == nil && dryRunOf(ctx) == nil {
+		tx, err = s.beginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				s.RollbackTx(tx)
+				return
+			}
+			err = s.CommitTx(tx)
+		}()
+	}
+	return s.insertTree(ctx, tx, reflect.ValueOf(model))
+}
+
+// insertTree inserts a model followed by its children, recursively
+func (s PostgreSQLConnector) insertTree(ctx context.Context, tx *sql.Tx, model reflect.Value) error {
+	if model.Kind() != reflect.Ptr {
+		return fmt.Errorf("model must be a pointer, got %s", model.Type())
+	}
+	if err := s.insertWithTx(ctx, tx, model.Interface()); err != nil {
+		return err
+	}
+
+	parent := model.Elem()
+	for _, rel := range childRelations(parent.Type(), s.naming()) {
+		localIndex, ok := columnFieldIndex(parent.Type(), s.naming(), rel.localColumn)
+		if !ok {
+			return fmt.Errorf("%s has no field for column %s", parent.Type().Name(), rel.localColumn)
+		}
+		foreignIndex, ok := columnFieldIndex(rel.target, s.naming(), rel.foreignColumn)
+		if !ok {
+			return fmt.Errorf("%s has no field for column %s", rel.target.Name(), rel.foreignColumn)
+		}
+		parentKey := parent.Field(localIndex)
+		if !parentKey.Type().AssignableTo(rel.target.Field(foreignIndex).Type) {
+			return fmt.Errorf("cannot assign %s.%s to %s.%s: type mismatch", parent.Type().Name(), rel.localColumn, rel.target.Name(), rel.foreignColumn)
+		}
+		// Polymorphic children also get the owner type
+		typeIndex := -1
+		if rel.typeColumn != "" {
+			typeIndex, _ = columnFieldIndex(rel.target, s.naming(), rel.typeColumn)
+			if rel.target.Field(typeIndex).Type.Kind() != reflect.String {
+				return fmt.Errorf("%s.%s must be a string", rel.target.Name(), rel.typeColumn)
+			}
+		}
+
+		var children []reflect.Value
+		field := parent.Field(rel.fieldIndex)
+		switch {
+		case field.Kind() == reflect.Slice:
+			for i := 0; i < field.Len(); i++ {
+				children = append(children, field.Index(i))
+			}
+		case field.Kind() == reflect.Ptr && !field.IsNil():
+			children = append(children, field)
+		case field.Kind() == reflect.Struct && !field.IsZero():
+			children = append(children, field)
+		}
+
+		for _, child := range children {
+			if child.Kind() == reflect.Ptr {
+				if child.IsNil() {
+					continue
+				}
+			} else {
+				child = child.Addr()
+			}
+			child.Elem().Field(foreignIndex).Set(parentKey)
+			if typeIndex >= 0 {
+				child.Elem().Field(typeIndex).SetString(rel.typeValue)
+			}
+			if err := s.insertTree(ctx, tx, child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AutoJoin derives a join condition such as "orm_user.id = orm_post.author_id"
+// from the fk(...) tags between two models, applying the connector TablePrefix
+func (s *PostgreSQLConnector) AutoJoin(mainModel, joinModel interface{}) (string, error) {
+	mainType := reflect.TypeOf(mainModel)
+	joinType := reflect.TypeOf(joinModel)
+	if mainType.Kind() == reflect.Ptr {
+		mainType = mainType.Elem()
+	}
+	if joinType.Kind() == reflect.Ptr {
+		joinType = joinType.Elem()
+	}
+	mainTable := s.tableName(mainModel)
+	joinTable := s.tableName(joinModel)
+
+	// The joined model references the main model
+	if fk := foreignKeyTo(joinType, s.naming(), modelBaseName(mainType, s.naming())); fk != nil {
+		return fmt.Sprintf("%s.%s = %s.%s", mainTable, fk.ForeignKey.Column, joinTable, fk.ColumnName), nil
+	}
+	// The main model references the joined model
+	if fk := foreignKeyTo(mainType, s.naming(), modelBaseName(joinType, s.naming())); fk != nil {
+		return fmt.Sprintf("%s.%s = %s.%s", mainTable, fk.ColumnName, joinTable, fk.ForeignKey.Column), nil
+	}
+	return "", fmt.Errorf("no foreign key found between %s and %s", mainType.Name(), joinType.Name())
+}
+
+// sortModelsByDependencies orders models so that every model comes after the
+// models its fk(...) tags reference. Input order is kept where possible,
+// references to models outside the list and self references are ignored.
+func sortModelsByDependencies(models []interface{}, naming NamingStrategy) ([]interface{}, error) {
+	names := make([]string, len(models))
+	positions := make(map[string]int)
+	for i, model := range models {
+		names[i] = modelBaseName(reflect.TypeOf(model), naming)
+		positions[names[i]] = i
+	}
+
+	// dependencies[i] holds the positions of the models that model i references
+	dependencies := make([]map[int]bool, len(models))
+	for i, model := range models {
+		dependencies[i] = make(map[int]bool)
+		t := indirectType(model)
+		for j := 0; j < t.NumField(); j++ {
+			gpoField := parseGPOTag(t.Field(j))
+			if gpoField == nil || gpoField.ForeignKey == nil {
+				continue
+			}
+			if dep, ok := positions[gpoField.ForeignKey.Table]; ok && dep != i {
+				dependencies[i][dep] = true
+			}
+		}
+	}
+
+	sorted := make([]interface{}, 0, len(models))
+	done := make([]bool, len(models))
+	for len(sorted) < len(models) {
+		progressed := false
+		for i := range models {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for dep := range dependencies[i] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[i] = true
+				sorted = append(sorted, models[i])
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			var cycle []string
+			for i := range models {
+				if !done[i] {
+					cycle = append(cycle, names[i])
+				}
+			}
+			return nil, fmt.Errorf("circular foreign key dependency between tables: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// RepositoryHooks are called around the operations of a Repository, an error
+// returned by a hook aborts the operation
+type RepositoryHooks[T any] struct {
+	BeforeInsert func(ctx context.Context, model *T) error
+	AfterInsert  func(ctx context.Context, model *T) error
+	BeforeUpdate func(ctx context.Context, model *T) error
+	AfterUpdate  func(ctx context.Context, model *T) error
+	AfterFind    func(ctx context.Context, model *T) error
+}
+
+// ErrNoConditions is returned by Repository.Delete without conditions, use DeleteAll to delete every row
+var ErrNoConditions = errors.New("delete without conditions, use DeleteAll to delete every row")
+
+// Repository supplies CRUD, transactions, hooks and scopes for one model type.
+// Embed it into application repositories so they only contain domain-specific queries:
+//
+//	type UserRepository struct {
+//		*db.Repository[User]
+//	}
+type Repository[T any] struct {
+	Connector *PostgreSQLConnector
+	// Scopes are conditions added to every find, update and delete, e.g. a tenant filter
+	Scopes []Condition
+	Hooks  RepositoryHooks[T]
+}
+
+// NewRepository creates a repository for the model type T
+func NewRepository[T any](connector *PostgreSQLConnector) *Repository[T] {
+	return &Repository[T]{Connector: connector}
+}
+
+// scoped returns the conditions with the repository scopes appended
+func (r *Repository[T]) scoped(conditions []Condition) []Condition {
+	return append(append([]Condition{}, conditions...), r.Scopes...)
+}
+
+// runHook calls the hook, if any, with the context of the operation
+func runHook[T any](hook func(ctx context.Context, model *T) error, ctx context.Context, model *T) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(ctx, model)
+}
+
+// Find returns the model with the given primary key value within the scopes,
+// or sql.ErrNoRows when there is none
+func (r *Repository[T]) Find(id interface{}, opts ...Option) (*T, error) {
+	return r.FindFirst(createPrimaryKeyCondition(new(T), r.Connector.naming(), id), opts...)
+}
+
+// FindFirst returns the first model matching the conditions within the scopes,
+// or sql.ErrNoRows when there is none
+func (r *Repository[T]) FindFirst(conditions []Condition, opts ...Option) (*T, error) {
+	config := processOptions(opts)
+	defer config.release()
+	model := new(T)
+	if err := r.Connector.FindFirst(model, r.scoped(conditions), append(opts[:len(opts):len(opts)], withRequiredRow())...); err != nil {
+		return nil, err
+	}
+	return model, runHook(r.Hooks.AfterFind, config.ctx, model)
+}
+
+// FindAll returns the models matching the query within the scopes
+func (r *Repository[T]) FindAll(queryProps *DatabaseQuery, opts ...Option) ([]T, error) {
+	if queryProps == nil {
+		queryProps = &DatabaseQuery{}
+	}
+	config := processOptions(opts)
+	defer config.release()
+	query := *queryProps
+	query.Conditions = r.scoped(queryProps.Conditions)
+	var models []T
+	if err := r.Connector.FindAll(&models, &query, opts...); err != nil {
+		return nil, err
+	}
+	for i := range models {
+		if err := runHook(r.Hooks.AfterFind, config.ctx, &models[i]); err != nil {
+			return nil, err
+		}
+	}
+	return models, nil
+}
+
+// Insert inserts the model
+func (r *Repository[T]) Insert(model *T, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	if err := runHook(r.Hooks.BeforeInsert, config.ctx, model); err != nil {
+		return err
+	}
+	if err := r.Connector.InsertModel(model, opts...); err != nil {
+		return err
+	}
+	return runHook(r.Hooks.AfterInsert, config.ctx, model)
+}
+
+// Update updates the row of the model, matched by primary key within the scopes
+func (r *Repository[T]) Update(model *T, opts ...Option) (int64, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if err := runHook(r.Hooks.BeforeUpdate, config.ctx, model); err != nil {
+		return 0, err
+	}
+	id, err := columnValue(model, r.Connector.naming(), getPrimaryKeyField(model, r.Connector.naming()))
+	if err != nil {
+		return 0, err
+	}
+	affected, err := r.Connector.UpdateModel(model, r.scoped(createPrimaryKeyCondition(model, r.Connector.naming(), id)), opts...)
+	if err != nil {
+		return 0, err
+	}
+	return affected, runHook(r.Hooks.AfterUpdate, config.ctx, model)
+}
+
+// Delete deletes the rows matching the conditions within the scopes. It returns
+// ErrNoConditions without conditions, so a missing filter cannot empty the table.
+func (r *Repository[T]) Delete(conditions []Condition, opts ...Option) (int64, error) {
+	if len(conditions) == 0 {
+		return 0, ErrNoConditions
+	}
+	return r.Connector.DeleteModel(new(T), r.scoped(conditions), opts...)
+}
+
+// DeleteAll deletes every row within the scopes
+func (r *Repository[T]) DeleteAll(opts ...Option) (int64, error) {
+	return r.Connector.DeleteModel(new(T), r.scoped(nil), opts...)
+}
+
+// DeleteByID deletes the row with the given primary key value within the scopes
+func (r *Repository[T]) DeleteByID(id interface{}, opts ...Option) (int64, error) {
+	return r.Delete(createPrimaryKeyCondition(new(T), r.Connector.naming(), id), opts...)
+}
+
+// Transaction runs fn in a transaction, see PostgreSQLConnector.WithinTransaction.
+// Pass WithTransaction(tx) to the repository methods called in fn.
+func (r *Repository[T]) Transaction(fn func(tx *sql.Tx) error, opts ...Option) error {
+	return r.Connector.WithinTransaction(fn, opts...)
+}
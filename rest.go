@@ -0,0 +1,371 @@
+package db
+
+import (
+	"database/sql"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// DefaultRESTMaxLimit caps the limit query parameter of REST list requests
+const DefaultRESTMaxLimit = 100
+
+// DefaultRESTMaxBodyBytes caps the size of REST request bodies
+const DefaultRESTMaxBodyBytes = 1 << 20
+
+// RESTOptions configure a handler created with NewRESTHandler. Fields are column names.
+type RESTOptions struct {
+	// ReadFields are returned in responses and usable for filtering and ordering, all but writeonly columns when empty
+	ReadFields []string
+	// WriteFields are accepted in create and update bodies, all but readonly columns when empty
+	WriteFields []string
+	// SearchFields are searched with the search query parameter
+	SearchFields []string
+	// MaxLimit caps the limit query parameter, DefaultRESTMaxLimit when zero
+	MaxLimit int
+	// MaxBodyBytes caps the size of request bodies, DefaultRESTMaxBodyBytes when zero
+	MaxBodyBytes int64
+}
+
+// restHandler serves CRUD endpoints for one model type
+type restHandler struct {
+	connector *PostgreSQLConnector
+	modelType reflect.Type
+	meta      *modelMetadata
+	options   RESTOptions
+	readable  map[string]bool
+	writable  map[string]bool
+	// columnsByKey maps JSON keys of the model to columns
+	columnsByKey map[string]string
+	// hiddenKeys are the JSON keys of columns which are not readable
+	hiddenKeys []string
+}
+
+// restError is an error with the HTTP status it is reported with
+type restError struct {
+	status int
+	err    error
+}
+
+func (e *restError) Error() string {
+	return e.err.Error()
+}
+
+// NewRESTHandler returns an http.Handler serving JSON CRUD endpoints for the model:
+//
+//	GET    /      list, with the limit, offset, order_by, order, search, q and filter query parameters
+//	POST   /      create
+//	GET    /{id}  get by primary key
+//	PUT    /{id}  update the fields in the body, PATCH is accepted too
+//	DELETE /{id}  delete by primary key
+//
+// Paths are relative to the handler, mount it with http.StripPrefix.
+func NewRESTHandler(connector *PostgreSQLConnector, model interface{}, options RESTOptions) http.Handler {
+	modelType := indirectType(model)
+	meta := metadataOf(modelType, connector.naming())
+	h := &restHandler{
+		connector:    connector,
+		modelType:    modelType,
+		meta:         meta,
+		options:      options,
+		readable:     columnSet(meta.selectable, options.ReadFields),
+		writable:     columnSet(meta.writable, options.WriteFields),
+		columnsByKey: make(map[string]string),
+	}
+	if h.options.MaxLimit <= 0 {
+		h.options.MaxLimit = DefaultRESTMaxLimit
+	}
+	if h.options.MaxBodyBytes <= 0 {
+		h.options.MaxBodyBytes = DefaultRESTMaxBodyBytes
+	}
+	for _, field := range meta.fields {
+		key := jsonFieldName(modelType.Field(field.index))
+		h.columnsByKey[key] = field.tag.ColumnName
+		if !h.readable[field.tag.ColumnName] {
+			h.hiddenKeys = append(h.hiddenKeys, key)
+		}
+	}
+	return h
+}
+
+// columnSet returns the allowed columns, all columns when allowed is empty
+func columnSet(columns Fields, allowed []string) map[string]bool {
+	if len(allowed) == 0 {
+		allowed = columns
+	}
+	set := make(map[string]bool, len(allowed))
+	for _, column := range allowed {
+		set[column] = true
+	}
+	return set
+}
+
+// jsonFieldName returns the key encoding/json uses for a struct field
+func jsonFieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(r.URL.Path, "/")
+	r.Body = http.MaxBytesReader(w, r.Body, h.options.MaxBodyBytes)
+	var body json.RawMessage
+	var err error
+	status := http.StatusOK
+	switch {
+	case strings.Contains(id, "/"):
+		err = &restError{http.StatusNotFound, errors.New("not found")}
+	case id == "" && r.Method == http.MethodGet:
+		body, err = h.list(r)
+	case id == "" && r.Method == http.MethodPost:
+		status = http.StatusCreated
+		body, err = h.create(r)
+	case id != "" && r.Method == http.MethodGet:
+		body, err = h.get(r, id)
+	case id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		body, err = h.update(r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		body, err = h.delete(r, id)
+	default:
+		err = &restError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status, response := h.errorResponse(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// errorResponse returns the status and body reporting an error. Validation
+// failures and invalid values are client errors; other errors are logged and
+// answered with a generic message, so database details are not exposed.
+func (h *restHandler) errorResponse(err error) (int, interface{}) {
+	var restErr *restError
+	var failures ValidationErrors
+	var failure *ValidationError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &restErr):
+		return restErr.status, map[string]string{"error": restErr.Error()}
+	case errors.As(err, &failures):
+		return http.StatusBadRequest, map[string]interface{}{"error": "validation failed", "failures": failures}
+	case errors.As(err, &failure):
+		return http.StatusBadRequest, map[string]interface{}{"error": "validation failed", "failures": ValidationErrors{failure}}
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, map[string]string{"error": "not found"}
+	case errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation":
+		return http.StatusConflict, map[string]string{"error": "conflict"}
+	case errors.As(err, &pqErr) && (pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23"):
+		// Data exceptions and other integrity violations are caused by the request
+		return http.StatusBadRequest, map[string]string{"error": "invalid request"}
+	}
+	h.connector.logger().Printf("REST handler for %s: %v", h.modelType.Name(), err)
+	return http.StatusInternalServerError, map[string]string{"error": "internal server error"}
+}
+
+func (h *restHandler) list(r *http.Request) (json.RawMessage, error) {
+	var query DatabaseQuery
+	ParseQueryParamsFromRequest(r, &query)
+	query.AllowPagination = true
+	if query.Limit <= 0 || query.Limit > h.options.MaxLimit {
+		query.Limit = h.options.MaxLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	if len(h.options.SearchFields) > 0 {
+		query.AllowSearch = true
+		query.SearchFields = h.options.SearchFields
+	} else {
+		query.SearchText = ""
+	}
+	conditions, err := ParseFilterFromRequest(r, h.allowedColumns())
+	if err != nil {
+		return nil, &restError{http.StatusBadRequest, err}
+	}
+	query.Conditions = conditions
+	if err := applyFilterParams(r, h.allowedColumns(), &query); err != nil {
+		return nil, &restError{http.StatusBadRequest, err}
+	}
+
+	models := reflect.New(reflect.SliceOf(h.modelType))
+	if err := h.connector.FindAll(models.Interface(), &query, WithContext(r.Context())); err != nil {
+		return nil, err
+	}
+	items := make([]json.RawMessage, models.Elem().Len())
+	for i := range items {
+		if items[i], err = h.encode(models.Elem().Index(i).Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(items)
+}
+
+// allowedColumns returns the readable columns in model order
+func (h *restHandler) allowedColumns() []string {
+	var columns []string
+	for _, column := range h.meta.columns {
+		if h.readable[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// parseID converts the id of a path to the type of the primary key, or returns a 400 error
+func (h *restHandler) parseID(id string) (interface{}, error) {
+	if h.meta.primaryKey == nil {
+		return nil, fmt.Errorf("%s has no primary key", h.modelType.Name())
+	}
+	value := reflect.New(h.modelType.Field(h.meta.primaryKey.index).Type)
+	var err error
+	if unmarshaler, ok := value.Interface().(encoding.TextUnmarshaler); ok {
+		err = unmarshaler.UnmarshalText([]byte(id))
+	} else {
+		switch value.Elem().Kind() {
+		case reflect.String:
+			value.Elem().SetString(id)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			if n, err = strconv.ParseInt(id, 10, value.Elem().Type().Bits()); err == nil {
+				value.Elem().SetInt(n)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n uint64
+			if n, err = strconv.ParseUint(id, 10, value.Elem().Type().Bits()); err == nil {
+				value.Elem().SetUint(n)
+			}
+		default:
+			return id, nil
+		}
+	}
+	if err != nil {
+		return nil, &restError{http.StatusBadRequest, errors.New("invalid id")}
+	}
+	return value.Elem().Interface(), nil
+}
+
+// find loads the model with the primary key value id, or returns a 404 error
+func (h *restHandler) find(r *http.Request, id string) (interface{}, error) {
+	key, err := h.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	model := reflect.New(h.modelType)
+	if err := h.connector.FindFirst(model.Interface(), key, WithContext(r.Context())); err != nil {
+		return nil, err
+	}
+	if model.Elem().Field(h.meta.primaryKey.index).IsZero() {
+		return nil, &restError{http.StatusNotFound, errors.New("not found")}
+	}
+	return model.Interface(), nil
+}
+
+func (h *restHandler) get(r *http.Request, id string) (json.RawMessage, error) {
+	model, err := h.find(r, id)
+	if err != nil {
+		return nil, err
+	}
+	return h.encode(model)
+}
+
+func (h *restHandler) create(r *http.Request) (json.RawMessage, error) {
+	model := reflect.New(h.modelType).Interface()
+	if err := h.decode(r, model); err != nil {
+		return nil, err
+	}
+	if err := h.connector.InsertModel(model, WithContext(r.Context())); err != nil {
+		return nil, err
+	}
+	return h.encode(model)
+}
+
+func (h *restHandler) update(r *http.Request, id string) (json.RawMessage, error) {
+	model, err := h.find(r, id)
+	if err != nil {
+		return nil, err
+	}
+	// The primary key is taken from the path, not from the body
+	pk := reflect.ValueOf(model).Elem().Field(h.meta.primaryKey.index)
+	pkValue := reflect.ValueOf(pk.Interface())
+	if err := h.decode(r, model); err != nil {
+		return nil, err
+	}
+	pk.Set(pkValue)
+	if _, err := h.connector.UpdateModel(model, nil, WithContext(r.Context())); err != nil {
+		return nil, err
+	}
+	return h.encode(model)
+}
+
+func (h *restHandler) delete(r *http.Request, id string) (json.RawMessage, error) {
+	key, err := h.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	model := reflect.New(h.modelType).Interface()
+	affected, err := h.connector.DeleteModel(model, createPrimaryKeyCondition(model, h.connector.naming(), key), WithContext(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, &restError{http.StatusNotFound, errors.New("not found")}
+	}
+	return json.Marshal(map[string]int64{"deleted": affected})
+}
+
+// decode decodes a JSON body into the model, rejecting keys of columns which are not writable
+func (h *restHandler) decode(r *http.Request, model interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &restError{http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit)}
+		}
+		return &restError{http.StatusBadRequest, err}
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return &restError{http.StatusBadRequest, fmt.Errorf("invalid JSON body: %v", err)}
+	}
+	for key := range keys {
+		if column, ok := h.columnsByKey[key]; !ok || !h.writable[column] {
+			return &restError{http.StatusBadRequest, fmt.Errorf("field %s is not writable", key)}
+		}
+	}
+	if err := json.Unmarshal(body, model); err != nil {
+		return &restError{http.StatusBadRequest, fmt.Errorf("invalid JSON body: %v", err)}
+	}
+	return nil
+}
+
+// encode encodes a masked copy of the model as JSON without the columns which are not readable
+func (h *restHandler) encode(model interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(Masked(model))
+	if err != nil || len(h.hiddenKeys) == 0 {
+		return data, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	for _, key := range h.hiddenKeys {
+		delete(object, key)
+	}
+	return json.Marshal(object)
+}
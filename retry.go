@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RetryPolicy configures retries of transient failures for reads outside of
+// transactions and for WithinTransaction
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled for every further retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// RetryableCodes are the SQLSTATE codes to retry, defaults to DefaultRetryableCodes
+	RetryableCodes []string
+}
+
+// DefaultRetryableCodes are serialization failures, deadlocks, connection
+// exceptions and server shutdowns
+var DefaultRetryableCodes = []string{
+	"40001", // serialization_failure
+	"40P01", // deadlock_detected
+	"08000", // connection_exception
+	"08001", // sqlclient_unable_to_establish_sqlconnection
+	"08003", // connection_does_not_exist
+	"08004", // sqlserver_rejected_establishment_of_sqlconnection
+	"08006", // connection_failure
+	"57P01", // admin_shutdown
+}
+
+// DefaultRetryPolicy retries up to three times with 50ms initial backoff
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// SerializationRetryPolicy retries serialization failures of WithinTransaction
+// called WithIsolation(sql.LevelSerializable) when the connector has no RetryPolicy
+var SerializationRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     500 * time.Millisecond,
+	RetryableCodes: []string{"40001"},
+}
+
+// IsRetryable reports whether err is a transient failure according to the policy
+func (p RetryPolicy) IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	codes := p.RetryableCodes
+	if codes == nil {
+		codes = DefaultRetryableCodes
+	}
+	for _, code := range codes {
+		if string(pqErr.Code) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the given retry, starting at 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return wait
+}
+
+// withRetry runs fn until it succeeds, fails permanently or the connector
+// RetryPolicy is exhausted. Without a policy fn runs once.
+func (s *PostgreSQLConnector) withRetry(ctx context.Context, fn func() error) error {
+	return retry(ctx, s.RetryPolicy, fn)
+}
+
+// retry runs fn until it succeeds, fails permanently or the policy is exhausted
+func retry(ctx context.Context, retryPolicy *RetryPolicy, fn func() error) error {
+	if retryPolicy == nil {
+		return fn()
+	}
+	policy := *retryPolicy
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if attempt >= policy.MaxAttempts || !policy.IsRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// WithinTransaction runs fn in a new transaction, committing when it returns nil
+// and rolling back otherwise. Transient failures restart the whole transaction
+// according to the connector RetryPolicy, so fn must be safe to run again.
+// With WithTransaction fn runs once in the given transaction. WithIsolation and
+// WithReadOnly set the isolation level and access mode of the transaction.
+func (s *PostgreSQLConnector) WithinTransaction(fn func(tx *sql.Tx) error, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	if config.tx != nil {
+		return fn(config.tx)
+	}
+	policy := s.RetryPolicy
+	if policy == nil && config.txOptions != nil && config.txOptions.Isolation == sql.LevelSerializable {
+		policy = &SerializationRetryPolicy
+	}
+	return retry(config.ctx, policy, func() error {
+		tx, err := s.beginTx(config.ctx)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			s.RollbackTx(tx)
+			return err
+		}
+		return s.CommitTx(tx)
+	})
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// scaffoldModel describes a model for the scaffold templates
+type scaffoldModel struct {
+	Type  string
+	Route string
+	// PrimaryKey is the field tagged pk, models without it get no PUT endpoint
+	PrimaryKey string
+}
+
+var scaffoldTemplates = map[string]*template.Template{
+	"main.go": template.Must(template.New("main.go").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+// Command {{ .Name }} is an example service serving CRUD endpoints for the models.
+// It is configured with the standard PG* environment variables.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	db "{{ .ImportPath }}"
+)
+
+func main() {
+	connector, err := db.NewConnectorFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := connector.Connect(); err != nil {
+		log.Fatal(err)
+	}
+	defer connector.Close()
+	if err := migrate(connector); err != nil {
+		log.Fatal(err)
+	}
+
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	log.Printf("listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, newMux(connector)))
+}
+`)),
+	"migrations.go": template.Must(template.New("migrations.go").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+package main
+
+import (
+	db "{{ .ImportPath }}"
+	models "{{ .ModelPath }}"
+)
+
+// migrate creates the tables of the models
+func migrate(connector *db.PostgreSQLConnector) error {
+	return connector.CreateTables({{ range .Models }}
+		&models.{{ .Type }}{},{{ end }}
+	)
+}
+`)),
+	"repositories.go": template.Must(template.New("repositories.go").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+package main
+
+import (
+	db "{{ .ImportPath }}"
+	models "{{ .ModelPath }}"
+)
+{{ range .Models }}
+// {{ .Type }}Repository provides CRUD for {{ .Type }}, add domain-specific queries as methods
+type {{ .Type }}Repository struct {
+	*db.Repository[models.{{ .Type }}]
+}
+{{ end }}`)),
+	"handlers.go": template.Must(template.New("handlers.go").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	db "{{ .ImportPath }}"
+	models "{{ .ModelPath }}"
+)
+
+// respond writes the value as JSON, or the error: 404 for missing rows, 400 with
+// the failures for validation errors and 500 without details otherwise
+func respond(w http.ResponseWriter, status int, value interface{}, err error) {
+	var failures db.ValidationErrors
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		status, value = http.StatusNotFound, map[string]string{"error": "not found"}
+	case errors.As(err, &failures):
+		status, value = http.StatusBadRequest, map[string]interface{}{"error": "validation failed", "failures": failures}
+	case err != nil:
+		log.Print(err)
+		status, value = http.StatusInternalServerError, map[string]string{"error": "internal server error"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// newMux registers the CRUD endpoints of all models
+func newMux(connector *db.PostgreSQLConnector) *http.ServeMux {
+	mux := http.NewServeMux()
+{{- range .Models }}
+	register{{ .Type }}Routes(mux, &{{ .Type }}Repository{db.NewRepository[models.{{ .Type }}](connector)})
+{{- end }}
+	return mux
+}
+{{ range .Models }}
+func register{{ .Type }}Routes(mux *http.ServeMux, repo *{{ .Type }}Repository) {
+	mux.HandleFunc("GET /{{ .Route }}", func(w http.ResponseWriter, r *http.Request) {
+		var query db.DatabaseQuery
+		db.ParseQueryParamsFromRequest(r, &query)
+		query.AllowPagination = true
+		items, err := repo.FindAll(&query, db.WithContext(r.Context()))
+		respond(w, http.StatusOK, items, err)
+	})
+	mux.HandleFunc("GET /{{ .Route }}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		item, err := repo.Find(r.PathValue("id"), db.WithContext(r.Context()))
+		respond(w, http.StatusOK, item, err)
+	})
+	mux.HandleFunc("POST /{{ .Route }}", func(w http.ResponseWriter, r *http.Request) {
+		var item models.{{ .Type }}
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respond(w, http.StatusCreated, item, repo.Insert(&item, db.WithContext(r.Context())))
+	})
+{{- if .PrimaryKey }}
+	mux.HandleFunc("PUT /{{ .Route }}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		existing, err := repo.Find(r.PathValue("id"), db.WithContext(r.Context()))
+		if err != nil {
+			respond(w, http.StatusOK, nil, err)
+			return
+		}
+		item := *existing
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The primary key is taken from the path, not from the body
+		item.{{ .PrimaryKey }} = existing.{{ .PrimaryKey }}
+		_, err = repo.Update(&item, db.WithContext(r.Context()))
+		respond(w, http.StatusOK, item, err)
+	})
+{{- end }}
+	mux.HandleFunc("DELETE /{{ .Route }}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		affected, err := repo.DeleteByID(r.PathValue("id"), db.WithContext(r.Context()))
+		if err == nil && affected == 0 {
+			err = sql.ErrNoRows
+		}
+		respond(w, http.StatusOK, map[string]int64{"deleted": affected}, err)
+	})
+}
+{{ end }}`)),
+	"main_test.go": template.Must(template.New("main_test.go").Parse(`// Code generated by go-postgresql-orm. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+{{- if .RoundTrips }}
+	"fmt"
+{{- end }}
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	db "{{ .ImportPath }}"
+{{- if .RoundTrips }}
+	models "{{ .ModelPath }}"
+{{- end }}
+)
+
+// TestEndpoints runs against the database configured with the PG* environment
+// variables. It lists every model and creates, reads, updates and deletes the
+// zero value of the models with a primary key.
+func TestEndpoints(t *testing.T) {
+	if os.Getenv("PGHOST") == "" {
+		t.Skip("PGHOST is not set")
+	}
+	connector, err := db.NewConnectorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer connector.Close()
+	if err := migrate(connector); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newMux(connector))
+	defer server.Close()
+	for _, route := range []string{ {{- range .Models }}"/{{ .Route }}", {{ end -}} } {
+		if status := call(t, http.MethodGet, server.URL+route, nil, nil); status != http.StatusOK {
+			t.Errorf("GET %s: expected 200, got %d", route, status)
+		}
+	}
+{{- range .Models }}{{ if .PrimaryKey }}
+
+	t.Run("{{ .Type }}", func(t *testing.T) {
+		var created models.{{ .Type }}
+		switch status := call(t, http.MethodPost, server.URL+"/{{ .Route }}", models.{{ .Type }}{}, &created); status {
+		case http.StatusCreated:
+		case http.StatusBadRequest:
+			t.Skip("the zero value of {{ .Type }} does not pass its validations")
+		default:
+			t.Fatalf("POST /{{ .Route }}: expected 201, got %d", status)
+		}
+		item := fmt.Sprintf("%s/{{ .Route }}/%v", server.URL, created.{{ .PrimaryKey }})
+		for _, step := range []struct {
+			method string
+			body   interface{}
+			want   int
+		}{
+			{http.MethodGet, nil, http.StatusOK},
+			{http.MethodPut, created, http.StatusOK},
+			{http.MethodDelete, nil, http.StatusOK},
+			{http.MethodGet, nil, http.StatusNotFound},
+		} {
+			if status := call(t, step.method, item, step.body, nil); status != step.want {
+				t.Errorf("%s %s: expected %d, got %d", step.method, item, step.want, status)
+			}
+		}
+	})
+{{- end }}{{ end }}
+}
+
+// call sends the body as JSON, decodes a successful response into result if
+// given and returns the status code
+func call(t *testing.T, method, url string, body, result interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if result != nil && resp.StatusCode < http.StatusMultipleChoices {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+`)),
+}
+
+// GenerateScaffold returns the files of a runnable example service for the
+// models, keyed by file name: main.go, migrations.go (CreateTables),
+// repositories.go, handlers.go (CRUD endpoints under /<model>s, PUT updates the
+// row of the path id and needs a field tagged pk, missing rows are 404 and
+// validation errors 400) and main_test.go (integration test against the PG*
+// database, with a create, read, update and delete round trip per model). The
+// models must be declared in one importable package.
+func GenerateScaffold(name string, models ...interface{}) (map[string][]byte, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("at least one model is required")
+	}
+	data := struct {
+		Name       string
+		ImportPath string
+		ModelPath  string
+		Models     []scaffoldModel
+		// RoundTrips is set when a model has a primary key, main_test.go then
+		// creates, reads, updates and deletes it
+		RoundTrips bool
+	}{Name: name, ImportPath: ImportPath}
+
+	for _, model := range models {
+		t := indirectType(model)
+		if t.Kind() != reflect.Struct || t.Name() == "" {
+			return nil, fmt.Errorf("cannot scaffold %s: not a named struct", t)
+		}
+		if data.ModelPath != "" && t.PkgPath() != data.ModelPath {
+			return nil, fmt.Errorf("models must be declared in one package, got %s and %s", data.ModelPath, t.PkgPath())
+		}
+		if t.PkgPath() == "main" {
+			return nil, fmt.Errorf("cannot scaffold %s: models must be declared in an importable package", t.Name())
+		}
+		data.ModelPath = t.PkgPath()
+		scaffolded := scaffoldModel{Type: t.Name(), Route: strings.ToLower(t.Name()) + "s"}
+		if pk := metadataOf(t, nil).primaryKey; pk != nil {
+			scaffolded.PrimaryKey = pk.name
+			data.RoundTrips = true
+		}
+		data.Models = append(data.Models, scaffolded)
+	}
+
+	files := make(map[string][]byte)
+	for fileName, tmpl := range scaffoldTemplates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("error generating %s: %v", fileName, err)
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("error formatting %s: %v", fileName, err)
+		}
+		files[fileName] = src
+	}
+	return files, nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import "reflect"
+
+// Scope adjusts the queries of a model, e.g. adding a tenant_id or deleted_at
+// condition, see AddScope
+type Scope func(query *DatabaseQuery)
+
+// AddScope adds a default scope to every FindFirst, FindAll, UpdateModel and
+// DeleteModel of the model, or of all models when model is nil. UpdateModel and
+// DeleteModel append the conditions of the scope, see scopedConditions. Unscoped
+// skips the scopes of an operation. Add scopes while setting up the connector, before it runs
+// statements.
+func (s *PostgreSQLConnector) AddScope(model interface{}, scope Scope) {
+	if s.scopes == nil {
+		s.scopes = make(map[reflect.Type][]Scope)
+	}
+	var t reflect.Type
+	if model != nil {
+		t = indirectType(model)
+	}
+	s.scopes[t] = append(s.scopes[t], scope)
+}
+
+// Unscoped skips the default scopes added with AddScope
+func Unscoped() Option {
+	return func(c *Config) { c.unscoped = true }
+}
+
+// scopesOf returns the global scopes followed by those of the model type
+func (s *PostgreSQLConnector) scopesOf(config *Config, t reflect.Type) []Scope {
+	if config.unscoped || len(s.scopes) == 0 {
+		return nil
+	}
+	return append(append([]Scope{}, s.scopes[nil]...), s.scopes[t]...)
+}
+
+// scopedQuery returns a copy of the query with the scopes of the model type
+// applied, or the query itself without scopes
+func (s *PostgreSQLConnector) scopedQuery(config *Config, t reflect.Type, query *DatabaseQuery) *DatabaseQuery {
+	scopes := s.scopesOf(config, t)
+	if len(scopes) == 0 {
+		return query
+	}
+	scoped := *query
+	scoped.Conditions = append([]Condition{}, query.Conditions...)
+	for _, scope := range scopes {
+		scope(&scoped)
+	}
+	return &scoped
+}
+
+// scopedConditions returns the conditions of an update or delete of the model
+// with the conditions of its scopes appended. Without conditions an update
+// matches the row of the primary key of the model, like updateWithTx, while a
+// delete matches all rows of the scopes, like a delete without scopes matches
+// all rows.
+func (s *PostgreSQLConnector) scopedConditions(config *Config, model interface{}, conditions []Condition, update bool) []Condition {
+	scopes := s.scopesOf(config, indirectType(model))
+	if len(scopes) == 0 {
+		return conditions
+	}
+	if len(conditions) == 0 && update {
+		conditions = createPrimaryKeyCondition(model, s.naming(), primaryKeyValue(model))
+	}
+	query := DatabaseQuery{Table: s.tableName(model), Conditions: append([]Condition{}, conditions...)}
+	for _, scope := range scopes {
+		scope(&query)
+	}
+	return query.Conditions
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits of the EXPLAIN statements of ExplainSlowQueries, see observeQuery
+const (
+	maxConcurrentExplains = 4
+	explainTimeout        = 5 * time.Second
+)
+
+// ErrExplainSkipped is the ExplainError of slow queries reported while too many
+// other slow queries were being explained
+var ErrExplainSkipped = errors.New("too many slow queries are being explained")
+
+// explainSlots bounds the EXPLAIN statements running in the background
+var explainSlots = make(chan struct{}, maxConcurrentExplains)
+
+// SlowQuery describes a statement that ran longer than SlowQueryThreshold
+type SlowQuery struct {
+	SQL      string
+	Args     []interface{}
+	Duration time.Duration
+	// Plan is the EXPLAIN output of the statement when ExplainSlowQueries is set
+	Plan *QueryPlan
+	// ExplainError is the reason Plan is missing when ExplainSlowQueries is set
+	ExplainError error
+}
+
+// explainable reports whether EXPLAIN supports the statement
+func explainable(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH":
+		return true
+	}
+	return false
+}
+
+// observeQuery records the statement with the Recorder and TrackQueries and
+// reports it to OnSlowQuery when it took longer than SlowQueryThreshold. Call it
+// deferred with the start time of the statement.
+func (s *PostgreSQLConnector) observeQuery(start time.Time, query string, args []interface{}) {
+	if s.Recorder != nil {
+		s.Recorder.record(query, args)
+	}
+	if s.TrackQueries && s.queries != nil {
+		s.queries.track(query)
+	}
+	if s.OnSlowQuery == nil || s.SlowQueryThreshold <= 0 {
+		return
+	}
+	duration := s.now().Sub(start)
+	if duration < s.SlowQueryThreshold {
+		return
+	}
+	slow := SlowQuery{SQL: query, Args: args, Duration: duration}
+	if !s.ExplainSlowQueries || !explainable(query) {
+		s.OnSlowQuery(slow)
+		return
+	}
+	select {
+	case explainSlots <- struct{}{}:
+	default:
+		slow.ExplainError = ErrExplainSkipped
+		s.OnSlowQuery(slow)
+		return
+	}
+	// Explain on another connection in the background, the caller may still hold
+	// the rows of the statement
+	go func() {
+		defer func() { <-explainSlots }()
+		slow.Plan, slow.ExplainError = s.explainSlowQuery(query, args)
+		s.OnSlowQuery(slow)
+	}()
+}
+
+// explainSlowQuery explains a statement on the primary within explainTimeout.
+// The EXPLAIN bypasses middleware, retries and observation, so it is neither
+// recorded nor reported as slow query itself.
+func (s *PostgreSQLConnector) explainSlowQuery(query string, args []interface{}) (*QueryPlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	rows, err := s.GetConnection().QueryContext(ctx, explainPrefix(false)+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error explaining query: %v", err)
+	}
+	return scanQueryPlan(rows)
+}
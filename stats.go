@@ -0,0 +1,278 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger is the logging interface used for advisory messages, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// VacuumThresholds configures when VacuumAdvisory reports that a table needs maintenance
+type VacuumThresholds struct {
+	// DeadTupleRatio is the dead/(live+dead) ratio above which a warning is emitted
+	DeadTupleRatio float64
+	// MinDeadTuples avoids warnings for small tables with a high ratio but few dead rows
+	MinDeadTuples int64
+}
+
+// DefaultVacuumThresholds mirror the autovacuum defaults (scale factor 0.2)
+var DefaultVacuumThresholds = VacuumThresholds{
+	DeadTupleRatio: 0.2,
+	MinDeadTuples:  1000,
+}
+
+// DeadTupleStats holds dead tuple and bloat information for a single table
+type DeadTupleStats struct {
+	Table          string
+	LiveTuples     int64
+	DeadTuples     int64
+	DeadTupleRatio float64
+	// TableBytes is the on-disk size of the table heap
+	TableBytes int64
+	// EstimatedBloatBytes is TableBytes scaled by the dead tuple ratio
+	EstimatedBloatBytes int64
+	LastVacuum          *time.Time
+	LastAutovacuum      *time.Time
+	// NeedsVacuum is set when the connector thresholds are exceeded
+	NeedsVacuum bool
+}
+
+func (s *PostgreSQLConnector) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return log.Default()
+}
+
+func (s *PostgreSQLConnector) vacuumThresholds() VacuumThresholds {
+	if s.VacuumThresholds != nil {
+		return *s.VacuumThresholds
+	}
+	return DefaultVacuumThresholds
+}
+
+// tableNameFromModelOrName resolves a model or a plain table name to a table name
+func (s *PostgreSQLConnector) tableNameFromModelOrName(modelOrTableName interface{}) string {
+	if name, ok := modelOrTableName.(string); ok {
+		return name
+	}
+	return s.tableName(modelOrTableName)
+}
+
+// DeadTupleStats returns dead tuple ratio and bloat estimate for a model's table
+func (s *PostgreSQLConnector) DeadTupleStats(modelOrTableName interface{}, opts ...Option) (*DeadTupleStats, error) {
+	config := processOptions(opts)
+	defer config.release()
+	stats := &DeadTupleStats{Table: s.tableNameFromModelOrName(modelOrTableName)}
+
+	var lastVacuum, lastAutovacuum sql.NullTime
+	err := s.GetConnection().QueryRowContext(config.ctx,
+		`SELECT n_live_tup, n_dead_tup, pg_relation_size(relid), last_vacuum, last_autovacuum
+		FROM pg_stat_user_tables WHERE relname = $1`, stats.Table).
+		Scan(&stats.LiveTuples, &stats.DeadTuples, &stats.TableBytes, &lastVacuum, &lastAutovacuum)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statistics for %s: %v", stats.Table, err)
+	}
+	if lastVacuum.Valid {
+		stats.LastVacuum = &lastVacuum.Time
+	}
+	if lastAutovacuum.Valid {
+		stats.LastAutovacuum = &lastAutovacuum.Time
+	}
+
+	s.assessDeadTuples(stats)
+	return stats, nil
+}
+
+// assessDeadTuples derives the dead tuple ratio, bloat estimate and vacuum
+// advice from the tuple counts and the table size
+func (s *PostgreSQLConnector) assessDeadTuples(stats *DeadTupleStats) {
+	if total := stats.LiveTuples + stats.DeadTuples; total > 0 {
+		stats.DeadTupleRatio = float64(stats.DeadTuples) / float64(total)
+	}
+	stats.EstimatedBloatBytes = int64(float64(stats.TableBytes) * stats.DeadTupleRatio)
+
+	thresholds := s.vacuumThresholds()
+	stats.NeedsVacuum = stats.DeadTuples >= thresholds.MinDeadTuples && stats.DeadTupleRatio >= thresholds.DeadTupleRatio
+}
+
+// VacuumAdvisory checks the given models' tables and logs a warning for every
+// table exceeding the vacuum thresholds. The statistics of all tables are returned.
+func (s *PostgreSQLConnector) VacuumAdvisory(modelsOrTableNames ...interface{}) ([]DeadTupleStats, error) {
+	var results []DeadTupleStats
+	for _, modelOrTableName := range modelsOrTableNames {
+		stats, err := s.DeadTupleStats(modelOrTableName)
+		if err != nil {
+			return results, err
+		}
+		if stats.NeedsVacuum {
+			s.logger().Printf("gpo: table %s has %d dead tuples (%.0f%%, ~%d bytes of bloat), consider VACUUM",
+				stats.Table, stats.DeadTuples, stats.DeadTupleRatio*100, stats.EstimatedBloatBytes)
+		}
+		results = append(results, *stats)
+	}
+	return results, nil
+}
+
+// TableStats holds sizes and statistics of a table for admin dashboards
+type TableStats struct {
+	DeadTupleStats
+	// EstimatedRows is the row estimate of the planner, see EstimateCount
+	EstimatedRows int64
+	// TotalBytes is the on-disk size of the table including indexes and TOAST
+	TotalBytes      int64
+	IndexBytes      int64
+	ToastBytes      int64
+	LastAnalyze     *time.Time
+	LastAutoanalyze *time.Time
+}
+
+// TableStats returns the row estimate, sizes, dead tuple and bloat estimates and
+// the last vacuum and analyze times of the table of a model or table name
+func (s *PostgreSQLConnector) TableStats(modelOrTableName interface{}, opts ...Option) (*TableStats, error) {
+	config := processOptions(opts)
+	defer config.release()
+	stats := &TableStats{DeadTupleStats: DeadTupleStats{Table: s.tableNameFromModelOrName(modelOrTableName)}}
+	rows, err := s.queryStatement(config.ctx, config.tx, `SELECT c.reltuples::bigint, pg_total_relation_size(c.oid),
+		pg_relation_size(c.oid), pg_indexes_size(c.oid), COALESCE(pg_total_relation_size(NULLIF(c.reltoastrelid, 0)), 0),
+		COALESCE(s.n_live_tup, 0), COALESCE(s.n_dead_tup, 0), s.last_vacuum, s.last_autovacuum, s.last_analyze, s.last_autoanalyze
+		FROM pg_class c LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid WHERE c.oid = $1::regclass`, stats.Table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statistics for %s: %v", stats.Table, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error reading statistics for %s: %v", stats.Table, err)
+		}
+		return nil, fmt.Errorf("error reading statistics for %s: table not found", stats.Table)
+	}
+	var times [4]sql.NullTime
+	if err := rows.Scan(&stats.EstimatedRows, &stats.TotalBytes, &stats.TableBytes, &stats.IndexBytes, &stats.ToastBytes,
+		&stats.LiveTuples, &stats.DeadTuples, &times[0], &times[1], &times[2], &times[3]); err != nil {
+		return nil, fmt.Errorf("error reading statistics for %s: %v", stats.Table, err)
+	}
+	for i, target := range []**time.Time{&stats.LastVacuum, &stats.LastAutovacuum, &stats.LastAnalyze, &stats.LastAutoanalyze} {
+		if times[i].Valid {
+			*target = &times[i].Time
+		}
+	}
+	if stats.EstimatedRows < 0 {
+		// Never analyzed
+		stats.EstimatedRows = stats.LiveTuples
+	}
+	s.assessDeadTuples(&stats.DeadTupleStats)
+	return stats, nil
+}
+
+// estimateCountQuery scales the rows per page of the last ANALYZE to the current
+// number of pages like the planner does. It is NULL for tables never analyzed.
+const estimateCountQuery = `SELECT (CASE WHEN c.reltuples < 0 THEN NULL
+	WHEN c.relpages = 0 THEN 0
+	ELSE c.reltuples / c.relpages * (pg_relation_size(c.oid) / current_setting('block_size')::int) END)::bigint
+	FROM pg_class c WHERE c.oid = $1::regclass`
+
+// EstimateCount returns the approximate number of rows of the table of a model or
+// table name from the planner statistics, fast also for huge tables where
+// COUNT(*) scans the whole table. WithAnalyze runs ANALYZE first to refresh the
+// statistics. Tables that were never analyzed are counted exactly.
+func (s *PostgreSQLConnector) EstimateCount(modelOrTableName interface{}, opts ...Option) (int64, error) {
+	config := processOptions(opts)
+	defer config.release()
+	table := s.tableNameFromModelOrName(modelOrTableName)
+	if !validIdentifier(table) {
+		return 0, fmt.Errorf("invalid table name %q", table)
+	}
+	if config.analyze {
+		if _, err := s.execStatement(config.ctx, config.tx, "ANALYZE "+table); err != nil {
+			return 0, fmt.Errorf("error analyzing %s: %v", table, err)
+		}
+	}
+	estimate, err := s.countRows(config, estimateCountQuery, table)
+	if err != nil {
+		return 0, fmt.Errorf("error estimating the rows of %s: %v", table, err)
+	}
+	if estimate.Valid {
+		return estimate.Int64, nil
+	}
+	count, err := s.countRows(config, "SELECT COUNT(*) FROM "+table)
+	if err != nil {
+		return 0, fmt.Errorf("error counting the rows of %s: %v", table, err)
+	}
+	return count.Int64, nil
+}
+
+// countRows runs a query returning a single count
+func (s *PostgreSQLConnector) countRows(config *Config, query string, args ...interface{}) (count sql.NullInt64, err error) {
+	rows, err := s.queryStatement(config.ctx, config.tx, query, args...)
+	if err != nil {
+		return count, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&count)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return count, err
+}
+
+// Analyze refreshes the planner statistics of the table of a model or table name
+func (s *PostgreSQLConnector) Analyze(modelOrTableName interface{}, opts ...Option) error {
+	return s.maintain("ANALYZE %s", modelOrTableName, true, opts)
+}
+
+// Vacuum reclaims the space of dead tuples of the table of a model or table name
+// and refreshes its statistics. VACUUM FULL rewrites the table to return the space
+// to the operating system, holding an exclusive lock meanwhile. VACUUM cannot
+// run in a transaction.
+func (s *PostgreSQLConnector) Vacuum(modelOrTableName interface{}, full bool, opts ...Option) error {
+	if full {
+		return s.maintain("VACUUM (FULL, ANALYZE) %s", modelOrTableName, false, opts)
+	}
+	return s.maintain("VACUUM (ANALYZE) %s", modelOrTableName, false, opts)
+}
+
+// Reindex rebuilds the indexes of the table of a model or table name.
+// Concurrently avoids locking out writes but takes longer, it needs Postgres 12
+// and cannot run in a transaction.
+func (s *PostgreSQLConnector) Reindex(modelOrTableName interface{}, concurrently bool, opts ...Option) error {
+	if concurrently {
+		return s.maintain("REINDEX TABLE CONCURRENTLY %s", modelOrTableName, false, opts)
+	}
+	return s.maintain("REINDEX TABLE %s", modelOrTableName, true, opts)
+}
+
+// maintain runs a maintenance statement format with the table name, unquoted
+// like in CREATE TABLE. The statement runs unprepared, in the transaction of
+// WithTransaction only when transactional, as VACUUM and concurrent REINDEX
+// cannot run in transactions.
+func (s *PostgreSQLConnector) maintain(format string, modelOrTableName interface{}, transactional bool, opts []Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	table := s.tableNameFromModelOrName(modelOrTableName)
+	if !validIdentifier(table) {
+		return fmt.Errorf("invalid identifier %q", table)
+	}
+	stmt := fmt.Sprintf(format, table)
+	var exec execer = s.observed(s.GetConnection())
+	if dry := dryRunOf(config.ctx); dry != nil {
+		exec = dry
+	} else if config.tx != nil {
+		if !transactional {
+			return fmt.Errorf("error maintaining %s: %s cannot run in a transaction", table, strings.Fields(stmt)[0])
+		}
+		exec = s.observed(config.tx)
+	}
+	if _, err := exec.ExecContext(config.ctx, stmt); err != nil {
+		return fmt.Errorf("error maintaining %s: %v", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TransferFormat is the file format of ExportTable and ImportTable
+type TransferFormat string
+
+const (
+	// FormatCSV is CSV with a header row naming the columns, NULL is written as \N
+	// to tell it from empty strings
+	FormatCSV TransferFormat = "csv"
+	// FormatCopyText is the text format of COPY without header, in model column order
+	FormatCopyText TransferFormat = "text"
+)
+
+// copyTextEscaper escapes values of the COPY text format
+var copyTextEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyTextUnescaper reverses copyTextEscaper
+var copyTextUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+// ExportTable writes all rows of the model's table to w, ordered by primary key.
+// Values are written in their Postgres text representation, so an export can be
+// read back with ImportTable or COPY.
+func (s *PostgreSQLConnector) ExportTable(model interface{}, w io.Writer, format TransferFormat, opts ...Option) (err error) {
+	config := processOptions(opts)
+	defer config.release()
+	if format != FormatCSV && format != FormatCopyText {
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+	columns := modelMetadataOf(model, s.naming()).columns
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = column + "::text"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(selects, ", "),
+		s.tableName(model), getPrimaryKeyField(model, s.naming()))
+	rows, err := s.readRows(config, query)
+	if err != nil {
+		return fmt.Errorf("error exporting table: %v", err)
+	}
+	defer rows.Close()
+
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	var writeRow func() error
+	if format == FormatCSV {
+		writer := csv.NewWriter(w)
+		defer func() {
+			if writer.Flush(); err == nil && writer.Error() != nil {
+				err = fmt.Errorf("error exporting table: %v", writer.Error())
+			}
+		}()
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+		record := make([]string, len(columns))
+		writeRow = func() error {
+			for i, value := range values {
+				record[i] = value.String
+				if !value.Valid {
+					record[i] = `\N`
+				}
+			}
+			return writer.Write(record)
+		}
+	} else {
+		buffered := bufio.NewWriter(w)
+		defer func() {
+			if flushErr := buffered.Flush(); err == nil && flushErr != nil {
+				err = fmt.Errorf("error exporting table: %v", flushErr)
+			}
+		}()
+		writeRow = func() error {
+			for i, value := range values {
+				if i > 0 {
+					buffered.WriteByte('\t')
+				}
+				if !value.Valid {
+					buffered.WriteString(`\N`)
+				} else {
+					copyTextEscaper.WriteString(buffered, value.String)
+				}
+			}
+			return buffered.WriteByte('\n')
+		}
+	}
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("error exporting table: %v", err)
+		}
+		if err := writeRow(); err != nil {
+			return fmt.Errorf("error exporting table: %v", err)
+		}
+	}
+	return rows.Err()
+}
+
+// readTransferRows reads the columns and rows of an import, nil values are NULL
+func readTransferRows(r io.Reader, format TransferFormat, modelColumns Fields) ([]string, [][]interface{}, error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil, fmt.Errorf("missing CSV header")
+		}
+		rows := make([][]interface{}, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make([]interface{}, len(record))
+			for i, value := range record {
+				// encoding/csv does not tell quoted from unquoted fields, NULL is \N
+				if value != `\N` {
+					row[i] = value
+				}
+			}
+			rows = append(rows, row)
+		}
+		return records[0], rows, nil
+	case FormatCopyText:
+		var rows [][]interface{}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == `\.` {
+				break
+			}
+			fields := strings.Split(line, "\t")
+			if len(fields) != len(modelColumns) {
+				return nil, nil, fmt.Errorf("expected %d columns, got %d", len(modelColumns), len(fields))
+			}
+			row := make([]interface{}, len(fields))
+			for i, field := range fields {
+				if field != `\N` {
+					row[i] = copyTextUnescaper.Replace(field)
+				}
+			}
+			rows = append(rows, row)
+		}
+		return modelColumns, rows, scanner.Err()
+	}
+	return nil, nil, fmt.Errorf("unsupported import format: %s", format)
+}
+
+// ImportTable inserts the rows read from r into the model's table with COPY, in
+// one transaction. CSV files name the columns in their header row, which must
+// be columns of the model; COPY text files contain all model columns in order.
+func (s *PostgreSQLConnector) ImportTable(model interface{}, r io.Reader, format TransferFormat, opts ...Option) (int64, error) {
+	meta := modelMetadataOf(model, s.naming())
+	columns, rows, err := readTransferRows(r, format, meta.columns)
+	if err != nil {
+		return 0, fmt.Errorf("error reading import: %v", err)
+	}
+	for _, column := range columns {
+		if _, ok := meta.byColumn[column]; !ok {
+			return 0, fmt.Errorf("unknown column %s in import", column)
+		}
+	}
+
+	table := s.tableName(model)
+	config := processOptions(opts)
+	defer config.release()
+	err = s.WithinTransaction(func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(config.ctx, copyInStmt(table, columns))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(config.ctx, row...); err != nil {
+				return err
+			}
+		}
+		_, err = stmt.ExecContext(config.ctx)
+		return err
+	}, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("error importing table: %v", err)
+	}
+	s.invalidateCache(config.ctx, config.tx, table)
+	return int64(len(rows)), nil
+}
+
+// SnapshotTables writes a consistent snapshot of the tables of the models to w,
+// read in one repeatable read transaction so the tables match each other, for
+// lightweight logical backups without pg_dump. Every table is written as a COPY
+// section in foreign key dependency order, so the snapshot can be loaded with
+// RestoreTables or psql. Option values in the list, e.g. WithContext, apply to
+// every statement.
+func (s *PostgreSQLConnector) SnapshotTables(w io.Writer, modelsAndOptions ...interface{}) error {
+	models, opts := splitModelsAndOptions(modelsAndOptions)
+	models, err := sortModelsByDependencies(models, s.naming())
+	if err != nil {
+		return err
+	}
+	snapshotOpts := append(opts[:len(opts):len(opts)], WithIsolation(sql.LevelRepeatableRead), WithReadOnly())
+	return s.WithinTransaction(func(tx *sql.Tx) error {
+		if _, err := io.WriteString(w, "-- go-postgresql-orm snapshot\n"); err != nil {
+			return err
+		}
+		for _, model := range models {
+			columns := modelMetadataOf(model, s.naming()).columns
+			if _, err := fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", s.tableName(model), strings.Join(columns, ", ")); err != nil {
+				return err
+			}
+			if err := s.ExportTable(model, w, FormatCopyText, append(opts[:len(opts):len(opts)], WithTransaction(tx))...); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\\.\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, snapshotOpts...)
+}
+
+// parseCopyHeader returns the table and columns of a "COPY table (columns) FROM stdin;" line
+func parseCopyHeader(line string) (string, []string, error) {
+	rest, ok := strings.CutPrefix(line, "COPY ")
+	if ok {
+		rest, ok = strings.CutSuffix(rest, ") FROM stdin;")
+	}
+	table, list, found := strings.Cut(rest, " (")
+	if !ok || !found || !validIdentifier(table) {
+		return "", nil, fmt.Errorf("invalid COPY header %q", line)
+	}
+	columns := strings.Split(list, ", ")
+	for _, column := range columns {
+		if !validIdentifier(column) {
+			return "", nil, fmt.Errorf("invalid column %q in COPY header", column)
+		}
+	}
+	return table, columns, nil
+}
+
+// copyInStmt returns the COPY FROM STDIN statement of a table and columns for
+// pq. Unlike pq.CopyIn it does not quote the names, they match the tables and
+// columns created unquoted also when they are mixed case.
+func copyInStmt(table string, columns []string) string {
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", table, strings.Join(columns, ", "))
+}
+
+// RestoreTables loads a snapshot written by SnapshotTables into the existing,
+// typically empty, tables in one transaction and returns the number of restored
+// rows. The sequences of serial and identity columns are advanced past the
+// restored values. A failure leaves all tables unchanged. The transaction is not
+// retried, as r cannot be read again.
+func (s *PostgreSQLConnector) RestoreTables(r io.Reader, opts ...Option) (int64, error) {
+	config := processOptions(opts)
+	defer config.release()
+	if config.dryRun != nil {
+		return 0, fmt.Errorf("RestoreTables does not support dry runs")
+	}
+	var restored int64
+	var tables []string
+	err := s.ddlTransaction(config.ctx, config.tx, s.BeginTx, func(tx *sql.Tx) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		var stmt *sql.Stmt
+		var columns int
+		for scanner.Scan() {
+			line := scanner.Text()
+			if stmt == nil {
+				if line == "" || strings.HasPrefix(line, "--") {
+					continue
+				}
+				table, names, err := parseCopyHeader(line)
+				if err != nil {
+					return err
+				}
+				if stmt, err = tx.PrepareContext(config.ctx, copyInStmt(table, names)); err != nil {
+					return err
+				}
+				defer stmt.Close()
+				tables, columns = append(tables, table), len(names)
+				continue
+			}
+			if line == `\.` {
+				if _, err := stmt.ExecContext(config.ctx); err != nil {
+					return err
+				}
+				stmt = nil
+				if err := s.advanceSequences(config.ctx, tx, tables[len(tables)-1]); err != nil {
+					return err
+				}
+				continue
+			}
+			fields := strings.Split(line, "\t")
+			if len(fields) != columns {
+				return fmt.Errorf("expected %d columns in %s, got %d", columns, tables[len(tables)-1], len(fields))
+			}
+			row := make([]interface{}, len(fields))
+			for i, field := range fields {
+				if field != `\N` {
+					row[i] = copyTextUnescaper.Replace(field)
+				}
+			}
+			if _, err := stmt.ExecContext(config.ctx, row...); err != nil {
+				return err
+			}
+			restored++
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if stmt != nil {
+			return fmt.Errorf("snapshot of %s is truncated", tables[len(tables)-1])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error restoring tables: %v", err)
+	}
+	s.invalidateCache(config.ctx, config.tx, tables...)
+	return restored, nil
+}
+
+// advanceSequences sets the sequences of the serial and identity columns of a
+// table to continue after the largest value in the table
+func (s *PostgreSQLConnector) advanceSequences(ctx context.Context, tx *sql.Tx, table string) error {
+	rows, err := s.queryStatement(ctx, tx, `SELECT a.attname FROM pg_attribute a
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		AND pg_get_serial_sequence($1, a.attname) IS NOT NULL`, table)
+	if err != nil {
+		return fmt.Errorf("error reading the sequences of %s: %v", table, err)
+	}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		stmt := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			quoteIdentifier(column), table)
+		if _, err := s.execStatement(ctx, tx, stmt, table, column); err != nil {
+			return fmt.Errorf("error advancing the sequence of %s.%s: %v", table, column, err)
+		}
+	}
+	return nil
+}
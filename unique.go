@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DefaultSuffixFunc produces "base", "base-2", "base-3", ...
+func DefaultSuffixFunc(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	return fmt.Sprintf("%s-%d", base, n)
+}
+
+// escapeLike escapes LIKE wildcards so a value is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// EnsureUniqueValue finds the first available value for column among
+// suffixFn(base, 1), suffixFn(base, 2), ... (DefaultSuffixFunc when nil), sets
+// it on the model and inserts the model, returning the chosen value. suffixFn
+// must return values starting with base, since taken values are fetched with a
+// single prefix query. A transaction-scoped advisory lock on the column keeps
+// concurrent callers from picking the same value.
+func (s PostgreSQLConnector) EnsureUniqueValue(model interface{}, column string, base string, suffixFn func(base string, n int) string, opts ...Option) (value string, err error) {
+	config := processOptions(opts)
+	defer config.release()
+	if suffixFn == nil {
+		suffixFn = DefaultSuffixFunc
+	}
+
+	val := reflect.ValueOf(model)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("model must be a pointer to a struct")
+	}
+	index, ok := columnFieldIndex(val.Elem().Type(), s.naming(), column)
+	if !ok || val.Elem().Field(index).Kind() != reflect.String {
+		return "", fmt.Errorf("%s has no string field for column %s", val.Elem().Type().Name(), column)
+	}
+
+	tx := config.tx
+	if tx == nil {
+		tx, err = s.beginTx(config.ctx)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err != nil {
+				s.RollbackTx(tx)
+				return
+			}
+			err = s.CommitTx(tx)
+		}()
+	}
+
+	table := s.tableName(model)
+	value, err = s.findAvailableValue(config.ctx, tx, table, column, base, suffixFn)
+	if err != nil {
+		return "", err
+	}
+	val.Elem().Field(index).SetString(value)
+	if err = s.insertWithTx(config.ctx, tx, model); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func (s PostgreSQLConnector) findAvailableValue(ctx context.Context, tx *sql.Tx, table, column, base string, suffixFn func(string, int) string) (string, error) {
+	// Serialize writers of this column until the transaction ends
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", table+"."+column); err != nil {
+		return "", fmt.Errorf("error locking %s.%s: %v", table, column, err)
+	}
+
+	// Every candidate starts with base, so one prefix query finds all taken values
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s LIKE $1", column, table, column),
+		escapeLike(base)+"%")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", err
+		}
+		taken[existing] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// An injective suffixFn yields a free value within len(taken)+1 attempts,
+	// one repeating taken values would loop forever
+	attempts := len(taken) + 1
+	for n := 1; n <= attempts; n++ {
+		if candidate := suffixFn(base, n); !taken[candidate] {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no available value for %s.%s after %d attempts", table, column, attempts)
+}
@@ -3,27 +3,25 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func parseTags(model interface{}, fields *Fields) FieldMap {
-	val := reflect.ValueOf(model)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-	fieldMap := make(FieldMap)
-	for i := 0; i < val.NumField(); i++ {
-		typeField := val.Type().Field(i)
-		if gpoField := parseGPOTag(typeField); gpoField != nil {
-			*fields = append(*fields, gpoField.ColumnName)
-			fieldMap[gpoField.ColumnName] = typeField.Name
-		}
-	}
-	return fieldMap
+func parseTags(model interface{}, naming NamingStrategy, fields *Fields) FieldMap {
+	meta := modelMetadataOf(model, naming)
+	*fields = append(*fields, meta.selectable...)
+	return meta.fieldMap
+}
+
+// writableColumns returns the columns written by inserts, all but the readonly ones
+func writableColumns(model interface{}, naming NamingStrategy) Fields {
+	return append(Fields{}, modelMetadataOf(model, naming).writable...)
 }
 
 // parseGPOTag parses the gpo tag and returns GPOField information
@@ -33,7 +31,7 @@ func parseGPOTag(field reflect.StructField) *GPOField {
 		return nil
 	}
 
-	parts := strings.Split(tag, ",")
+	parts := splitTagOptions(tag)
 	if len(parts) == 0 {
 		return nil
 	}
@@ -48,10 +46,30 @@ func parseGPOTag(field reflect.StructField) *GPOField {
 
 		if option == "pk" {
 			gpoField.IsPrimaryKey = true
+		} else if option == "bigint" {
+			gpoField.Type = "BIGINT"
+		} else if option == "timestamptz" {
+			gpoField.Type = "TIMESTAMPTZ"
+		} else if option == "citext" {
+			gpoField.Type = "CITEXT"
+		} else if option == "date" || option == "time" || option == "interval" ||
+			option == "inet" || option == "cidr" || option == "macaddr" {
+			gpoField.Type = strings.ToUpper(option)
 		} else if option == "unique" {
 			gpoField.IsUnique = true
 		} else if option == "nullable" {
 			gpoField.IsNullable = true
+		} else if option == "masked" {
+			gpoField.IsMasked = true
+		} else if option == "readonly" {
+			gpoField.IsReadOnly = true
+		} else if option == "writeonly" {
+			gpoField.IsWriteOnly = true
+		} else if option == "notempty" || option == "email" {
+			gpoField.Validations = append(gpoField.Validations, ValidationRule{Name: option})
+		} else if (strings.HasPrefix(option, "min(") || strings.HasPrefix(option, "max(")) && strings.HasSuffix(option, ")") {
+			// Parse min(n) and max(n), limits of the length of strings and slices or of numbers
+			gpoField.Validations = append(gpoField.Validations, ValidationRule{Name: option[:3], Param: strings.TrimSpace(option[4 : len(option)-1])})
 		} else if strings.HasPrefix(option, "length(") && strings.HasSuffix(option, ")") {
 			// Parse length(50)
 			lengthStr := option[7 : len(option)-1] // Remove "length(" and ")"
@@ -59,7 +77,7 @@ func parseGPOTag(field reflect.StructField) *GPOField {
 				gpoField.Length = length
 			}
 		} else if strings.HasPrefix(option, "fk(") && strings.HasSuffix(option, ")") {
-			// Parse fk(table:column) or fk(table:column,cascade)
+			// Parse fk(table:column), fk(table:column,cascade) or fk(table:column,cascade,deferred)
 			fkContent := option[3 : len(option)-1] // Remove "fk(" and ")"
 			fkParts := strings.Split(fkContent, ",")
 
@@ -72,18 +90,112 @@ func parseGPOTag(field reflect.StructField) *GPOField {
 						Column: strings.TrimSpace(tableColumn[colonIdx+1:]),
 					}
 
-					// Parse onDelete option if present
-					if len(fkParts) >= 2 {
-						gpoField.ForeignKey.OnDelete = strings.TrimSpace(fkParts[1])
+					// Parse the onDelete and deferrable options if present
+					for _, fkOption := range fkParts[1:] {
+						switch fkOption = strings.TrimSpace(fkOption); fkOption {
+						case "deferrable":
+							gpoField.ForeignKey.Deferrable = true
+						case "deferred":
+							gpoField.ForeignKey.Deferrable = true
+							gpoField.ForeignKey.InitiallyDeferred = true
+						default:
+							gpoField.ForeignKey.OnDelete = fkOption
+						}
 					}
 				}
 			}
+		} else if strings.HasPrefix(option, "polymorphic(") && strings.HasSuffix(option, ")") {
+			// Parse polymorphic(type_column,id_column) of relation fields
+			columns := strings.Split(option[12:len(option)-1], ",")
+			if len(columns) == 2 {
+				gpoField.Polymorphic = &PolymorphicInfo{
+					TypeColumn: strings.TrimSpace(columns[0]),
+					IDColumn:   strings.TrimSpace(columns[1]),
+				}
+			}
+		} else if strings.HasPrefix(option, "index(") && strings.HasSuffix(option, ")") {
+			// Parse index(name), index(name,unique) or index(name,unique,where:condition)
+			if index := parseIndexOption(option[6 : len(option)-1]); index != nil {
+				gpoField.Indexes = append(gpoField.Indexes, *index)
+			}
+		} else if strings.HasPrefix(option, "collate(") && strings.HasSuffix(option, ")") {
+			// Parse collate(name), e.g. collate(und-x-icu)
+			gpoField.Collation = strings.TrimSpace(option[8 : len(option)-1])
+		} else if strings.HasPrefix(option, "comment(") {
+			// Parse comment(text), the text may contain commas and balanced parentheses.
+			// Unbalanced ones would split the tag in the wrong places.
+			if !strings.HasSuffix(option, ")") || !balancedParentheses(option[8:len(option)-1]) {
+				gpoField.commentErr = fmt.Errorf("invalid comment option of column %s: unbalanced parentheses in %s", gpoField.ColumnName, option)
+				continue
+			}
+			gpoField.Comment = strings.TrimSpace(option[8 : len(option)-1])
 		}
 	}
 
 	return gpoField
 }
 
+// splitTagOptions splits a gpo tag on commas that are not enclosed in parentheses,
+// so options such as fk(table:column,cascade) stay intact
+func splitTagOptions(tag string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, r := range tag {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, tag[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tag[start:])
+}
+
+// balancedParentheses reports whether every parenthesis of text is closed in order
+func balancedParentheses(text string) bool {
+	depth := 0
+	for _, r := range text {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// parseIndexOption parses the content of an index(...) tag option. Everything
+// after "where:" is the partial index condition, so it may contain commas.
+func parseIndexOption(content string) *IndexInfo {
+	parts := splitTagOptions(content)
+	index := &IndexInfo{Name: strings.TrimSpace(parts[0])}
+	if index.Name == "" {
+		return nil
+	}
+	for i := 1; i < len(parts); i++ {
+		part := strings.TrimSpace(parts[i])
+		if part == "unique" {
+			index.Unique = true
+		} else if strings.HasPrefix(part, "where:") {
+			condition := strings.TrimSpace(strings.Join(parts[i:], ","))
+			index.Where = strings.TrimSpace(strings.TrimPrefix(condition, "where:"))
+			break
+		}
+	}
+	return index
+}
+
 func convertGoTypeToPostgresType(goType string, length int) string {
 	// Convert Go type to Postgres type
 	switch goType {
@@ -95,18 +207,17 @@ func convertGoTypeToPostgresType(goType string, length int) string {
 			return "TEXT"
 		}
 		return "VARCHAR(255)"
+	case "int8", "int16", "uint8":
+		return "SMALLINT"
 	case "int":
 		return "INTEGER"
-	case "int32":
+	case "int32", "uint16":
 		return "INTEGER"
 	case "int64":
-		return "INTEGER"
-	case "uint":
-		return "INTEGER"
-	case "uint32":
-		return "INTEGER"
-	case "uint64":
-		return "INTEGER"
+		return "BIGINT"
+	case "uint", "uint32", "uint64":
+		// Values of uint and uint64 above math.MaxInt64 cannot be written, see CreateTable
+		return "BIGINT"
 	case "float":
 		return "REAL"
 	case "float32":
@@ -117,9 +228,15 @@ func convertGoTypeToPostgresType(goType string, length int) string {
 		return "BOOLEAN"
 	case "UUID":
 		return "UUID"
+	case "ULID":
+		// The C collation sorts the text like the bytes
+		return `CHAR(26) COLLATE "C"`
+	case "Snowflake":
+		return "BIGINT"
 	case "Time":
 		return "TIMESTAMP"
-	case "time.Duration":
+	case "Duration":
+		// time.Duration without the interval option is stored in nanoseconds
 		return "BIGINT"
 	default:
 		if length > 0 {
@@ -129,7 +246,13 @@ func convertGoTypeToPostgresType(goType string, length int) string {
 	}
 }
 
-func getColumnsAndForeignKeysFromStructWithPrefix(s interface{}, tablePrefix string) ([]Column, []ForeignKey) {
+func getColumnsAndForeignKeysFromStructWithPrefix(s interface{}, tablePrefix string, naming NamingStrategy) ([]Column, []ForeignKey) {
+	return columnsAndForeignKeys(s, naming, func(table string) string { return tablePrefix + table })
+}
+
+// columnsAndForeignKeys returns the columns and foreign keys of a model, the
+// tables of fk(...) declarations are resolved with referencedTable
+func columnsAndForeignKeys(s interface{}, naming NamingStrategy, referencedTable func(table string) string) ([]Column, []ForeignKey) {
 	t := reflect.TypeOf(s)
 
 	// If the type is a pointer, get the element type
@@ -140,39 +263,52 @@ func getColumnsAndForeignKeysFromStructWithPrefix(s interface{}, tablePrefix str
 	var columns []Column
 	var foreignKeys []ForeignKey
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		gpoField := parseGPOTag(field)
-
-		if gpoField != nil {
-			columnType := convertGoTypeToPostgresType(field.Type.Name(), gpoField.Length)
-
-			columns = append(columns, Column{
-				Name:       gpoField.ColumnName,
-				Type:       columnType,
-				PrimaryKey: gpoField.IsPrimaryKey,
-				Unique:     gpoField.IsUnique,
-				Null:       gpoField.IsNullable,
-				Length:     gpoField.Length,
-			})
-
-			// Handle foreign key
-			if gpoField.ForeignKey != nil {
-				// Add table prefix to the foreign key reference
-				referencedTable := tablePrefix + gpoField.ForeignKey.Table
-				references := fmt.Sprintf("%s(%s)", referencedTable, gpoField.ForeignKey.Column)
-
-				foreignKey := ForeignKey{
-					ColumnName: gpoField.ColumnName,
-					References: references,
-				}
+	for _, meta := range metadataOf(t, naming).fields {
+		field := t.Field(meta.index)
+		gpoField := meta.tag
 
-				if gpoField.ForeignKey.OnDelete != "" {
-					foreignKey.OnDelete = gpoField.ForeignKey.OnDelete
-				}
+		// Nullable pointer fields such as *time.Time map like their element type
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		columnType := convertGoTypeToPostgresType(fieldType.Name(), gpoField.Length)
+		if gpoField.Type != "" {
+			columnType = gpoField.Type
+		}
+		// The collate option replaces a collation of the type, e.g. of ULID
+		if i := strings.Index(columnType, " COLLATE "); i >= 0 && gpoField.Collation != "" {
+			columnType = columnType[:i]
+		}
 
-				foreignKeys = append(foreignKeys, foreignKey)
+		columns = append(columns, Column{
+			Name:       gpoField.ColumnName,
+			Type:       columnType,
+			PrimaryKey: gpoField.IsPrimaryKey,
+			Unique:     gpoField.IsUnique,
+			Null:       gpoField.IsNullable,
+			Length:     gpoField.Length,
+			Comment:    gpoField.Comment,
+			Collation:  gpoField.Collation,
+			commentErr: gpoField.commentErr,
+		})
+
+		// Handle foreign key
+		if gpoField.ForeignKey != nil {
+			references := fmt.Sprintf("%s(%s)", referencedTable(gpoField.ForeignKey.Table), gpoField.ForeignKey.Column)
+
+			foreignKey := ForeignKey{
+				ColumnName:        gpoField.ColumnName,
+				References:        references,
+				Deferrable:        gpoField.ForeignKey.Deferrable,
+				InitiallyDeferred: gpoField.ForeignKey.InitiallyDeferred,
 			}
+
+			if gpoField.ForeignKey.OnDelete != "" {
+				foreignKey.OnDelete = gpoField.ForeignKey.OnDelete
+			}
+
+			foreignKeys = append(foreignKeys, foreignKey)
 		}
 	}
 
@@ -193,6 +329,36 @@ func getColumnsAndForeignKeysFromStructWithPrefix(s interface{}, tablePrefix str
 	return columns, foreignKeys
 }
 
+// getIndexesFromStruct collects the indexes declared with index(...) tag options.
+// Fields sharing an index name form a multi-column index in field order.
+func getIndexesFromStruct(s interface{}, naming NamingStrategy) []Index {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var indexes []Index
+	positions := make(map[string]int)
+	for _, field := range metadataOf(t, naming).fields {
+		gpoField := field.tag
+		for _, info := range gpoField.Indexes {
+			pos, ok := positions[info.Name]
+			if !ok {
+				pos = len(indexes)
+				positions[info.Name] = pos
+				indexes = append(indexes, Index{Name: info.Name})
+			}
+			index := &indexes[pos]
+			index.Columns = append(index.Columns, gpoField.ColumnName)
+			index.Unique = index.Unique || info.Unique
+			if info.Where != "" {
+				index.Where = info.Where
+			}
+		}
+	}
+	return indexes
+}
+
 func validateOnDeleteText(text string) bool {
 	switch strings.ToUpper(text) {
 	case "NO ACTION", "RESTRICT", "CASCADE", "SET NULL", "SET DEFAULT":
@@ -201,10 +367,126 @@ func validateOnDeleteText(text string) bool {
 	return false
 }
 
-func _createTable(db *sql.DB, table Table) error {
+// createColumnExtensions creates the extensions providing the types of the columns, e.g. citext
+func createColumnExtensions(ctx context.Context, db execer, columns []Column) error {
+	for _, column := range columns {
+		if strings.EqualFold(column.Type, "CITEXT") {
+			if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS citext"); err != nil {
+				return fmt.Errorf("error creating the citext extension for %s: %v", column.Name, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// columnTypeText returns the type of a column with its COLLATE clause
+func columnTypeText(column Column) string {
+	if column.Collation == "" {
+		return column.Type
+	}
+	return column.Type + " COLLATE " + quoteIdentifier(column.Collation)
+}
+
+// quoteIdentifier quotes a name as SQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// columnCollation returns the collation of a column, also when it is part of the type
+func columnCollation(column Column) string {
+	if column.Collation != "" {
+		return column.Collation
+	}
+	if i := strings.Index(column.Type, " COLLATE "); i >= 0 {
+		return strings.Trim(strings.TrimSpace(column.Type[i+9:]), `"`)
+	}
+	return ""
+}
+
+// primaryKeyColumns returns the primary key columns of a table, including the
+// partition columns of a partitioned table, none when no column is tagged pk
+func primaryKeyColumns(table Table) []string {
+	var keyColumns []string
+	for _, column := range table.Columns {
+		if column.PrimaryKey {
+			keyColumns = append(keyColumns, column.Name)
+		}
+	}
+	if len(keyColumns) == 0 {
+		return nil
+	}
+	return withPartitionColumns(table, keyColumns)
+}
+
+// uniqueKeyColumns returns the columns of the unique constraint of a column
+// tagged unique, including the partition columns of a partitioned table
+func uniqueKeyColumns(table Table, column string) []string {
+	return withPartitionColumns(table, []string{column})
+}
+
+// withPartitionColumns appends the partition columns missing from the key
+// columns, Postgres requires them in the keys of partitioned tables
+func withPartitionColumns(table Table, keyColumns []string) []string {
+	if table.Partitioning != nil {
+		for _, column := range table.Partitioning.Columns {
+			if !contains(keyColumns, column) {
+				keyColumns = append(keyColumns, column)
+			}
+		}
+	}
+	return keyColumns
+}
+
+// foreignKeyClause returns the FOREIGN KEY ... REFERENCES clause of a foreign key
+func foreignKeyClause(fk ForeignKey) (string, error) {
+	// Split the references into table and column
+	parts := strings.SplitN(fk.References, "(", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid foreign key reference: %s", fk.References)
+	}
+	table := parts[0]
+	column := strings.TrimSuffix(parts[1], ")")
+
+	// Check if the ON DELETE clause is set
+	onDeleteText := ""
+	if fk.OnDelete != "" {
+		if !validateOnDeleteText(fk.OnDelete) {
+			return "", fmt.Errorf("invalid ON DELETE clause: %s", fk.OnDelete)
+		}
+		onDeleteText = fmt.Sprintf(" ON DELETE %s", strings.ToUpper(fk.OnDelete))
+	}
+	deferrableText := ""
+	if fk.InitiallyDeferred {
+		deferrableText = " DEFERRABLE INITIALLY DEFERRED"
+	} else if fk.Deferrable {
+		deferrableText = " DEFERRABLE"
+	}
+
+	// Correctly format the REFERENCES clause
+	return fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s)%s%s", fk.ColumnName, table, column, onDeleteText, deferrableText), nil
+}
+
+// execer runs statements, it is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// querier runs statements returning rows, e.g. a *sql.Tx or *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func _createTable(ctx context.Context, db execer, table Table) error {
 	if table.Name == "" {
 		return fmt.Errorf("table name cannot be empty")
 	}
+	if err := checkComments(table); err != nil {
+		return err
+	}
+	if err := createColumnExtensions(ctx, db, table.Columns); err != nil {
+		return err
+	}
 
 	// Start the create table statement
 	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (", table.Name)
@@ -216,55 +498,161 @@ func _createTable(db *sql.DB, table Table) error {
 			nullText = "NULL"
 		}
 		uniqueText := ""
-		if column.Unique {
+		if column.Unique && table.Partitioning == nil {
 			uniqueText = "UNIQUE"
 		}
 		pkText := ""
-		if column.PrimaryKey {
+		if column.PrimaryKey && table.Partitioning == nil {
 			pkText = "PRIMARY KEY"
 		}
-		sql += fmt.Sprintf("%s %s %s %s %s,", column.Name, column.Type, nullText, uniqueText, pkText)
+		if column.Default != "" {
+			nullText += " DEFAULT " + column.Default
+		}
+		sql += fmt.Sprintf("%s %s %s %s %s,", column.Name, columnTypeText(column), nullText, uniqueText, pkText)
 	}
 
 	// Add foreign keys
 	for _, fk := range table.ForeignKeys {
-		// Split the references into table and column
-		parts := strings.SplitN(fk.References, "(", 2)
-		table := parts[0]
-		column := strings.TrimSuffix(parts[1], ")")
-
-		// Check if the ON DELETE clause is set
-		onDeleteText := ""
-		if fk.OnDelete != "" {
-			if !validateOnDeleteText(fk.OnDelete) {
-				return fmt.Errorf("invalid ON DELETE clause: %s", fk.OnDelete)
-			}
-			onDeleteText = fmt.Sprintf(" ON DELETE %s", strings.ToUpper(fk.OnDelete))
+		clause, err := foreignKeyClause(fk)
+		if err != nil {
+			return err
 		}
+		sql += clause + ","
+	}
 
-		// Correctly format the REFERENCES clause
-		sql += fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s)%s,", fk.ColumnName, table, column, onDeleteText)
+	// The keys of a partitioned table have to include the partition columns
+	if table.Partitioning != nil {
+		if keyColumns := primaryKeyColumns(table); len(keyColumns) > 0 {
+			sql += fmt.Sprintf("PRIMARY KEY (%s),", strings.Join(keyColumns, ", "))
+		}
+		for _, column := range table.Columns {
+			if column.Unique {
+				sql += fmt.Sprintf("UNIQUE (%s),", strings.Join(uniqueKeyColumns(table, column.Name), ", "))
+			}
+		}
 	}
 
 	// Remove trailing comma and close parentheses
 	sql = strings.TrimSuffix(sql, ",") + ")"
+	if table.Partitioning != nil {
+		partitionClause, err := table.Partitioning.clause()
+		if err != nil {
+			return err
+		}
+		sql += partitionClause
+	}
 
 	// Execute the create table statement
-	_, err := db.Exec(sql)
+	_, err := db.ExecContext(ctx, sql)
 	if err != nil {
 		return err
 	}
 
+	// Document the table and columns
+	for _, stmt := range buildCommentStmts(table, nil) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error adding comment: %v", err)
+		}
+	}
+
+	// Create indexes declared with index(...) tag options
+	for _, index := range table.Indexes {
+		if _, err := db.ExecContext(ctx, buildCreateIndexStmt(table.Name, index)); err != nil {
+			return fmt.Errorf("error creating index %s: %v", index.Name, err)
+		}
+	}
+
+	// Create the history table and its trigger
+	if table.History {
+		for _, stmt := range historyStmts(table) {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("error creating history of %s: %v", table.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildCommentStmts returns the COMMENT ON statements of a table and its columns
+// whose comments differ from the existing ones, keyed by column name and by ""
+// for the table. Existing comments no longer declared are removed.
+func buildCommentStmts(table Table, existing map[string]string) []string {
+	var stmts []string
+	if table.Comment != existing[""] {
+		stmts = append(stmts, fmt.Sprintf("COMMENT ON TABLE %s IS %s", table.Name, commentLiteral(table.Comment)))
+	}
+	for _, column := range table.Columns {
+		if column.Comment != existing[column.Name] {
+			stmts = append(stmts, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", table.Name, column.Name, commentLiteral(column.Comment)))
+		}
+	}
+	return stmts
+}
+
+// commentLiteral quotes a comment, the empty comment removes it
+func commentLiteral(comment string) string {
+	if comment == "" {
+		return "NULL"
+	}
+	return quoteLiteral(comment)
+}
+
+// checkComments returns the error of the first column with an invalid comment option
+func checkComments(table Table) error {
+	for _, column := range table.Columns {
+		if column.commentErr != nil {
+			return column.commentErr
+		}
+	}
 	return nil
 }
 
-func getTableNameFromModel(tablePrefix string, model interface{}) string {
+// quoteLiteral quotes a string as a SQL literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func buildCreateIndexStmt(tableName string, index Index) string {
+	uniqueText := ""
+	if index.Unique {
+		uniqueText = "UNIQUE "
+	}
+	sql := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)", uniqueText, index.Name, tableName, strings.Join(index.Columns, ", "))
+	if index.Where != "" {
+		sql += " WHERE " + index.Where
+	}
+	return sql
+}
+
+// tablePrefix returns the prefix of the connector's table names
+func (s *PostgreSQLConnector) tablePrefix() string {
+	if s.TablePrefix == "" {
+		return defaultTablePrefix
+	}
+	return s.TablePrefix
+}
+
+// tableName returns the table name of a model using the connector's prefix and naming strategy
+func (s *PostgreSQLConnector) tableName(model interface{}) string {
+	return getTableNameFromModel(s.TablePrefix, s.naming(), model)
+}
+
+// getTableNameFromModel returns the table name of a model, nil naming means DefaultNaming
+func getTableNameFromModel(tablePrefix string, naming NamingStrategy, model interface{}) string {
 	modelType := reflect.TypeOf(model)
 	if modelType.Kind() == reflect.Ptr {
 		modelType = modelType.Elem()
 	}
-	modelName := modelType.Name()
-	tableName := strings.ToLower(modelName)
+	if modelType.Kind() == reflect.Struct {
+		if name := metadataOf(modelType, nil).tableName; name != "" {
+			return name
+		}
+	}
+	if naming == nil {
+		naming = DefaultNaming
+	}
+	tableName := naming.TableName(modelType.Name())
 	tPrefix := tablePrefix
 	if tPrefix == "" {
 		tPrefix = defaultTablePrefix
@@ -277,10 +665,12 @@ func buildQuery(params *DatabaseQuery) (string, []interface{}) {
 	qb := NewQueryBuilder()
 	qb.Select(params.fields.String()...).From(params.Table)
 
-	// Add conditions
-	for _, condition := range params.Conditions {
-		qb.Where(condition.Field, condition.Operator, condition.Value)
-	}
+	// Add conditions, they are not checked against the known operators of Where
+	qb.conditions = append(qb.conditions, params.Conditions...)
+
+	// Add grouping
+	qb.GroupBy(params.GroupBy...)
+	qb.havingConditions = append(qb.havingConditions, params.Having...)
 
 	// Add ordering
 	if params.OrderBy != "" {
@@ -290,6 +680,13 @@ func buildQuery(params *DatabaseQuery) (string, []interface{}) {
 			qb.OrderByAsc(params.OrderBy)
 		}
 	}
+	if params.tieBreaker != "" && params.tieBreaker != params.OrderBy {
+		if params.Descending {
+			qb.OrderByDesc(params.tieBreaker)
+		} else {
+			qb.OrderByAsc(params.tieBreaker)
+		}
+	}
 
 	// Add limit
 	if params.Limit > 0 {
@@ -319,6 +716,9 @@ func ParseQueryParamsFromRequest(r *http.Request, query *DatabaseQuery) {
 	if searchText := r.URL.Query().Get("search"); searchText != "" {
 		query.SearchText = searchText
 	}
+	if view := r.URL.Query().Get("view"); view != "" {
+		query.View = view
+	}
 
 }
 
@@ -327,16 +727,18 @@ func buildAdvancedQuery(params *DatabaseQuery) (string, []interface{}) {
 	qb := NewQueryBuilder()
 	qb.Select(params.fields.String()...).From(params.Table)
 
-	// Add conditions
-	for _, condition := range params.Conditions {
-		qb.Where(condition.Field, condition.Operator, condition.Value)
-	}
+	// Add conditions, they are not checked against the known operators of Where
+	qb.conditions = append(qb.conditions, params.Conditions...)
 
 	// Add search functionality
 	if len(params.SearchFields) > 0 && params.SearchText != "" {
 		qb.Search(params.SearchFields.String(), params.SearchText)
 	}
 
+	// Add grouping
+	qb.GroupBy(params.GroupBy...)
+	qb.havingConditions = append(qb.havingConditions, params.Having...)
+
 	// Add ordering
 	if params.OrderBy != "" {
 		if params.Descending {
@@ -345,6 +747,13 @@ func buildAdvancedQuery(params *DatabaseQuery) (string, []interface{}) {
 			qb.OrderByAsc(params.OrderBy)
 		}
 	}
+	if params.tieBreaker != "" && params.tieBreaker != params.OrderBy {
+		if params.Descending {
+			qb.OrderByDesc(params.tieBreaker)
+		} else {
+			qb.OrderByAsc(params.tieBreaker)
+		}
+	}
 
 	// Add limit (default to 10 if not specified)
 	if params.Limit > 0 {
@@ -362,56 +771,56 @@ func buildAdvancedQuery(params *DatabaseQuery) (string, []interface{}) {
 	return query, args
 }
 
-func buildInsertStmt(params *DatabaseInsert, model interface{}) (string, []interface{}, error) {
+func buildInsertStmt(params *DatabaseInsert, model interface{}, naming NamingStrategy) (string, []interface{}, error) {
 	var query string
-	query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (", params.Table, strings.Join(params.Fields.String(), ","))
-	vals := make([]interface{}, len(params.Fields))
+	columns := params.Fields.String()
+	var vals []interface{}
+	if params.key != nil {
+		columns = append([]string{DefaultIDField}, columns...)
+		vals = append(vals, params.key)
+	}
+	query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (", params.Table, strings.Join(columns, ","))
 	modelValue := reflect.ValueOf(model)
 	if modelValue.Kind() == reflect.Ptr {
 		modelValue = modelValue.Elem()
 	}
-	t := modelValue.Type()
+	meta := metadataOf(modelValue.Type(), naming)
 	for i := 0; i < len(params.Fields); i++ {
 		dbColumnName := params.Fields[i]
-		var structFieldName string
-		for j := 0; j < t.NumField(); j++ {
-			field := t.Field(j)
-			if gpoField := parseGPOTag(field); gpoField != nil && gpoField.ColumnName == dbColumnName {
-				structFieldName = field.Name
-				break
-			}
-		}
-		if structFieldName == "" {
+		field, ok := meta.byColumn[dbColumnName]
+		if !ok {
 			return "", nil, fmt.Errorf("no struct field found for database column %s", dbColumnName)
 		}
-		field := modelValue.FieldByName(structFieldName)
-		vals[i] = field.Interface()
-		query += fmt.Sprintf("$%d", i+1)
-		if i < len(params.Fields)-1 {
+		vals = append(vals, columnArg(field, modelValue.Field(field.index)))
+	}
+	for i := range vals {
+		if i > 0 {
 			query += ","
 		}
+		query += fmt.Sprintf("$%d", i+1)
 	}
 	query += ")"
 	return query, vals, nil
 }
 
-func buildUpdateStmt(params *DatabaseUpdate, model interface{}) (string, []interface{}, error) {
+func buildUpdateStmt(params *DatabaseUpdate, model interface{}, naming NamingStrategy) (string, []interface{}, error) {
 	var query string
 	query = fmt.Sprintf("UPDATE %s SET ", params.Table)
 	val := reflect.ValueOf(model)
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
 	}
-	t := val.Type()
 	args := make([]interface{}, 0)
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		gpoField := parseGPOTag(field)
-		if gpoField == nil || gpoField.IsPrimaryKey {
+	for _, field := range metadataOf(val.Type(), naming).fields {
+		if field.tag.IsPrimaryKey || field.tag.IsReadOnly {
 			continue
 		}
-		query += fmt.Sprintf("%s = $%d, ", gpoField.ColumnName, len(args)+1)
-		args = append(args, val.Field(i).Interface())
+		// writeonly fields are empty in loaded models, only set values are written
+		if field.tag.IsWriteOnly && val.Field(field.index).IsZero() {
+			continue
+		}
+		query += fmt.Sprintf("%s = $%d, ", field.tag.ColumnName, len(args)+1)
+		args = append(args, columnArg(&field, val.Field(field.index)))
 	}
 	query = strings.TrimSuffix(query, ", ")
 
@@ -428,6 +837,12 @@ func buildUpdateStmt(params *DatabaseUpdate, model interface{}) (string, []inter
 
 // buildConditions builds WHERE conditions from a slice of Condition structs with centralized IN/NOT IN handling
 func buildConditions(conditions []Condition, existingArgs []interface{}) (string, []interface{}) {
+	return buildConditionList(conditions, "AND", existingArgs)
+}
+
+// buildConditionList joins conditions with the given logical operator, nested
+// AND/OR groups (see And and Or) are rendered in parentheses
+func buildConditionList(conditions []Condition, logicalOperator string, existingArgs []interface{}) (string, []interface{}) {
 	if len(conditions) == 0 {
 		return "", existingArgs
 	}
@@ -436,14 +851,25 @@ func buildConditions(conditions []Condition, existingArgs []interface{}) (string
 	args := existingArgs
 
 	for _, condition := range conditions {
-		if condition.Operator == "IN" || condition.Operator == "NOT IN" {
+		if condition.Operator == "AND" || condition.Operator == "OR" {
+			group, _ := condition.Value.([]Condition)
+			groupClause, groupArgs := buildConditionList(group, condition.Operator, args)
+			if groupClause != "" {
+				conditionParts = append(conditionParts, "("+groupClause+")")
+				args = groupArgs
+			}
+		} else if list, ok := condition.Value.(largeInList); ok {
+			var clause string
+			clause, args = buildLargeInCondition(condition, list, args)
+			conditionParts = append(conditionParts, clause)
+		} else if condition.Operator == "IN" || condition.Operator == "NOT IN" {
 			// Handle IN/NOT IN with reflection for any slice type
 			v := reflect.ValueOf(condition.Value)
 			if v.Kind() == reflect.Slice {
 				placeholders := make([]string, v.Len())
 				for i := 0; i < v.Len(); i++ {
 					placeholders[i] = fmt.Sprintf("$%d", len(args)+1)
-					args = append(args, v.Index(i).Interface())
+					args = append(args, networkArg(v.Index(i).Interface()))
 				}
 				conditionParts = append(conditionParts, fmt.Sprintf("%s %s (%s)",
 					condition.Field, condition.Operator, strings.Join(placeholders, ",")))
@@ -452,16 +878,61 @@ func buildConditions(conditions []Condition, existingArgs []interface{}) (string
 				conditionParts = append(conditionParts, fmt.Sprintf("%s = $%d", condition.Field, len(args)+1))
 				args = append(args, condition.Value)
 			}
-		} else if condition.Operator == "LIKE" || condition.Operator == "NOT LIKE" {
+		} else if condition.Operator == "LIKE" || condition.Operator == "NOT LIKE" || condition.Operator == "ILIKE" {
 			conditionParts = append(conditionParts, fmt.Sprintf("%s %s $%d", condition.Field, condition.Operator, len(args)+1))
 			args = append(args, "%"+condition.Value.(string)+"%")
 		} else {
 			conditionParts = append(conditionParts, fmt.Sprintf("%s %s $%d", condition.Field, condition.Operator, len(args)+1))
-			args = append(args, condition.Value)
+			args = append(args, networkArg(condition.Value))
 		}
 	}
 
-	return strings.Join(conditionParts, " AND "), args
+	return strings.Join(conditionParts, " "+logicalOperator+" "), args
+}
+
+// buildAggregateSelect renders an aggregate selection, only well-known aggregate functions are allowed
+func buildAggregateSelect(aggregate Aggregate) (string, error) {
+	function := strings.ToUpper(aggregate.Function)
+	switch function {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+	default:
+		return "", fmt.Errorf("unsupported aggregate function: %s", aggregate.Function)
+	}
+	if aggregate.Column == "" || (aggregate.Column == "*" && function != "COUNT") {
+		return "", fmt.Errorf("invalid column for %s: %q", function, aggregate.Column)
+	}
+	if aggregate.Alias == "" {
+		return "", fmt.Errorf("alias is required for %s(%s)", function, aggregate.Column)
+	}
+	return fmt.Sprintf("%s(%s) AS \"%s\"", function, aggregate.Column, aggregate.Alias), nil
+}
+
+// aggregateFields replaces the fields named like an aggregate alias with the
+// aggregate, so report structs are tagged with the aliases
+func aggregateFields(fields Fields, aggregates []Aggregate) (Fields, error) {
+	selects := make(map[string]string, len(aggregates))
+	for _, aggregate := range aggregates {
+		aggregateSelect, err := buildAggregateSelect(aggregate)
+		if err != nil {
+			return nil, err
+		}
+		selects[aggregate.Alias] = aggregateSelect
+	}
+	result := make(Fields, 0, len(fields))
+	for _, field := range fields {
+		if aggregateSelect, ok := selects[field]; ok {
+			result = append(result, aggregateSelect)
+			delete(selects, field)
+			continue
+		}
+		result = append(result, field)
+	}
+	for _, aggregate := range aggregates {
+		if _, ok := selects[aggregate.Alias]; ok {
+			return nil, fmt.Errorf("no field tagged with the aggregate alias %s", aggregate.Alias)
+		}
+	}
+	return result, nil
 }
 
 // buildConditionsWithSearch builds WHERE conditions including search functionality
@@ -502,31 +973,130 @@ type QueryBuilder struct {
 	queryType    string
 	table        string
 	fields       []string
-	joins        []string
+	joins        []rawFragment
 	conditions   []Condition
 	orderBy      []string
 	groupBy      []string
-	having       []string
+	having       []rawFragment
 	limit        int
 	offset       int
-	values       map[string]interface{}
+	values       []columnAssignment
 	updateModel  interface{}
 	insertModel  interface{}
 	searchText   string
 	searchFields []string
+	explain      string
+
+	// havingConditions are bound like conditions, after the WHERE arguments
+	havingConditions []Condition
+	// errs are the misuses found while building, reported by Build
+	errs BuildErrors
+}
+
+// BuildErrors collects the misuses of a QueryBuilder, such as a Where before
+// Select or an unknown operator, which Build reports together
+type BuildErrors []error
+
+func (e BuildErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "invalid query: " + strings.Join(messages, "; ")
+}
+
+// knownOperators are the comparison operators accepted by Where and HavingCondition
+var knownOperators = map[string]bool{
+	"=": true, "!=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true,
+	"LIKE": true, "NOT LIKE": true, "ILIKE": true, "NOT ILIKE": true,
+	"IN": true, "NOT IN": true, "IS": true, "IS NOT": true,
+	"IS DISTINCT FROM": true, "IS NOT DISTINCT FROM": true,
+	"~": true, "~*": true, "!~": true, "!~*": true, "%": true, "@@": true,
+	"@>": true, "<@": true, "?": true, "?|": true, "?&": true,
+	NetContainedIn: true, NetContainedInOrEqual: true, NetContains: true, NetContainsOrEqual: true, NetOverlaps: true,
+	// Nested groups, see And and Or
+	"AND": true, "OR": true,
+}
+
+// expect records an error when method is used with another query type than allowed
+func (qb *QueryBuilder) expect(method string, queryTypes ...string) {
+	for _, queryType := range queryTypes {
+		if qb.queryType == queryType {
+			return
+		}
+	}
+	if qb.queryType == "" {
+		qb.errs = append(qb.errs, fmt.Errorf("%s called before %s", method, strings.Join(queryTypes, ", ")))
+		return
+	}
+	qb.errs = append(qb.errs, fmt.Errorf("%s is not allowed in a %s query", method, qb.queryType))
+}
+
+// checkOperator records an error for operators not in knownOperators
+func (qb *QueryBuilder) checkOperator(method, operator string) {
+	if !knownOperators[strings.ToUpper(operator)] {
+		qb.errs = append(qb.errs, fmt.Errorf("%s: unknown operator %q", method, operator))
+	}
 }
 
 // NewQueryBuilder creates a new QueryBuilder instance
 func NewQueryBuilder() *QueryBuilder {
 	return &QueryBuilder{
 		fields:     []string{},
-		joins:      []string{},
+		joins:      []rawFragment{},
 		conditions: []Condition{},
 		orderBy:    []string{},
 		groupBy:    []string{},
-		having:     []string{},
-		values:     make(map[string]interface{}),
+		having:     []rawFragment{},
+	}
+}
+
+// columnAssignment is a column value of an INSERT or UPDATE
+type columnAssignment struct {
+	column string
+	value  interface{}
+}
+
+// rawFragment is a SQL fragment with the args of its $n placeholders
+type rawFragment struct {
+	sql  string
+	args []interface{}
+}
+
+// renumberPlaceholders shifts the $n placeholders of a raw fragment by offset,
+// quoted literals and identifiers are left alone
+func renumberPlaceholders(fragment string, offset int) string {
+	if offset == 0 {
+		return fragment
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(fragment); i++ {
+		c := fragment[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$' && i+1 < len(fragment) && isDigit(fragment[i+1]):
+			end := i + 1
+			for end < len(fragment) && isDigit(fragment[end]) {
+				end++
+			}
+			n, _ := strconv.Atoi(fragment[i+1 : end])
+			b.WriteString("$" + strconv.Itoa(n+offset))
+			i = end - 1
+			continue
+		}
+		b.WriteByte(c)
 	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
 }
 
 // SELECT operations
@@ -545,29 +1115,36 @@ func (qb *QueryBuilder) From(table string) *QueryBuilder {
 	return qb
 }
 
-// JOIN operations
-func (qb *QueryBuilder) Join(table, condition string) *QueryBuilder {
-	qb.joins = append(qb.joins, fmt.Sprintf("JOIN %s ON %s", table, condition))
+// JOIN operations. The condition may bind args with the placeholders $1, $2, ...
+// counted from the condition, they are renumbered to their position in the query.
+func (qb *QueryBuilder) Join(table, condition string, args ...interface{}) *QueryBuilder {
+	qb.expect("Join", "SELECT")
+	qb.joins = append(qb.joins, rawFragment{fmt.Sprintf("JOIN %s ON %s", table, condition), args})
 	return qb
 }
 
-func (qb *QueryBuilder) LeftJoin(table, condition string) *QueryBuilder {
-	qb.joins = append(qb.joins, fmt.Sprintf("LEFT JOIN %s ON %s", table, condition))
+func (qb *QueryBuilder) LeftJoin(table, condition string, args ...interface{}) *QueryBuilder {
+	qb.expect("LeftJoin", "SELECT")
+	qb.joins = append(qb.joins, rawFragment{fmt.Sprintf("LEFT JOIN %s ON %s", table, condition), args})
 	return qb
 }
 
-func (qb *QueryBuilder) RightJoin(table, condition string) *QueryBuilder {
-	qb.joins = append(qb.joins, fmt.Sprintf("RIGHT JOIN %s ON %s", table, condition))
+func (qb *QueryBuilder) RightJoin(table, condition string, args ...interface{}) *QueryBuilder {
+	qb.expect("RightJoin", "SELECT")
+	qb.joins = append(qb.joins, rawFragment{fmt.Sprintf("RIGHT JOIN %s ON %s", table, condition), args})
 	return qb
 }
 
-func (qb *QueryBuilder) FullJoin(table, condition string) *QueryBuilder {
-	qb.joins = append(qb.joins, fmt.Sprintf("FULL OUTER JOIN %s ON %s", table, condition))
+func (qb *QueryBuilder) FullJoin(table, condition string, args ...interface{}) *QueryBuilder {
+	qb.expect("FullJoin", "SELECT")
+	qb.joins = append(qb.joins, rawFragment{fmt.Sprintf("FULL OUTER JOIN %s ON %s", table, condition), args})
 	return qb
 }
 
 // WHERE conditions using centralized buildConditions
 func (qb *QueryBuilder) Where(field, operator string, value interface{}) *QueryBuilder {
+	qb.expect("Where", "SELECT", "UPDATE", "DELETE")
+	qb.checkOperator("Where", operator)
 	qb.conditions = append(qb.conditions, Condition{
 		Field:    field,
 		Operator: operator,
@@ -577,6 +1154,7 @@ func (qb *QueryBuilder) Where(field, operator string, value interface{}) *QueryB
 }
 
 func (qb *QueryBuilder) WhereIn(field string, values interface{}) *QueryBuilder {
+	qb.expect("WhereIn", "SELECT", "UPDATE", "DELETE")
 	qb.conditions = append(qb.conditions, Condition{
 		Field:    field,
 		Operator: "IN",
@@ -586,6 +1164,7 @@ func (qb *QueryBuilder) WhereIn(field string, values interface{}) *QueryBuilder
 }
 
 func (qb *QueryBuilder) WhereNotIn(field string, values interface{}) *QueryBuilder {
+	qb.expect("WhereNotIn", "SELECT", "UPDATE", "DELETE")
 	qb.conditions = append(qb.conditions, Condition{
 		Field:    field,
 		Operator: "NOT IN",
@@ -595,6 +1174,7 @@ func (qb *QueryBuilder) WhereNotIn(field string, values interface{}) *QueryBuild
 }
 
 func (qb *QueryBuilder) WhereLike(field string, value string) *QueryBuilder {
+	qb.expect("WhereLike", "SELECT", "UPDATE", "DELETE")
 	qb.conditions = append(qb.conditions, Condition{
 		Field:    field,
 		Operator: "LIKE",
@@ -605,6 +1185,7 @@ func (qb *QueryBuilder) WhereLike(field string, value string) *QueryBuilder {
 
 // Search functionality
 func (qb *QueryBuilder) Search(fields []string, text string) *QueryBuilder {
+	qb.expect("Search", "SELECT")
 	qb.searchFields = fields
 	qb.searchText = text
 	return qb
@@ -612,6 +1193,10 @@ func (qb *QueryBuilder) Search(fields []string, text string) *QueryBuilder {
 
 // ORDER BY
 func (qb *QueryBuilder) OrderBy(field, direction string) *QueryBuilder {
+	qb.expect("OrderBy", "SELECT")
+	if direction := strings.ToUpper(direction); direction != "ASC" && direction != "DESC" {
+		qb.errs = append(qb.errs, fmt.Errorf("OrderBy: direction must be ASC or DESC, got %q", direction))
+	}
 	qb.orderBy = append(qb.orderBy, fmt.Sprintf("%s %s", field, strings.ToUpper(direction)))
 	return qb
 }
@@ -626,22 +1211,37 @@ func (qb *QueryBuilder) OrderByDesc(field string) *QueryBuilder {
 
 // GROUP BY and HAVING
 func (qb *QueryBuilder) GroupBy(fields ...string) *QueryBuilder {
+	qb.expect("GroupBy", "SELECT")
 	qb.groupBy = append(qb.groupBy, fields...)
 	return qb
 }
 
-func (qb *QueryBuilder) Having(condition string) *QueryBuilder {
-	qb.having = append(qb.having, condition)
+// Having adds a raw HAVING condition, which may bind args like the Join conditions,
+// e.g. Having("COUNT(*) > $1", 5)
+func (qb *QueryBuilder) Having(condition string, args ...interface{}) *QueryBuilder {
+	qb.expect("Having", "SELECT")
+	qb.having = append(qb.having, rawFragment{condition, args})
+	return qb
+}
+
+// HavingCondition adds a HAVING condition with a bound value, the field may be an
+// aggregate expression, e.g. HavingCondition("COUNT(*)", ">", 5)
+func (qb *QueryBuilder) HavingCondition(field, operator string, value interface{}) *QueryBuilder {
+	qb.expect("HavingCondition", "SELECT")
+	qb.checkOperator("HavingCondition", operator)
+	qb.havingConditions = append(qb.havingConditions, Condition{Field: field, Operator: operator, Value: value})
 	return qb
 }
 
 // LIMIT and OFFSET
 func (qb *QueryBuilder) Limit(limit int) *QueryBuilder {
+	qb.expect("Limit", "SELECT")
 	qb.limit = limit
 	return qb
 }
 
 func (qb *QueryBuilder) Offset(offset int) *QueryBuilder {
+	qb.expect("Offset", "SELECT")
 	qb.offset = offset
 	return qb
 }
@@ -654,12 +1254,23 @@ func (qb *QueryBuilder) Insert(model interface{}) *QueryBuilder {
 }
 
 func (qb *QueryBuilder) Into(table string) *QueryBuilder {
+	qb.expect("Into", "INSERT")
 	qb.table = table
 	return qb
 }
 
+// Values sets the column values of an INSERT, added in column name order so the
+// SQL is the same for every call; use Set to control the order
 func (qb *QueryBuilder) Values(values map[string]interface{}) *QueryBuilder {
-	qb.values = values
+	qb.expect("Values", "INSERT")
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		qb.Set(column, values[column])
+	}
 	return qb
 }
 
@@ -670,15 +1281,22 @@ func (qb *QueryBuilder) Update(table string) *QueryBuilder {
 	return qb
 }
 
+// Set sets the value of a column of an UPDATE or INSERT, columns are written in
+// the order they were first set
 func (qb *QueryBuilder) Set(field string, value interface{}) *QueryBuilder {
-	if qb.values == nil {
-		qb.values = make(map[string]interface{})
+	qb.expect("Set", "UPDATE", "INSERT")
+	for i := range qb.values {
+		if qb.values[i].column == field {
+			qb.values[i].value = value
+			return qb
+		}
 	}
-	qb.values[field] = value
+	qb.values = append(qb.values, columnAssignment{column: field, value: value})
 	return qb
 }
 
 func (qb *QueryBuilder) SetModel(model interface{}) *QueryBuilder {
+	qb.expect("SetModel", "UPDATE")
 	qb.updateModel = model
 	return qb
 }
@@ -695,8 +1313,27 @@ func (qb *QueryBuilder) DeleteFrom(table string) *QueryBuilder {
 	return qb
 }
 
+// Explain prefixes the built query with EXPLAIN (FORMAT JSON), or EXPLAIN ANALYZE
+// when analyze is set. Parse the single result value with ParseQueryPlan, or pass
+// the unexplained query to PostgreSQLConnector.ExplainSQL.
+func (qb *QueryBuilder) Explain(analyze bool) *QueryBuilder {
+	qb.explain = explainPrefix(analyze)
+	return qb
+}
+
 // Build the final SQL query using existing centralized functions
 func (qb *QueryBuilder) Build() (string, []interface{}, error) {
+	if len(qb.errs) > 0 {
+		return "", nil, qb.errs
+	}
+	query, args, err := qb.build()
+	if err != nil {
+		return "", nil, err
+	}
+	return qb.explain + query, args, nil
+}
+
+func (qb *QueryBuilder) build() (string, []interface{}, error) {
 	switch qb.queryType {
 	case "SELECT":
 		return qb.buildSelect()
@@ -719,12 +1356,13 @@ func (qb *QueryBuilder) buildSelect() (string, []interface{}, error) {
 	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(qb.fields, ", "), qb.table)
 
 	// Add JOINs
+	var args []interface{}
 	for _, join := range qb.joins {
-		query += " " + join
+		query += " " + renumberPlaceholders(join.sql, len(args))
+		args = append(args, join.args...)
 	}
 
 	// Add WHERE conditions using centralized function
-	var args []interface{}
 	if len(qb.conditions) > 0 || len(qb.searchFields) > 0 {
 		whereClause, whereArgs := buildConditionsWithSearch(qb.conditions, qb.searchFields, qb.searchText, args)
 		if whereClause != "" {
@@ -739,8 +1377,18 @@ func (qb *QueryBuilder) buildSelect() (string, []interface{}, error) {
 	}
 
 	// Add HAVING
-	if len(qb.having) > 0 {
-		query += " HAVING " + strings.Join(qb.having, " AND ")
+	var having []string
+	for _, condition := range qb.having {
+		having = append(having, renumberPlaceholders(condition.sql, len(args)))
+		args = append(args, condition.args...)
+	}
+	if len(qb.havingConditions) > 0 {
+		var havingClause string
+		havingClause, args = buildConditions(qb.havingConditions, args)
+		having = append(having, havingClause)
+	}
+	if len(having) > 0 {
+		query += " HAVING " + strings.Join(having, " AND ")
 	}
 
 	// Add ORDER BY
@@ -763,14 +1411,14 @@ func (qb *QueryBuilder) buildSelect() (string, []interface{}, error) {
 
 func (qb *QueryBuilder) buildInsert() (string, []interface{}, error) {
 	if qb.table == "" {
-		return "", nil, fmt.Errorf("table name is required for INSERT")
+		return "", nil, fmt.Errorf("table name is required for INSERT, set it with Into")
 	}
 
 	if qb.insertModel != nil {
 		// Use existing buildInsertStmt function
 		insertParams := &DatabaseInsert{Table: qb.table}
-		parseTags(qb.insertModel, &insertParams.Fields)
-		return buildInsertStmt(insertParams, qb.insertModel)
+		insertParams.Fields = writableColumns(qb.insertModel, nil)
+		return buildInsertStmt(insertParams, qb.insertModel, nil)
 	}
 
 	if len(qb.values) == 0 {
@@ -781,10 +1429,10 @@ func (qb *QueryBuilder) buildInsert() (string, []interface{}, error) {
 	var placeholders []string
 	var args []interface{}
 
-	for field, value := range qb.values {
-		fields = append(fields, field)
+	for _, assignment := range qb.values {
+		fields = append(fields, assignment.column)
 		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)+1))
-		args = append(args, value)
+		args = append(args, assignment.value)
 	}
 
 	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
@@ -806,7 +1454,7 @@ func (qb *QueryBuilder) buildUpdate() (string, []interface{}, error) {
 			Table:      qb.table,
 			Conditions: qb.conditions,
 		}
-		return buildUpdateStmt(updateParams, qb.updateModel)
+		return buildUpdateStmt(updateParams, qb.updateModel, nil)
 	}
 
 	if len(qb.values) == 0 {
@@ -817,9 +1465,9 @@ func (qb *QueryBuilder) buildUpdate() (string, []interface{}, error) {
 	var args []interface{}
 
 	var setParts []string
-	for field, value := range qb.values {
-		setParts = append(setParts, fmt.Sprintf("%s = $%d", field, len(args)+1))
-		args = append(args, value)
+	for _, assignment := range qb.values {
+		setParts = append(setParts, fmt.Sprintf("%s = $%d", assignment.column, len(args)+1))
+		args = append(args, assignment.value)
 	}
 
 	query += strings.Join(setParts, ", ")
@@ -857,48 +1505,115 @@ func (qb *QueryBuilder) buildDelete() (string, []interface{}, error) {
 }
 
 // getPrimaryKeyField returns the database column name of the primary key field from a struct
-func getPrimaryKeyField(model interface{}) string {
-	val := reflect.ValueOf(model)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-
-	t := val.Type()
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		// Check if this field has the primary key tag
-		if gpoField := parseGPOTag(field); gpoField != nil && gpoField.IsPrimaryKey {
-			return gpoField.ColumnName
-		}
+func getPrimaryKeyField(model interface{}, naming NamingStrategy) string {
+	if pk := modelMetadataOf(model, naming).primaryKey; pk != nil {
+		return pk.tag.ColumnName
 	}
 	// Fallback to default if no primary key tag is found
 	return DefaultIDField
 }
 
-// isPrimaryKeyField checks if a field is marked as primary key
-func isPrimaryKeyField(field reflect.StructField) bool {
-	gpoField := parseGPOTag(field)
-	return gpoField != nil && gpoField.IsPrimaryKey
+// rowScanner scans the rows of a query into models of one type. The column to
+// field mapping is resolved once per query and the scan arguments are reused
+// between rows.
+type rowScanner struct {
+	// paths are the field index paths per column, nil discards the column
+	paths [][]int
+	args  []interface{}
+	// discards hold the values of the discarded columns
+	discards []interface{}
+	// converters wrap the fields of columns needing conversion, nil scans directly
+	converters []func(dst reflect.Value) sql.Scanner
+	// location converts scanned times when not nil
+	location *time.Location
 }
 
-// scanRowToModel creates scan arguments for a single row based on field mapping
-func scanRowToModel(columns []string, fieldMap FieldMap, modelVal reflect.Value) []interface{} {
-	scanArgs := make([]interface{}, len(columns))
+// scannerFor returns a row scanner using the naming strategy and Location of the connector
+func (s *PostgreSQLConnector) scannerFor(columns []string, fieldMap FieldMap, t reflect.Type) *rowScanner {
+	scanner := newRowScanner(columns, fieldMap, t, s.naming())
+	scanner.location = s.Location
+	return scanner
+}
+
+// newRowScanner maps the columns present in fieldMap to the fields of t
+func newRowScanner(columns []string, fieldMap FieldMap, t reflect.Type, naming NamingStrategy) *rowScanner {
+	meta := metadataOf(t, naming)
+	scanner := &rowScanner{paths: make([][]int, len(columns)), args: make([]interface{}, len(columns)),
+		discards: make([]interface{}, len(columns)), converters: make([]func(reflect.Value) sql.Scanner, len(columns))}
 	for i, column := range columns {
-		if field, ok := fieldMap[column]; ok {
-			fieldVal := modelVal.FieldByName(field)
-			if fieldVal.IsValid() && fieldVal.CanAddr() {
-				scanArgs[i] = fieldVal.Addr().Interface()
-			} else {
-				var discard interface{}
-				scanArgs[i] = &discard
+		if field := meta.byColumn[column]; field != nil {
+			if _, ok := fieldMap[column]; ok {
+				scanner.paths[i] = []int{field.index}
+				scanner.converters[i] = columnConverter(field, t.Field(field.index).Type)
+			}
+		}
+	}
+	return scanner
+}
+
+// columnConverter returns the scanner converting the values of a field's column,
+// or nil when the column is scanned into the field directly
+func columnConverter(field *fieldMetadata, fieldType reflect.Type) func(dst reflect.Value) sql.Scanner {
+	if field.tag.Type == "INTERVAL" {
+		return func(dst reflect.Value) sql.Scanner { return intervalScanner{dst: dst} }
+	}
+	if isNetworkType(fieldType) {
+		return func(dst reflect.Value) sql.Scanner { return networkScanner{dst: dst} }
+	}
+	return nil
+}
+
+// columnArg returns the argument written to the column of a field, converting
+// durations of interval columns to interval literals and network addresses to text
+func columnArg(field *fieldMetadata, value reflect.Value) interface{} {
+	if field.tag.Type != "INTERVAL" {
+		return networkArg(value.Interface())
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Int64 {
+		return value.Interface()
+	}
+	return formatInterval(time.Duration(value.Int()))
+}
+
+// scan scans the current row into modelVal, which must be addressable
+func (r *rowScanner) scan(rows *sql.Rows, modelVal reflect.Value) error {
+	for i, path := range r.paths {
+		switch len(path) {
+		case 0:
+			r.args[i] = &r.discards[i]
+		case 1:
+			if r.converters[i] != nil {
+				r.args[i] = r.converters[i](modelVal.Field(path[0]))
+				continue
+			}
+			r.args[i] = modelVal.Field(path[0]).Addr().Interface()
+		default:
+			r.args[i] = modelVal.FieldByIndex(path).Addr().Interface()
+		}
+	}
+	if err := rows.Scan(r.args...); err != nil {
+		return err
+	}
+	if r.location != nil {
+		for _, arg := range r.args {
+			switch v := arg.(type) {
+			case *time.Time:
+				*v = v.In(r.location)
+			case **time.Time:
+				if *v != nil {
+					local := (*v).In(r.location)
+					*v = &local
+				}
 			}
-		} else {
-			var discard interface{}
-			scanArgs[i] = &discard
 		}
 	}
-	return scanArgs
+	return nil
 }
 
 // prepareStatement prepares a SQL statement with optional transaction support
@@ -910,8 +1625,8 @@ func prepareStatement(ctx context.Context, tx *sql.Tx, db *sql.DB, query string)
 }
 
 // createPrimaryKeyCondition creates a condition for primary key lookup
-func createPrimaryKeyCondition(model interface{}, idValue interface{}) []Condition {
-	pkField := getPrimaryKeyField(model)
+func createPrimaryKeyCondition(model interface{}, naming NamingStrategy, idValue interface{}) []Condition {
+	pkField := getPrimaryKeyField(model, naming)
 	return []Condition{
 		{
 			Field:    pkField,
@@ -920,3 +1635,46 @@ func createPrimaryKeyCondition(model interface{}, idValue interface{}) []Conditi
 		},
 	}
 }
+
+// isExample reports whether a FindFirst conditionOrId is a model used as query
+// by example rather than a primary key value
+func isExample(value interface{}) bool {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	_, valuer := value.(driver.Valuer)
+	return !valuer
+}
+
+// exampleConditions returns equality conditions for the non-zero tagged fields of
+// an example model
+func exampleConditions(example interface{}, naming NamingStrategy) []Condition {
+	val := reflect.Indirect(reflect.ValueOf(example))
+	meta := metadataOf(val.Type(), naming)
+	var conditions []Condition
+	for i := range meta.fields {
+		field := &meta.fields[i]
+		value := val.Field(field.index)
+		if value.IsZero() {
+			continue
+		}
+		conditions = append(conditions, Condition{Field: field.tag.ColumnName, Operator: "=", Value: columnArg(field, value)})
+	}
+	return conditions
+}
+
+// primaryKeyValue returns the primary key field value of a model, nil when it has no primary key
+func primaryKeyValue(model interface{}) interface{} {
+	val := reflect.Indirect(reflect.ValueOf(model))
+	if pk := metadataOf(val.Type(), nil).primaryKey; pk != nil {
+		return val.Field(pk.index).Interface()
+	}
+	return nil
+}
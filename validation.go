@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationError describes a user-facing validation failure of a single field
+type ValidationError struct {
+	Field   string
+	Message string
+	// Key identifies the message for translation, Params are its arguments
+	Key    string
+	Params map[string]interface{}
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// ValidationErrors collects the validation failures of a model
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// columnValue returns the value of the struct field tagged with the given column
+func columnValue(model interface{}, naming NamingStrategy, column string) (interface{}, error) {
+	val := reflect.ValueOf(model)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	index, ok := columnFieldIndex(val.Type(), naming, column)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field for column %s", val.Type().Name(), column)
+	}
+	return val.Field(index).Interface(), nil
+}
+
+// ValidateUniqueIgnoringSelf checks that no other row than the model itself
+// (matched by primary key) has the model's value in column
+func (s PostgreSQLConnector) ValidateUniqueIgnoringSelf(model interface{}, column string, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	value, err := columnValue(model, s.naming(), column)
+	if err != nil {
+		return err
+	}
+	pkField := getPrimaryKeyField(model, s.naming())
+	pkValue, err := columnValue(model, s.naming(), pkField)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = $1 AND %s <> $2 LIMIT 1",
+		s.tableName(model), column, pkField)
+	defer lockTx(config.tx)()
+	rows, err := s.queryRows(config.ctx, config.tx, query, value, pkValue)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return s.validationError(config.ctx, column, MsgTaken, map[string]interface{}{"field": column, "value": value})
+	}
+	return rows.Err()
+}
+
+// ValidateExists checks that the model's value in column references an existing
+// row of refModel. The referenced column is taken from the column's fk tag and
+// defaults to the primary key of refModel.
+func (s PostgreSQLConnector) ValidateExists(model interface{}, column string, refModel interface{}, opts ...Option) error {
+	config := processOptions(opts)
+	defer config.release()
+	value, err := columnValue(model, s.naming(), column)
+	if err != nil {
+		return err
+	}
+
+	refColumn := getPrimaryKeyField(refModel, s.naming())
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	if field, ok := metadataOf(modelType, s.naming()).byColumn[column]; ok && field.tag.ForeignKey != nil {
+		refColumn = field.tag.ForeignKey.Column
+	}
+
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = $1 LIMIT 1",
+		s.tableName(refModel), refColumn)
+	defer lockTx(config.tx)()
+	rows, err := s.queryRows(config.ctx, config.tx, query, value)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return s.validationError(config.ctx, column, MsgNotExists, map[string]interface{}{"field": column, "value": value})
+	}
+	return nil
+}
+
+// txLocks holds a lock per transaction used by validators, see lockTx
+var txLocks = struct {
+	sync.Mutex
+	held map[*sql.Tx]*txLock
+}{held: make(map[*sql.Tx]*txLock)}
+
+// txLock serializes the validators of one transaction, users counts the
+// validators holding or waiting for it
+type txLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockTx waits until no other validator runs a statement in tx and returns the
+// function releasing tx. A transaction runs one statement at a time, so checks
+// of RunValidators sharing it take turns. Without transaction it does not wait.
+func lockTx(tx *sql.Tx) (unlock func()) {
+	if tx == nil {
+		return func() {}
+	}
+	txLocks.Lock()
+	lock := txLocks.held[tx]
+	if lock == nil {
+		lock = &txLock{}
+		txLocks.held[tx] = lock
+	}
+	lock.users++
+	txLocks.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		txLocks.Lock()
+		defer txLocks.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(txLocks.held, tx)
+		}
+	}
+}
+
+// RunValidators runs the given checks concurrently. Validation failures are
+// collected into ValidationErrors, any other error is returned as is.
+// ValidateUniqueIgnoringSelf and ValidateExists checks with WithTransaction run
+// one at a time in their transaction.
+func RunValidators(checks ...func() error) error {
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() error) {
+			defer wg.Done()
+			errs[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	var validationErrors ValidationErrors
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var validationError *ValidationError
+		if !errors.As(err, &validationError) {
+			return err
+		}
+		validationErrors = append(validationErrors, validationError)
+	}
+	if len(validationErrors) > 0 {
+		return validationErrors
+	}
+	return nil
+}
+
+// Validator is implemented by models with validation beyond the tag options.
+// Validate is called by InsertModel and UpdateModel after the tag options were
+// checked; ValidationError and ValidationErrors are merged with their failures.
+type Validator interface {
+	Validate() error
+}
+
+// validateModel checks the validation tag options and the Validator of a model
+func (s PostgreSQLConnector) validateModel(ctx context.Context, model interface{}) error {
+	val := reflect.Indirect(reflect.ValueOf(model))
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+	var failures ValidationErrors
+	for _, field := range metadataOf(val.Type(), s.naming()).fields {
+		for _, rule := range field.tag.Validations {
+			if failure := s.checkRule(ctx, field.tag.ColumnName, rule, val.Field(field.index)); failure != nil {
+				failures = append(failures, failure)
+				break
+			}
+		}
+	}
+	if validator, ok := model.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			var validationErrors ValidationErrors
+			var validationError *ValidationError
+			switch {
+			case errors.As(err, &validationErrors):
+				failures = append(failures, validationErrors...)
+			case errors.As(err, &validationError):
+				failures = append(failures, validationError)
+			default:
+				return err
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// checkRule checks one validation rule against a field value, nil pointers only fail notempty
+func (s PostgreSQLConnector) checkRule(ctx context.Context, column string, rule ValidationRule, value reflect.Value) *ValidationError {
+	if rule.Name == "notempty" {
+		if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+			return s.validationError(ctx, column, MsgRequired, map[string]interface{}{"field": column})
+		}
+		return nil
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	switch rule.Name {
+	case "email":
+		if value.Kind() != reflect.String || value.String() == "" {
+			return nil
+		}
+		if address, err := mail.ParseAddress(value.String()); err != nil || address.Address != value.String() {
+			return s.validationError(ctx, column, MsgEmail, map[string]interface{}{"field": column, "value": value.String()})
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(rule.Param, 64)
+		if err != nil {
+			return nil
+		}
+		var actual float64
+		isLength := false
+		switch value.Kind() {
+		case reflect.String:
+			actual, isLength = float64(utf8.RuneCountInString(value.String())), true
+		case reflect.Slice, reflect.Map, reflect.Array:
+			actual, isLength = float64(value.Len()), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			actual = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			actual = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			actual = value.Float()
+		default:
+			return nil
+		}
+		params := map[string]interface{}{"field": column, rule.Name: rule.Param}
+		if rule.Name == "min" && actual < limit {
+			if isLength {
+				return s.validationError(ctx, column, MsgTooShort, params)
+			}
+			return s.validationError(ctx, column, MsgTooSmall, params)
+		}
+		if rule.Name == "max" && actual > limit {
+			if isLength {
+				return s.validationError(ctx, column, MsgTooLong, params)
+			}
+			return s.validationError(ctx, column, MsgTooLarge, params)
+		}
+	}
+	return nil
+}